@@ -0,0 +1,107 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNoActivation is returned when the process was passed no socket of the requested name by
+// socket activation
+var ErrNoActivation = errors.New("no activated socket")
+
+// listenFdsStart is the first file descriptor passed by socket activation, from sd-daemon.h
+const listenFdsStart = 3
+
+var activation struct {
+	once      sync.Once
+	listeners map[string][]net.Listener
+	err       error
+}
+
+// ActivationListeners returns the listening sockets passed to the process by systemd socket
+// activation (LISTEN_PID and LISTEN_FDS) whose FileDescriptorName is name, or all of them if
+// name is empty, so that servers can be started on demand and restarted without refusing
+// connections, the sockets being kept open by systemd in the meantime. The environment
+// variables of the protocol are unset on the first call, so that child processes do not take
+// the sockets for theirs; later calls return the same sockets. Sockets without a name are
+// named "unknown", as systemd names them.
+func ActivationListeners(name string) ([]net.Listener, error) {
+	activation.once.Do(func() {
+		activation.listeners, activation.err = activationListeners(os.Getenv("LISTEN_PID"),
+			os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), listenFdsStart)
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	if activation.err != nil {
+		return nil, activation.err
+	}
+	var ls []net.Listener
+	if name == "" {
+		for _, named := range activation.listeners {
+			ls = append(ls, named...)
+		}
+	} else {
+		ls = activation.listeners[name]
+	}
+	if len(ls) == 0 {
+		return nil, ErrNoActivation
+	}
+	return ls, nil
+}
+
+// activationListeners returns the listeners of the activated sockets described by the
+// environment variables of the protocol, starting at file descriptor start, by name
+func activationListeners(pid, fds, fdnames string, start int) (map[string][]net.Listener, error) {
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+		// meant for another process, such as our parent
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("ActivationListeners: invalid LISTEN_FDS %q", fds)
+	}
+	var names []string
+	if fdnames != "" {
+		names = strings.Split(fdnames, ":")
+	}
+	ls := map[string][]net.Listener{}
+	for i := 0; i < n; i++ {
+		fd := start + i
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// the listener has a duplicate of the descriptor, closed on exec, so that the original
+		// is not inherited by child processes
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("ActivationListeners: %s: %v", name, err)
+		}
+		ls[name] = append(ls[name], l)
+	}
+	return ls, nil
+}
+
+// serveListeners serves every listener of ls with serve until one fails, returning its error
+func serveListeners(ls []net.Listener, serve func(net.Listener) error) error {
+	errc := make(chan error, len(ls))
+	for _, l := range ls {
+		go func(l net.Listener) { errc <- serve(l) }(l)
+	}
+	return <-errc
+}
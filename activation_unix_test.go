@@ -0,0 +1,58 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package clamav
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestActivationListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	// the socket as passed by systemd, at a file descriptor of its own, not owned by an
+	// *os.File
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File: %v", err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatalf("Dup: %v", err)
+	}
+	pid := strconv.Itoa(os.Getpid())
+
+	if ls, err := activationListeners(strconv.Itoa(os.Getppid()), "1", "clamd", fd); err != nil || ls != nil {
+		t.Errorf("activationListeners: sockets of another process taken: %v, %v", ls, err)
+	}
+	if _, err := activationListeners(pid, "x", "", fd); err == nil {
+		t.Errorf("activationListeners: invalid LISTEN_FDS accepted")
+	}
+
+	ls, err := activationListeners(pid, "1", "clamd", fd)
+	if err != nil || len(ls["clamd"]) != 1 {
+		t.Fatalf("activationListeners: %v, %v", ls, err)
+	}
+	s := &ClamdServer{Scanner: eicarScanner{}}
+	defer s.Close()
+	go serveListeners(ls["clamd"], s.Serve)
+	if err := NewClamdClient(l.Addr().String()).Ping(); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	if _, err := ActivationListeners("clamd"); err != ErrNoActivation {
+		t.Errorf("ActivationListeners: %v without activation", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Allowlist is a set of SHA256 hashes of known good content, such as the files of golden images
+// or signed installers. Objects with an allowed hash, be they files or objects inside them, are
+// reported clean without being scanned. Hashing an object costs a read of it, so allowlisting
+// pays off for large or deeply nested content.
+type Allowlist struct {
+	// first, for the 64-bit atomic operations to be aligned on 32-bit platforms
+	hits, misses, lookupErrors uint64
+
+	// Lookup, if set, is consulted for the hashes not in the set, to query an external
+	// reputation service for instance. Objects are scanned if it fails.
+	Lookup func(sha256 string) (bool, error)
+
+	mu     sync.RWMutex
+	hashes map[string]bool
+}
+
+// AllowlistStats counts the decisions of an Allowlist
+type AllowlistStats struct {
+	Hits         uint64 // objects skipped
+	Misses       uint64 // objects scanned
+	LookupErrors uint64 // failed calls to Lookup, also counted as misses
+}
+
+// NewAllowlist returns an allowlist of the given hex encoded SHA256 hashes
+func NewAllowlist(hashes ...string) *Allowlist {
+	a := &Allowlist{}
+	a.Add(hashes...)
+	return a
+}
+
+// Add adds hex encoded SHA256 hashes to the set
+func (a *Allowlist) Add(hashes ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.hashes == nil {
+		a.hashes = map[string]bool{}
+	}
+	for _, h := range hashes {
+		a.hashes[strings.ToLower(h)] = true
+	}
+}
+
+// Remove removes hashes from the set
+func (a *Allowlist) Remove(hashes ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, h := range hashes {
+		delete(a.hashes, strings.ToLower(h))
+	}
+}
+
+// Len returns the number of hashes in the set
+func (a *Allowlist) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.hashes)
+}
+
+// Allowed reports whether the content with the hex encoded SHA256 hash is known good, counting
+// the decision in the statistics
+func (a *Allowlist) Allowed(sha256 string) bool {
+	sha256 = strings.ToLower(sha256)
+	a.mu.RLock()
+	ok := a.hashes[sha256]
+	a.mu.RUnlock()
+	if !ok && a.Lookup != nil {
+		var err error
+		if ok, err = a.Lookup(sha256); err != nil {
+			atomic.AddUint64(&a.lookupErrors, 1)
+			ok = false
+		}
+	}
+	if ok {
+		atomic.AddUint64(&a.hits, 1)
+	} else {
+		atomic.AddUint64(&a.misses, 1)
+	}
+	return ok
+}
+
+// Stats returns the decisions counted so far
+func (a *Allowlist) Stats() AllowlistStats {
+	return AllowlistStats{
+		Hits:         atomic.LoadUint64(&a.hits),
+		Misses:       atomic.LoadUint64(&a.misses),
+		LookupErrors: atomic.LoadUint64(&a.lookupErrors),
+	}
+}
+
+// allowedData reports whether buf is known good
+func (a *Allowlist) allowedData(buf []byte) bool {
+	sum := sha256.Sum256(buf)
+	return a.Allowed(hex.EncodeToString(sum[:]))
+}
+
+// allowedDesc reports whether the file open as fd is known good, reading it without moving its
+// offset. Files that cannot be read are scanned.
+func (a *Allowlist) allowedDesc(fd int) bool {
+	h := sha256.New()
+	if _, err := io.Copy(h, &descReader{fd: fd}); err != nil {
+		return false
+	}
+	return a.Allowed(hex.EncodeToString(h.Sum(nil)))
+}
+
+// SetAllowlist makes the scans skip the objects allowed by a, or stops allowlisting if a is
+// nil. Like the callbacks, the allowlist is process-wide, shared by all engines, and may be
+// replaced while scans are in progress; it is consulted from the pre_cache callback for files
+// and before scanning for objects in memory.
+func (e *Engine) SetAllowlist(a *Allowlist) {
+	if a == nil {
+		setCallbackFunc("allowlist", nil)
+		return
+	}
+	setCallbackFunc("allowlist", a)
+	e.hook()
+}
+
+// currentAllowlist returns the allowlist set with SetAllowlist, nil if none
+func currentAllowlist() *Allowlist {
+	a, _ := callbackFunc("allowlist").(*Allowlist)
+	return a
+}
@@ -0,0 +1,95 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestAllowlist(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	// the test file is known good, whatever it contains
+	golden := append([]byte("golden image\n"), eicar...)
+	a := NewAllowlist(fmt.Sprintf("%X", sha256.Sum256(golden)))
+	eng.SetAllowlist(a)
+	defer eng.SetAllowlist(nil)
+
+	if virus, _, err := eng.ScanBytes(golden, "golden", stdopts); virus != "" || err != nil {
+		t.Errorf("ScanBytes: allowed: %q %v", virus, err)
+	}
+	if virus, _, _ := eng.ScanBytes(eicar, "eicar", stdopts); virus == "" {
+		t.Errorf("ScanBytes: eicar allowed")
+	}
+
+	path := filepath.Join(t.TempDir(), "golden")
+	ioutil.WriteFile(path, golden, 0644)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if virus, _, err := eng.ScanDescCb("golden", int(f.Fd()), stdopts, nil); virus != "" || err != nil {
+		t.Errorf("ScanDescCb: allowed: %q %v", virus, err)
+	}
+
+	if s := a.Stats(); s.Hits != 2 || s.Misses != 1 || s.LookupErrors != 0 {
+		t.Errorf("Stats: %+v", s)
+	}
+
+	a.Remove(fmt.Sprintf("%x", sha256.Sum256(golden)))
+	lookups := 0
+	a.Lookup = func(string) (bool, error) {
+		lookups++
+		return false, errors.New("service unavailable")
+	}
+	if virus, _, _ := eng.ScanBytes(golden, "golden", stdopts); virus == "" {
+		t.Errorf("ScanBytes: removed hash still allowed")
+	}
+	if s := a.Stats(); lookups != 1 || s.Misses != 2 || s.LookupErrors != 1 || a.Len() != 0 {
+		t.Errorf("Stats: after lookup error: %+v, %d lookups", s, lookups)
+	}
+}
+
+func TestAllowlistConcurrent(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	defer eng.SetAllowlist(nil)
+
+	// the allowlist is replaced while other goroutines scan
+	a := NewAllowlist(fmt.Sprintf("%x", sha256.Sum256(eicar)))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				// reported or allowed, depending on the allowlist in place
+				if virus, _, _ := eng.ScanBytes(eicar, "eicar", stdopts); virus != "" && virus != "Eicar-Test-Signature" {
+					t.Errorf("ScanBytes: %q", virus)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		eng.SetAllowlist(a)
+		eng.SetAllowlist(nil)
+	}
+	wg.Wait()
+}
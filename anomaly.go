@@ -0,0 +1,215 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Defaults of RateMonitor
+const (
+	DefaultRateWindow   = time.Minute
+	DefaultRateHistory  = 60
+	DefaultRateMinScans = 50
+	DefaultRateFactor   = 4
+)
+
+// rateMinEvents is the number of detections or errors below which a window is never judged
+// anomalous, however far from the baseline, lest a few chance events raise alerts
+const rateMinEvents = 10
+
+// Kinds of RateAnomaly
+const (
+	DetectionsHigh = "detections high" // such as every file "infected" by a bad signature
+	DetectionsLow  = "detections low"  // such as none at all after a broken reload
+	ErrorsHigh     = "errors high"
+)
+
+// RateAnomaly is a window of scans whose rate of detections or errors deviated sharply from the
+// baseline
+type RateAnomaly struct {
+	Kind     string
+	Start    time.Time // of the window
+	Scans    int64     // in the window
+	Events   int64     // detections or errors in the window
+	Rate     float64   // of Events per scan
+	Baseline float64   // rate of the windows before
+}
+
+func (a *RateAnomaly) String() string {
+	return fmt.Sprintf("%s: %d of %d scans (%.2f%%) since %s, baseline %.2f%%", a.Kind, a.Events, a.Scans,
+		100*a.Rate, a.Start.Format(time.RFC3339), 100*a.Baseline)
+}
+
+// RateStats are the counts of a RateMonitor
+type RateStats struct {
+	Scans, Detections, Errors int64   // of the current window
+	DetectionRate, ErrorRate  float64 // baselines, of the windows before
+	Anomalies                 int64   // raised so far
+}
+
+// RateMonitor is a Scanner tracking the rates of detections and errors of the scans of another
+// over windows of time, and raising an alert when the rates of a window deviate sharply from
+// those of the windows before: a bad custom signature can make every file "infected", and a
+// broken reload can leave an engine that finds nothing.
+type RateMonitor struct {
+	Scanner Scanner
+
+	// Window is the length of the windows, DefaultRateWindow if zero
+	Window time.Duration
+
+	// History is the number of windows the baseline is taken over, DefaultRateHistory if zero
+	History int
+
+	// MinScans is the number of scans of a window below which it is not judged,
+	// DefaultRateMinScans if zero
+	MinScans int64
+
+	// Factor is how many times higher, or lower, than the baseline the rate of a window must be
+	// to be anomalous, DefaultRateFactor if zero
+	Factor float64
+
+	// OnAnomaly, if not nil, is called with every anomaly
+	OnAnomaly func(*RateAnomaly)
+
+	now func() time.Time // time.Now if nil, for tests
+
+	mu        sync.Mutex
+	cur       rateWindow
+	history   []rateWindow
+	anomalies int64
+}
+
+// rateWindow are the counts of a window
+type rateWindow struct {
+	start                     time.Time
+	scans, detections, errors int64
+}
+
+// Scan scans the data read from r with the scanner, counting the outcome
+func (m *RateMonitor) Scan(r io.Reader, name string) (*ScanResult, error) {
+	res, err := m.Scanner.Scan(r, name)
+	m.record(res != nil && res.Virus != "", err != nil)
+	return res, err
+}
+
+// Stats returns the counts of the current window and the baselines. A window ending meanwhile
+// raises its anomalies as scans do.
+func (m *RateMonitor) Stats() RateStats {
+	m.mu.Lock()
+	anomalies := m.roll()
+	det, errs := m.baseline()
+	st := RateStats{Scans: m.cur.scans, Detections: m.cur.detections, Errors: m.cur.errors,
+		DetectionRate: det, ErrorRate: errs, Anomalies: m.anomalies}
+	m.mu.Unlock()
+	m.raise(anomalies)
+	return st
+}
+
+// record counts a scan
+func (m *RateMonitor) record(detected, failed bool) {
+	m.mu.Lock()
+	anomalies := m.roll()
+	m.cur.scans++
+	if detected {
+		m.cur.detections++
+	} else if failed {
+		m.cur.errors++
+	}
+	m.mu.Unlock()
+	m.raise(anomalies)
+}
+
+// raise calls OnAnomaly with the anomalies, outside of the lock
+func (m *RateMonitor) raise(anomalies []*RateAnomaly) {
+	if m.OnAnomaly != nil {
+		for _, a := range anomalies {
+			m.OnAnomaly(a)
+		}
+	}
+}
+
+// roll ends the current window if its time is over, returning its anomalies
+func (m *RateMonitor) roll() []*RateAnomaly {
+	now := time.Now
+	if m.now != nil {
+		now = m.now
+	}
+	t := now()
+	window := m.Window
+	if window <= 0 {
+		window = DefaultRateWindow
+	}
+	if m.cur.start.IsZero() {
+		m.cur.start = t
+		return nil
+	}
+	if t.Sub(m.cur.start) < window {
+		return nil
+	}
+
+	anomalies := m.judge(m.cur)
+	m.anomalies += int64(len(anomalies))
+	history := m.History
+	if history <= 0 {
+		history = DefaultRateHistory
+	}
+	if m.cur.scans > 0 {
+		m.history = append(m.history, m.cur)
+		if len(m.history) > history {
+			m.history = m.history[len(m.history)-history:]
+		}
+	}
+	m.cur = rateWindow{start: t}
+	return anomalies
+}
+
+// baseline returns the rates of detections and errors of the windows of the history
+func (m *RateMonitor) baseline() (detections, errors float64) {
+	var w rateWindow
+	for _, h := range m.history {
+		w.scans += h.scans
+		w.detections += h.detections
+		w.errors += h.errors
+	}
+	if w.scans == 0 {
+		return 0, 0
+	}
+	return float64(w.detections) / float64(w.scans), float64(w.errors) / float64(w.scans)
+}
+
+// judge returns the anomalies of the window w against the history
+func (m *RateMonitor) judge(w rateWindow) []*RateAnomaly {
+	min, factor := m.MinScans, m.Factor
+	if min <= 0 {
+		min = DefaultRateMinScans
+	}
+	if factor <= 0 {
+		factor = DefaultRateFactor
+	}
+	if w.scans < min || len(m.history) == 0 {
+		return nil
+	}
+	det, errs := m.baseline()
+	var anomalies []*RateAnomaly
+	add := func(kind string, events int64, baseline float64) {
+		anomalies = append(anomalies, &RateAnomaly{Kind: kind, Start: w.start, Scans: w.scans, Events: events,
+			Rate: float64(events) / float64(w.scans), Baseline: baseline})
+	}
+	expected := det * float64(w.scans)
+	if w.detections >= rateMinEvents && float64(w.detections) > factor*expected {
+		add(DetectionsHigh, w.detections, det)
+	}
+	if expected >= rateMinEvents && float64(w.detections)*factor < expected {
+		add(DetectionsLow, w.detections, det)
+	}
+	if w.errors >= rateMinEvents && float64(w.errors) > factor*errs*float64(w.scans) {
+		add(ErrorsHigh, w.errors, errs)
+	}
+	return anomalies
+}
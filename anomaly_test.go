@@ -0,0 +1,82 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// outcomeScanner finds a virus in the objects named "virus" and fails on those named "error"
+type outcomeScanner struct{}
+
+func (outcomeScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	switch name {
+	case "virus":
+		return &ScanResult{Name: name, Virus: "Win.Test.EICAR_HDB-1"}, nil
+	case "error":
+		return nil, errors.New("LibClamAV Error: CL_EFORMAT")
+	}
+	return &ScanResult{Name: name}, nil
+}
+
+func TestRateMonitor(t *testing.T) {
+	clock := time.Unix(1e9, 0)
+	var raised []*RateAnomaly
+	m := &RateMonitor{Scanner: outcomeScanner{}, now: func() time.Time { return clock }, OnAnomaly: func(a *RateAnomaly) {
+		raised = append(raised, a)
+	}}
+	// window scans a window of 100 objects, of which detections are viruses and errors fail,
+	// returning the anomalies raised when it ends
+	window := func(detections, errs int) []*RateAnomaly {
+		raised = nil
+		for i := 0; i < 100; i++ {
+			name := "clean"
+			if i < detections {
+				name = "virus"
+			} else if i < detections+errs {
+				name = "error"
+			}
+			m.Scan(strings.NewReader(""), name)
+		}
+		clock = clock.Add(DefaultRateWindow)
+		m.Scan(strings.NewReader(""), "clean")
+		return raised
+	}
+
+	for i := 0; i < 5; i++ {
+		if a := window(2, 1); len(a) != 0 {
+			t.Fatalf("normal window %d: %v", i, a)
+		}
+	}
+	if st := m.Stats(); st.DetectionRate < 0.019 || st.DetectionRate > 0.021 || st.Anomalies != 0 {
+		t.Errorf("Stats: %+v", st)
+	}
+	if a := window(2, 30); len(a) != 1 || a[0].Kind != ErrorsHigh || a[0].Events != 30 {
+		t.Errorf("failing scans: %v", a)
+	}
+	if a := window(60, 1); len(a) != 1 || a[0].Kind != DetectionsHigh || a[0].Events != 60 {
+		t.Errorf("bad signature: %v", a)
+	}
+	if a := window(0, 0); len(a) != 1 || a[0].Kind != DetectionsLow {
+		t.Errorf("broken reload: %v", a)
+	}
+	if st := m.Stats(); st.Anomalies != 3 {
+		t.Errorf("Stats: %+v", st)
+	}
+
+	// a window ended by Stats raises its anomalies too
+	raised = nil
+	for i := 0; i < 100; i++ {
+		m.Scan(strings.NewReader(""), "virus")
+	}
+	clock = clock.Add(DefaultRateWindow)
+	if st := m.Stats(); st.Anomalies != 4 || len(raised) != 1 || raised[0].Kind != DetectionsHigh {
+		t.Errorf("Stats: %+v, raised %v", st, raised)
+	}
+}
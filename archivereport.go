@@ -0,0 +1,80 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "strings"
+
+// ArchiveReport is the tree of objects ClamAV unpacked from a scanned object: archive members,
+// files embedded in documents or executables, and so on. Objects ClamAV did not extract, because
+// they are encrypted or beyond the scan limits, are missing from the tree, which tells what was
+// and wasn't inspected.
+type ArchiveReport struct {
+	Name      string // member name, if the container records one
+	FileType  string // e.g. "CL_TYPE_ZIP"
+	Size      int64
+	Depth     int    // zero for the scanned object, one for its members, and so on
+	MD5       string // if reported
+	Encrypted bool   // the object is encrypted, so its content was not inspected
+	Viruses   []string
+
+	Contained []*ArchiveReport // extracted members
+}
+
+// parseArchiveReport builds the report of the object described by props and its members
+func parseArchiveReport(props map[string]interface{}, depth int) *ArchiveReport {
+	r := &ArchiveReport{Depth: depth}
+	r.Name, _ = props["FileName"].(string)
+	r.FileType, _ = props["FileType"].(string)
+	r.Size = int64(jsonNumber(props["FileSize"]))
+	r.MD5, _ = props["FileMD5"].(string)
+	r.Encrypted = isTrue(props["Encrypted"])
+	if stats, ok := props["PDFStats"].(map[string]interface{}); ok {
+		r.Encrypted = r.Encrypted || isTrue(stats["Encrypted"])
+	}
+	if vs, ok := props["Viruses"].([]interface{}); ok {
+		for _, v := range vs {
+			if s, ok := v.(string); ok {
+				r.Viruses = append(r.Viruses, s)
+				// ScanHeuristicEncryptedArchive and ScanHeuristicEncryptedDoc alerts
+				r.Encrypted = r.Encrypted || strings.HasPrefix(s, "Heuristics.Encrypted.")
+			}
+		}
+	}
+	for _, k := range []string{"ContainedObjects", "EmbeddedObjects"} {
+		objs, _ := props[k].([]interface{})
+		for _, o := range objs {
+			if o, ok := o.(map[string]interface{}); ok {
+				r.Contained = append(r.Contained, parseArchiveReport(o, depth+1))
+			}
+		}
+	}
+	return r
+}
+
+// Walk calls fn for the object and all its members, depth first, stopping early if fn returns
+// false
+func (r *ArchiveReport) Walk(fn func(*ArchiveReport) bool) bool {
+	if !fn(r) {
+		return false
+	}
+	for _, c := range r.Contained {
+		if !c.Walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxDepth returns the depth of the most deeply nested member
+func (r *ArchiveReport) MaxDepth() int {
+	max := r.Depth
+	r.Walk(func(c *ArchiveReport) bool {
+		if c.Depth > max {
+			max = c.Depth
+		}
+		return true
+	})
+	return max
+}
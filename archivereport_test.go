@@ -0,0 +1,70 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"reflect"
+	"testing"
+)
+
+const archiveMetadata = `{
+  "Magic": "CLAMJSONv0",
+  "RootFileType": "CL_TYPE_GZ",
+  "FileType": "CL_TYPE_GZ",
+  "FileSize": 4096,
+  "ContainedObjects": [{
+    "FileName": "bundle.tar",
+    "FileType": "CL_TYPE_POSIX_TAR",
+    "FileSize": 20480,
+    "ContainedObjects": [
+      {"FileName": "readme.txt", "FileType": "CL_TYPE_TEXT_ASCII", "FileSize": 12, "FileMD5": "6f5902ac237024bdd0c176cb93063dc4"},
+      {"FileName": "secret.zip", "FileType": "CL_TYPE_ZIP", "FileSize": 300, "Viruses": ["Heuristics.Encrypted.Zip"]},
+      {"FileName": "report.pdf", "FileType": "CL_TYPE_PDF", "FileSize": 900, "PDFStats": {"Encrypted": true},
+       "EmbeddedObjects": [{"FileType": "CL_TYPE_MSEXE", "FileSize": 100, "Viruses": ["Win.Test.Dropper"]}]}
+    ]
+  }]
+}`
+
+func TestArchiveReport(t *testing.T) {
+	m, err := parseMetadata(archiveMetadata)
+	if err != nil {
+		t.Fatalf("parseMetadata: %v", err)
+	}
+	r := m.Archive
+	if r == nil || r.FileType != "CL_TYPE_GZ" || r.Size != 4096 || r.Depth != 0 || len(r.Contained) != 1 {
+		t.Fatalf("parseMetadata: archive %+v", r)
+	}
+	if r.MaxDepth() != 3 {
+		t.Errorf("MaxDepth: %d, want 3", r.MaxDepth())
+	}
+
+	var names, encrypted, viruses []string
+	r.Walk(func(c *ArchiveReport) bool {
+		names = append(names, c.Name)
+		if c.Encrypted {
+			encrypted = append(encrypted, c.Name)
+		}
+		viruses = append(viruses, c.Viruses...)
+		return true
+	})
+	if want := []string{"", "bundle.tar", "readme.txt", "secret.zip", "report.pdf", ""}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Walk: got %q, want %q", names, want)
+	}
+	if want := []string{"secret.zip", "report.pdf"}; !reflect.DeepEqual(encrypted, want) {
+		t.Errorf("Encrypted: got %q, want %q", encrypted, want)
+	}
+	if want := []string{"Heuristics.Encrypted.Zip", "Win.Test.Dropper"}; !reflect.DeepEqual(viruses, want) {
+		t.Errorf("Viruses: got %q, want %q", viruses, want)
+	}
+	if txt := r.Contained[0].Contained[0]; txt.MD5 != "6f5902ac237024bdd0c176cb93063dc4" || txt.Size != 12 || txt.Depth != 2 {
+		t.Errorf("parseMetadata: member %+v", txt)
+	}
+
+	visited := 0
+	r.Walk(func(*ArchiveReport) bool { visited++; return visited < 2 })
+	if visited != 2 {
+		t.Errorf("Walk: %d objects visited after stopping, want 2", visited)
+	}
+}
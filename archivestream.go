@@ -0,0 +1,355 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// signatures of the records of a zip archive
+const (
+	zipLocalHeader      = 0x04034b50
+	zipCentralHeader    = 0x02014b50
+	zipEndOfDirectory   = 0x06054b50
+	zip64EndOfDirectory = 0x06064b50
+	zipDataDescriptor   = 0x08074b50
+)
+
+var (
+	errZipEncrypted = errors.New("encrypted member")
+	errZipMethod    = errors.New("unsupported compression method")
+)
+
+// MemberResult is the verdict on a member of an archive scanned by ScanArchiveStream
+type MemberResult struct {
+	Path   string
+	Size   int64       // uncompressed size of the member
+	Result *ScanResult // nil if the member could not be scanned
+	Err    error       // reason the member could not be scanned
+}
+
+// ScanArchiveStream reads a tar, gzip compressed tar or zip archive from r and scans its regular
+// members one at a time with s, as they are read, calling fn with the verdict on each: CI
+// pipelines get a verdict per file of their artifacts without writing them to disk or holding
+// them in memory. Unlike ScanArtifact, members are not unpacked further; archives nested in
+// the archive are scanned as members, and unpacked by the scanner if it does so.
+//
+// Zip archives are read from their local headers, without the central directory at their end.
+// Members stored uncompressed with their size in a data descriptor cannot be read that way and
+// stop the scan with an error, as do the limits other than the member size: members larger
+// than that are reported as not scanned. Scanning stops with the error fn returns, if any, wrapped.
+func ScanArchiveStream(s Scanner, r io.Reader, limits ArchiveLimits, fn func(*MemberResult) error) error {
+	a := &archiveStream{s: s, limits: limits, fn: fn}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(512)
+	var err error
+	switch archiveKind(magic) {
+	case "gzip":
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(br); err != nil {
+			break
+		}
+		zbr := bufio.NewReader(zr)
+		if magic, _ := zbr.Peek(512); archiveKind(magic) == "tar" {
+			err = a.tar(zbr)
+		} else {
+			err = a.member("data", -1, zbr)
+		}
+	case "tar":
+		err = a.tar(br)
+	case "zip":
+		err = a.zip(br)
+	default:
+		err = errNotArchive
+	}
+	if err != nil {
+		return fmt.Errorf("ScanArchiveStream: %w", err)
+	}
+	return nil
+}
+
+// archiveStream is the state of ScanArchiveStream
+type archiveStream struct {
+	s       Scanner
+	limits  ArchiveLimits
+	fn      func(*MemberResult) error
+	entries int
+	total   int64
+}
+
+func (a *archiveStream) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := a.member(hdr.Name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *archiveStream) zip(br *bufio.Reader) error {
+	for {
+		var sig [4]byte
+		if _, err := io.ReadFull(br, sig[:]); err != nil {
+			return fmt.Errorf("zip: %v", err)
+		}
+		switch binary.LittleEndian.Uint32(sig[:]) {
+		case zipLocalHeader:
+		case zipCentralHeader, zipEndOfDirectory, zip64EndOfDirectory:
+			// the members are all read
+			return nil
+		default:
+			return errors.New("zip: invalid local header")
+		}
+		var h [26]byte
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			return fmt.Errorf("zip: %v", err)
+		}
+		flags := binary.LittleEndian.Uint16(h[2:])
+		method := binary.LittleEndian.Uint16(h[4:])
+		csize := uint64(binary.LittleEndian.Uint32(h[14:]))
+		usize := uint64(binary.LittleEndian.Uint32(h[18:]))
+		nameExtra := make([]byte, int(binary.LittleEndian.Uint16(h[22:]))+int(binary.LittleEndian.Uint16(h[24:])))
+		if _, err := io.ReadFull(br, nameExtra); err != nil {
+			return fmt.Errorf("zip: %v", err)
+		}
+		name := string(nameExtra[:binary.LittleEndian.Uint16(h[22:])])
+		zip64 := zip64Sizes(nameExtra[len(name):], &usize, &csize)
+		descriptor := flags&0x8 != 0
+
+		var body io.Reader
+		var bodyErr error
+		data := io.LimitReader(br, int64(csize))
+		switch {
+		case flags&0x1 != 0:
+			bodyErr = errZipEncrypted
+		case method == 0:
+			body = data
+		case method == 8 && descriptor:
+			// the end of the member is found by decompressing it, reading no further
+			body = flate.NewReader(br)
+		case method == 8:
+			body = flate.NewReader(data)
+		default:
+			bodyErr = errZipMethod
+		}
+		if descriptor && (body == nil || method == 0) {
+			return fmt.Errorf("zip: %s: cannot find the end of the member", name)
+		}
+
+		size := int64(usize)
+		if descriptor {
+			size = -1
+		}
+		switch {
+		case strings.HasSuffix(name, "/"):
+			// a directory
+		case bodyErr != nil:
+			a.entries++
+			if err := a.fn(&MemberResult{Path: name, Size: size, Err: bodyErr}); err != nil {
+				return err
+			}
+		default:
+			if err := a.member(name, size, body); err != nil {
+				return err
+			}
+		}
+		if body != nil {
+			if _, err := a.drain(body); err != nil {
+				return fmt.Errorf("zip: %s: %v", name, err)
+			}
+		}
+		if !descriptor {
+			if _, err := io.Copy(ioutil.Discard, data); err != nil {
+				return fmt.Errorf("zip: %s: %v", name, err)
+			}
+			continue
+		}
+		// crc and sizes, after an optional signature
+		n := 12
+		if zip64 {
+			n = 20
+		}
+		d := make([]byte, n+4)
+		if _, err := io.ReadFull(br, d[:4]); err != nil {
+			return fmt.Errorf("zip: %s: %v", name, err)
+		}
+		if binary.LittleEndian.Uint32(d) == zipDataDescriptor {
+			_, err := io.ReadFull(br, d[4:])
+			if err != nil {
+				return fmt.Errorf("zip: %s: %v", name, err)
+			}
+		} else if _, err := io.ReadFull(br, d[4:n]); err != nil {
+			return fmt.Errorf("zip: %s: %v", name, err)
+		}
+	}
+}
+
+// zip64Sizes sets the sizes of a member from the zip64 field of its extra fields, if they are
+// recorded there, reporting whether there is such a field
+func zip64Sizes(extra []byte, usize, csize *uint64) bool {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		n := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+n > len(extra) {
+			return false
+		}
+		field := extra[4 : 4+n]
+		if id == 0x0001 {
+			for _, size := range []*uint64{usize, csize} {
+				if *size == 0xffffffff && len(field) >= 8 {
+					*size = binary.LittleEndian.Uint64(field)
+					field = field[8:]
+				}
+			}
+			return true
+		}
+		extra = extra[4+n:]
+	}
+	return false
+}
+
+// member scans the member at path of the given size, -1 if unknown, reading it from r
+func (a *archiveStream) member(path string, size int64, r io.Reader) error {
+	a.entries++
+	if a.limits.MaxEntries > 0 && a.entries > a.limits.MaxEntries {
+		return errTooManyEntries
+	}
+	m := &MemberResult{Path: path, Size: size}
+	if max := a.limits.MaxEntrySize; max > 0 && size > max {
+		m.Err = errEntryTooLarge
+	} else {
+		lr := &streamLimitReader{r: r, a: a}
+		m.Result, m.Err = a.s.Scan(lr, path)
+		if lr.err == errTooLarge {
+			return lr.err
+		}
+		if lr.err != nil {
+			m.Result, m.Err = nil, lr.err
+		}
+		// and the rest of the member, if the scanner stopped reading
+		n, err := a.drain(r)
+		if err != nil {
+			return err
+		}
+		m.Size = lr.n + n
+	}
+	return a.fn(m)
+}
+
+// drain reads the rest of r, within the total size limit
+func (a *archiveStream) drain(r io.Reader) (int64, error) {
+	return io.Copy(ioutil.Discard, &streamLimitReader{r: r, a: a, noMember: true})
+}
+
+// streamLimitReader enforces the size limits of an archiveStream on a member
+type streamLimitReader struct {
+	r        io.Reader
+	a        *archiveStream
+	noMember bool  // only the total size limit applies
+	n        int64 // bytes read from the member
+	err      error // limit that was hit
+}
+
+func (l *streamLimitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	l.a.total += int64(n)
+	if max := l.a.limits.MaxTotalSize; max > 0 && l.a.total > max {
+		l.err = errTooLarge
+		return n, l.err
+	}
+	if max := l.a.limits.MaxEntrySize; !l.noMember && max > 0 && l.n > max {
+		l.err = errEntryTooLarge
+		return n, l.err
+	}
+	return n, err
+}
+
+// MemberScanner is a Scanner unpacking zip, gzip and gzip compressed tar archives itself, with
+// ScanArchiveStream, to scan their members one at a time with Scanner rather than have it scan
+// the archive. An EngineScanner scans the members from memory up to its MemoryLimit, spooling
+// only larger ones, where libclamav would extract every member to its temporary directory:
+// deployments on read-only filesystems keep archive coverage. Archives nested in the members
+// are left to Scanner, which may extract them. Other objects are scanned as is.
+//
+// The Virus of the result of an archive is that of its first infected member, the members are
+// told apart by OnMember; if none is infected and a member could not be scanned, that is the
+// error of the scan. An archive found corrupt after an infected member is returned with both
+// the result and the error.
+type MemberScanner struct {
+	Scanner Scanner
+	Limits  ArchiveLimits
+
+	// OnMember, if not nil, is called with the verdict on every member
+	OnMember func(*MemberResult)
+}
+
+// Scan scans the members of the archive read from r, or the object if it is not an archive
+func (s *MemberScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	var magic []byte
+	if f, _, ok := seekableFile(r); ok {
+		// sniffed without moving the offset, for the descriptor to be scanned as is
+		magic = make([]byte, 512)
+		n, _ := f.ReadAt(magic, 0)
+		magic = magic[:n]
+	} else {
+		br := bufio.NewReaderSize(r, 1024)
+		magic, _ = br.Peek(512)
+		r = br
+	}
+	if archiveKind(magic) == "" {
+		return s.Scanner.Scan(r, name)
+	}
+	res := &ScanResult{Name: name}
+	var failed *MemberResult
+	err := ScanArchiveStream(s.Scanner, r, s.Limits, func(m *MemberResult) error {
+		if s.OnMember != nil {
+			s.OnMember(m)
+		}
+		switch {
+		case m.Result != nil && m.Result.Virus != "":
+			if res.Virus == "" {
+				res.Virus = m.Result.Virus
+			}
+			res.Detections = append(res.Detections, m.Result.Detections...)
+		case m.Err != nil && failed == nil:
+			failed = m
+		}
+		return nil
+	})
+	if err != nil {
+		// a corrupt tail does not hide the members found infected before it
+		if res.Virus != "" {
+			return res, fmt.Errorf("MemberScanner: %v", err)
+		}
+		return nil, fmt.Errorf("MemberScanner: %v", err)
+	}
+	if res.Virus == "" && failed != nil {
+		return nil, fmt.Errorf("MemberScanner: %s: %w", failed.Path, failed.Err)
+	}
+	return res, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestScanArchiveStream(t *testing.T) {
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	// deflated with a data descriptor, as written when streaming
+	w, _ := zw.Create("src/eicar.com")
+	w.Write(eicar)
+	zw.Create("src/")
+	w, _ = zw.Create("README")
+	w.Write([]byte(strings.Repeat("clean ", 1000)))
+	// stored with its sizes in the local header
+	w, _ = zw.CreateRaw(&zip.FileHeader{Name: "stored.com", Method: zip.Store, CRC32: crc32.ChecksumIEEE(eicar),
+		CompressedSize64: uint64(len(eicar)), UncompressedSize64: uint64(len(eicar))})
+	w.Write(eicar)
+	zw.Close()
+	tgz := gzipBytes(t, tarBytes(t, "package/eicar.com", string(eicar)))
+
+	for _, c := range []struct {
+		name    string
+		archive []byte
+		want    string
+	}{
+		{"zip", zbuf.Bytes(), "src/eicar.com:Eicar-Test-Signature README: stored.com:Eicar-Test-Signature"},
+		{"tgz", tgz, "package/eicar.com:Eicar-Test-Signature"},
+	} {
+		var got []string
+		err := ScanArchiveStream(eicarScanner{}, onlyReader{bytes.NewReader(c.archive)}, ArchiveLimits{}, func(m *MemberResult) error {
+			if m.Err != nil || m.Result == nil {
+				t.Errorf("ScanArchiveStream: %s: %+v", c.name, m)
+				return nil
+			}
+			got = append(got, m.Path+":"+m.Result.Virus)
+			return nil
+		})
+		if err != nil || strings.Join(got, " ") != c.want {
+			t.Errorf("ScanArchiveStream: %s: %v, %v", c.name, got, err)
+		}
+	}
+
+	var sizes []string
+	err := ScanArchiveStream(eicarScanner{}, bytes.NewReader(zbuf.Bytes()), ArchiveLimits{MaxEntrySize: 100}, func(m *MemberResult) error {
+		sizes = append(sizes, fmt.Sprintf("%s:%d:%v", m.Path, m.Size, m.Err))
+		return nil
+	})
+	want := "src/eicar.com:68:<nil> README:6000:member exceeds size limit stored.com:68:<nil>"
+	if err != nil || strings.Join(sizes, " ") != want {
+		t.Errorf("ScanArchiveStream: %q, %v, want %q", sizes, err, want)
+	}
+
+	stop := errors.New("stop")
+	err = ScanArchiveStream(eicarScanner{}, bytes.NewReader(zbuf.Bytes()), ArchiveLimits{}, func(m *MemberResult) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Errorf("ScanArchiveStream: %v, want the error of fn", err)
+	}
+	if err := ScanArchiveStream(eicarScanner{}, bytes.NewReader(zbuf.Bytes()), ArchiveLimits{MaxTotalSize: 1000}, func(*MemberResult) error { return nil }); err == nil {
+		t.Errorf("ScanArchiveStream: total size limit not enforced")
+	}
+	if err := ScanArchiveStream(eicarScanner{}, strings.NewReader("plain text"), ArchiveLimits{}, nil); err == nil {
+		t.Errorf("ScanArchiveStream: not an archive accepted")
+	}
+}
+
+// onlyReader hides the other methods of a reader, such as io.Seeker
+type onlyReader struct {
+	io.Reader
+}
+
+func TestMemberScanner(t *testing.T) {
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	w, _ := zw.Create("README")
+	w.Write([]byte("clean"))
+	w, _ = zw.Create("lib/eicar.com")
+	w.Write(eicar)
+	zw.Close()
+
+	var members []string
+	s := &MemberScanner{Scanner: eicarScanner{}, OnMember: func(m *MemberResult) {
+		members = append(members, m.Path)
+	}}
+	res, err := s.Scan(onlyReader{bytes.NewReader(zbuf.Bytes())}, "lib.zip")
+	if err != nil || res.Name != "lib.zip" || res.Virus != "Eicar-Test-Signature" || strings.Join(members, " ") != "README lib/eicar.com" {
+		t.Errorf("Scan: %+v, %v, members %v", res, err, members)
+	}
+
+	// objects other than archives are scanned as is, files from their descriptor
+	f, err := ioutil.TempFile(t.TempDir(), "eicar")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	defer f.Close()
+	f.Write(eicar)
+	f.Seek(0, io.SeekStart)
+	members = nil
+	if res, err := s.Scan(f, "eicar.com"); err != nil || res.Virus != "Eicar-Test-Signature" || members != nil {
+		t.Errorf("Scan: %+v, %v", res, err)
+	}
+
+	// an infected first member followed by a truncated one
+	tr := writeTar(t, []tarEntry{{name: "eicar.com", body: eicar}, {name: "tail", body: make([]byte, 4096)}})
+	res, err = s.Scan(onlyReader{bytes.NewReader(tr[:len(tr)-2048])}, "cut.tar")
+	if err == nil || res == nil || res.Virus != "Eicar-Test-Signature" {
+		t.Errorf("Scan: %+v, %v from a truncated archive", res, err)
+	}
+
+	s.Limits.MaxEntrySize = 10
+	if _, err := s.Scan(bytes.NewReader(tarBytes(t, "big", strings.Repeat("x", 100))), "big.tar"); !errors.Is(err, errEntryTooLarge) {
+		t.Errorf("Scan: %v, want the error of the member", err)
+	}
+}
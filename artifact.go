@@ -0,0 +1,243 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ArchiveLimits bounds the work done unpacking an untrusted archive. A zero field means no limit.
+type ArchiveLimits struct {
+	MaxEntries   int   // number of members, counting members of nested archives
+	MaxEntrySize int64 // uncompressed size of a single member
+	MaxTotalSize int64 // uncompressed size of all members together
+	MaxDepth     int   // nesting of archives inside the artifact, such as data.tar.gz in a gem
+}
+
+// DefaultArchiveLimits are reasonable limits for package registry artifacts
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxEntries:   20000,
+	MaxEntrySize: 256 << 20,
+	MaxTotalSize: 1 << 30,
+	MaxDepth:     2,
+}
+
+// EntryVerdict is the scan result of a single member of an archive
+type EntryVerdict struct {
+	Path  string // member path, members of nested archives are prefixed by the archive path
+	Size  int64  // uncompressed size of the member
+	Virus string // virus name, empty if the member is clean
+	Err   error  // reason the member could not be scanned
+}
+
+var (
+	errTooManyEntries = errors.New("too many archive members")
+	errTooLarge       = errors.New("archive exceeds total size limit")
+	errEntryTooLarge  = errors.New("member exceeds size limit")
+	errNotArchive     = errors.New("unsupported archive format")
+)
+
+// ScanArtifact unpacks a package artifact in memory and scans each member individually. npm
+// and crate tarballs, Python sdists and wheels and Ruby gems are supported, as is any tar, gzip
+// compressed tar or zip archive. A verdict is returned for every regular member. Unpacking
+// stops with an error when limits are exceeded, except for the size of individual members:
+// those are reported as not scanned.
+func (e *Engine) ScanArtifact(r io.Reader, name string, limits ArchiveLimits, opts *ScanOptions) ([]EntryVerdict, error) {
+	u := &unpacker{e: e, limits: limits, opts: opts}
+	if err := u.unpack(r, "", 0); err != nil {
+		return u.verdicts, fmt.Errorf("ScanArtifact: %s: %v", name, err)
+	}
+	return u.verdicts, nil
+}
+
+// unpacker walks an archive and its nested archives, keeping track of limits
+type unpacker struct {
+	e        *Engine
+	limits   ArchiveLimits
+	opts     *ScanOptions
+	entries  int
+	total    int64
+	verdicts []EntryVerdict
+}
+
+// archiveKind returns the format of the data starting with magic, or "" if it is not an archive
+func archiveKind(magic []byte) string {
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return "zip"
+	case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+		return "tar"
+	}
+	return ""
+}
+
+// unpack scans the members of the archive in r. Member paths are prefixed with prefix.
+func (u *unpacker) unpack(r io.Reader, prefix string, depth int) error {
+	br := bufio.NewReaderSize(r, 1024)
+	magic, _ := br.Peek(512)
+	switch archiveKind(magic) {
+	case "gzip":
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		zbr := bufio.NewReaderSize(zr, 1024)
+		magic, _ := zbr.Peek(512)
+		if archiveKind(magic) == "tar" {
+			return u.unpackTar(zbr, prefix, depth)
+		}
+		// a single compressed file, such as metadata.gz in gems, keeps the name of the archive
+		name := strings.TrimSuffix(prefix, "/")
+		if name == "" {
+			name = "data"
+		}
+		return u.member(zbr, name, -1, depth)
+	case "zip":
+		return u.unpackZip(br, prefix, depth)
+	case "tar":
+		return u.unpackTar(br, prefix, depth)
+	}
+	return errNotArchive
+}
+
+func (u *unpacker) unpackTar(r io.Reader, prefix string, depth int) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := u.member(tr, prefix+hdr.Name, hdr.Size, depth); err != nil {
+			return err
+		}
+	}
+}
+
+func (u *unpacker) unpackZip(r io.Reader, prefix string, depth int) error {
+	// zip needs random access, hold the compressed archive in memory
+	max := u.limits.MaxTotalSize
+	if depth > 0 && u.limits.MaxEntrySize > 0 {
+		max = u.limits.MaxEntrySize
+	}
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if max > 0 && int64(len(buf)) > max {
+		return errTooLarge
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			u.verdicts = append(u.verdicts, EntryVerdict{Path: prefix + f.Name, Err: err})
+			continue
+		}
+		err = u.member(rc, prefix+f.Name, int64(f.UncompressedSize64), depth)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// member scans a single archive member, recursing into it if it is an archive itself. Size is
+// the size recorded in the archive, or -1 if unknown. Only limit violations are returned as
+// errors, anything else is recorded in the member's verdict.
+func (u *unpacker) member(r io.Reader, path string, size int64, depth int) error {
+	u.entries++
+	if u.limits.MaxEntries > 0 && u.entries > u.limits.MaxEntries {
+		return errTooManyEntries
+	}
+	if u.limits.MaxEntrySize > 0 && size > u.limits.MaxEntrySize {
+		u.verdicts = append(u.verdicts, EntryVerdict{Path: path, Size: size, Err: errEntryTooLarge})
+		return nil
+	}
+
+	lr := &limitedReader{r: r, u: u}
+	br := bufio.NewReaderSize(lr, 1024)
+	magic, _ := br.Peek(512)
+	if archiveKind(magic) != "" && (u.limits.MaxDepth <= 0 || depth < u.limits.MaxDepth) {
+		err := u.unpack(br, path+"/", depth+1)
+		if lr.err == errTooLarge {
+			return lr.err
+		}
+		if err == errTooLarge || err == errTooManyEntries {
+			return err
+		}
+		if err != nil {
+			u.verdicts = append(u.verdicts, EntryVerdict{Path: path, Size: lr.n, Err: err})
+		}
+		return nil
+	}
+
+	virus, _, err := u.e.ScanReader(br, path, u.opts)
+	if lr.err == errTooLarge {
+		return lr.err
+	}
+	v := EntryVerdict{Path: path, Size: lr.n, Virus: virus}
+	if lr.err != nil {
+		v.Err = lr.err
+	} else if virus == "" {
+		v.Err = err
+	}
+	u.verdicts = append(u.verdicts, v)
+	return nil
+}
+
+// limitedReader enforces the member and total size limits of an unpacker
+type limitedReader struct {
+	r   io.Reader
+	u   *unpacker
+	n   int64 // bytes read from this member
+	err error // limit that was hit
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	l.u.total += int64(n)
+	if max := l.u.limits.MaxTotalSize; max > 0 && l.u.total > max {
+		l.err = errTooLarge
+		return n, l.err
+	}
+	if max := l.u.limits.MaxEntrySize; max > 0 && l.n > max {
+		l.err = errEntryTooLarge
+		return n, l.err
+	}
+	return n, err
+}
@@ -0,0 +1,129 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func verdictPaths(vs []EntryVerdict) []string {
+	var paths []string
+	for _, v := range vs {
+		paths = append(paths, v.Path)
+	}
+	return paths
+}
+
+func TestScanArtifactGem(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	data := gzipBytes(t, writeTar(t, []tarEntry{
+		{name: "lib/gem.rb", body: []byte("module Gem; end")},
+		{name: "bin/eicar", body: eicar},
+	}))
+	gem := writeTar(t, []tarEntry{
+		{name: "metadata.gz", body: gzipBytes(t, []byte("--- !ruby/object:Gem::Specification"))},
+		{name: "data.tar.gz", body: data},
+	})
+
+	vs, err := eng.ScanArtifact(bytes.NewReader(gem), "test.gem", DefaultArchiveLimits, stdopts)
+	if err != nil {
+		t.Fatalf("ScanArtifact: %v", err)
+	}
+	want := []string{"metadata.gz", "data.tar.gz/lib/gem.rb", "data.tar.gz/bin/eicar"}
+	if got := verdictPaths(vs); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanArtifact: %q, want %q", got, want)
+	}
+	for _, v := range vs {
+		if v.Err != nil {
+			t.Errorf("ScanArtifact: %s: %v", v.Path, v.Err)
+		}
+		if v.Virus != "" && v.Path != "data.tar.gz/bin/eicar" {
+			t.Errorf("ScanArtifact: %s: unexpected virus %s", v.Path, v.Virus)
+		}
+	}
+}
+
+func TestScanArtifactWheel(t *testing.T) {
+	eng := New()
+	defer eng.Free()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"pkg/__init__.py", "pkg-1.0.dist-info/METADATA"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte("# " + name))
+	}
+	zw.Close()
+
+	vs, err := eng.ScanArtifact(&buf, "pkg-1.0-py3-none-any.whl", DefaultArchiveLimits, stdopts)
+	if err != nil {
+		t.Fatalf("ScanArtifact: %v", err)
+	}
+	want := []string{"pkg/__init__.py", "pkg-1.0.dist-info/METADATA"}
+	if got := verdictPaths(vs); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanArtifact: %q, want %q", got, want)
+	}
+}
+
+func TestScanArtifactLimits(t *testing.T) {
+	eng := New()
+	defer eng.Free()
+
+	tgz := gzipBytes(t, writeTar(t, []tarEntry{
+		{name: "package/index.js", body: []byte("module.exports = 1")},
+		{name: "package/big.bin", body: make([]byte, 4096)},
+		{name: "package/package.json", body: []byte("{}")},
+	}))
+
+	limits := ArchiveLimits{MaxEntrySize: 1024}
+	vs, err := eng.ScanArtifact(bytes.NewReader(tgz), "pkg.tgz", limits, stdopts)
+	if err != nil {
+		t.Fatalf("ScanArtifact: %v", err)
+	}
+	if len(vs) != 3 || vs[1].Err != errEntryTooLarge || vs[0].Err != nil || vs[2].Err != nil {
+		t.Errorf("ScanArtifact: MaxEntrySize not enforced: %+v", vs)
+	}
+
+	limits = ArchiveLimits{MaxEntries: 2}
+	if _, err := eng.ScanArtifact(bytes.NewReader(tgz), "pkg.tgz", limits, stdopts); err == nil {
+		t.Errorf("ScanArtifact: MaxEntries not enforced")
+	}
+
+	limits = ArchiveLimits{MaxTotalSize: 2048}
+	if _, err := eng.ScanArtifact(bytes.NewReader(tgz), "pkg.tgz", limits, stdopts); err == nil {
+		t.Errorf("ScanArtifact: MaxTotalSize not enforced")
+	}
+
+	if _, err := eng.ScanArtifact(bytes.NewReader([]byte("plain text")), "x.txt", limits, stdopts); err == nil {
+		t.Errorf("ScanArtifact: unsupported format accepted")
+	}
+}
+
+func TestScanArtifactNoLimits(t *testing.T) {
+	eng := New()
+	defer eng.Free()
+
+	// with no limit on the depth, every nested archive is unpacked
+	inner := writeTar(t, []tarEntry{{name: "lib/index.js", body: []byte("module.exports = 1")}})
+	middle := writeTar(t, []tarEntry{{name: "inner.tar", body: inner}})
+	outer := writeTar(t, []tarEntry{{name: "middle.tar.gz", body: gzipBytes(t, middle)}})
+
+	vs, err := eng.ScanArtifact(bytes.NewReader(outer), "pkg.tar", ArchiveLimits{}, stdopts)
+	if err != nil {
+		t.Fatalf("ScanArtifact: %v", err)
+	}
+	want := []string{"middle.tar.gz/inner.tar/lib/index.js"}
+	if got := verdictPaths(vs); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanArtifact: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"io"
+)
+
+// AuthenticodeStatus describes the Authenticode signature of a PE file. Whether the signer is
+// trusted is decided by libclamav against the .crb databases: files it trusts are reported
+// clean without further scanning.
+type AuthenticodeStatus int
+
+// Authenticode states
+const (
+	AuthenticodeUnknown  AuthenticodeStatus = iota // not checked
+	AuthenticodeUnsigned                           // no signature
+	AuthenticodeSigned                             // carries a well-formed signature
+	AuthenticodeBroken                             // the certificate table is malformed
+)
+
+var authenticodeNames = []string{"unknown", "unsigned", "signed", "broken"}
+
+func (s AuthenticodeStatus) String() string {
+	if s < 0 || int(s) >= len(authenticodeNames) {
+		return "invalid"
+	}
+	return authenticodeNames[s]
+}
+
+// WIN_CERTIFICATE values
+const (
+	winCertRevision1       = 0x0100
+	winCertRevision2       = 0x0200
+	winCertTypePKCSSigned  = 0x0002
+	winCertHeaderSize      = 8
+	securityDirectoryIndex = 4 // IMAGE_DIRECTORY_ENTRY_SECURITY
+)
+
+// authenticodeStatus inspects the certificate table of the PE file in r. It checks that the
+// signature is present and well formed, the signature itself is verified by libclamav.
+func authenticodeStatus(r io.ReaderAt, size int64) AuthenticodeStatus {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return AuthenticodeUnknown
+	}
+	defer f.Close()
+
+	var dir pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if oh.NumberOfRvaAndSizes > securityDirectoryIndex {
+			dir = oh.DataDirectory[securityDirectoryIndex]
+		}
+	case *pe.OptionalHeader64:
+		if oh.NumberOfRvaAndSizes > securityDirectoryIndex {
+			dir = oh.DataDirectory[securityDirectoryIndex]
+		}
+	default:
+		return AuthenticodeUnknown
+	}
+	if dir.Size == 0 {
+		return AuthenticodeUnsigned
+	}
+
+	// unlike other directories, the certificate table is located by file offset
+	if dir.Size < winCertHeaderSize || int64(dir.VirtualAddress)+int64(dir.Size) > size {
+		return AuthenticodeBroken
+	}
+	var hdr [winCertHeaderSize + 1]byte
+	if _, err := r.ReadAt(hdr[:], int64(dir.VirtualAddress)); err != nil {
+		return AuthenticodeBroken
+	}
+	length := binary.LittleEndian.Uint32(hdr[0:])
+	revision := binary.LittleEndian.Uint16(hdr[4:])
+	certType := binary.LittleEndian.Uint16(hdr[6:])
+	switch {
+	case length <= winCertHeaderSize || length > dir.Size:
+		return AuthenticodeBroken
+	case revision != winCertRevision1 && revision != winCertRevision2:
+		return AuthenticodeBroken
+	case certType != winCertTypePKCSSigned:
+		return AuthenticodeBroken
+	case hdr[winCertHeaderSize] != 0x30:
+		// PKCS #7 SignedData is a DER SEQUENCE
+		return AuthenticodeBroken
+	}
+	return AuthenticodeSigned
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// buildPE returns a minimal PE32 file, followed by cert as its certificate table if not nil
+func buildPE(cert []byte) []byte {
+	var buf bytes.Buffer
+	dos := make([]byte, 64)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 64)
+	buf.Write(dos)
+	buf.WriteString("PE\x00\x00")
+	binary.Write(&buf, binary.LittleEndian, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_I386,
+		SizeOfOptionalHeader: uint16(binary.Size(pe.OptionalHeader32{})),
+		Characteristics:      pe.IMAGE_FILE_EXECUTABLE_IMAGE,
+	})
+	oh := pe.OptionalHeader32{Magic: 0x10b, NumberOfRvaAndSizes: 16, Subsystem: pe.IMAGE_SUBSYSTEM_WINDOWS_GUI}
+	if cert != nil {
+		end := buf.Len() + binary.Size(oh)
+		oh.DataDirectory[securityDirectoryIndex] = pe.DataDirectory{VirtualAddress: uint32(end), Size: uint32(len(cert))}
+	}
+	binary.Write(&buf, binary.LittleEndian, oh)
+	buf.Write(cert)
+	return buf.Bytes()
+}
+
+// winCertificate returns a WIN_CERTIFICATE with the given revision and type around a fake
+// PKCS #7 blob
+func winCertificate(revision, certType uint16) []byte {
+	blob := []byte{0x30, 0x03, 0x02, 0x01, 0x01}
+	cert := make([]byte, winCertHeaderSize, winCertHeaderSize+len(blob))
+	binary.LittleEndian.PutUint32(cert, uint32(winCertHeaderSize+len(blob)))
+	binary.LittleEndian.PutUint16(cert[4:], revision)
+	binary.LittleEndian.PutUint16(cert[6:], certType)
+	return append(cert, blob...)
+}
+
+func TestAuthenticodeStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want AuthenticodeStatus
+	}{
+		{"unsigned", buildPE(nil), AuthenticodeUnsigned},
+		{"signed", buildPE(winCertificate(winCertRevision2, winCertTypePKCSSigned)), AuthenticodeSigned},
+		{"bad type", buildPE(winCertificate(winCertRevision2, 1)), AuthenticodeBroken},
+		{"bad revision", buildPE(winCertificate(7, winCertTypePKCSSigned)), AuthenticodeBroken},
+		{"truncated", buildPE(winCertificate(winCertRevision2, winCertTypePKCSSigned))[:220], AuthenticodeUnknown},
+		{"not a PE", []byte("MZ but nothing else"), AuthenticodeUnknown},
+	}
+	for _, tt := range tests {
+		got := authenticodeStatus(bytes.NewReader(tt.data), int64(len(tt.data)))
+		if got != tt.want {
+			t.Errorf("authenticodeStatus: %s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a certificate table pointing past the end of the file
+	data := buildPE(winCertificate(winCertRevision2, winCertTypePKCSSigned))
+	data = data[:len(data)-2]
+	if got := authenticodeStatus(bytes.NewReader(data), int64(len(data))); got != AuthenticodeBroken {
+		t.Errorf("authenticodeStatus: cut certificate: %v", got)
+	}
+}
+
+func TestEngineScannerPE(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	s := &EngineScanner{Engine: eng, Options: &ScanOptions{General: ScanGeneralCollectMetadata, Parse: ScanParsePE}}
+
+	res, err := s.Scan(bytes.NewReader(buildPE(winCertificate(winCertRevision2, winCertTypePKCSSigned))), "signed.exe")
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if res.Metadata == nil || res.Metadata.PE == nil {
+		t.Fatalf("Scan: no PE metadata: %+v", res)
+	}
+	p := res.Metadata.PE
+	if p.Authenticode != AuthenticodeSigned || p.EntryPoint != 0x1000 || !strings.HasPrefix(p.Machine, "Intel") {
+		t.Errorf("Scan: PE metadata: %+v", p)
+	}
+}
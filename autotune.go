@@ -0,0 +1,179 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAutotuneInterval is the time between the adjustments of Autotune if its Interval is
+// zero
+const DefaultAutotuneInterval = 10 * time.Second
+
+// Autotune sizes the workers of an EnginePool to the resources of the host, see
+// EnginePool.Autotune
+type Autotune struct {
+	// MaxWorkers caps the workers, no cap but the CPUs available if zero
+	MaxWorkers int
+
+	// MemoryLimit is the memory the process may use, the limit of its cgroup if zero. Memory
+	// does not limit the workers if neither is set.
+	MemoryLimit int64
+
+	// Interval is the time between adjustments, DefaultAutotuneInterval if zero
+	Interval time.Duration
+
+	// cgroup is the mount point of the cgroup file system, /sys/fs/cgroup if empty
+	cgroup string
+}
+
+// autotuner adjusts the workers of a pool
+type autotuner struct {
+	a       Autotune
+	idle    int64   // resident memory with no scan in progress, once measured
+	perScan float64 // moving average of the memory a scan takes
+	done    chan struct{}
+}
+
+// Autotune sets the workers of the pool from the resources of the host, then adjusts them
+// every Interval until Close. The workers are the CPUs available to the process, as bounded by
+// GOMAXPROCS and by the CPU quota of its cgroup, as long as the memory left by the engine fits
+// that many scans. The memory a scan takes is measured from the growth of the resident memory
+// of the process (Linux only) while the pool scans. Calling Autotune again replaces the
+// settings.
+func (p *EnginePool) Autotune(a Autotune) {
+	if a.Interval <= 0 {
+		a.Interval = DefaultAutotuneInterval
+	}
+	if a.cgroup == "" {
+		a.cgroup = "/sys/fs/cgroup"
+	}
+	t := &autotuner{a: a, done: make(chan struct{})}
+	p.wmu.Lock()
+	if p.tuner != nil {
+		p.tuner.stop()
+	}
+	p.tuner = t
+	p.wmu.Unlock()
+
+	t.tune(p)
+	go func() {
+		tk := time.NewTicker(a.Interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-tk.C:
+				t.tune(p)
+			case <-t.done:
+				return
+			}
+		}
+	}()
+}
+
+func (t *autotuner) stop() {
+	close(t.done)
+}
+
+// tune measures the memory scans take and sets the workers of p
+func (t *autotuner) tune(p *EnginePool) {
+	st := p.Status()
+	if rss, ok := residentMemory(); ok {
+		if st.Inflight == 0 {
+			t.idle = rss
+		} else if t.idle > 0 && rss > t.idle {
+			per := float64(rss-t.idle) / float64(st.Inflight)
+			if t.perScan == 0 {
+				t.perScan = per
+			} else {
+				t.perScan = 0.7*t.perScan + 0.3*per
+			}
+		}
+	}
+	base := t.idle
+	if base == 0 {
+		base = st.Memory.Bytes
+	}
+
+	cpus := runtime.GOMAXPROCS(0)
+	if q := cgroupCPUs(t.a.cgroup); q > 0 && q < cpus {
+		cpus = q
+	}
+	limit := t.a.MemoryLimit
+	if limit <= 0 {
+		limit = cgroupMemory(t.a.cgroup)
+	}
+	p.SetWorkers(autotuneWorkers(cpus, t.a.MaxWorkers, limit, base, t.perScan))
+}
+
+// autotuneWorkers returns the workers for cpus CPUs, capped at max if not zero, and for limit
+// bytes of memory if not zero, of which the process takes base before scanning and every scan
+// perScan
+func autotuneWorkers(cpus, max int, limit, base int64, perScan float64) int {
+	n := cpus
+	if max > 0 && max < n {
+		n = max
+	}
+	if limit > 0 && perScan > 0 {
+		if m := int(float64(limit-base) / perScan); m < n {
+			n = m
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// cgroupCPUs returns the CPUs the quota of the cgroup mounted at root allows, rounded up, zero
+// if there is no quota or it cannot be read
+func cgroupCPUs(root string) int {
+	var quota, period int64
+	if b, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		// cgroup v2: "max 100000" or "200000 100000"
+		f := strings.Fields(string(b))
+		if len(f) != 2 || f[0] == "max" {
+			return 0
+		}
+		quota, _ = strconv.ParseInt(f[0], 10, 64)
+		period, _ = strconv.ParseInt(f[1], 10, 64)
+	} else {
+		quota = readCgroupInt(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+		period = readCgroupInt(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	}
+	if quota <= 0 || period <= 0 {
+		return 0
+	}
+	return int((quota + period - 1) / period)
+}
+
+// cgroupMemory returns the memory limit of the cgroup mounted at root, zero if there is no
+// limit or it cannot be read
+func cgroupMemory(root string) int64 {
+	if b, err := ioutil.ReadFile(filepath.Join(root, "memory.max")); err == nil {
+		n, _ := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		return n
+	}
+	// cgroup v1 reports no limit as a huge number
+	if n := readCgroupInt(filepath.Join(root, "memory", "memory.limit_in_bytes")); n < 1<<62 {
+		return n
+	}
+	return 0
+}
+
+// readCgroupInt returns the number in the cgroup file at path, zero if it cannot be read
+func readCgroupInt(path string) int64 {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return n
+}
@@ -0,0 +1,109 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	v2 := t.TempDir()
+	ioutil.WriteFile(filepath.Join(v2, "cpu.max"), []byte("150000 100000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(v2, "memory.max"), []byte("1073741824\n"), 0644)
+	if n, m := cgroupCPUs(v2), cgroupMemory(v2); n != 2 || m != 1<<30 {
+		t.Errorf("cgroup v2: %d CPUs, %d bytes", n, m)
+	}
+	ioutil.WriteFile(filepath.Join(v2, "cpu.max"), []byte("max 100000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(v2, "memory.max"), []byte("max\n"), 0644)
+	if n, m := cgroupCPUs(v2), cgroupMemory(v2); n != 0 || m != 0 {
+		t.Errorf("cgroup v2: unlimited: %d CPUs, %d bytes", n, m)
+	}
+
+	v1 := t.TempDir()
+	os.Mkdir(filepath.Join(v1, "cpu"), 0755)
+	os.Mkdir(filepath.Join(v1, "memory"), 0755)
+	ioutil.WriteFile(filepath.Join(v1, "cpu", "cpu.cfs_quota_us"), []byte("400000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(v1, "cpu", "cpu.cfs_period_us"), []byte("100000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(v1, "memory", "memory.limit_in_bytes"), []byte("9223372036854771712\n"), 0644)
+	if n, m := cgroupCPUs(v1), cgroupMemory(v1); n != 4 || m != 0 {
+		t.Errorf("cgroup v1: %d CPUs, %d bytes", n, m)
+	}
+}
+
+var autotuneWorkersTests = []struct {
+	cpus, max   int
+	limit, base int64
+	perScan     float64
+	workers     int
+}{
+	{8, 0, 0, 0, 0, 8},
+	{8, 4, 0, 0, 0, 4},
+	{8, 0, 1 << 30, 512 << 20, 128 << 20, 4},
+	{8, 0, 1 << 30, 1 << 30, 128 << 20, 1},
+	{2, 0, 1 << 30, 0, 1 << 20, 2},
+}
+
+func TestAutotuneWorkers(t *testing.T) {
+	for _, tt := range autotuneWorkersTests {
+		if n := autotuneWorkers(tt.cpus, tt.max, tt.limit, tt.base, tt.perScan); n != tt.workers {
+			t.Errorf("autotuneWorkers(%+v) = %d", tt, n)
+		}
+	}
+}
+
+// gatedReader blocks the first read until gate is closed
+type gatedReader struct {
+	gate chan struct{}
+	r    io.Reader
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	<-g.gate
+	return g.r.Read(p)
+}
+
+func TestEnginePoolWorkers(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	eng.Free()
+	p, err := NewEnginePool(LoadEngine(DBDir(), DbStdopt, nil), nil, stdopts)
+	if err != nil {
+		t.Fatalf("NewEnginePool: %v", err)
+	}
+	defer p.Close()
+
+	p.Autotune(Autotune{MaxWorkers: 2, cgroup: t.TempDir()})
+	if st := p.Status(); st.Workers < 1 || st.Workers > 2 {
+		t.Errorf("Autotune: %d workers", st.Workers)
+	}
+
+	p.SetWorkers(1)
+	gate := make(chan struct{})
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := p.Scan(&gatedReader{gate, bytes.NewReader(eicar)}, "eicar.com")
+			done <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if st := p.Status(); st.Inflight != 1 {
+		t.Errorf("Status: %d scans in progress with one worker", st.Inflight)
+	}
+	close(gate)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Scan: %v", err)
+		}
+	}
+}
@@ -22,6 +22,8 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"strings"
+	"sync"
 )
 
 import "github.com/mirtchovski/clamav"
@@ -34,13 +36,34 @@ var workers = flag.Int("workers", 8, "number of scanning workers")
 var cpus = flag.Int("cpus", 2, "number of active OS threads")
 var db = flag.String("db", clamav.DBDir(), "virus definition database")
 var testmap = flag.Bool("testfmap", false, "test memory scanning only")
+var format = flag.String("format", "text", "output format: "+strings.Join(clamav.ResultFormats, ", "))
+var ci = flag.Bool("ci", false, "annotate detections and errors for CI pipelines, print a summary and exit with 0 if clean, 1 if infected, 2 on errors")
+var threshold = flag.Int("threshold", 1, "number of infected files from which -ci fails")
+
+// exit codes, as clamscan's: infected takes precedence over errors so that pipelines gate on it
+const (
+	exitClean    = 0
+	exitInfected = 1
+	exitError    = 2
+)
+
+// scan options: all-match mode, parsing every supported file type
+var opts = &clamav.ScanOptions{General: clamav.ScanGeneralAllmatches, Parse: ^uint32(0)}
+
+// results are written one at a time by the workers
+var results struct {
+	sync.Mutex
+	w clamav.ResultWriter
+
+	scanned, infected, errors int
+}
 
 var eicar = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: %s path [...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s path [...]\n       %s service print|install [flags] path [...]\n", os.Args[0], os.Args[0])
 	flag.PrintDefaults()
-	os.Exit(1)
+	os.Exit(exitError)
 }
 
 // A counter goroutine sits between the walker and the workers and keeps track
@@ -65,12 +88,24 @@ func worker(in, cnt chan string, done chan bool, engine *clamav.Engine) {
 			log.Printf("scanning %s", path)
 		}
 		if *scan {
-			virus, _, err := engine.ScanFileCb(path, clamav.ScanStdopt|clamav.ScanAllmatches, path)
+			virus, _, err := engine.ScanFileCb(path, opts, path)
 			if virus != "" {
-				log.Printf("virus found in %s: %s", path, virus)
-			} else if err != nil {
-				log.Printf("error scanning %s: %v", path, err)
+				err = nil
+			}
+			results.Lock()
+			if err := results.w.WriteResult(&clamav.ScanResult{Name: path, Virus: virus}, err); err != nil {
+				log.Printf("error writing the result of %s: %v", path, err)
+			}
+			results.scanned++
+			switch {
+			case virus != "":
+				results.infected++
+				annotate("error", path, "infected: "+virus)
+			case err != nil:
+				results.errors++
+				annotate("warning", path, "scan failed: "+err.Error())
 			}
+			results.Unlock()
 		}
 	}
 	done <- true
@@ -86,7 +121,7 @@ func walker(path string, in chan string) {
 	// return true.
 	lfi, err := os.Lstat(path)
 	if err != nil {
-		log.Printf("%v", err)
+		walkError(path, err)
 		return
 	}
 	if lfi.Mode()&os.ModeSymlink != 0 {
@@ -98,7 +133,7 @@ func walker(path string, in chan string) {
 	if lfi.IsDir() {
 		dir, err := ioutil.ReadDir(path)
 		if err != nil {
-			log.Printf("%v", err)
+			walkError(path, err)
 			return
 		}
 		for _, v := range dir {
@@ -108,7 +143,7 @@ func walker(path string, in chan string) {
 	}
 	fi, err := os.Stat(path)
 	if err != nil {
-		log.Printf("%v", err)
+		walkError(path, err)
 		return
 	}
 	if fi.IsDir() {
@@ -117,6 +152,42 @@ func walker(path string, in chan string) {
 	in <- path
 }
 
+// walkError reports a file or directory that could not be walked, counted as an error
+func walkError(path string, err error) {
+	log.Printf("%v", err)
+	results.Lock()
+	results.errors++
+	annotate("warning", path, err.Error())
+	results.Unlock()
+}
+
+// annotation escapes the data and properties of workflow commands
+var (
+	annotationData     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	annotationProperty = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// annotate prints, with -ci, an annotation of the file as a workflow command, which GitHub
+// Actions shows on the run and other CI systems log as is. The annotations go to standard
+// error, not to mix with the results.
+func annotate(level, path, msg string) {
+	if !*ci {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "::%s file=%s::%s\n", level, annotationProperty.Replace(path), annotationData.Replace(msg))
+}
+
+// exitCode returns the exit code of a -ci scan
+func exitCode() int {
+	switch {
+	case results.infected >= *threshold && results.infected > 0:
+		return exitInfected
+	case results.errors > 0:
+		return exitError
+	}
+	return exitClean
+}
+
 func preCacheCb(fd int, ftype string, context interface{}) clamav.ErrorCode {
 	if *debug {
 		log.Printf("pre cache callback for %s: fd=%d ftype=%s", context, fd, ftype)
@@ -154,7 +225,8 @@ func initClamAV() *clamav.Engine {
 	engine := clamav.New()
 	sigs, err := engine.Load(*db, clamav.DbStdopt)
 	if err != nil {
-		log.Fatalf("can not initialize ClamAV engine: %v", err)
+		log.Printf("can not initialize ClamAV engine: %v", err)
+		os.Exit(exitError)
 	}
 	if *debug {
 		log.Printf("loaded %d signatures", sigs)
@@ -173,6 +245,11 @@ func initClamAV() *clamav.Engine {
 func main() {
 	var engine *clamav.Engine
 
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		serviceMain(os.Args[2:])
+		return
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 
@@ -189,6 +266,13 @@ func main() {
 
 	runtime.GOMAXPROCS(*cpus)
 
+	w, err := clamav.NewResultWriter(os.Stdout, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		usage()
+	}
+	results.w = w
+
 	if *scan {
 		log.Println("initializing ClamAV database...")
 		engine = initClamAV()
@@ -198,7 +282,7 @@ func main() {
 		fmap := clamav.OpenMemory(eicar)
 		defer clamav.CloseMemory(fmap)
 
-		virus, _, err := engine.ScanMapCb(fmap, clamav.ScanStdopt|clamav.ScanAllmatches, "eicar memorytest")
+		virus, _, err := engine.ScanMapCb(fmap, "eicar", opts, "eicar memorytest")
 		if err != nil {
 			log.Printf("error scanning in-memory: %v\n", err)
 		}
@@ -231,6 +315,14 @@ func main() {
 	for i := 0; i < *workers; i++ {
 		<-done
 	}
+	if err := results.w.Close(); err != nil {
+		log.Printf("error writing results: %v", err)
+	}
 
 	log.Println("scan completed...")
+
+	if *ci {
+		fmt.Fprintf(os.Stderr, "scanned=%d infected=%d errors=%d threshold=%d\n", results.scanned, results.infected, results.errors, *threshold)
+		os.Exit(exitCode())
+	}
 }
@@ -0,0 +1,79 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+import "github.com/mirtchovski/clamav"
+
+// serviceMain runs "avclient service print|install [flags] [avclient flags] path [...]", which
+// generates, and installs, the systemd unit and timer or launchd job scanning the paths with
+// the given avclient flags every -interval
+func serviceMain(args []string) {
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	name := fs.String("name", "avclient", "name of the service")
+	interval := fs.Duration("interval", 24*time.Hour, "time between scans")
+	user := fs.String("user", "", "user the scans run as, root if empty")
+	dir := fs.String("dir", "", "directory the files are installed in, "+clamav.SystemdUnitDir+" or "+clamav.LaunchdDaemonDir+" if empty")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s service print|install [flags] [--] [avclient flags] path [...]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(exitError)
+	}
+	if len(args) == 0 || (args[0] != "print" && args[0] != "install") {
+		fs.Usage()
+	}
+	cmd := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "error: missing path")
+		fs.Usage()
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitError)
+	}
+	svc := &clamav.Service{
+		Name:        *name,
+		Description: "ClamAV scan with avclient",
+		Exec:        append([]string{exe}, fs.Args()...),
+		User:        *user,
+		Interval:    *interval,
+	}
+
+	if cmd == "install" {
+		paths, err := svc.Install(*dir)
+		for _, p := range paths {
+			fmt.Println(p)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitError)
+		}
+		return
+	}
+	var out string
+	if runtime.GOOS == "darwin" {
+		out, err = svc.LaunchdPlist()
+	} else if out, err = svc.SystemdUnit(); err == nil {
+		out = fmt.Sprintf("# %s.service\n%s\n# %s.timer\n%s", *name, out, *name, svc.SystemdTimer())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitError)
+	}
+	fmt.Print(out)
+}
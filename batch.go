@@ -0,0 +1,161 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#include <clamav.h>
+#include <stdlib.h>
+
+// scan_batch scans n objects laid out in arena, each with its name following its data, in one
+// call from Go
+static void scan_batch(struct cl_engine *engine, struct cl_scan_options *opts, void *context,
+	char *arena, size_t *off, size_t *len, int n, cl_error_t *ret, const char **virname,
+	unsigned long *scanned)
+{
+	int i;
+	for (i = 0; i < n; i++) {
+		cl_fmap_t *map = cl_fmap_open_memory(arena + off[i], len[i]);
+		if (map == NULL) {
+			ret[i] = CL_EMEM;
+			continue;
+		}
+		virname[i] = NULL;
+		scanned[i] = 0;
+		ret[i] = cl_scanmap_callback(map, arena + off[i] + len[i], &virname[i], &scanned[i], engine, opts, context);
+		cl_fmap_close(map);
+	}
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// batchArenaSize is the most data ScanBatch copies for libclamav at once. Objects larger than
+// that are scanned on their own, as with ScanBytes.
+const batchArenaSize = 4 << 20
+
+// BatchResult is the result of the scan of one object of ScanBatch, as returned by ScanBytes,
+// but for Scanned being counted for clean objects scanned in a batch too
+type BatchResult struct {
+	Virus   string
+	Scanned uint
+	Err     error
+}
+
+// ScanBatch scans many small in-memory objects, such as mail parts or chat attachments, named
+// by names. The objects are scanned in as few calls into libclamav as possible, sharing the
+// setup of the callback context, so that the cost of crossing into C is paid once per batch
+// rather than once per object. Results are returned in the order of bufs.
+func (e *Engine) ScanBatch(bufs [][]byte, names []string, opts *ScanOptions) ([]BatchResult, error) {
+	return e.scanBatch(bufs, names, opts, nil)
+}
+
+// scanBatch implements ScanBatch, passing context to the callbacks
+func (e *Engine) scanBatch(bufs [][]byte, names []string, opts *ScanOptions, context interface{}) ([]BatchResult, error) {
+	if len(names) != len(bufs) {
+		return nil, fmt.Errorf("ScanBatch: %d objects but %d names", len(bufs), len(names))
+	}
+	if opts == nil {
+		return nil, fmt.Errorf("ScanBatch: %v", errNilOptions)
+	}
+	for _, name := range names {
+		// the names are copied NUL-terminated into the arena
+		if strings.IndexByte(name, 0) >= 0 {
+			return nil, fmt.Errorf("ScanBatch: %q: NUL byte in string", name)
+		}
+	}
+	res := make([]BatchResult, len(bufs))
+	if currentSkipPolicy() != nil {
+		// the pre_cache callback needs the content of each object in its context
+		for i, buf := range bufs {
+			res[i].Virus, res[i].Scanned, res[i].Err = e.scanBytes(buf, names[i], opts, context)
+		}
+		return res, nil
+	}
+
+	a := currentAllowlist()
+	var batch []int
+	size := 0
+	for i, buf := range bufs {
+		if len(buf) == 0 || (a != nil && a.allowedData(buf)) {
+			// nothing to scan
+			continue
+		}
+		if len(buf)+len(names[i])+1 > batchArenaSize {
+			res[i].Virus, res[i].Scanned, res[i].Err = e.scanBytes(buf, names[i], opts, context)
+			continue
+		}
+		if size+len(buf)+len(names[i])+1 > batchArenaSize {
+			e.scanArena(bufs, names, batch, size, opts, context, res)
+			batch, size = batch[:0], 0
+		}
+		batch = append(batch, i)
+		size += len(buf) + len(names[i]) + 1
+	}
+	if len(batch) > 0 {
+		e.scanArena(bufs, names, batch, size, opts, context, res)
+	}
+	return res, nil
+}
+
+// scanArena copies the objects of bufs at the indices of batch, size bytes with their names,
+// to C memory and scans them in a single call, storing the results in res
+func (e *Engine) scanArena(bufs [][]byte, names []string, batch []int, size int, opts *ScanOptions, context interface{}, res []BatchResult) {
+	n := len(batch)
+	// the data and the arrays are allocated in C since libclamav may not hold on to Go memory
+	arena := C.malloc(C.size_t(size + 1))
+	sizes := C.malloc(C.size_t(n) * 2 * C.size_t(unsafe.Sizeof(C.size_t(0))))
+	rets := C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof(C.cl_error_t(0))))
+	virnames := C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof((*C.char)(nil))))
+	scanned := C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof(C.ulong(0))))
+	if arena == nil || sizes == nil || rets == nil || virnames == nil || scanned == nil {
+		panic("C malloc")
+	}
+	defer func() {
+		for _, p := range []unsafe.Pointer{arena, sizes, rets, virnames, scanned} {
+			C.free(p)
+		}
+	}()
+
+	mem := unsafe.Slice((*byte)(arena), size+1)
+	offs := unsafe.Slice((*C.size_t)(sizes), 2*n)
+	pos := 0
+	for j, i := range batch {
+		offs[j], offs[n+j] = C.size_t(pos), C.size_t(len(bufs[i]))
+		pos += copy(mem[pos:], bufs[i])
+		pos += copy(mem[pos:], names[i])
+		mem[pos] = 0
+		pos++
+	}
+
+	cctx := setContext(context)
+	defer deleteContext(cctx)
+	C.scan_batch((*C.struct_cl_engine)(e), (*C.struct_cl_scan_options)(unsafe.Pointer(opts)), unsafe.Pointer(cctx),
+		(*C.char)(arena), &offs[0], &offs[n], C.int(n), (*C.cl_error_t)(rets), (**C.char)(virnames), (*C.ulong)(scanned))
+
+	// a panicking callback stops the scan of the object as if it had detected it
+	p := takePanic(cctx)
+	errs := unsafe.Slice((*C.cl_error_t)(rets), n)
+	vs := unsafe.Slice((**C.char)(virnames), n)
+	ss := unsafe.Slice((*C.ulong)(scanned), n)
+	for j, i := range batch {
+		switch err := ErrorCode(errs[j]); err {
+		case Success:
+			res[i].Scanned = uint(ss[j])
+		case Virus:
+			if p != nil && C.GoString(vs[j]) == detectedByCallback {
+				res[i].Err = p
+				break
+			}
+			res[i] = BatchResult{C.GoString(vs[j]), uint(ss[j]), fmt.Errorf("%v", StrError(err))}
+		default:
+			res[i].Err = fmt.Errorf("%v", StrError(err))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestScanBatch(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	large := append(bytes.Repeat([]byte("x"), batchArenaSize), eicar...)
+	bufs := [][]byte{[]byte("clean"), eicar, nil, large, eicar}
+	names := []string{"a", "b", "c", "d", "e"}
+	for i := 0; i < 2000; i++ {
+		bufs = append(bufs, bytes.Repeat([]byte("y"), 4096))
+		names = append(names, fmt.Sprint(i))
+	}
+	res, err := eng.ScanBatch(bufs, names, stdopts)
+	if err != nil {
+		t.Fatalf("ScanBatch: %v", err)
+	}
+	for i, buf := range bufs {
+		virus, _, err := eng.ScanBytes(buf, names[i], stdopts)
+		if res[i].Virus != virus || (res[i].Err == nil) != (err == nil) {
+			t.Errorf("ScanBatch: %s: %+v, ScanBytes: %q %v", names[i], res[i], virus, err)
+		}
+	}
+	if res[1].Virus == "" || res[3].Virus == "" {
+		t.Errorf("ScanBatch: eicar not found: %+v", res[:5])
+	}
+	if res[0].Scanned == 0 || res[5].Scanned == 0 || res[2].Scanned != 0 {
+		t.Errorf("ScanBatch: scanned counts: %+v", res[:6])
+	}
+
+	if _, err := eng.ScanBatch(bufs, names[:1], stdopts); err == nil {
+		t.Errorf("ScanBatch: missing names accepted")
+	}
+	if _, err := eng.ScanBatch(bufs[:1], names[:1], nil); err == nil {
+		t.Errorf("ScanBatch: nil options accepted")
+	}
+	if _, err := eng.ScanBatch(bufs[:1], []string{"a\x00b"}, stdopts); err == nil {
+		t.Errorf("ScanBatch: name with a NUL byte accepted")
+	}
+}
+
+// BenchmarkScanBatch compares scanning many small objects with ScanBytes, one at a time, and
+// with ScanBatch
+func BenchmarkScanBatch(b *testing.B) {
+	eng, err := testInitAll()
+	if err != nil {
+		b.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	bufs := make([][]byte, 1000)
+	names := make([]string, len(bufs))
+	for i := range bufs {
+		bufs[i] = bytes.Repeat([]byte{byte(i)}, 256)
+		names[i] = fmt.Sprintf("part%d", i)
+	}
+	b.Run("Bytes", func(b *testing.B) {
+		b.SetBytes(256 * int64(len(bufs)))
+		for i := 0; i < b.N; i++ {
+			for j, buf := range bufs {
+				eng.ScanBytes(buf, names[j], stdopts)
+			}
+		}
+	})
+	b.Run("Batch", func(b *testing.B) {
+		b.SetBytes(256 * int64(len(bufs)))
+		for i := 0; i < b.N; i++ {
+			eng.ScanBatch(bufs, names, stdopts)
+		}
+	})
+}
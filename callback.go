@@ -14,42 +14,200 @@ cl_error_t precache_cgo(int fd, const char *type, void *context);
 cl_error_t prescan_cgo(int fd, const char *type, void *context);
 cl_error_t postscan_cgo(int fd, int result, char *virname, void *context);
 
+void msg_cgo(enum cl_msg severity, const char *fullmsg, const char *msg, void *context);
 void hash_cgo(int fd, unsigned long long size, const unsigned char *md5, const char *virname, void *context);
+int fileprops_cgo(const char *j_propstr, int rc, void *cbdata);
+cl_error_t meta_cgo(const char *container_type, unsigned long fsize_container, const char *filename, unsigned long fsize_real, int is_encrypted, unsigned int filepos_container, void *context);
 */
 import "C"
-import "unsafe"
+import (
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
 
-var callbackFuncs = map[string]interface{}{
-	"precache": nil,
-	"prescan":  nil,
-	"postscan": nil,
-	"sigload":  nil,
-	"hash":     nil,
-	"msg":      nil,
-	"meta":     nil,
+// callbackFuncs holds the callbacks and the settings consulted from them. They are
+// process-wide: libclamav calls the same callbacks for all engines, and they may be set while
+// scans are in progress.
+var callbackFuncs = struct {
+	sync.RWMutex
+	m map[string]interface{}
+}{m: map[string]interface{}{
+	"precache":  nil,
+	"prescan":   nil,
+	"postscan":  nil,
+	"sigload":   nil,
+	"hash":      nil,
+	"msg":       nil,
+	"meta":      nil,
+	"allowlist": nil,
+	"skip":      nil,
+	"stats":     nil,
+}}
+
+// callbackFunc returns the callback or setting stored as name, nil if none
+func callbackFunc(name string) interface{} {
+	callbackFuncs.RLock()
+	defer callbackFuncs.RUnlock()
+	return callbackFuncs.m[name]
+}
+
+// setCallbackFunc stores v as the callback or setting name
+func setCallbackFunc(name string, v interface{}) {
+	callbackFuncs.Lock()
+	callbackFuncs.m[name] = v
+	callbackFuncs.Unlock()
+}
+
+// scanContext is passed as the context of scans for which the package itself needs information
+// from the callbacks. The callbacks set by the user get the user context it wraps.
+type scanContext struct {
+	user     interface{}
+	fileType string // type of the top level object, the first one reported before caching
+	metadata string // JSON metadata, with ScanGeneralCollectMetadata
+
+	// inspect, if set, is called by scanReader with the scanned data once the scan is over
+	inspect func(r io.ReaderAt, size int64)
+
+	// hasher, if set, is written the data scanReader reads
+	hasher *hasher
+
+	// usage, if set, accounts for the resources scanReader uses
+	usage *ScanUsage
+
+	// timer, if set, accounts for the time the phases of the scan take
+	timer *scanTimer
+
+	// tmpdir, if set, is where scanReader spools large objects
+	tmpdir string
+
+	// memoryLimit, if set, is the largest object scanReader scans from memory
+	memoryLimit int64
+
+	// aborted, if set, tells whether to abort the scan at the next object libclamav reports
+	aborted func() bool
+
+	// encrypted are the encrypted archive members libclamav reported
+	encrypted []EncryptedObject
+
+	// extract, if set, is called with the objects libclamav unpacks, open as fd
+	extract func(fd int, fileType string)
+
+	// data is the object scanned from memory, if it is
+	data []byte
+}
+
+// hookedEngines records the engines on which the package installed its own callbacks, along
+// with their references, so that an engine later allocated at the address of a freed one is
+// hooked too
+var hookedEngines = struct {
+	sync.Mutex
+	m map[*Engine]int
+}{m: map[*Engine]int{}}
+
+// hook installs the callbacks filling scanContexts on e, keeping the callbacks set by the user
+func (e *Engine) hook() {
+	hookedEngines.Lock()
+	defer hookedEngines.Unlock()
+	if hookedEngines.m[e] > 0 {
+		return
+	}
+	hookedEngines.m[e] = 1
+	C.cl_engine_set_clcb_pre_cache((*C.struct_cl_engine)(unsafe.Pointer(e)), (C.clcb_pre_cache)(unsafe.Pointer(C.precache_cgo)))
+	C.cl_engine_set_clcb_file_props((*C.struct_cl_engine)(unsafe.Pointer(e)), (C.clcb_file_props)(unsafe.Pointer(C.fileprops_cgo)))
+	C.cl_engine_set_clcb_meta((*C.struct_cl_engine)(unsafe.Pointer(e)), (C.clcb_meta)(unsafe.Pointer(C.meta_cgo)))
+}
+
+// refHook counts a reference to e taken with Addref, or released with Free if delta is
+// negative, forgetting that e was hooked along with its last reference
+func (e *Engine) refHook(delta int) {
+	hookedEngines.Lock()
+	defer hookedEngines.Unlock()
+	if n := hookedEngines.m[e]; n > 0 {
+		if n += delta; n <= 0 {
+			delete(hookedEngines.m, e)
+		} else {
+			hookedEngines.m[e] = n
+		}
+	}
+}
+
+//export metaCallback
+func metaCallback(ctype *C.char, csize C.ulong, name *C.char, size C.ulong, encrypted C.int, pos C.uint, context unsafe.Pointer) C.cl_error_t {
+	sc, _ := lookupContext(context)
+	if sc != nil {
+		sc.timer.member()
+	}
+	if sc != nil && encrypted != 0 {
+		sc.encrypted = append(sc.encrypted, EncryptedObject{Name: C.GoString(name), Container: C.GoString(ctype)})
+	}
+	return Clean
+}
+
+//export filepropsCallback
+func filepropsCallback(props *C.char, rc C.int, context unsafe.Pointer) C.int {
+	if sc, _ := lookupContext(context); sc != nil {
+		sc.metadata = C.GoString(props)
+	}
+	// the scan result is replaced with what the callback returns
+	return rc
 }
 
 //export precacheCallback
-func precacheCallback(fd C.int, ftype *C.char, context unsafe.Pointer) C.cl_error_t {
-	fn := callbackFuncs["precache"]
+func precacheCallback(fd C.int, ftype *C.char, context unsafe.Pointer) (ret C.cl_error_t) {
+	defer recoverCallback("precache", context, func() { ret = Virus })
+	sc, ctx := lookupContext(context)
+	if sc != nil && sc.aborted != nil && sc.aborted() {
+		// stops the scan, the caller discards the result
+		return Virus
+	}
+	// the first object reported is the top level one
+	top := sc != nil && sc.fileType == ""
+	if sc != nil {
+		sc.timer.object(top)
+	}
+	if top {
+		sc.fileType = C.GoString(ftype)
+	} else if sc != nil && sc.extract != nil && fd >= 0 {
+		sc.extract(int(fd), C.GoString(ftype))
+	}
+	if p := currentSkipPolicy(); p != nil {
+		var skip bool
+		switch {
+		case fd >= 0:
+			skip = p.skipDesc(int(fd), C.GoString(ftype))
+		case top:
+			skip = p.skipData(sc.data, C.GoString(ftype))
+		default:
+			skip = p.skipData(nil, C.GoString(ftype))
+		}
+		if skip {
+			return Break
+		}
+	}
+	if a := currentAllowlist(); a != nil && fd >= 0 && a.allowedDesc(int(fd)) {
+		return Break
+	}
+	fn := callbackFunc("precache")
 	if fn == nil {
 		return Clean
 	}
-	ctx := findContext(context)
 	return C.cl_error_t(fn.(CallbackPreCache)(int(fd), C.GoString(ftype), ctx))
 }
 
 // SetPreCacheCallback sets the callback function to use with ClamAV's
 // pre_cache callback
 func (e *Engine) SetPreCacheCallback(cb CallbackPreCache) {
-	callbackFuncs["precache"] = cb
+	setCallbackFunc("precache", cb)
 
 	C.cl_engine_set_clcb_pre_cache((*C.struct_cl_engine)(unsafe.Pointer(e)), (C.clcb_pre_cache)(unsafe.Pointer(C.precache_cgo)))
 }
 
 //export prescanCallback
-func prescanCallback(fd C.int, ftype *C.char, context unsafe.Pointer) C.cl_error_t {
-	v := callbackFuncs["prescan"]
+func prescanCallback(fd C.int, ftype *C.char, context unsafe.Pointer) (ret C.cl_error_t) {
+	defer recoverCallback("prescan", context, func() { ret = Virus })
+	v := callbackFunc("prescan")
 	if v == nil {
 		return Clean
 	}
@@ -60,13 +218,14 @@ func prescanCallback(fd C.int, ftype *C.char, context unsafe.Pointer) C.cl_error
 // SetPreScanCallback will set the callback function ClamAV will call before a
 // scan commences to the specified function
 func (e *Engine) SetPreScanCallback(cb CallbackPreScan) {
-	callbackFuncs["prescan"] = cb
+	setCallbackFunc("prescan", cb)
 	C.cl_engine_set_clcb_pre_scan((*C.struct_cl_engine)(unsafe.Pointer(e)), C.clcb_pre_scan(unsafe.Pointer(C.prescan_cgo)))
 }
 
 //export postscanCallback
-func postscanCallback(fd, result C.int, virname *C.char, context unsafe.Pointer) C.cl_error_t {
-	v := callbackFuncs["postscan"]
+func postscanCallback(fd, result C.int, virname *C.char, context unsafe.Pointer) (ret C.cl_error_t) {
+	defer recoverCallback("postscan", context, func() { ret = Virus })
+	v := callbackFunc("postscan")
 	if v == nil {
 		return Clean
 	}
@@ -77,7 +236,7 @@ func postscanCallback(fd, result C.int, virname *C.char, context unsafe.Pointer)
 // SetPostScanCallback will set the callback function ClamAV will call before the
 // cache is consulted for a particular scan to cb
 func (e *Engine) SetPostScanCallback(cb CallbackPostScan) {
-	callbackFuncs["postscan"] = cb
+	setCallbackFunc("postscan", cb)
 	C.cl_engine_set_clcb_post_scan((*C.struct_cl_engine)(unsafe.Pointer(e)), (C.clcb_post_scan)(unsafe.Pointer(C.postscan_cgo)))
 }
 
@@ -87,7 +246,15 @@ func (e *Engine) SetPostScanCallback(cb CallbackPostScan) {
 var preadHandleCallbacks = map[*interface{}]CallbackPread{}
 
 //export preadCallback
-func preadCallback(handle unsafe.Pointer, buf unsafe.Pointer, count C.size_t, offset C.off_t) C.off_t {
+func preadCallback(handle unsafe.Pointer, buf unsafe.Pointer, count C.size_t, offset C.off_t) (n C.off_t) {
+	// the handle is not the key of a scan, the scan fails with a read error
+	defer recoverCallback("pread", nil, func() { n = -1 })
+	scanHandles.Lock()
+	h := scanHandles.m[handle]
+	scanHandles.Unlock()
+	if h != nil {
+		return C.off_t(h.pread(unsafe.Slice((*byte)(buf), int(count)), int64(offset)))
+	}
 	v, ok := preadHandleCallbacks[(*interface{})(handle)]
 	if !ok {
 		return -1 // couldn't find callback
@@ -101,14 +268,22 @@ func preadCallback(handle unsafe.Pointer, buf unsafe.Pointer, count C.size_t, of
 //	return 0
 // }
 
-//export msgcb
-var msgcb = func(severity C.enum_cl_msg, fullmsg *C.char, msg *C.char, context unsafe.Pointer) {
-	v := callbackFuncs["msg"]
-	if v == nil {
+// msgCallbackC is the message callback installed in libclamav, calling msgCallback
+var msgCallbackC = C.clcb_msg(C.msg_cgo)
+
+//export msgCallback
+func msgCallback(severity C.enum_cl_msg, fullmsg *C.char, msg *C.char, context unsafe.Pointer) {
+	defer recoverCallback("msg", context, nil)
+	if context == nil {
+		loadMessage(Msg(severity), C.GoString(msg))
+	}
+	cb, _ := callbackFunc("msg").(CallbackMsg)
+	if cb == nil {
+		// logged as libclamav would without a callback, which prints every message it emits
+		os.Stderr.WriteString(C.GoString(fullmsg))
 		return
 	}
-	ctx := findContext(context)
-	v.(CallbackMsg)(Msg(severity), C.GoString(fullmsg), C.GoString(msg), ctx)
+	cb(Msg(severity), C.GoString(fullmsg), C.GoString(msg), findContext(context))
 }
 
 // SetMsgCallback will set the callback function ClamAV will call for any error and warning
@@ -118,13 +293,22 @@ var msgcb = func(severity C.enum_cl_msg, fullmsg *C.char, msg *C.char, context u
 // Just like with cl_debug() this must be called before going multithreaded.
 // Callable before cl_init, if you want to log messages from cl_init() itself.
 func SetMsgCallback(cb CallbackMsg) {
-	callbackFuncs["msg"] = cb
-	C.cl_set_clcb_msg((C.clcb_msg)(unsafe.Pointer(&msgcb)))
+	setCallbackFunc("msg", cb)
+	C.cl_set_clcb_msg(msgCallbackC)
+}
+
+var msgHook sync.Once
+
+// hookMsg installs the message callback, for the package to capture the warnings of database
+// loads, logging the other messages to stderr as usual unless SetMsgCallback was called
+func hookMsg() {
+	msgHook.Do(func() { C.cl_set_clcb_msg(msgCallbackC) })
 }
 
 //export hashCallback
 func hashCallback(fd C.int, size C.ulonglong, md5 *C.uchar, virname *C.char, context unsafe.Pointer) {
-	v := callbackFuncs["hash"]
+	defer recoverCallback("hash", context, nil)
+	v := callbackFunc("hash")
 	if v == nil {
 		return
 	}
@@ -135,7 +319,7 @@ func hashCallback(fd C.int, size C.ulonglong, md5 *C.uchar, virname *C.char, con
 // SetHashCallback will set the callback function ClamAV will call with statistics
 // about the scanned file
 func (e *Engine) SetHashCallback(cb CallbackHash) {
-	callbackFuncs["hash"] = cb
+	setCallbackFunc("hash", cb)
 
 	C.cl_engine_set_clcb_hash((*C.struct_cl_engine)(unsafe.Pointer(e)), (C.clcb_hash)(unsafe.Pointer(C.hash_cgo)))
 }
@@ -158,12 +342,28 @@ func FmapOpenMemory(buf []byte) *Fmap {
 	if len(buf) == 0 {
 		return nil
 	}
-	return (*Fmap)(C.cl_fmap_open_memory(unsafe.Pointer(&buf[0]), C.size_t(len(buf))))
+	f := (*Fmap)(C.cl_fmap_open_memory(unsafe.Pointer(&buf[0]), C.size_t(len(buf))))
+	if f != nil {
+		fmapBuffers.Lock()
+		fmapBuffers.m[f] = buf
+		fmapBuffers.Unlock()
+	}
+	return f
 }
 
+// fmapBuffers records the memory of the maps opened by FmapOpenMemory, for ScanMapRange. It
+// also keeps the memory alive for as long as the map is open.
+var fmapBuffers = struct {
+	sync.Mutex
+	m map[*Fmap][]byte
+}{m: map[*Fmap][]byte{}}
+
 // Close resources associated with the map, you should release any resources
 // you hold only after (handles, maps) calling this function */
 func (f *Fmap) Close() {
+	fmapBuffers.Lock()
+	delete(fmapBuffers.m, f)
+	fmapBuffers.Unlock()
 	C.cl_fmap_close((*C.struct_cl_fmap)(f))
 }
 
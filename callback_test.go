@@ -34,7 +34,7 @@ func TestScanMapCb(t *testing.T) {
 	}
 	defer fmap.Close()
 
-	virus, scan, err := eng.ScanMapCb(fmap, ScanStdopt, nil)
+	virus, scan, err := eng.ScanMapCb(fmap, "eicar", stdopts, nil)
 	if err != nil {
 		if virus != "" {
 			if virus != eicarvirname {
@@ -51,3 +51,53 @@ func TestFmapClose(t *testing.T) {
 	}
 	fmap.Close()
 }
+
+func TestScanMapRange(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	// a container with a clean member, the eicar file, and another clean member
+	buf := append(append([]byte("clean member"), eicar...), "trailer"...)
+	fmap := FmapOpenMemory(buf)
+	if fmap == nil {
+		t.Fatalf("FmapOpenMemory failed")
+	}
+	defer fmap.Close()
+
+	if virus, _, _ := eng.ScanMapRange(fmap, 0, 12, "first", stdopts, nil); virus != "" {
+		t.Errorf("ScanMapRange: clean member: virus = %s", virus)
+	}
+	if virus, _, _ := eng.ScanMapRange(fmap, 12, int64(len(eicar)), "eicar", stdopts, nil); virus != "Eicar-Test-Signature" {
+		t.Errorf("ScanMapRange: eicar member: virus = %q", virus)
+	}
+	if _, _, err := eng.ScanMapRange(fmap, 12, int64(len(buf)), "overflow", stdopts, nil); err == nil {
+		t.Errorf("ScanMapRange: out of range accepted")
+	}
+}
+
+func TestHookFree(t *testing.T) {
+	eng := New()
+	eng.hook()
+	hooked := func() bool {
+		hookedEngines.Lock()
+		defer hookedEngines.Unlock()
+		return hookedEngines.m[eng] > 0
+	}
+	if !hooked() {
+		t.Fatalf("hook: engine not recorded")
+	}
+
+	// the engine stays hooked until the last reference is freed
+	eng.Addref()
+	eng.Free()
+	if !hooked() {
+		t.Errorf("Free: engine forgotten with a reference left")
+	}
+	eng.Free()
+	if hooked() {
+		t.Errorf("Free: freed engine still recorded as hooked")
+	}
+}
@@ -33,5 +33,92 @@ void hash_cgo(int fd, unsigned long long size, const unsigned char *md5, const c
 {
 	return hashCallback(fd, size, md5, virname, context);
 }
+
+extern cl_error_t metaCallback(char *container_type, unsigned long fsize_container, char *filename, unsigned long fsize_real, int is_encrypted, unsigned int filepos_container, void *context);
+cl_error_t meta_cgo(const char *container_type, unsigned long fsize_container, const char *filename, unsigned long fsize_real, int is_encrypted, unsigned int filepos_container, void *context)
+{
+	return metaCallback((char *)container_type, fsize_container, (char *)filename, fsize_real, is_encrypted, filepos_container, context);
+}
+
+extern cl_error_t progressCallback(size_t total_items, size_t now_completed, void *context);
+cl_error_t progress_cgo(size_t total_items, size_t now_completed, void *context)
+{
+	return progressCallback(total_items, now_completed, context);
+}
+
+extern void msgCallback(enum cl_msg severity, char *fullmsg, char *msg, void *context);
+void msg_cgo(enum cl_msg severity, const char *fullmsg, const char *msg, void *context)
+{
+	msgCallback(severity, (char *)fullmsg, (char *)msg, context);
+}
+
+// call_clcb_msg calls cb as libclamav calls its message callback
+void call_clcb_msg(clcb_msg cb, enum cl_msg severity, const char *fullmsg, const char *msg)
+{
+	cb(severity, fullmsg, msg, NULL);
+}
+
+extern int filepropsCallback(char *j_propstr, int rc, void *cbdata);
+int fileprops_cgo(const char *j_propstr, int rc, void *cbdata)
+{
+	return filepropsCallback((char *)j_propstr, rc, cbdata);
+}
+extern void statsAddSampleCallback(char *virname, unsigned char *md5, size_t size, stats_section_t *sections, void *cbdata);
+void stats_add_sample_cgo(const char *virname, const unsigned char *md5, size_t size, stats_section_t *sections, void *cbdata)
+{
+	statsAddSampleCallback((char *)virname, (unsigned char *)md5, size, sections, cbdata);
+}
+
+extern void statsRemoveSampleCallback(char *virname, unsigned char *md5, size_t size, void *cbdata);
+void stats_remove_sample_cgo(const char *virname, const unsigned char *md5, size_t size, void *cbdata)
+{
+	statsRemoveSampleCallback((char *)virname, (unsigned char *)md5, size, cbdata);
+}
+
+extern void statsDecrementCountCallback(char *virname, unsigned char *md5, size_t size, void *cbdata);
+void stats_decrement_count_cgo(const char *virname, const unsigned char *md5, size_t size, void *cbdata)
+{
+	statsDecrementCountCallback((char *)virname, (unsigned char *)md5, size, cbdata);
+}
+
+extern void statsSubmitCallback(struct cl_engine *engine, void *cbdata);
+void stats_submit_cgo(struct cl_engine *engine, void *cbdata)
+{
+	statsSubmitCallback(engine, cbdata);
+}
+
+extern void statsFlushCallback(struct cl_engine *engine, void *cbdata);
+void stats_flush_cgo(struct cl_engine *engine, void *cbdata)
+{
+	statsFlushCallback(engine, cbdata);
+}
+
+extern size_t statsGetNumCallback(void *cbdata);
+size_t stats_get_num_cgo(void *cbdata)
+{
+	return statsGetNumCallback(cbdata);
+}
+
+extern size_t statsGetSizeCallback(void *cbdata);
+size_t stats_get_size_cgo(void *cbdata)
+{
+	return statsGetSizeCallback(cbdata);
+}
+
+extern char *statsGetHostidCallback(void *cbdata);
+char *stats_get_hostid_cgo(void *cbdata)
+{
+	return statsGetHostidCallback(cbdata);
+}
 */
 import "C"
+import "unsafe"
+
+// logMessage passes a message to the message callback installed in libclamav, as libclamav
+// does when logging outside of a scan; it lets the tests go through the C callback
+func logMessage(severity Msg, fullmsg, msg string) {
+	cfull, cmsg := C.CString(fullmsg), C.CString(msg)
+	defer C.free(unsafe.Pointer(cfull))
+	defer C.free(unsafe.Pointer(cmsg))
+	C.call_clcb_msg(msgCallbackC, C.enum_cl_msg(severity), cfull, cmsg)
+}
@@ -0,0 +1,16 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build darwin && amd64 && !clamav_nodefaultpaths && !clamav_pkgconfig
+// +build darwin,amd64,!clamav_nodefaultpaths,!clamav_pkgconfig
+
+package clamav
+
+// Homebrew installs under /usr/local on Intel Macs, MacPorts under /opt/local
+
+/*
+#cgo CFLAGS:-I/usr/local/include -I/opt/local/include -I/usr/local/clamav/include
+#cgo LDFLAGS:-L/usr/local/lib -L/usr/local/lib/x86_64 -L/opt/local/lib -L/usr/local/clamav/lib
+*/
+import "C"
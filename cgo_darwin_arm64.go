@@ -0,0 +1,16 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build darwin && arm64 && !clamav_nodefaultpaths && !clamav_pkgconfig
+// +build darwin,arm64,!clamav_nodefaultpaths,!clamav_pkgconfig
+
+package clamav
+
+// Homebrew installs under /opt/homebrew on Apple silicon, MacPorts under /opt/local
+
+/*
+#cgo CFLAGS:-I/opt/homebrew/include -I/opt/local/include -I/usr/local/clamav/include
+#cgo LDFLAGS:-L/opt/homebrew/lib -L/opt/local/lib -L/usr/local/clamav/lib
+*/
+import "C"
@@ -0,0 +1,16 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build clamav_pkgconfig
+// +build clamav_pkgconfig
+
+package clamav
+
+// Built with the clamav_pkgconfig tag, the flags are those pkg-config gives for libclamav, which
+// honors PKG_CONFIG_PATH for installations anywhere
+
+/*
+#cgo pkg-config: libclamav
+*/
+import "C"
@@ -0,0 +1,19 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !darwin && !windows && !clamav_nodefaultpaths && !clamav_pkgconfig
+// +build !darwin,!windows,!clamav_nodefaultpaths,!clamav_pkgconfig
+
+package clamav
+
+// The packages of Linux distributions and of the BSDs install libclamav where the compiler
+// looks by default, or under /usr/local; builds from source install it under /usr/local, or
+// /usr/local/clamav. Build with the clamav_nodefaultpaths tag to search none of these, and set
+// CGO_CFLAGS and CGO_LDFLAGS instead, or with the clamav_pkgconfig tag to ask pkg-config.
+
+/*
+#cgo CFLAGS:-I/usr/local/include -I/usr/local/clamav/include
+#cgo LDFLAGS:-L/usr/local/lib -L/usr/local/lib64 -L/usr/local/clamav/lib
+*/
+import "C"
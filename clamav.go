@@ -8,8 +8,8 @@ package clamav
 
 /*
 #cgo darwin CPPFLAGS:-Wno-incompatible-pointer-types-discards-qualifiers
-#cgo CFLAGS:-I/usr/local/clamav/include
-#cgo LDFLAGS:-L/usr/local/lib -lclamav
+#cgo CFLAGS:-D_FILE_OFFSET_BITS=64
+#cgo LDFLAGS:-lclamav
 
 #include <clamav.h>
 #include <stdlib.h>
@@ -17,8 +17,17 @@ package clamav
 import "C"
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -51,12 +60,27 @@ func setContext(i interface{}) unsafe.Pointer {
 }
 
 func findContext(key unsafe.Pointer) interface{} {
+	_, user := lookupContext(key)
+	return user
+}
+
+// lookupContext returns the context stored under key, along with the state the package keeps
+// for the scan if it was started with a scanContext. Scans started without a context, such as
+// with ScanDesc, call back with a nil key and have neither.
+func lookupContext(key unsafe.Pointer) (*scanContext, interface{}) {
+	if key == nil {
+		return nil, nil
+	}
 	callbacks.Lock()
 	defer callbacks.Unlock()
-	if v, ok := callbacks.cb[key]; ok {
-		return v
+	v, ok := callbacks.cb[key]
+	if !ok {
+		panic("no context for callback")
+	}
+	if sc, ok := v.(*scanContext); ok {
+		return sc, sc.user
 	}
-	panic("no context for callback")
+	return nil, v
 }
 
 func deleteContext(key unsafe.Pointer) {
@@ -64,6 +88,7 @@ func deleteContext(key unsafe.Pointer) {
 	defer callbacks.Unlock()
 	if _, ok := callbacks.cb[key]; ok {
 		delete(callbacks.cb, key)
+		callbackPanics.Delete(key)
 		C.free(key)
 		return
 	}
@@ -109,6 +134,8 @@ func (e *Engine) Addref() error {
 	if ErrorCode(err) != Success {
 		return fmt.Errorf("%v", StrError(ErrorCode(err)))
 	}
+	e.refMemory(1)
+	e.refHook(1)
 	return nil
 }
 
@@ -117,6 +144,8 @@ func (e *Engine) Addref() error {
 // by the Go garbage collector, Free should be called when the engine is no
 // longer in use.
 func (e *Engine) Free() int {
+	e.refMemory(-1)
+	e.refHook(-1)
 	return int(C.cl_engine_free((*C.struct_cl_engine)(e)))
 }
 
@@ -147,7 +176,10 @@ func (e *Engine) GetNum(field EngineField) (uint64, error) {
 // SetString sets a string in the corresponding field of the engine configuration.
 // See dat.go for the corresponding (char *) fields in ClamAV.
 func (e *Engine) SetString(field EngineField, s string) error {
-	str := C.CString(s)
+	str, serr := cString(s)
+	if serr != nil {
+		return serr
+	}
 	defer C.free(unsafe.Pointer(str))
 
 	err := C.cl_engine_set_str((*C.struct_cl_engine)(e), C.enum_cl_engine_field(field), str)
@@ -193,15 +225,20 @@ func FreeSettings(s *Settings) error {
 
 // Compile makes the engine functional
 func (e *Engine) Compile() error {
-	err := ErrorCode(C.cl_engine_compile((*C.struct_cl_engine)(e)))
-	if err != Success {
-		return fmt.Errorf("%v", StrError(err))
-	}
-	return nil
+	return e.measureMemory(func(m *EngineMemory) *int64 { return &m.Compiled }, func() error {
+		err := ErrorCode(C.cl_engine_compile((*C.struct_cl_engine)(e)))
+		if err != Success {
+			return fmt.Errorf("%v", StrError(err))
+		}
+		return nil
+	})
 }
 
 // ScanDesc scans a file descriptor with the provided engine
 func (e *Engine) ScanDesc(filename string, desc int, opts *ScanOptions) (string, uint, error) {
+	if opts == nil {
+		return "", 0, errNilOptions
+	}
 	var name *C.char
 	var scanned C.ulong
 	cFilename := C.CString(filename)
@@ -211,9 +248,35 @@ func (e *Engine) ScanDesc(filename string, desc int, opts *ScanOptions) (string,
 		return "", 0, nil
 	}
 	if err == Virus {
-		return C.GoString(name), uint(scanned), fmt.Errorf(StrError(err))
+		return C.GoString(name), uint(scanned), fmt.Errorf("%v", StrError(err))
 	}
-	return "", 0, fmt.Errorf(StrError(err))
+	return "", 0, fmt.Errorf("%v", StrError(err))
+}
+
+// ScanDescCb scans a file descriptor like ScanDesc, passing context to the callbacks
+func (e *Engine) ScanDescCb(filename string, desc int, opts *ScanOptions, context interface{}) (string, uint, error) {
+	if opts == nil {
+		return "", 0, errNilOptions
+	}
+	var name *C.char
+	var scanned C.ulong
+	cFilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cFilename))
+
+	cctx := setContext(context)
+	defer deleteContext(cctx)
+
+	err := ErrorCode(C.cl_scandesc_callback(C.int(desc), cFilename, &name, &scanned, (*C.struct_cl_engine)(e), (*C.struct_cl_scan_options)(unsafe.Pointer(opts)), cctx))
+	if p := takePanic(cctx); p != nil {
+		return "", 0, p
+	}
+	if err == Success {
+		return "", 0, nil
+	}
+	if err == Virus {
+		return C.GoString(name), uint(scanned), fmt.Errorf("%v", StrError(err))
+	}
+	return "", 0, fmt.Errorf("%v", StrError(err))
 }
 
 // ScanFile scans a single file for viruses using the ClamAV databases. It returns the virus name
@@ -222,18 +285,24 @@ func (e *Engine) ScanDesc(filename string, desc int, opts *ScanOptions) (string,
 // virus is found the error code will be the corresponding string for Virus (currently "Virus(es)
 // detected").
 func (e *Engine) ScanFile(path string, opts *ScanOptions) (string, uint, error) {
+	if opts == nil {
+		return "", 0, errNilOptions
+	}
 	var name *C.char
 	var scanned C.ulong
-	cpath := C.CString(path)
+	cpath, perr := cString(path)
+	if perr != nil {
+		return "", 0, perr
+	}
 	defer C.free(unsafe.Pointer(cpath))
 	err := ErrorCode(C.cl_scanfile(cpath, &name, &scanned, (*C.struct_cl_engine)(e), (*C.struct_cl_scan_options)(unsafe.Pointer(opts))))
 	if err == Success {
 		return "", 0, nil
 	}
 	if err == Virus {
-		return C.GoString(name), uint(scanned), fmt.Errorf(StrError(err))
+		return C.GoString(name), uint(scanned), fmt.Errorf("%v", StrError(err))
 	}
-	return "", 0, fmt.Errorf(StrError(err))
+	return "", 0, fmt.Errorf("%v", StrError(err))
 }
 
 // ScanFileCb scans a single file for viruses using the ClamAV databases and using callbacks from
@@ -246,10 +315,16 @@ func (e *Engine) ScanFile(path string, opts *ScanOptions) (string, uint, error)
 // The context argument will be sent back to the callbacks, so effort must be made to retain it
 // throughout the execution of the scan from garbage collection
 func (e *Engine) ScanFileCb(path string, opts *ScanOptions, context interface{}) (string, uint, error) {
+	if opts == nil {
+		return "", 0, errNilOptions
+	}
 	var name *C.char
 	var scanned C.ulong
 	// pass a C-allocated pointer to the path to avoid crashing with garbage collector
-	cpath := C.CString(path)
+	cpath, perr := cString(path)
+	if perr != nil {
+		return "", 0, perr
+	}
 	defer C.free(unsafe.Pointer(cpath))
 
 	// find where to store the context in our callback map. we do _not_ pass the context to
@@ -259,27 +334,36 @@ func (e *Engine) ScanFileCb(path string, opts *ScanOptions, context interface{})
 	defer deleteContext(cctx)
 
 	err := ErrorCode(C.cl_scanfile_callback(cpath, &name, &scanned, (*C.struct_cl_engine)(e), (*C.struct_cl_scan_options)(unsafe.Pointer(opts)), cctx))
+	if p := takePanic(cctx); p != nil {
+		return "", 0, p
+	}
 	if err == Success {
 		return "", 0, nil
 	}
 	if err == Virus {
-		return C.GoString(name), uint(scanned), fmt.Errorf(StrError(err))
+		return C.GoString(name), uint(scanned), fmt.Errorf("%v", StrError(err))
 	}
-	return "", 0, fmt.Errorf(StrError(err))
+	return "", 0, fmt.Errorf("%v", StrError(err))
 }
 
 // OpenMemory creates an object from the given memory that can be scanned using ScanMapCb
 func OpenMemory(start []byte) *Fmap {
-	return (*Fmap)(C.cl_fmap_open_memory(unsafe.Pointer(&start[0]), C.size_t(len(start))))
+	return FmapOpenMemory(start)
 }
 
 // CloseMemory destroys the fmap associated with an in-memory object
 func CloseMemory(f *Fmap) {
-	C.cl_fmap_close((*C.cl_fmap_t)(f))
+	f.Close()
 }
 
 // ScanMapCb scans custom data
 func (e *Engine) ScanMapCb(fmap *Fmap, filename string, opts *ScanOptions, context interface{}) (string, uint, error) {
+	if fmap == nil {
+		return "", 0, fmt.Errorf("ScanMapCb: nil map")
+	}
+	if opts == nil {
+		return "", 0, errNilOptions
+	}
 	var name *C.char
 	var scanned C.ulong
 
@@ -292,27 +376,274 @@ func (e *Engine) ScanMapCb(fmap *Fmap, filename string, opts *ScanOptions, conte
 	defer C.free(unsafe.Pointer(cfilename))
 
 	err := ErrorCode(C.cl_scanmap_callback((*C.cl_fmap_t)(fmap), cfilename, &name, &scanned, (*C.struct_cl_engine)(e), (*C.struct_cl_scan_options)(unsafe.Pointer(opts)), unsafe.Pointer(cctx)))
+	if p := takePanic(cctx); p != nil {
+		return "", 0, p
+	}
 	if err == Success {
 		return "", 0, nil
 	}
 	if err == Virus {
-		return C.GoString(name), uint(scanned), fmt.Errorf(StrError(err))
+		return C.GoString(name), uint(scanned), fmt.Errorf("%v", StrError(err))
+	}
+	return "", 0, fmt.Errorf("%v", StrError(err))
+}
+
+// ScanMapRange scans length bytes at offset of a map opened with FmapOpenMemory, as if they
+// were a file of their own, without copying them. This lets applications scan the members of
+// containers they parse themselves. Results are returned as for ScanMapCb.
+func (e *Engine) ScanMapRange(fmap *Fmap, offset, length int64, filename string, opts *ScanOptions, context interface{}) (string, uint, error) {
+	fmapBuffers.Lock()
+	buf, ok := fmapBuffers.m[fmap]
+	fmapBuffers.Unlock()
+	if !ok {
+		return "", 0, fmt.Errorf("ScanMapRange: not an open memory map")
+	}
+	if offset < 0 || length < 0 || offset > int64(len(buf)) || length > int64(len(buf))-offset {
+		return "", 0, fmt.Errorf("ScanMapRange: range %d+%d out of map of %d bytes", offset, length, len(buf))
+	}
+
+	sub := FmapOpenMemory(buf[offset : offset+length])
+	if sub == nil {
+		// nothing to scan
+		return "", 0, nil
 	}
-	return "", 0, fmt.Errorf(StrError(err))
+	defer sub.Close()
+	return e.ScanMapCb(sub, filename, opts, context)
+}
+
+// ScanBytes scans an in-memory object, such as a file extracted from an archive. The filename is
+// only used by ClamAV for reporting and file type hints. Results are returned as for ScanFile.
+func (e *Engine) ScanBytes(buf []byte, filename string, opts *ScanOptions) (string, uint, error) {
+	return e.scanBytes(buf, filename, opts, nil)
+}
+
+// scanBytes implements ScanBytes, passing context to the callbacks
+func (e *Engine) scanBytes(buf []byte, filename string, opts *ScanOptions, context interface{}) (string, uint, error) {
+	if a := currentAllowlist(); a != nil && a.allowedData(buf) {
+		return "", 0, nil
+	}
+	if currentSkipPolicy() != nil {
+		// let the pre_cache callback know the size and content of the object
+		sc, ok := context.(*scanContext)
+		if !ok {
+			sc = &scanContext{user: context}
+			context = sc
+		}
+		sc.data = buf
+	}
+	fmap := FmapOpenMemory(buf)
+	if fmap == nil {
+		// nothing to scan
+		return "", 0, nil
+	}
+	defer fmap.Close()
+	// the map refers to buf directly, keep it alive until the scan is over
+	defer runtime.KeepAlive(buf)
+
+	return e.ScanMapCb(fmap, filename, opts, context)
+}
+
+// readerMemoryLimit is the largest object ScanReader will hold in memory, unless an
+// EngineScanner sets its MemoryLimit. Larger objects are spooled to a temporary file first.
+const readerMemoryLimit = 16 << 20
+
+// readerBuffers are the buffers ScanReader reads objects into, reused across scans up to
+// readerBufferMax bytes so that scanning many small objects doesn't churn the heap
+var readerBuffers = sync.Pool{New: func() interface{} { b := make([]byte, 0, 512); return &b }}
+
+const readerBufferMax = 4 << 20
+
+// readAll appends the data read from r until EOF to buf, like ioutil.ReadAll
+func readAll(buf []byte, r io.Reader) ([]byte, error) {
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			return buf, nil
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
+}
+
+// seekableFile returns r if it is a regular file read from its start, along with its size
+func seekableFile(r io.Reader) (*os.File, int64, bool) {
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil, 0, false
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, 0, false
+	}
+	if off, err := f.Seek(0, io.SeekCurrent); err != nil || off != 0 {
+		return nil, 0, false
+	}
+	return f, fi.Size(), true
+}
+
+// ScanReader scans the data read from r until EOF. Regular files read from their start are
+// scanned from their descriptor, like with ScanDesc. Other small objects are scanned from
+// memory, larger ones are copied to a temporary file in the default temporary directory, which
+// has no name where the system allows and is removed once the scan completes, see spoolFile.
+// Results are returned as for ScanFile.
+func (e *Engine) ScanReader(r io.Reader, filename string, opts *ScanOptions) (string, uint, error) {
+	return e.scanReader(r, filename, opts, nil)
+}
+
+// scanReader implements ScanReader, passing context to the callbacks
+func (e *Engine) scanReader(r io.Reader, filename string, opts *ScanOptions, context interface{}) (string, uint, error) {
+	sc, _ := context.(*scanContext)
+	var usage *ScanUsage
+	var timer *scanTimer
+	if sc != nil {
+		usage, timer = sc.usage, sc.timer
+	}
+	var err error
+	var virus string
+	var scanned uint
+	if f, size, ok := seekableFile(r); ok {
+		if err := checkMapSize(size); err != nil {
+			return "", 0, fmt.Errorf("ScanReader: %w", err)
+		}
+		// scan the descriptor directly rather than a copy of the file
+		if sc != nil && sc.hasher != nil {
+			start := time.Now()
+			if _, err := io.Copy(sc.hasher, io.NewSectionReader(f, 0, size)); err != nil {
+				return "", 0, fmt.Errorf("ScanReader: %v", err)
+			}
+			timer.read(start)
+		}
+		if usage != nil {
+			usage.BytesRead += size
+		}
+		usage.measure(func() {
+			timer.engine(func() { virus, scanned, err = e.ScanDescCb(filename, int(f.Fd()), opts, context) })
+		})
+		if sc != nil && sc.inspect != nil {
+			sc.inspect(f, size)
+		}
+		f.Seek(size, io.SeekStart)
+		return virus, scanned, err
+	}
+	if sc != nil && sc.hasher != nil {
+		r = io.TeeReader(r, sc.hasher)
+	}
+	if usage != nil {
+		r = &usageReader{r, usage}
+	}
+
+	limit := int64(readerMemoryLimit)
+	if sc != nil && sc.memoryLimit > 0 {
+		limit = sc.memoryLimit
+	}
+	start := time.Now()
+	bp := readerBuffers.Get().(*[]byte)
+	buf, err := readAll((*bp)[:0], io.LimitReader(r, limit+1))
+	defer func() {
+		if cap(buf) <= readerBufferMax {
+			*bp = buf[:0]
+			readerBuffers.Put(bp)
+		}
+	}()
+	if err != nil {
+		return "", 0, fmt.Errorf("ScanReader: %v", err)
+	}
+	if int64(len(buf)) <= limit {
+		timer.read(start)
+		usage.measure(func() {
+			timer.engine(func() { virus, scanned, err = e.scanBytes(buf, filename, opts, context) })
+		})
+		if sc != nil && sc.inspect != nil {
+			sc.inspect(bytes.NewReader(buf), int64(len(buf)))
+		}
+		return virus, scanned, err
+	}
+
+	tmpdir := ""
+	if sc != nil {
+		tmpdir = sc.tmpdir
+	}
+	f, cleanup, err := spoolFile(tmpdir)
+	if err != nil {
+		return "", 0, fmt.Errorf("ScanReader: %v", err)
+	}
+	defer cleanup()
+
+	size, err := io.Copy(f, io.MultiReader(bytes.NewReader(buf), r))
+	if err != nil {
+		return "", 0, fmt.Errorf("ScanReader: %v", err)
+	}
+	if err := checkMapSize(size); err != nil {
+		return "", 0, fmt.Errorf("ScanReader: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, fmt.Errorf("ScanReader: %v", err)
+	}
+	timer.read(start)
+	usage.measure(func() {
+		timer.engine(func() { virus, scanned, err = e.ScanDescCb(filename, int(f.Fd()), opts, context) })
+	})
+	if sc != nil && sc.inspect != nil {
+		sc.inspect(f, size)
+	}
+	return virus, scanned, err
 }
 
 // Load loads a single database file or all databases depending on whether its first argument
 // (path) points to a file or a directory. A number of loaded signatures will be added to signo
-// (the virus counter should be initialized to zero initially)
+// (the virus counter should be initialized to zero initially). Errors are of type
+// *DatabaseError.
 func (e *Engine) Load(path string, dbopts uint) (uint, error) {
-	var signo uint
-	cpath := C.CString(path)
+	var signo C.uint
+	cpath, err := cString(path)
+	if err != nil {
+		return 0, &DatabaseError{Path: path, Code: Earg, Err: err}
+	}
 	defer C.free(unsafe.Pointer(cpath))
-	err := ErrorCode(C.cl_load(cpath, (*C.struct_cl_engine)(e), (*C.uint)(unsafe.Pointer(&signo)), C.uint(dbopts)))
-	if err != Success {
-		return 0, fmt.Errorf("Load: %v", StrError(err))
+	err = e.measureMemory(func(m *EngineMemory) *int64 { return &m.Loaded }, func() error {
+		err := ErrorCode(C.cl_load(cpath, (*C.struct_cl_engine)(e), &signo, C.uint(dbopts)))
+		if err != Success {
+			return databaseError(path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint(signo), nil
+}
+
+// loadDatabases loads databases generated in memory, by file name. libclamav recognizes
+// databases by their extension, so they are written to a temporary directory first.
+func (e *Engine) loadDatabases(files map[string]string, dbopts uint) (uint, error) {
+	dir, err := ioutil.TempDir("", "clamav")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			return 0, err
+		}
+	}
+	return e.Load(dir, dbopts)
+}
+
+// errNilOptions is the error of scans without options, which libclamav would dereference
+var errNilOptions = errors.New("nil scan options")
+
+// cString returns s as a C string, to be freed, failing if s holds a NUL byte, which would
+// make libclamav see a shorter path or setting than the one given
+func cString(s string) (*C.char, error) {
+	if strings.IndexByte(s, 0) >= 0 {
+		return nil, fmt.Errorf("%q: NUL byte in string", s)
 	}
-	return signo, nil
+	return C.CString(s), nil
 }
 
 // DBDir returns the directory where the virus database is located
@@ -323,11 +654,13 @@ func DBDir() string {
 // StatIniDir initializes the Stat structure so the internal state of the database
 // can be checked for errors stat should not be reused across calls to Stat*
 func StatIniDir(dir string, stat *Stat) error {
-	p := C.CString(dir)
+	p, err := cString(dir)
+	if err != nil {
+		return fmt.Errorf("StatIniDir: %v", err)
+	}
 	defer C.free(unsafe.Pointer(p))
-	err := ErrorCode(C.cl_statinidir(p, (*C.struct_cl_stat)(stat)))
-	if err != Success {
-		return fmt.Errorf("StatIniDir: %v", StrError(err))
+	if cerr := ErrorCode(C.cl_statinidir(p, (*C.struct_cl_stat)(stat))); cerr != Success {
+		return fmt.Errorf("StatIniDir: %v", StrError(cerr))
 	}
 	return nil
 }
@@ -365,15 +698,17 @@ func StatChkReload(stat *Stat) (bool, error) {
 // CountSigs counts the number of signatures that can be loaded from
 // the directory in path.
 func CountSigs(path string, options uint) (uint, error) {
-	var cnt uint
+	var cnt C.uint
 
-	p := C.CString(path)
+	p, err := cString(path)
+	if err != nil {
+		return 0, fmt.Errorf("CountSigs: %v", err)
+	}
 	defer C.free(unsafe.Pointer(p))
-	err := ErrorCode(C.cl_countsigs(p, C.uint(options), (*C.uint)(unsafe.Pointer(&cnt))))
-	if err != Success {
-		return 0, fmt.Errorf("CountSigs: %v", StrError(err))
+	if cerr := ErrorCode(C.cl_countsigs(p, C.uint(options), &cnt)); cerr != Success {
+		return 0, fmt.Errorf("CountSigs: %v", StrError(cerr))
 	}
-	return cnt, nil
+	return uint(cnt), nil
 }
 
 // Debug enables debug messages from libclamav
@@ -0,0 +1,399 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ClamdClient is a Scanner that submits data to a clamd daemon with the INSTREAM command.
+// Every command uses a new connection.
+type ClamdClient struct {
+	Network string        // "tcp" or "unix"
+	Address string        // host:port, or the path of the clamd socket
+	Timeout time.Duration // deadline for a complete command, no limit if zero
+
+	// TLSConfig, if set, secures connections with TLS. clamd itself only speaks cleartext, so
+	// this is for a ClamdServer or for clamd behind a TLS terminating proxy. The server name
+	// defaults to the host of Address.
+	TLSConfig *tls.Config
+
+	// Token, if set, is sent with an AUTH command at the start of every connection, as
+	// expected by a ClamdServer with a Token
+	Token string
+
+	// Hashes requests the digests of the scanned streams in the results of Scan
+	Hashes bool
+
+	// ChunkSize is the size of the chunks data is streamed in, 32 KiB if zero. Data is read
+	// from the scanned reader a chunk at a time, so streams are never buffered in full.
+	ChunkSize int
+
+	// WriteTimeout is the deadline for sending each chunk of a stream, so that a scan fails
+	// rather than hangs when clamd stops reading; no limit if zero
+	WriteTimeout time.Duration
+
+	// Retries is the number of times a command is retried after failing to reach clamd, such
+	// as while clamd restarts, waiting RetryDelay in between. Only commands that are safe to
+	// repeat are retried: scans only if none of their data was read yet, or if it can be read
+	// again by seeking back.
+	Retries    int
+	RetryDelay time.Duration // DefaultClamdRetryDelay if zero
+
+	// HealthInterval, if not zero, is how often sessions ping clamd while idle, to notice a
+	// broken connection before a scan does
+	HealthInterval time.Duration
+
+	// IdleTimeout, if not zero, ends the connection of sessions idle for that long, before clamd
+	// drops it after its own IdleTimeout. The session reconnects on its next request.
+	IdleTimeout time.Duration
+}
+
+// DefaultClamdRetryDelay is the default delay between the retries of a ClamdClient
+const DefaultClamdRetryDelay = 500 * time.Millisecond
+
+// NewClamdClient returns a client for the clamd daemon listening at addr, either a unix socket
+// path ("/run/clamav/clamd.ctl" or "unix:/run/clamav/clamd.ctl") or a TCP address
+// ("localhost:3310", "tcp://localhost:3310", or "tls://localhost:3310" to use TLS).
+func NewClamdClient(addr string) *ClamdClient {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return &ClamdClient{Network: "unix", Address: strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")}
+	case strings.HasPrefix(addr, "/"):
+		return &ClamdClient{Network: "unix", Address: addr}
+	case strings.HasPrefix(addr, "tls://"):
+		return &ClamdClient{Network: "tcp", Address: strings.TrimPrefix(addr, "tls://"), TLSConfig: &tls.Config{}}
+	}
+	return &ClamdClient{Network: "tcp", Address: strings.TrimPrefix(addr, "tcp://")}
+}
+
+// clamdChunkSize is the default size of the chunks data is streamed to clamd in
+const clamdChunkSize = 32 << 10
+
+// errInstreamStopped is the error of writing a stream clamd replied to before its end
+var errInstreamStopped = errors.New("clamd: stream stopped by an early reply")
+
+// ClamdError is an error reported by clamd in reply to a command
+type ClamdError string
+
+func (e ClamdError) Error() string {
+	return "clamd: " + string(e)
+}
+
+func (c *ClamdClient) String() string {
+	return c.Network + ":" + c.Address
+}
+
+// dial connects to clamd, setting the command deadline, and sets up TLS and authentication
+func (c *ClamdClient) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(c.Network, c.Address, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	if c.TLSConfig != nil {
+		cfg := c.TLSConfig
+		if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+			cfg = cfg.Clone()
+			cfg.ServerName, _, _ = net.SplitHostPort(c.Address)
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	if c.Token != "" {
+		if _, err := io.WriteString(conn, "zAUTH "+c.Token+"\x00"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		// nothing follows the reply until the next command, so it can be read with a
+		// throwaway buffer
+		reply, err := readClamdReply(bufio.NewReader(conn))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if reply != "OK" {
+			conn.Close()
+			return nil, ClamdError(strings.TrimSuffix(reply, " ERROR"))
+		}
+	}
+	return conn, nil
+}
+
+// retry reports whether a command that failed with err after attempt retries is to be retried,
+// after waiting for the retry delay
+func (c *ClamdClient) retry(attempt int, err error) bool {
+	if err == nil || isClamdError(err) || attempt >= c.Retries {
+		return false
+	}
+	d := c.RetryDelay
+	if d <= 0 {
+		d = DefaultClamdRetryDelay
+	}
+	time.Sleep(d)
+	return true
+}
+
+// command sends a command without arguments and returns the reply, retrying as configured
+func (c *ClamdClient) command(cmd string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		reply, err := c.commandOnce(cmd)
+		if !c.retry(attempt, err) {
+			return reply, err
+		}
+	}
+}
+
+// commandOnce sends a command without arguments and returns the reply
+func (c *ClamdClient) commandOnce(cmd string) (string, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "z"+cmd+"\x00"); err != nil {
+		return "", err
+	}
+	return readClamdReply(bufio.NewReader(conn))
+}
+
+// readClamdReply reads a single NUL terminated reply
+func readClamdReply(r *bufio.Reader) (string, error) {
+	reply, err := r.ReadString(0)
+	if err != nil {
+		if err == io.EOF && reply != "" {
+			return strings.TrimSpace(reply), nil
+		}
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// Ping checks that clamd is alive
+func (c *ClamdClient) Ping() error {
+	reply, err := c.command("PING")
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return ClamdError(reply)
+	}
+	return nil
+}
+
+// Version returns the version of clamd and of its virus database
+func (c *ClamdClient) Version() (string, error) {
+	return c.command("VERSION")
+}
+
+// Scan streams the data read from r to clamd
+func (c *ClamdClient) Scan(r io.Reader, name string) (*ScanResult, error) {
+	seeker, _ := r.(io.Seeker)
+	var start int64
+	if seeker != nil && c.Retries > 0 {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		res, read, err := c.scan(r, name)
+		if read && seeker == nil || !c.retry(attempt, err) {
+			return res, err
+		}
+		if read {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// countingReader counts the bytes read from r and records its failure
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error // other than io.EOF
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	return n, err
+}
+
+// scan streams the data read from r to clamd once, reporting whether any of it was read
+func (c *ClamdClient) scan(r io.Reader, name string) (*ScanResult, bool, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	cr := &countingReader{r: r}
+	r = cr
+
+	var h *hasher
+	if c.Hashes {
+		h = newHasher()
+		r = io.TeeReader(r, h)
+	}
+	// clamd replies and stops reading when the stream exceeds its size limit, so the reply is
+	// read while the data is sent, to stop sending as soon as it arrives
+	type result struct {
+		reply string
+		err   error
+	}
+	done := make(chan result, 1)
+	stop := make(chan struct{})
+	go func() {
+		reply, err := readClamdReply(bufio.NewReader(conn))
+		if err == nil {
+			close(stop)
+			// interrupt a chunk being sent
+			conn.SetWriteDeadline(time.Now())
+		}
+		done <- result{reply, err}
+	}()
+	iw := c.instreamWriter()
+	iw.stop = stop
+	if c.Timeout > 0 {
+		iw.deadline = time.Now().Add(c.Timeout)
+	}
+	werr := iw.write(conn, r)
+	if cr.err != nil {
+		// clamd waits for the rest of the stream and will not reply
+		conn.SetDeadline(time.Now())
+	} else if werr != nil && werr != errInstreamStopped && c.WriteTimeout > 0 {
+		// a reply explaining the failure is only waited for so long
+		conn.SetReadDeadline(time.Now().Add(c.WriteTimeout))
+	}
+	rr := <-done
+	reply, err := rr.reply, rr.err
+	if err != nil {
+		if werr != nil {
+			return nil, cr.n > 0, werr
+		}
+		return nil, cr.n > 0, err
+	}
+	virus, err := parseScanReply(reply)
+	if err != nil {
+		return nil, cr.n > 0, err
+	}
+	res := &ScanResult{Name: name, Virus: virus, Encrypted: encryptedObjects(nil, virus, "", nil)}
+	if h != nil && werr == nil {
+		res.Hashes = h.sum()
+	}
+	return res, true, nil
+}
+
+// instreamWriter returns the writer of the INSTREAM commands of the client
+func (c *ClamdClient) instreamWriter() *instreamWriter {
+	return &instreamWriter{chunk: c.ChunkSize, timeout: c.WriteTimeout}
+}
+
+// instreamWriter sends INSTREAM commands
+type instreamWriter struct {
+	chunk    int           // size of the chunks, clamdChunkSize if zero
+	timeout  time.Duration // for sending each chunk, no limit if zero
+	deadline time.Time     // of the whole command, none if zero
+
+	// stop, if not nil, is closed when clamd replied before the end of the stream
+	stop <-chan struct{}
+}
+
+// write sends an INSTREAM command with the data read from r, as chunks prefixed by their
+// length in network byte order and terminated by an empty chunk
+func (iw *instreamWriter) write(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+	chunk := iw.chunk
+	if chunk <= 0 {
+		chunk = clamdChunkSize
+	}
+	buf := make([]byte, 4+chunk)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if err := iw.send(w, buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return iw.send(w, []byte{0, 0, 0, 0})
+}
+
+// send writes a chunk within the deadlines, unless clamd already replied
+func (iw *instreamWriter) send(w io.Writer, b []byte) error {
+	if d, ok := w.(interface{ SetWriteDeadline(time.Time) error }); ok && (iw.timeout > 0 || iw.stop != nil) {
+		dl := iw.deadline
+		if iw.timeout > 0 && (dl.IsZero() || time.Now().Add(iw.timeout).Before(dl)) {
+			dl = time.Now().Add(iw.timeout)
+		}
+		d.SetWriteDeadline(dl)
+	}
+	// checked after setting the deadline, which the reader of the reply resets after closing stop
+	select {
+	case <-iw.stop:
+		return errInstreamStopped
+	default:
+	}
+	_, err := w.Write(b)
+	if err != nil && iw.stop != nil {
+		select {
+		case <-iw.stop:
+			return errInstreamStopped
+		default:
+		}
+	}
+	return err
+}
+
+// parseScanReply interprets the reply to a scan command, such as "stream: OK",
+// "stream: Eicar-Test-Signature FOUND" or "INSTREAM size limit exceeded. ERROR"
+func parseScanReply(reply string) (string, error) {
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		reply = strings.TrimSuffix(reply, " FOUND")
+		if i := strings.LastIndex(reply, ": "); i >= 0 {
+			reply = reply[i+2:]
+		}
+		return reply, nil
+	case strings.HasSuffix(reply, ": OK"):
+		return "", nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return "", ClamdError(strings.TrimSuffix(reply, " ERROR"))
+	case reply == "":
+		return "", errors.New("clamd: empty reply")
+	}
+	return "", fmt.Errorf("clamd: unexpected reply %q", reply)
+}
@@ -0,0 +1,544 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// fakeClamd serves PING, VERSION, STATS and INSTREAM, alone or in an IDSESSION, flagging streams containing the EICAR string
+func fakeClamd(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeClamd(conn)
+		}
+	}()
+	return l
+}
+
+func serveFakeClamd(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	if cmd != "zIDSESSION\x00" {
+		if reply, ok := fakeClamdReply(cmd, r); ok {
+			io.WriteString(conn, reply+"\x00")
+		}
+		return
+	}
+
+	// answer session requests concurrently, so replies may be out of order
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for id := 1; ; id++ {
+		cmd, err := r.ReadString(0)
+		if err != nil || cmd == "zEND\x00" {
+			return
+		}
+		reply, ok := fakeClamdReply(cmd, r)
+		if !ok {
+			return
+		}
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+			mu.Lock()
+			fmt.Fprintf(conn, "%d: %s\x00", id, reply)
+			mu.Unlock()
+		}(id)
+	}
+}
+
+func fakeClamdReply(cmd string, r *bufio.Reader) (string, bool) {
+	switch strings.TrimSuffix(cmd, "\x00") {
+	case "zPING":
+		return "PONG", true
+	case "zVERSION":
+		return "ClamAV 0.103.8/26000/Mon Jan  1 00:00:00 2024", true
+	case "zVERSIONCOMMANDS":
+		return "ClamAV 0.103.8/26000/Mon Jan  1 00:00:00 2024| COMMANDS: SCAN QUIT RELOAD PING STATS IDSESSION INSTREAM END VERSIONCOMMANDS", true
+	case "zSTATS":
+		return fakeClamdStats, true
+	case "zINSTREAM":
+		var data []byte
+		for {
+			var n uint32
+			if binary.Read(r, binary.BigEndian, &n) != nil {
+				return "", false
+			}
+			if n == 0 {
+				break
+			}
+			chunk := make([]byte, n)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return "", false
+			}
+			data = append(data, chunk...)
+		}
+		if bytes.Contains(data, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+			return "stream: Eicar-Test-Signature FOUND", true
+		}
+		return "stream: OK", true
+	}
+	return "UNKNOWN COMMAND", true
+}
+
+const fakeClamdStats = `POOLS: 1
+
+STATE: VALID PRIMARY
+THREADS: live 2  idle 0 max 12 idle-timeout 30
+QUEUE: 1 items
+	STATS 0.000052 
+	INSTREAM 1.500000 
+
+MEMSTATS: heap 3.000M mmap N/A used 1.500M free 0.528M releasable 0.125M pools 1 pools_used 565.013M pools_total 565.046M
+END
+`
+
+func TestClamdClient(t *testing.T) {
+	l := fakeClamd(t)
+	defer l.Close()
+	c := NewClamdClient("tcp://" + l.Addr().String())
+
+	if err := c.Ping(); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+	if v, err := c.Version(); err != nil || !strings.HasPrefix(v, "ClamAV ") {
+		t.Errorf("Version: %q %v", v, err)
+	}
+	res, err := c.Scan(bytes.NewReader(eicar), "eicar.com")
+	if err != nil || res.Virus != "Eicar-Test-Signature" {
+		t.Errorf("Scan: eicar: %+v %v", res, err)
+	}
+	res, err = c.Scan(strings.NewReader(strings.Repeat("clean", 20000)), "clean")
+	if err != nil || res.Virus != "" {
+		t.Errorf("Scan: clean: %+v %v", res, err)
+	}
+
+	// a stream failing midway is not waited for by clamd
+	errRead := errors.New("read failed")
+	_, err = c.Scan(io.MultiReader(bytes.NewReader(eicar), iotest.ErrReader(errRead)), "broken")
+	if err != errRead {
+		t.Errorf("Scan: failing reader: %v, want %v", err, errRead)
+	}
+}
+
+// zeroReader reads endless zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestClamdClientInstream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	hold := make(chan struct{})
+	defer close(hold)
+	chunks := make(chan uint32, 2)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(first bool) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if first {
+					r.ReadString(0)
+					// reply to the first chunk like clamd does past StreamMaxLength
+					var n uint32
+					binary.Read(r, binary.BigEndian, &n)
+					chunks <- n
+					io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+				}
+				// then read nothing more
+				<-hold
+			}(i == 0)
+		}
+	}()
+
+	c := NewClamdClient(l.Addr().String())
+	c.ChunkSize = 1024
+	c.WriteTimeout = 10 * time.Second
+	_, err = c.Scan(zeroReader{}, "endless")
+	if ce, ok := err.(ClamdError); !ok || !strings.Contains(string(ce), "size limit") {
+		t.Errorf("Scan: %v, want the size limit reply", err)
+	}
+	if n := <-chunks; n != 1024 {
+		t.Errorf("Scan: sent a chunk of %d bytes, want 1024", n)
+	}
+
+	// no reply, and no reading
+	c.WriteTimeout = 100 * time.Millisecond
+	_, err = c.Scan(zeroReader{}, "endless")
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Scan: stalled server: %v, want a timeout", err)
+	}
+}
+
+var parseScanReplyTests = []struct {
+	reply, virus string
+	err          bool
+}{
+	{"stream: OK", "", false},
+	{"stream: Eicar-Test-Signature FOUND", "Eicar-Test-Signature", false},
+	{"1: stream: Win.Test.EICAR_HDB-1 FOUND", "Win.Test.EICAR_HDB-1", false},
+	{"INSTREAM size limit exceeded. ERROR", "", true},
+	{"", "", true},
+	{"garbage", "", true},
+}
+
+func TestParseScanReply(t *testing.T) {
+	for _, tt := range parseScanReplyTests {
+		virus, err := parseScanReply(tt.reply)
+		if virus != tt.virus || (err != nil) != tt.err {
+			t.Errorf("parseScanReply(%q) = %q, %v", tt.reply, virus, err)
+		}
+	}
+}
+
+func TestNewClamdClient(t *testing.T) {
+	for addr, want := range map[string]string{
+		"/run/clamav/clamd.ctl":      "unix:/run/clamav/clamd.ctl",
+		"unix:/run/clamav/clamd.ctl": "unix:/run/clamav/clamd.ctl",
+		"localhost:3310":             "tcp:localhost:3310",
+		"tcp://localhost:3310":       "tcp:localhost:3310",
+	} {
+		if got := NewClamdClient(addr).String(); got != want {
+			t.Errorf("NewClamdClient(%q) = %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestClamdPoolFailover(t *testing.T) {
+	live := fakeClamd(t)
+	defer live.Close()
+	dead := fakeClamd(t)
+	dead.Close()
+
+	for _, strategy := range []PoolStrategy{RoundRobin, LeastLoaded} {
+		p := NewClamdPool(strategy, NewClamdClient(dead.Addr().String()), NewClamdClient(live.Addr().String()))
+		for i := 0; i < 4; i++ {
+			res, err := p.Scan(bytes.NewBuffer(eicar), "eicar.com")
+			if err != nil || res.Virus != "Eicar-Test-Signature" {
+				t.Fatalf("Scan: %+v %v", res, err)
+			}
+		}
+		st := p.Status()
+		if st[0].Healthy || st[0].Err == nil || !st[1].Healthy {
+			t.Errorf("Status: %+v", st)
+		}
+
+		p.Check()
+		if st := p.Status(); st[0].Healthy || !st[1].Healthy {
+			t.Errorf("Check: %+v", st)
+		}
+	}
+
+	p := NewClamdPool(RoundRobin, NewClamdClient(dead.Addr().String()))
+	if _, err := p.Scan(bytes.NewReader(eicar), "eicar.com"); err == nil {
+		t.Errorf("Scan: no error with all daemons down")
+	}
+}
+
+// namedScanner records the names of the objects scanned by a daemon
+type namedScanner struct {
+	mu    *sync.Mutex
+	names map[string]string
+	addr  *string
+}
+
+func (s namedScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	s.mu.Lock()
+	s.names[name] = *s.addr
+	s.mu.Unlock()
+	return eicarScanner{}.Scan(r, name)
+}
+
+func TestClamdPoolContentHash(t *testing.T) {
+	var mu sync.Mutex
+	served := map[string]string{}
+	var clients []*ClamdClient
+	var servers []*ClamdServer
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		addr := new(string)
+		s := &ClamdServer{Scanner: namedScanner{&mu, served, addr}}
+		defer s.Close()
+		*addr = startClamdServer(t, s)
+		servers = append(servers, s)
+		addrs = append(addrs, *addr)
+		clients = append(clients, NewClamdClient(*addr))
+	}
+	p := NewClamdPool(ContentHash, clients...)
+
+	// the client does not send names, the scanner sees them as "stream"
+	scan := func(i int) string {
+		if _, err := p.Scan(strings.NewReader(fmt.Sprintf("content %d", i)), "x"); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return served["stream"]
+	}
+	first := map[int]string{}
+	used := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		first[i] = scan(i)
+		used[first[i]] = true
+	}
+	if len(used) != 3 {
+		t.Errorf("Scan: content spread over %d daemons", len(used))
+	}
+	for i := 0; i < 30; i++ {
+		if addr := scan(i); addr != first[i] {
+			t.Errorf("Scan: content %d moved from %s to %s", i, first[i], addr)
+		}
+	}
+
+	// only the content of a daemon out of rotation moves
+	servers[0].Close()
+	down := addrs[0]
+	for i := 0; i < 30; i++ {
+		if addr := scan(i); addr == down || first[i] != down && addr != first[i] {
+			t.Errorf("Scan: content %d moved from %s to %s", i, first[i], addr)
+		}
+	}
+}
+
+func TestClamdSession(t *testing.T) {
+	l := fakeClamd(t)
+	defer l.Close()
+
+	s, err := NewClamdClient(l.Addr().String()).Session()
+	if err != nil {
+		t.Fatalf("Session: %v", err)
+	}
+	if err := s.Ping(); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, want := []byte("clean"), ""
+			if i%3 == 0 {
+				data, want = eicar, "Eicar-Test-Signature"
+			}
+			name := fmt.Sprintf("object%d", i)
+			res, err := s.Scan(bytes.NewReader(data), name)
+			if err != nil {
+				t.Errorf("Scan: %s: %v", name, err)
+				return
+			}
+			if res.Virus != want || res.Name != name {
+				t.Errorf("Scan: %s: %+v, want virus %q", name, res, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := s.Scan(bytes.NewReader(eicar), "late"); err == nil {
+		t.Errorf("Scan: no error on closed session")
+	}
+}
+
+// flakyClamd is a fakeClamd dropping the first drop connections it accepts, like a clamd
+// restarting, and able to break the connections it serves
+type flakyClamd struct {
+	net.Listener
+	mu    sync.Mutex
+	drop  int
+	conns []net.Conn
+}
+
+func newFlakyClamd(t *testing.T, drop int) *flakyClamd {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	f := &flakyClamd{Listener: l, drop: drop}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			if f.drop > 0 {
+				f.drop--
+				conn.Close()
+			} else {
+				f.conns = append(f.conns, conn)
+				go serveFakeClamd(conn)
+			}
+			f.mu.Unlock()
+		}
+	}()
+	return f
+}
+
+// breakConns closes the connections served so far
+func (f *flakyClamd) breakConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+func TestClamdClientRetry(t *testing.T) {
+	f := newFlakyClamd(t, 2)
+	defer f.Close()
+	c := NewClamdClient(f.Addr().String())
+	c.RetryDelay = time.Millisecond
+
+	if err := c.Ping(); err == nil {
+		t.Errorf("Ping: no error without retries")
+	}
+	c.Retries = 1
+	if err := c.Ping(); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	// the data of the first attempt is gone unless it can be read again
+	f.mu.Lock()
+	f.drop = 1
+	f.mu.Unlock()
+	if _, err := c.Scan(onlyReader{bytes.NewReader(eicar)}, "eicar"); err == nil {
+		t.Errorf("Scan: retried a stream that cannot be read again")
+	}
+	// nothing of an empty stream is read before the connection fails
+	f.mu.Lock()
+	f.drop = 1
+	f.mu.Unlock()
+	if _, err := c.Scan(onlyReader{bytes.NewReader(nil)}, "empty"); err != nil {
+		t.Errorf("Scan: empty stream not retried: %v", err)
+	}
+	f.mu.Lock()
+	f.drop = 1
+	f.mu.Unlock()
+	res, err := c.Scan(bytes.NewReader(eicar), "eicar")
+	if err != nil || res.Virus != "Eicar-Test-Signature" {
+		t.Errorf("Scan: %+v %v", res, err)
+	}
+}
+
+func TestClamdSessionReconnect(t *testing.T) {
+	f := newFlakyClamd(t, 0)
+	defer f.Close()
+	c := NewClamdClient(f.Addr().String())
+	c.Retries = 1
+	c.RetryDelay = time.Millisecond
+	c.IdleTimeout = 50 * time.Millisecond
+	s, err := c.Session()
+	if err != nil {
+		t.Fatalf("Session: %v", err)
+	}
+	defer s.Close()
+
+	f.breakConns()
+	res, err := s.Scan(bytes.NewReader(eicar), "eicar")
+	if err != nil || res.Virus != "Eicar-Test-Signature" {
+		t.Errorf("Scan: after a broken connection: %+v %v", res, err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	s.mu.Lock()
+	expired := s.cur == nil
+	s.mu.Unlock()
+	if !expired {
+		t.Errorf("Session: connection kept past IdleTimeout")
+	}
+	if err := s.Ping(); err != nil {
+		t.Errorf("Ping: after expiry: %v", err)
+	}
+}
+
+func TestClamdStats(t *testing.T) {
+	l := fakeClamd(t)
+	defer l.Close()
+	c := NewClamdClient(l.Addr().String())
+
+	v, err := c.VersionInfo()
+	if err != nil {
+		t.Fatalf("VersionInfo: %v", err)
+	}
+	if v.Version != "ClamAV 0.103.8" || v.DBVersion != 26000 || v.DBTime.Year() != 2024 {
+		t.Errorf("VersionInfo: %+v", v)
+	}
+	v, cmds, err := c.VersionCommands()
+	if err != nil || v.DBVersion != 26000 || len(cmds) != 9 || cmds[5] != "IDSESSION" {
+		t.Errorf("VersionCommands: %+v %q %v", v, cmds, err)
+	}
+
+	st, err := c.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if len(st.Pools) != 1 || len(st.Unknown) != 0 {
+		t.Fatalf("Stats: %+v", st)
+	}
+	p := st.Pools[0]
+	if p.State != "VALID PRIMARY" || p.Live != 2 || p.Max != 12 || p.IdleTimeout != 30*time.Second || p.QueueLen != 1 {
+		t.Errorf("Stats: pool %+v", p)
+	}
+	if len(p.Queue) != 2 || p.Queue[1].Command != "INSTREAM" || p.Queue[1].Age != 1500*time.Millisecond {
+		t.Errorf("Stats: queue %+v", p.Queue)
+	}
+	if st.Mem.Heap != 3<<20 || st.Mem.Used != 3<<19 || st.Mem.Mmap != -1 || st.Mem.Pools != 1 {
+		t.Errorf("Stats: memory %+v", st.Mem)
+	}
+}
+
+func TestParseClamdVersion(t *testing.T) {
+	if v, err := parseClamdVersion("ClamAV 0.103.8"); err != nil || v.Version != "ClamAV 0.103.8" || v.DBVersion != 0 {
+		t.Errorf("parseClamdVersion: no database: %+v %v", v, err)
+	}
+	for _, s := range []string{"", "COMMAND READ TIMED OUT", "ClamAV 0.103.8/x/y"} {
+		if _, err := parseClamdVersion(s); err == nil {
+			t.Errorf("parseClamdVersion(%q) accepted", s)
+		}
+	}
+}
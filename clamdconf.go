@@ -0,0 +1,330 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClamdConfig is the configuration of a clamd.conf file, to run services built on this package
+// with the settings of an existing ClamAV deployment
+type ClamdConfig struct {
+	DatabaseDirectory string // DBDir() unless configured
+	DBOptions         uint   // for Engine.Load
+
+	// Options are the scan options, starting from the defaults of clamd
+	Options ScanOptions
+
+	// Limits are the engine limits configured, such as MaxScansize, applied by Apply
+	Limits map[EngineField]uint64
+
+	TemporaryDirectory  string
+	LeaveTemporaryFiles bool
+
+	// ExcludePath are the paths excluded from scanning, see Excluded
+	ExcludePath []*regexp.Regexp
+
+	// the listeners, for a ClamdServer
+	LocalSocket     string
+	TCPSocket       int
+	TCPAddr         []string
+	StreamMaxLength int64
+	IdleTimeout     time.Duration
+
+	// Other has the values of the directives not listed above, such as LogFile or User
+	Other map[string][]string
+}
+
+// clamdOption is a clamd.conf directive setting scan option bits
+type clamdOption struct {
+	field string // as in scanOptions
+	bits  uint32
+	on    bool // enabled by default
+}
+
+var clamdScanOptions = map[string]clamdOption{
+	"ScanPE":                      {"Parse", ScanParsePE, true},
+	"ScanELF":                     {"Parse", ScanParseElf, true},
+	"ScanOLE2":                    {"Parse", ScanParseOle2, true},
+	"ScanPDF":                     {"Parse", ScanParsePdf, true},
+	"ScanSWF":                     {"Parse", ScanParseSwf, true},
+	"ScanXMLDOCS":                 {"Parse", ScanParseXMLDocs, true},
+	"ScanHWP3":                    {"Parse", ScanParseHwp3, true},
+	"ScanOneNote":                 {"Parse", ScanParseOneNote, true},
+	"ScanMail":                    {"Parse", ScanParseMail, true},
+	"ScanHTML":                    {"Parse", ScanParseHTML, true},
+	"ScanArchive":                 {"Parse", ScanParseArchive, true},
+	"ScanImage":                   {"Parse", ScanParseImage, true},
+	"ScanImageFuzzyHash":          {"Parse", ScanParseImageFuzzyHash, true},
+	"HeuristicAlerts":             {"General", ScanGeneralHeuristics, true},
+	"HeuristicScanPrecedence":     {"General", ScanGeneralHeuristicsPrecendence, false},
+	"GenerateMetadataJson":        {"General", ScanGeneralCollectMetadata, false},
+	"AlertBrokenExecutables":      {"Heuristic", ScanHeuristicBroken, false},
+	"AlertBrokenMedia":            {"Heuristic", ScanHeuristicBrokenMedia, false},
+	"AlertEncrypted":              {"Heuristic", ScanHeuristicEncryptedArchive | ScanHeuristicEncryptedDoc, false},
+	"AlertEncryptedArchive":       {"Heuristic", ScanHeuristicEncryptedArchive, false},
+	"AlertEncryptedDoc":           {"Heuristic", ScanHeuristicEncryptedDoc, false},
+	"AlertOLE2Macros":             {"Heuristic", ScanHeuristicMacros, false},
+	"AlertExceedsMax":             {"Heuristic", ScanHeuristicExceedsMax, false},
+	"AlertPhishingSSLMismatch":    {"Heuristic", ScanHeuristicPhishingSSLMismatch, false},
+	"AlertPhishingCloak":          {"Heuristic", ScanHeuristicPhishingCloak, false},
+	"AlertPartitionIntersection":  {"Heuristic", ScanHeuristicPartitionIntxn, false},
+	"StructuredDataDetection":     {"Heuristic", ScanHeuristicStructure, false},
+	"StructuredSSNFormatNormal":   {"Heuristic", ScanHeuristicStructuredSSNNormal, true},
+	"StructuredSSNFormatStripped": {"Heuristic", ScanHeuristicStructuredSSNStripped, false},
+	"StructuredCCOnly":            {"Heuristic", ScanHeuristicStructuredCC, false},
+	"ScanPartialMessages":         {"Mail", ScanMailPartialMessage, false},
+}
+
+// optionField returns the named field of o
+func optionField(o *ScanOptions, field string) *uint32 {
+	switch field {
+	case "General":
+		return &o.General
+	case "Parse":
+		return &o.Parse
+	case "Heuristic":
+		return &o.Heuristic
+	case "Mail":
+		return &o.Mail
+	}
+	return &o.Dev
+}
+
+// clamd.conf directives setting engine limits, sizes accepting K and M suffixes
+var clamdLimits = map[string]struct {
+	field EngineField
+	size  bool
+}{
+	"MaxScanSize":                  {MaxScansize, true},
+	"MaxFileSize":                  {MaxFilesize, true},
+	"MaxRecursion":                 {MaxRecursion, false},
+	"MaxFiles":                     {MaxFiles, false},
+	"MaxEmbeddedPE":                {MaxEmbeddedpe, true},
+	"MaxHTMLNormalize":             {MaxHtmlnormalize, true},
+	"MaxHTMLNoTags":                {MaxHtmlnotags, true},
+	"MaxScriptNormalize":           {MaxScriptnormalize, true},
+	"MaxZipTypeRcg":                {MaxZiptypercg, true},
+	"MaxPartitions":                {MaxPartitions, false},
+	"MaxIconsPE":                   {MaxIconspe, false},
+	"BytecodeTimeout":              {BytecodeTimeout, false},
+	"StructuredMinCreditCardCount": {MinCCCount, false},
+	"StructuredMinSSNCount":        {MinSSNCount, false},
+}
+
+// clamd.conf directives setting database options, and whether they are enabled by default
+var clamdDBOptions = map[string]struct {
+	bits uint
+	on   bool
+}{
+	"PhishingSignatures":   {DbPhishing, true},
+	"PhishingScanURLs":     {DbPhishingUrls, true},
+	"Bytecode":             {DbBytecode, true},
+	"DetectPUA":            {DbPua, false},
+	"OfficialDatabaseOnly": {DbOfficialOnly, false},
+}
+
+// ReadClamdConfig reads a clamd.conf file
+func ReadClamdConfig(path string) (*ClamdConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadClamdConfig: %v", err)
+	}
+	defer f.Close()
+	c, err := ParseClamdConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("ReadClamdConfig: %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// ParseClamdConfig parses the directives of a clamd.conf file
+func ParseClamdConfig(r io.Reader) (*ClamdConfig, error) {
+	c := &ClamdConfig{DatabaseDirectory: DBDir(), Limits: map[EngineField]uint64{}, Other: map[string][]string{}}
+	for _, o := range clamdScanOptions {
+		if o.on {
+			*optionField(&c.Options, o.field) |= o.bits
+		}
+	}
+	for _, o := range clamdDBOptions {
+		if o.on {
+			c.DBOptions |= o.bits
+		}
+	}
+
+	if err := parseConf(r, c.set); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseConf calls set with the directives of a ClamAV configuration file, refusing the Example
+// directive of the sample configurations
+func parseConf(r io.Reader, set func(name, value string) error) error {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		name, value := f[0], strings.TrimSpace(strings.TrimPrefix(line, f[0]))
+		if name == "Example" {
+			return fmt.Errorf("line %d: the Example directive must be removed", n)
+		}
+		if err := set(name, strings.Trim(value, `"`)); err != nil {
+			return fmt.Errorf("line %d: %s: %v", n, name, err)
+		}
+	}
+	return s.Err()
+}
+
+// set applies a directive
+func (c *ClamdConfig) set(name, value string) error {
+	if o, ok := clamdScanOptions[name]; ok {
+		on, err := parseClamdBool(value)
+		if err != nil {
+			return err
+		}
+		if on {
+			*optionField(&c.Options, o.field) |= o.bits
+		} else {
+			*optionField(&c.Options, o.field) &^= o.bits
+		}
+		return nil
+	}
+	if o, ok := clamdDBOptions[name]; ok {
+		on, err := parseClamdBool(value)
+		if err != nil {
+			return err
+		}
+		if on {
+			c.DBOptions |= o.bits
+		} else {
+			c.DBOptions &^= o.bits
+		}
+		return nil
+	}
+	if l, ok := clamdLimits[name]; ok {
+		n, err := parseClamdNumber(value, l.size)
+		if err != nil {
+			return err
+		}
+		c.Limits[l.field] = n
+		return nil
+	}
+
+	var err error
+	switch name {
+	case "DatabaseDirectory":
+		c.DatabaseDirectory = value
+	case "TemporaryDirectory":
+		c.TemporaryDirectory = value
+	case "LeaveTemporaryFiles":
+		c.LeaveTemporaryFiles, err = parseClamdBool(value)
+	case "ExcludePath":
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(value); err == nil {
+			c.ExcludePath = append(c.ExcludePath, re)
+		}
+	case "LocalSocket":
+		c.LocalSocket = value
+	case "TCPSocket":
+		c.TCPSocket, err = strconv.Atoi(value)
+	case "TCPAddr":
+		c.TCPAddr = append(c.TCPAddr, value)
+	case "StreamMaxLength":
+		var n uint64
+		n, err = parseClamdNumber(value, true)
+		c.StreamMaxLength = int64(n)
+	case "IdleTimeout":
+		var n uint64
+		n, err = parseClamdNumber(value, false)
+		c.IdleTimeout = time.Duration(n) * time.Second
+	default:
+		c.Other[name] = append(c.Other[name], value)
+	}
+	return err
+}
+
+// parseClamdBool parses a boolean directive
+func parseClamdBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", v)
+}
+
+// parseClamdNumber parses a numeric directive, with a K or M suffix for sizes
+func parseClamdNumber(v string, size bool) (uint64, error) {
+	mult := uint64(1)
+	if size && v != "" {
+		switch v[len(v)-1] {
+		case 'k', 'K':
+			mult, v = 1<<10, v[:len(v)-1]
+		case 'm', 'M':
+			mult, v = 1<<20, v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", v)
+	}
+	return n * mult, nil
+}
+
+// Apply sets the limits and the temporary directory settings of the configuration on e
+func (c *ClamdConfig) Apply(e *Engine) error {
+	return c.apply(e, e.SetNum, e.SetString)
+}
+
+// ApplyStrict is Apply failing with an *UnsupportedError if the linked libclamav does not
+// support the scan options or does not take the settings as configured, see
+// ScanOptions.Supported and Engine.SetNumStrict
+func (c *ClamdConfig) ApplyStrict(e *Engine) error {
+	if err := c.Options.Supported(); err != nil {
+		return fmt.Errorf("ApplyStrict: %w", err)
+	}
+	return c.apply(e, e.SetNumStrict, e.SetStringStrict)
+}
+
+func (c *ClamdConfig) apply(e *Engine, setNum func(EngineField, uint64) error, setString func(EngineField, string) error) error {
+	for f, n := range c.Limits {
+		if err := setNum(f, n); err != nil {
+			return fmt.Errorf("Apply: field %d: %w", f, err)
+		}
+	}
+	if c.TemporaryDirectory != "" {
+		if err := setString(Tmpdir, c.TemporaryDirectory); err != nil {
+			return fmt.Errorf("Apply: TemporaryDirectory: %w", err)
+		}
+	}
+	if c.LeaveTemporaryFiles {
+		if err := setNum(Keeptmp, 1); err != nil {
+			return fmt.Errorf("Apply: LeaveTemporaryFiles: %w", err)
+		}
+	}
+	return nil
+}
+
+// Excluded reports whether path matches one of the ExcludePath expressions
+func (c *ClamdConfig) Excluded(path string) bool {
+	for _, re := range c.ExcludePath {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testClamdConf = `
+# clamd.conf of an existing deployment
+LogFile /var/log/clamav/clamd.log
+LocalSocket /run/clamav/clamd.ctl
+TCPSocket 3310
+TCPAddr 127.0.0.1
+TCPAddr ::1
+DatabaseDirectory /srv/clamav/db
+TemporaryDirectory "/var/tmp/clamav"
+StreamMaxLength 100M
+IdleTimeout 30
+ExcludePath ^/proc/
+ExcludePath ^/sys/
+MaxScanSize 400M
+MaxFileSize 100M
+MaxRecursion 17
+MaxFiles 10000
+MaxHTMLNormalize 10K
+ScanSWF no
+ScanPartialMessages yes
+AlertEncrypted yes
+AlertOLE2Macros true
+DetectPUA yes
+Bytecode no
+User clamav
+`
+
+func TestParseClamdConfig(t *testing.T) {
+	c, err := ParseClamdConfig(strings.NewReader(testClamdConf))
+	if err != nil {
+		t.Fatalf("ParseClamdConfig: %v", err)
+	}
+	if c.DatabaseDirectory != "/srv/clamav/db" || c.TemporaryDirectory != "/var/tmp/clamav" || c.LocalSocket != "/run/clamav/clamd.ctl" ||
+		c.TCPSocket != 3310 || len(c.TCPAddr) != 2 || c.StreamMaxLength != 100<<20 || c.IdleTimeout != 30*time.Second {
+		t.Errorf("ParseClamdConfig: %+v", c)
+	}
+	if c.Limits[MaxScansize] != 400<<20 || c.Limits[MaxFilesize] != 100<<20 || c.Limits[MaxRecursion] != 17 ||
+		c.Limits[MaxFiles] != 10000 || c.Limits[MaxHtmlnormalize] != 10<<10 || len(c.Limits) != 5 {
+		t.Errorf("ParseClamdConfig: limits %v", c.Limits)
+	}
+
+	o := c.Options
+	if o.Parse&ScanParseSwf != 0 || o.Parse&ScanParsePE == 0 || o.General&ScanGeneralHeuristics == 0 || o.Mail != ScanMailPartialMessage {
+		t.Errorf("ParseClamdConfig: options %+v", o)
+	}
+	if want := uint32(ScanHeuristicEncryptedArchive | ScanHeuristicEncryptedDoc | ScanHeuristicMacros | ScanHeuristicStructuredSSNNormal); o.Heuristic != want {
+		t.Errorf("ParseClamdConfig: heuristic options %#x, want %#x", o.Heuristic, want)
+	}
+	if want := uint(DbPhishing | DbPhishingUrls | DbPua); c.DBOptions != want {
+		t.Errorf("ParseClamdConfig: database options %#x, want %#x", c.DBOptions, want)
+	}
+	if !c.Excluded("/proc/1/mem") || c.Excluded("/home/user/proc/") {
+		t.Errorf("Excluded: %v", c.ExcludePath)
+	}
+	if len(c.Other["User"]) != 1 || c.Other["LogFile"][0] != "/var/log/clamav/clamd.log" {
+		t.Errorf("ParseClamdConfig: other directives %v", c.Other)
+	}
+
+	eng := New()
+	defer eng.Free()
+	if err := c.Apply(eng); err != nil {
+		t.Errorf("Apply: %v", err)
+	}
+
+	for _, conf := range []string{"Example\n", "ScanPE maybe\n", "MaxScanSize 10G\n", "ExcludePath [\n"} {
+		if _, err := ParseClamdConfig(strings.NewReader(conf)); err == nil {
+			t.Errorf("ParseClamdConfig: %q accepted", conf)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// PoolStrategy selects how a ClamdPool dispatches scans to its daemons
+type PoolStrategy int
+
+// Dispatch strategies
+const (
+	RoundRobin  PoolStrategy = iota // take turns
+	LeastLoaded                     // pick the daemon with the fewest scans in progress
+
+	// ContentHash sends the same content to the same daemon, so that it is found in the
+	// cache of that daemon when scanned again. Content is mapped to daemons by rendezvous
+	// hashing: when a daemon is out of rotation, only its share of the content moves to the
+	// others, and it gets its share back once healthy again. The content is read once more to
+	// be hashed.
+	ContentHash
+)
+
+// ClamdPool is a Scanner spreading scans across several clamd daemons. Daemons that fail to
+// accept a scan, or fail a health check, are taken out of rotation until they answer a health
+// check again, and the scan is retried on the next daemon.
+type ClamdPool struct {
+	Strategy PoolStrategy
+
+	mu       sync.Mutex
+	backends []*clamdBackend
+	next     int
+	stop     chan struct{}
+}
+
+// clamdBackend tracks the state of a daemon in a pool
+type clamdBackend struct {
+	client   *ClamdClient
+	inflight int
+	healthy  bool
+	lastErr  error
+}
+
+// BackendStatus describes a daemon of a pool
+type BackendStatus struct {
+	Address  string
+	Healthy  bool
+	Inflight int   // scans in progress
+	Err      error // last connection or health check failure
+}
+
+// NewClamdPool returns a pool dispatching scans to clients with the given strategy
+func NewClamdPool(strategy PoolStrategy, clients ...*ClamdClient) *ClamdPool {
+	p := &ClamdPool{Strategy: strategy}
+	for _, c := range clients {
+		p.backends = append(p.backends, &clamdBackend{client: c, healthy: true})
+	}
+	return p
+}
+
+// Scan scans the data read from r on one of the daemons of the pool. To be able to fail over,
+// the data is held in memory or, if large, in a temporary file unless r is an io.ReadSeeker.
+func (p *ClamdPool) Scan(r io.Reader, name string) (*ScanResult, error) {
+	if len(p.backends) == 0 {
+		return nil, errors.New("ClamdPool: no daemons")
+	}
+	rs, cleanup, err := replayable(r)
+	if err != nil {
+		return nil, fmt.Errorf("ClamdPool: %v", err)
+	}
+	defer cleanup()
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("ClamdPool: %v", err)
+	}
+
+	var key []byte
+	if p.Strategy == ContentHash {
+		h := md5.New()
+		if _, err := io.Copy(h, rs); err != nil {
+			return nil, fmt.Errorf("ClamdPool: %v", err)
+		}
+		key = h.Sum(nil)
+	}
+
+	var lastErr error
+	tried := map[*clamdBackend]bool{}
+	for len(tried) < len(p.backends) {
+		b := p.pick(tried, key)
+		tried[b] = true
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("ClamdPool: %v", err)
+		}
+
+		res, err := b.client.Scan(rs, name)
+		p.done(b, err)
+		if err == nil {
+			return res, nil
+		}
+		if isClamdError(err) {
+			// the daemon is working, but refused this scan
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("ClamdPool: all daemons failed, last error: %v", lastErr)
+}
+
+// pick selects the next daemon for a scan of the content hashed to key among those not tried
+// yet, preferring healthy ones
+func (p *ClamdPool) pick(tried map[*clamdBackend]bool, key []byte) *clamdBackend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Strategy == ContentHash {
+		var best *clamdBackend
+		var bestWeight uint64
+		for _, b := range p.backends {
+			if tried[b] {
+				continue
+			}
+			w := rendezvousWeight(key, b.client.String())
+			if best == nil || b.healthy && !best.healthy || b.healthy == best.healthy && w > bestWeight {
+				best, bestWeight = b, w
+			}
+		}
+		best.inflight++
+		return best
+	}
+
+	var best *clamdBackend
+	n := len(p.backends)
+	for i := 0; i < n; i++ {
+		b := p.backends[(p.next+i)%n]
+		if tried[b] {
+			continue
+		}
+		if best == nil || b.healthy && !best.healthy {
+			best = b
+			if p.Strategy == RoundRobin && b.healthy {
+				break
+			}
+			continue
+		}
+		if p.Strategy == LeastLoaded && b.healthy == best.healthy && b.inflight < best.inflight {
+			best = b
+		}
+	}
+	p.next = (p.next + 1) % n
+	best.inflight++
+	return best
+}
+
+// done records the outcome of a scan on b. Connection failures take b out of rotation.
+func (p *ClamdPool) done(b *clamdBackend, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b.inflight--
+	if err != nil && !isClamdError(err) {
+		b.healthy = false
+		b.lastErr = err
+	}
+}
+
+// rendezvousWeight is the weight of the daemon at addr for the content hashed to key, the
+// content going to the daemon of highest weight
+func rendezvousWeight(key []byte, addr string) uint64 {
+	h := md5.New()
+	h.Write(key)
+	h.Write([]byte(addr))
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+func isClamdError(err error) bool {
+	_, ok := err.(ClamdError)
+	return ok
+}
+
+// Check pings every daemon of the pool, updating its health
+func (p *ClamdPool) Check() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func(b *clamdBackend) {
+			defer wg.Done()
+			err := b.client.Ping()
+			p.mu.Lock()
+			b.healthy = err == nil
+			b.lastErr = err
+			p.mu.Unlock()
+		}(b)
+	}
+	wg.Wait()
+}
+
+// StartHealthChecks runs Check every interval until Close is called
+func (p *ClamdPool) StartHealthChecks(interval time.Duration) {
+	p.mu.Lock()
+	if p.stop != nil {
+		p.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	p.stop = stop
+	p.mu.Unlock()
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.Check()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the health checks of the pool
+func (p *ClamdPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// Status returns the state of every daemon of the pool
+func (p *ClamdPool) Status() []BackendStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := make([]BackendStatus, len(p.backends))
+	for i, b := range p.backends {
+		st[i] = BackendStatus{Address: b.client.String(), Healthy: b.healthy, Inflight: b.inflight, Err: b.lastErr}
+	}
+	return st
+}
+
+// replayable returns r as an io.ReadSeeker, copying it to memory or to a temporary file if it
+// is not one already. The cleanup function releases the copy.
+func replayable(r io.Reader) (io.ReadSeeker, func(), error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return rs, func() {}, nil
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(r, readerMemoryLimit+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(buf) <= readerMemoryLimit {
+		return bytes.NewReader(buf), func() {}, nil
+	}
+
+	f, cleanup, err := spoolFile("")
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.Copy(f, io.MultiReader(bytes.NewReader(buf), r)); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return f, cleanup, nil
+}
@@ -0,0 +1,340 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// ClamdServer serves the clamd protocol, so that existing clamd clients can scan through any
+// Scanner, such as an EngineScanner or a ClamdPool. It supports the PING, VERSION, INSTREAM,
+// IDSESSION and END commands, both in their NUL terminated ("z") and newline terminated ("n")
+// forms. Commands that work on paths of the server, such as SCAN, are refused. The scans of a
+// session are run one after the other.
+type ClamdServer struct {
+	Scanner Scanner
+
+	// Version is the reply to the VERSION command, "ClamAV" and the libclamav version if empty
+	Version string
+
+	// MaxStreamSize is the largest INSTREAM accepted, DefaultMaxStreamSize if zero
+	MaxStreamSize int64
+
+	// IdleTimeout is how long to wait for the next command of a connection, no limit if zero
+	IdleTimeout time.Duration
+
+	// ScanTimeout, if not zero, bounds the time an INSTREAM command takes, from receiving its
+	// data to scanning it, so that neither a slow upload nor a scan stuck on a crafted sample
+	// ties up the server. Requests over the limit get an error reply, and their connection is
+	// closed; the scanner is asked to abort the scan as a Watchdog does.
+	ScanTimeout time.Duration
+
+	// TLSConfig, if set, makes the server accept TLS connections only
+	TLSConfig *tls.Config
+
+	// Token, if set, must be presented by clients with "AUTH <token>" before any other command
+	Token string
+
+	conns tracker
+}
+
+// DefaultMaxStreamSize is the INSTREAM size limit used when ClamdServer.MaxStreamSize is zero,
+// the default StreamMaxLength of clamd
+const DefaultMaxStreamSize = 25 << 20
+
+// ErrClamdServerClosed is returned by ClamdServer.Serve after Close
+var ErrClamdServerClosed = errors.New("clamd: server closed")
+
+// Errors of INSTREAM commands over the limits of the server
+var (
+	errStreamTooLarge = errors.New("INSTREAM size limit exceeded")
+	errStreamTimeout  = errors.New("INSTREAM time limit exceeded")
+)
+
+// maxCommandLine is the length of the longest command accepted, as it is read before
+// authentication
+const maxCommandLine = 1024
+
+var errCommandTooLong = errors.New("Command too long")
+
+// ListenAndServe listens on the network address and serves connections, see Serve
+func (s *ClamdServer) ListenAndServe(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ServeActivated serves connections on the sockets named name passed by socket activation, see
+// ActivationListeners, until Close is called or one of them fails
+func (s *ClamdServer) ServeActivated(name string) error {
+	ls, err := ActivationListeners(name)
+	if err != nil {
+		return err
+	}
+	return serveListeners(ls, s.Serve)
+}
+
+// Serve accepts connections on l until Close is called, always returning a non-nil error
+func (s *ClamdServer) Serve(l net.Listener) error {
+	if s.TLSConfig != nil {
+		l = tls.NewListener(l, s.TLSConfig)
+	}
+	err := s.conns.serve(l, s.serveConn)
+	if err == errTrackerClosed {
+		return ErrClamdServerClosed
+	}
+	return err
+}
+
+// Close closes the listeners and all connections of the server, interrupting scans in progress
+func (s *ClamdServer) Close() error {
+	s.conns.close()
+	return nil
+}
+
+// clamdConn is a connection to a ClamdServer
+type clamdConn struct {
+	s      *ClamdServer
+	conn   net.Conn
+	r      *bufio.Reader
+	authed bool
+
+	// abandoned is set once a scan over the time limit was given up on while it may still be
+	// reading the connection
+	abandoned bool
+}
+
+func (s *ClamdServer) serveConn(conn net.Conn) {
+	c := &clamdConn{s: s, conn: conn, r: bufio.NewReader(conn), authed: s.Token == ""}
+	session := false
+	for id := 0; ; {
+		cmd, arg, term, err := c.readCommand()
+		if err == errCommandTooLong {
+			c.reply(0, err.Error()+". ERROR", term)
+			return
+		}
+		if err != nil {
+			return
+		}
+		if session {
+			id++
+		}
+		if !c.authed && cmd != "AUTH" {
+			c.reply(id, "Authentication required. ERROR", term)
+			return
+		}
+
+		switch cmd {
+		case "AUTH":
+			if session || s.Token == "" || subtle.ConstantTimeCompare([]byte(arg), []byte(s.Token)) != 1 {
+				c.reply(id, "Authentication failed. ERROR", term)
+				return
+			}
+			c.authed = true
+			c.reply(id, "OK", term)
+			continue
+		case "PING":
+			c.reply(id, "PONG", term)
+		case "VERSION":
+			v := s.Version
+			if v == "" {
+				v = "ClamAV " + Retver()
+			}
+			c.reply(id, v, term)
+		case "INSTREAM":
+			reply, ok := c.instream()
+			if reply != "" {
+				c.reply(id, reply, term)
+			}
+			if !ok {
+				if !c.abandoned {
+					c.drain()
+				}
+				return
+			}
+		case "IDSESSION":
+			if session {
+				c.reply(id, "Command invalid inside IDSESSION. ERROR", term)
+				return
+			}
+			session = true
+			continue
+		case "END":
+			return
+		default:
+			c.reply(id, "UNKNOWN COMMAND", term)
+			return
+		}
+		if !session {
+			return
+		}
+	}
+}
+
+// readCommand reads the next command, such as "zINSTREAM\x00" or "nAUTH secret\n", returning
+// its name, argument and terminator
+func (c *clamdConn) readCommand() (cmd, arg string, term byte, err error) {
+	if c.s.IdleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.s.IdleTimeout))
+	}
+	prefix, err := c.r.ReadByte()
+	if err != nil {
+		return "", "", 0, err
+	}
+	switch prefix {
+	case 'z':
+		term = 0
+	case 'n':
+		term = '\n'
+	default:
+		return "", "", 0, fmt.Errorf("clamd: unsupported command prefix %q", prefix)
+	}
+	line, err := readLine(c.r, term, maxCommandLine)
+	if err != nil {
+		return "", "", term, err
+	}
+	c.conn.SetReadDeadline(time.Time{})
+
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		return line[:i], line[i+1:], term, nil
+	}
+	return line, "", term, nil
+}
+
+// readLine reads from r up to term, which it strips, failing with errCommandTooLong rather than
+// reading more than max bytes
+func readLine(r *bufio.Reader, term byte, max int) (string, error) {
+	var line []byte
+	for {
+		b, err := r.ReadSlice(term)
+		if len(line)+len(b) > max+1 {
+			return "", errCommandTooLong
+		}
+		line = append(line, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(line[:len(line)-1]), nil
+	}
+}
+
+// reply sends a reply, numbered with the request id inside a session
+func (c *clamdConn) reply(id int, msg string, term byte) {
+	if id > 0 {
+		msg = fmt.Sprintf("%d: %s", id, msg)
+	}
+	io.WriteString(c.conn, msg+string(term))
+}
+
+// instream scans the data of an INSTREAM command and returns the reply. The connection cannot
+// be used further if ok is false.
+func (c *clamdConn) instream() (reply string, ok bool) {
+	max := c.s.MaxStreamSize
+	if max <= 0 {
+		max = DefaultMaxStreamSize
+	}
+	ir := &instreamReader{r: c.r, max: max}
+	scanner := c.s.Scanner
+	if t := c.s.ScanTimeout; t > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(t))
+		defer c.conn.SetReadDeadline(time.Time{})
+		scanner = &Watchdog{Scanner: scanner, Limit: t}
+	}
+	res, err := scanner.Scan(ir, "stream")
+	if err == ErrScanHung {
+		c.abandoned = true
+		return errStreamTimeout.Error() + ". ERROR", false
+	}
+	// consume the chunks the scanner left
+	io.Copy(ioutil.Discard, ir)
+	switch {
+	case ir.err == errStreamTooLarge:
+		return errStreamTooLarge.Error() + ". ERROR", false
+	case isTimeout(ir.err):
+		return errStreamTimeout.Error() + ". ERROR", false
+	case ir.err != io.EOF:
+		return "", false
+	case err != nil:
+		return strings.Replace(err.Error(), "\n", " ", -1) + " ERROR", true
+	case res.Virus != "":
+		return "stream: " + res.Virus + " FOUND", true
+	}
+	return "stream: OK", true
+}
+
+// drain discards what the client is still sending before the connection is closed, for a
+// while, so that the client gets to read the last reply instead of a connection reset
+func (c *clamdConn) drain() {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	io.Copy(ioutil.Discard, io.LimitReader(c.r, DefaultMaxStreamSize))
+}
+
+// instreamReader reads the data of an INSTREAM command, chunks prefixed by their length in
+// network byte order and terminated by an empty chunk
+type instreamReader struct {
+	r          io.Reader
+	max, total int64
+	left       uint32 // unread bytes of the current chunk
+	err        error  // io.EOF once the empty chunk has been read
+}
+
+func (ir *instreamReader) Read(p []byte) (int, error) {
+	if ir.err != nil {
+		return 0, ir.err
+	}
+	for ir.left == 0 {
+		var hdr [4]byte
+		if _, err := io.ReadFull(ir.r, hdr[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			ir.err = err
+			return 0, err
+		}
+		ir.left = binary.BigEndian.Uint32(hdr[:])
+		if ir.left == 0 {
+			ir.err = io.EOF
+			return 0, io.EOF
+		}
+		ir.total += int64(ir.left)
+		if ir.total > ir.max {
+			ir.err = errStreamTooLarge
+			return 0, ir.err
+		}
+	}
+	if uint32(len(p)) > ir.left {
+		p = p[:ir.left]
+	}
+	n, err := ir.r.Read(p)
+	ir.left -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		ir.err = err
+	}
+	return n, err
+}
+
+// isTimeout reports whether err is the expiry of a deadline
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
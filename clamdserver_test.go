@@ -0,0 +1,171 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicarScanner flags data containing the EICAR string
+type eicarScanner struct{}
+
+func (eicarScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	res := &ScanResult{Name: name}
+	if bytes.Contains(buf, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+		res.Virus = "Eicar-Test-Signature"
+	}
+	return res, nil
+}
+
+func startClamdServer(t *testing.T, s *ClamdServer) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+func TestClamdServer(t *testing.T) {
+	s := &ClamdServer{Scanner: eicarScanner{}, Version: "ClamAV 0.103.8/26000/Mon Jan  1 00:00:00 2024", MaxStreamSize: 1 << 20}
+	defer s.Close()
+	c := NewClamdClient(startClamdServer(t, s))
+
+	if err := c.Ping(); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+	if v, err := c.VersionInfo(); err != nil || v.DBVersion != 26000 {
+		t.Errorf("VersionInfo: %+v %v", v, err)
+	}
+	res, err := c.Scan(bytes.NewReader(eicar), "eicar.com")
+	if err != nil || res.Virus != "Eicar-Test-Signature" {
+		t.Errorf("Scan: eicar: %+v %v", res, err)
+	}
+	res, err = c.Scan(strings.NewReader(strings.Repeat("clean", 20000)), "clean")
+	if err != nil || res.Virus != "" {
+		t.Errorf("Scan: clean: %+v %v", res, err)
+	}
+	_, err = c.Scan(bytes.NewReader(make([]byte, 2<<20)), "large")
+	if _, ok := err.(ClamdError); !ok {
+		t.Errorf("Scan: oversized stream: %v", err)
+	}
+
+	sess, err := c.Session()
+	if err != nil {
+		t.Fatalf("Session: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		res, err := sess.Scan(bytes.NewReader(eicar), "eicar.com")
+		if err != nil || res.Virus != "Eicar-Test-Signature" {
+			t.Errorf("Session: Scan: %+v %v", res, err)
+		}
+	}
+	if err := sess.Ping(); err != nil {
+		t.Errorf("Session: Ping: %v", err)
+	}
+	sess.Close()
+}
+
+func TestClamdServerScanTimeout(t *testing.T) {
+	g := &gateScanner{}
+	defer g.release("stream")
+	s := &ClamdServer{Scanner: readingScanner{g}, ScanTimeout: 50 * time.Millisecond}
+	defer s.Close()
+	_, err := NewClamdClient(startClamdServer(t, s)).Scan(bytes.NewReader(eicar), "stuck")
+	if ce, ok := err.(ClamdError); !ok || !strings.Contains(string(ce), "time limit") {
+		t.Errorf("Scan: stuck scan: %v", err)
+	}
+
+	// an upload announcing more data than it sends
+	s = &ClamdServer{Scanner: eicarScanner{}, ScanTimeout: 50 * time.Millisecond}
+	defer s.Close()
+	conn, err := net.Dial("tcp", startClamdServer(t, s))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "zINSTREAM\x00\x00\x00\x00\x10abc")
+	reply, err := readClamdReply(bufio.NewReader(conn))
+	if err != nil || reply != "INSTREAM time limit exceeded. ERROR" {
+		t.Errorf("INSTREAM: stalled upload: %q %v", reply, err)
+	}
+}
+
+func TestClamdServerCommandTooLong(t *testing.T) {
+	s := &ClamdServer{Scanner: eicarScanner{}, Token: "secret"}
+	defer s.Close()
+	conn, err := net.Dial("tcp", startClamdServer(t, s))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	// an endless line, before authentication
+	go func() {
+		io.WriteString(conn, "zAUTH ")
+		chunk := strings.Repeat("x", 4096)
+		for i := 0; i < 1024; i++ {
+			if _, err := io.WriteString(conn, chunk); err != nil {
+				return
+			}
+		}
+	}()
+	reply, err := readClamdReply(bufio.NewReader(conn))
+	if err != nil || reply != "Command too long. ERROR" {
+		t.Errorf("AUTH: endless line: %q %v", reply, err)
+	}
+
+	// commands within the limit still go through
+	s = &ClamdServer{Scanner: eicarScanner{}}
+	defer s.Close()
+	if err := NewClamdClient(startClamdServer(t, s)).Ping(); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
+
+func TestClamdServerTLS(t *testing.T) {
+	// borrow the test certificate of httptest
+	ts := httptest.NewUnstartedServer(http.NotFoundHandler())
+	ts.StartTLS()
+	serverConfig := ts.TLS
+	clientConfig := ts.Client().Transport.(*http.Transport).TLSClientConfig
+	ts.Close()
+
+	s := &ClamdServer{Scanner: eicarScanner{}, TLSConfig: serverConfig, Token: "secret"}
+	defer s.Close()
+	addr := startClamdServer(t, s)
+
+	c := NewClamdClient("tls://" + addr)
+	c.TLSConfig = clientConfig
+	c.Token = "secret"
+	res, err := c.Scan(bytes.NewReader(eicar), "eicar.com")
+	if err != nil || res.Virus != "Eicar-Test-Signature" {
+		t.Errorf("Scan: %+v %v", res, err)
+	}
+
+	c.Token = "wrong"
+	if err := c.Ping(); err == nil {
+		t.Errorf("Ping: wrong token accepted")
+	}
+	c.Token = ""
+	if err := c.Ping(); err == nil {
+		t.Errorf("Ping: missing token accepted")
+	}
+	if err := NewClamdClient(addr).Ping(); err == nil {
+		t.Errorf("Ping: cleartext connection accepted")
+	}
+}
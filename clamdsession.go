@@ -0,0 +1,340 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClamdSession is a Scanner that pipelines many scans over a single clamd connection, using
+// clamd's IDSESSION mode. Scan may be called from several goroutines at once: data is streamed
+// one scan at a time, but clamd works on the scans concurrently and replies as each completes,
+// which saves a connection per scan for workloads with many small objects.
+//
+// A broken connection fails the requests waiting on it, which are retried as configured by
+// the Retries of the client, and the next request opens a new one, so that a clamd restart
+// does not break the session for good.
+type ClamdSession struct {
+	c  *ClamdClient
+	iw *instreamWriter
+
+	wmu sync.Mutex // serializes requests
+
+	mu       sync.Mutex
+	cur      *sessionConn // nil until the next request reconnects
+	lastUsed time.Time
+	closed   bool
+	stop     chan struct{} // closed by Close
+}
+
+// sessionConn is a connection of a session
+type sessionConn struct {
+	net.Conn
+	nextID  int
+	pending map[int]chan sessionReply
+	err     error // set once the connection is broken or ended
+}
+
+// sessionReply is the reply to a request of a session, or the failure of its connection
+type sessionReply struct {
+	reply string
+	err   error
+}
+
+// Errors of the requests of a session
+var (
+	errSessionClosed  = errors.New("clamd: session closed")
+	errSessionExpired = errors.New("clamd: session idle")
+)
+
+// Session opens a new IDSESSION with clamd. The session has no deadline, Timeout only applies
+// to establishing connections. Sessions ping clamd every HealthInterval of the client, and end
+// their connection once idle for its IdleTimeout.
+func (c *ClamdClient) Session() (*ClamdSession, error) {
+	s := &ClamdSession{c: c, iw: c.instreamWriter(), lastUsed: time.Now(), stop: make(chan struct{})}
+	if _, err := s.connect(); err != nil {
+		return nil, err
+	}
+	if c.HealthInterval > 0 || c.IdleTimeout > 0 {
+		go s.maintain()
+	}
+	return s, nil
+}
+
+// connect opens a new connection for the session. It is called with wmu held.
+func (s *ClamdSession) connect() (*sessionConn, error) {
+	conn, err := s.c.dial()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	if _, err := io.WriteString(conn, "zIDSESSION\x00"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sc := &sessionConn{Conn: conn, nextID: 1, pending: map[int]chan sessionReply{}}
+	s.mu.Lock()
+	s.cur = sc
+	s.mu.Unlock()
+	go s.readReplies(sc)
+	return sc, nil
+}
+
+// Scan streams the data read from r to clamd within the session
+func (s *ClamdSession) Scan(r io.Reader, name string) (*ScanResult, error) {
+	seeker, _ := r.(io.Seeker)
+	var start int64
+	if seeker != nil && s.c.Retries > 0 {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		sent := false
+		reply, err := s.do(func(w io.Writer) error {
+			sent = true
+			return s.iw.write(w, r)
+		})
+		if err == nil {
+			virus, err := parseScanReply(reply)
+			if err != nil {
+				return nil, err
+			}
+			return &ScanResult{Name: name, Virus: virus, Encrypted: encryptedObjects(nil, virus, "", nil)}, nil
+		}
+		if err == errSessionClosed || sent && seeker == nil || !s.c.retry(attempt, err) {
+			return nil, err
+		}
+		if sent {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Ping checks that clamd still serves the session
+func (s *ClamdSession) Ping() error {
+	for attempt := 0; ; attempt++ {
+		reply, err := s.do(func(w io.Writer) error {
+			_, err := io.WriteString(w, "zPING\x00")
+			return err
+		})
+		if err == nil && reply != "PONG" {
+			return ClamdError(reply)
+		}
+		if err == errSessionClosed || !s.c.retry(attempt, err) {
+			return err
+		}
+	}
+}
+
+// do sends a request written by send and waits for its reply, connecting again if the
+// connection of the session was broken or ended
+func (s *ClamdSession) do(send func(w io.Writer) error) (string, error) {
+	ch := make(chan sessionReply, 1)
+
+	s.wmu.Lock()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		s.wmu.Unlock()
+		return "", errSessionClosed
+	}
+	s.lastUsed = time.Now()
+	sc := s.cur
+	s.mu.Unlock()
+	if sc == nil {
+		var err error
+		if sc, err = s.connect(); err != nil {
+			s.wmu.Unlock()
+			return "", err
+		}
+	}
+	s.mu.Lock()
+	err := sc.add(ch)
+	s.mu.Unlock()
+	if err != nil {
+		s.wmu.Unlock()
+		return "", err
+	}
+
+	err = send(sc)
+	s.wmu.Unlock()
+	if err != nil {
+		s.fail(sc, fmt.Errorf("clamd: session: %v", err))
+	}
+	r := <-ch
+	return r.reply, r.err
+}
+
+// add registers a request waiting for its reply on ch, failing if the connection is broken.
+// It is called with the locks of the session held.
+func (sc *sessionConn) add(ch chan sessionReply) error {
+	if sc.err != nil {
+		return sc.err
+	}
+	// clamd numbers the requests of a connection in the order it receives them
+	sc.pending[sc.nextID] = ch
+	sc.nextID++
+	return nil
+}
+
+// readReplies dispatches the replies of clamd on sc, formatted as "<id>: <reply>", to the
+// waiting requests
+func (s *ClamdSession) readReplies(sc *sessionConn) {
+	r := bufio.NewReader(sc)
+	for {
+		line, err := readClamdReply(r)
+		if err != nil {
+			s.fail(sc, fmt.Errorf("clamd: session: %v", err))
+			return
+		}
+		var id int
+		i := strings.Index(line, ": ")
+		if i >= 0 {
+			id, err = strconv.Atoi(line[:i])
+		}
+		if i < 0 || err != nil {
+			// errors about the session itself are not numbered
+			s.fail(sc, ClamdError(line))
+			return
+		}
+
+		s.mu.Lock()
+		ch := sc.pending[id]
+		delete(sc.pending, id)
+		s.lastUsed = time.Now()
+		s.mu.Unlock()
+		if ch != nil {
+			ch <- sessionReply{reply: line[i+2:]}
+		}
+	}
+}
+
+// fail breaks sc, failing all its pending requests with err
+func (s *ClamdSession) fail(sc *sessionConn, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sc.err == nil {
+		sc.err = err
+		sc.Close()
+	}
+	if s.cur == sc {
+		s.cur = nil
+	}
+	for id, ch := range sc.pending {
+		ch <- sessionReply{err: sc.err}
+		delete(sc.pending, id)
+	}
+}
+
+// maintain checks the connection of the session every HealthInterval, and ends it once idle
+// for IdleTimeout, until the session is closed
+func (s *ClamdSession) maintain() {
+	var health, idle <-chan time.Time
+	if d := s.c.HealthInterval; d > 0 {
+		t := time.NewTicker(d)
+		defer t.Stop()
+		health = t.C
+	}
+	if d := s.c.IdleTimeout; d > 0 {
+		t := time.NewTicker(d / 2)
+		defer t.Stop()
+		idle = t.C
+	}
+	for {
+		select {
+		case <-health:
+			s.check(s.c.HealthInterval)
+		case <-idle:
+			s.expire()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// check pings clamd over the connection of the session, if there is one, breaking it unless
+// clamd replies within timeout. Unlike Ping, it neither reconnects nor counts as a use of the
+// session.
+func (s *ClamdSession) check(timeout time.Duration) {
+	ch := make(chan sessionReply, 1)
+	s.wmu.Lock()
+	s.mu.Lock()
+	sc := s.cur
+	if sc == nil || sc.add(ch) != nil {
+		s.mu.Unlock()
+		s.wmu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	_, err := io.WriteString(sc, "zPING\x00")
+	s.wmu.Unlock()
+	if err != nil {
+		s.fail(sc, fmt.Errorf("clamd: session: %v", err))
+		return
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-ch:
+		if r.err == nil && r.reply != "PONG" {
+			s.fail(sc, ClamdError(r.reply))
+		}
+	case <-t.C:
+		s.fail(sc, errors.New("clamd: session: health check timed out"))
+	}
+}
+
+// expire ends the connection of the session if it has been idle for IdleTimeout
+func (s *ClamdSession) expire() {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.mu.Lock()
+	sc := s.cur
+	if sc == nil || len(sc.pending) > 0 || time.Since(s.lastUsed) < s.c.IdleTimeout {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	io.WriteString(sc, "zEND\x00")
+	s.fail(sc, errSessionExpired)
+}
+
+// Close ends the session. Requests still waiting for a reply fail.
+func (s *ClamdSession) Close() error {
+	s.wmu.Lock()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		s.wmu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	sc := s.cur
+	s.mu.Unlock()
+	var err error
+	if sc != nil {
+		_, err = io.WriteString(sc, "zEND\x00")
+	}
+	s.wmu.Unlock()
+
+	if sc != nil {
+		s.fail(sc, errSessionClosed)
+	}
+	return err
+}
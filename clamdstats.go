@@ -0,0 +1,256 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClamdVersion is the reply to the VERSION command
+type ClamdVersion struct {
+	Version   string    // e.g. "ClamAV 0.103.8"
+	DBVersion uint      // version of the daily database, zero if clamd did not report it
+	DBTime    time.Time // build time of the daily database
+}
+
+// ClamdStats is the reply to the STATS command
+type ClamdStats struct {
+	Pools   []ClamdPoolStats
+	Mem     ClamdMemStats
+	Unknown []string // lines that could not be parsed, from newer clamd versions
+}
+
+// ClamdPoolStats describes a thread pool of clamd
+type ClamdPoolStats struct {
+	State       string // e.g. "VALID PRIMARY"
+	Live        int    // busy threads
+	Idle        int
+	Max         int
+	IdleTimeout time.Duration
+	QueueLen    int
+	Queue       []ClamdQueueItem
+}
+
+// ClamdQueueItem is a queued or running command
+type ClamdQueueItem struct {
+	Command string
+	Age     time.Duration
+	Info    string // the rest of the line, such as the file scanned
+}
+
+// ClamdMemStats are the memory statistics of clamd, in bytes. Values clamd could not report are
+// -1.
+type ClamdMemStats struct {
+	Heap, Mmap, Used, Free, Releasable int64
+	Pools                              int
+	PoolsUsed, PoolsTotal              int64
+}
+
+// VersionInfo returns the parsed reply to the VERSION command
+func (c *ClamdClient) VersionInfo() (*ClamdVersion, error) {
+	reply, err := c.command("VERSION")
+	if err != nil {
+		return nil, err
+	}
+	return parseClamdVersion(reply)
+}
+
+// VersionCommands returns the version of clamd and the commands it supports
+func (c *ClamdClient) VersionCommands() (*ClamdVersion, []string, error) {
+	reply, err := c.command("VERSIONCOMMANDS")
+	if err != nil {
+		return nil, nil, err
+	}
+	i := strings.Index(reply, "| COMMANDS:")
+	if i < 0 {
+		return nil, nil, ClamdError(reply)
+	}
+	v, err := parseClamdVersion(reply[:i])
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, strings.Fields(reply[i+len("| COMMANDS:"):]), nil
+}
+
+// Stats returns the parsed reply to the STATS command
+func (c *ClamdClient) Stats() (*ClamdStats, error) {
+	reply, err := c.command("STATS")
+	if err != nil {
+		return nil, err
+	}
+	return parseClamdStats(reply)
+}
+
+// parseClamdVersion parses a version such as "ClamAV 0.103.8/26000/Mon Jan  1 00:00:00 2024".
+// Without a database the reply is just "ClamAV 0.103.8".
+func parseClamdVersion(s string) (*ClamdVersion, error) {
+	f := strings.SplitN(strings.TrimSpace(s), "/", 3)
+	if !strings.HasPrefix(f[0], "ClamAV ") {
+		return nil, fmt.Errorf("clamd: unexpected version %q", s)
+	}
+	v := &ClamdVersion{Version: f[0]}
+	if len(f) == 1 {
+		return v, nil
+	}
+	n, err := strconv.ParseUint(f[1], 10, 0)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: unexpected database version in %q", s)
+	}
+	v.DBVersion = uint(n)
+	if len(f) == 3 {
+		v.DBTime, err = time.Parse(time.ANSIC, f[2])
+		if err != nil {
+			return nil, fmt.Errorf("clamd: unexpected database time in %q", s)
+		}
+	}
+	return v, nil
+}
+
+// parseClamdStats parses the multi-line STATS reply, ending in "END"
+func parseClamdStats(s string) (*ClamdStats, error) {
+	st := &ClamdStats{}
+	var pool *ClamdPoolStats
+	var inQueue, ended bool
+
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		if inQueue && strings.HasPrefix(line, "\t") {
+			pool.Queue = append(pool.Queue, parseQueueItem(line))
+			continue
+		}
+		inQueue = false
+
+		key, val := line, ""
+		if i := strings.Index(line, ": "); i >= 0 {
+			key, val = line[:i], line[i+2:]
+		}
+		var err error
+		switch key {
+		case "POOLS":
+			_, err = strconv.Atoi(val)
+		case "STATE":
+			st.Pools = append(st.Pools, ClamdPoolStats{State: val})
+			pool = &st.Pools[len(st.Pools)-1]
+		case "THREADS":
+			if pool == nil {
+				return nil, fmt.Errorf("clamd: STATS: THREADS outside a pool")
+			}
+			err = parseThreads(pool, val)
+		case "QUEUE":
+			if pool == nil {
+				return nil, fmt.Errorf("clamd: STATS: QUEUE outside a pool")
+			}
+			pool.QueueLen, err = strconv.Atoi(strings.TrimSuffix(val, " items"))
+			inQueue = true
+		case "MEMSTATS":
+			err = parseMemStats(&st.Mem, val)
+		case "END":
+			ended = true
+		default:
+			st.Unknown = append(st.Unknown, line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("clamd: STATS: unexpected line %q", line)
+		}
+	}
+	if !ended {
+		return nil, ClamdError(strings.TrimSpace(s))
+	}
+	return st, nil
+}
+
+// parseThreads parses "live 1  idle 0 max 12 idle-timeout 30"
+func parseThreads(pool *ClamdPoolStats, s string) error {
+	f := strings.Fields(s)
+	for i := 0; i+1 < len(f); i += 2 {
+		n, err := strconv.Atoi(f[i+1])
+		if err != nil {
+			return err
+		}
+		switch f[i] {
+		case "live":
+			pool.Live = n
+		case "idle":
+			pool.Idle = n
+		case "max":
+			pool.Max = n
+		case "idle-timeout":
+			pool.IdleTimeout = time.Duration(n) * time.Second
+		}
+	}
+	return nil
+}
+
+// parseQueueItem parses "\tSTATS 0.000052 " or "\tINSTREAM 1.250041 /tmp/file"
+func parseQueueItem(s string) ClamdQueueItem {
+	f := strings.SplitN(strings.TrimSpace(s), " ", 3)
+	q := ClamdQueueItem{Command: f[0]}
+	if len(f) > 1 {
+		if sec, err := strconv.ParseFloat(f[1], 64); err == nil {
+			q.Age = time.Duration(sec * float64(time.Second))
+		}
+	}
+	if len(f) > 2 {
+		q.Info = strings.TrimSpace(f[2])
+	}
+	return q
+}
+
+// parseMemStats parses "heap 3.656M mmap 0.129M used 3.130M free 0.528M releasable 0.125M
+// pools 1 pools_used 565.013M pools_total 565.046M", where values may be "N/A"
+func parseMemStats(m *ClamdMemStats, s string) error {
+	f := strings.Fields(s)
+	for i := 0; i+1 < len(f); i += 2 {
+		if f[i] == "pools" {
+			n, err := strconv.Atoi(f[i+1])
+			if err != nil {
+				return err
+			}
+			m.Pools = n
+			continue
+		}
+		n, err := parseMegabytes(f[i+1])
+		if err != nil {
+			return err
+		}
+		switch f[i] {
+		case "heap":
+			m.Heap = n
+		case "mmap":
+			m.Mmap = n
+		case "used":
+			m.Used = n
+		case "free":
+			m.Free = n
+		case "releasable":
+			m.Releasable = n
+		case "pools_used":
+			m.PoolsUsed = n
+		case "pools_total":
+			m.PoolsTotal = n
+		}
+	}
+	return nil
+}
+
+// parseMegabytes converts a size such as "3.656M" to bytes, "N/A" to -1
+func parseMegabytes(s string) (int64, error) {
+	if s == "N/A" {
+		return -1, nil
+	}
+	mb, err := strconv.ParseFloat(strings.TrimSuffix(s, "M"), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(mb * (1 << 20)), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cvdHeaderSize is the size of the header of .cvd and .cld files, padded with spaces
+const cvdHeaderSize = 512
+
+// CVDHeader is the header of a signature database container (.cvd, or .cld once updated with
+// diffs), such as daily.cvd
+type CVDHeader struct {
+	Name    string // database name, from the file name, e.g. "daily"
+	Time    string // build time, as written by the builder
+	Version uint
+	Sigs    uint // number of signatures
+	Flevel  uint // minimal functionality level
+	MD5     string
+	DSig    string // digital signature of MD5
+	Builder string
+	Built   time.Time // build time, from the header timestamp
+}
+
+// ReadCVDHeader reads the header of the database container at path
+func ReadCVDHeader(path string) (*CVDHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadCVDHeader: %v", err)
+	}
+	defer f.Close()
+	buf := make([]byte, cvdHeaderSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, fmt.Errorf("ReadCVDHeader: %s: %v", path, err)
+	}
+	h, err := parseCVDHeader(buf)
+	if err != nil {
+		return nil, fmt.Errorf("ReadCVDHeader: %s: %v", path, err)
+	}
+	h.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return h, nil
+}
+
+// parseCVDHeader parses a header such as
+// "ClamAV-VDB:16 Oct 2026 07:52 -0400:27431:2069437:90:<md5>:<dsig>:builder:1792150320"
+func parseCVDHeader(buf []byte) (*CVDHeader, error) {
+	buf = bytes.TrimRight(buf, " \x00")
+	f := strings.Split(string(buf), ":")
+	// the build time itself contains a colon
+	if len(f) < 10 || f[0] != "ClamAV-VDB" {
+		return nil, fmt.Errorf("not a database header")
+	}
+	f = append([]string{f[0], f[1] + ":" + f[2]}, f[3:]...)
+	var nums [3]uint64
+	for i := range nums {
+		n, err := strconv.ParseUint(f[2+i], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid header field %q", f[2+i])
+		}
+		nums[i] = n
+	}
+	h := &CVDHeader{
+		Time:    f[1],
+		Version: uint(nums[0]),
+		Sigs:    uint(nums[1]),
+		Flevel:  uint(nums[2]),
+		MD5:     f[5],
+		DSig:    f[6],
+		Builder: f[7],
+	}
+	if stime, err := strconv.ParseInt(f[8], 10, 64); err == nil {
+		h.Built = time.Unix(stime, 0).UTC()
+	}
+	return h, nil
+}
+
+// DatabaseVersions returns the headers of the database containers in dir, by name. Databases
+// loaded from other files, such as unofficial .ndb or .hdb files, have no version.
+func DatabaseVersions(dir string) ([]CVDHeader, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("DatabaseVersions: %v", err)
+	}
+	var hs []CVDHeader
+	for _, fi := range entries {
+		switch filepath.Ext(fi.Name()) {
+		case ".cvd", ".cld":
+		default:
+			continue
+		}
+		h, err := ReadCVDHeader(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("DatabaseVersions: %v", err)
+		}
+		hs = append(hs, *h)
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].Name < hs[j].Name })
+	return hs, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCVD writes a database container with a header and no content to dir
+func writeCVD(t *testing.T, dir, name string, version uint) {
+	h := fmt.Sprintf("ClamAV-VDB:16 Oct 2026 07:52 -0400:%d:2000:90:0123456789abcdef0123456789abcdef:dsig:builder:1792150320", version)
+	h += strings.Repeat(" ", cvdHeaderSize-len(h))
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(h), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestDatabaseVersions(t *testing.T) {
+	dir := t.TempDir()
+	writeCVD(t, dir, "main.cvd", 62)
+	writeCVD(t, dir, "daily.cld", 27431)
+	ioutil.WriteFile(filepath.Join(dir, "local.ndb"), []byte("Test:0:*:414243\n"), 0644)
+
+	dbs, err := DatabaseVersions(dir)
+	if err != nil {
+		t.Fatalf("DatabaseVersions: %v", err)
+	}
+	if len(dbs) != 2 || dbs[0].Name != "daily" || dbs[0].Version != 27431 || dbs[1].Name != "main" || dbs[1].Version != 62 {
+		t.Fatalf("DatabaseVersions: %+v", dbs)
+	}
+	want := CVDHeader{Name: "daily", Time: "16 Oct 2026 07:52 -0400", Version: 27431, Sigs: 2000, Flevel: 90,
+		MD5: "0123456789abcdef0123456789abcdef", DSig: "dsig", Builder: "builder", Built: time.Unix(1792150320, 0).UTC()}
+	if dbs[0] != want {
+		t.Errorf("DatabaseVersions: got %+v, want %+v", dbs[0], want)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "bytecode.cvd"), []byte("not a database"), 0644)
+	if _, err := DatabaseVersions(dir); err == nil {
+		t.Errorf("DatabaseVersions: truncated header accepted")
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#include <clamav.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// CVDKey is an RSA public key verifying the digital signature of database containers, for
+// private databases signed by the operator in the scheme of the official ones. The keys of the
+// official databases are built into libclamav.
+type CVDKey struct {
+	N *big.Int
+	E int
+}
+
+// cvdSigAlphabet is the alphabet of the little-endian base 64 encoding of signatures
+const cvdSigAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+/"
+
+// verify checks that dsig is the signature of the hex encoded MD5 sum
+func (k *CVDKey) verify(sum, dsig string) bool {
+	c := new(big.Int)
+	for i := len(dsig) - 1; i >= 0; i-- {
+		d := strings.IndexByte(cvdSigAlphabet, dsig[i])
+		if d < 0 {
+			return false
+		}
+		c.Lsh(c, 6)
+		c.Or(c, big.NewInt(int64(d)))
+	}
+	p := new(big.Int).Exp(c, big.NewInt(int64(k.E)), k.N)
+	b := p.Bytes()
+	if len(b) > md5.Size {
+		return false
+	}
+	plain := make([]byte, md5.Size)
+	copy(plain[md5.Size-len(b):], b)
+	return hex.EncodeToString(plain) == strings.ToLower(sum)
+}
+
+// VerifyCVD checks the database container at path: its content must match the MD5 sum of its
+// header, and the digital signature of the sum must be valid for one of keys, or, if no keys
+// are given, for the keys of the official databases as verified by libclamav. Containers
+// updated locally (.cld) carry no signature and fail.
+func VerifyCVD(path string, keys ...*CVDKey) error {
+	h, err := ReadCVDHeader(path)
+	if err != nil {
+		return fmt.Errorf("VerifyCVD: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("VerifyCVD: %v", err)
+	}
+	defer f.Close()
+	sum := md5.New()
+	if _, err := io.Copy(sum, io.NewSectionReader(f, cvdHeaderSize, 1<<62)); err != nil {
+		return fmt.Errorf("VerifyCVD: %v", err)
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != strings.ToLower(h.MD5) {
+		return fmt.Errorf("VerifyCVD: %s: content does not match the header", path)
+	}
+
+	if len(keys) == 0 {
+		cpath := C.CString(path)
+		defer C.free(unsafe.Pointer(cpath))
+		if err := ErrorCode(C.cl_cvdverify(cpath)); err != Success {
+			return fmt.Errorf("VerifyCVD: %s: %v", path, StrError(err))
+		}
+		return nil
+	}
+	for _, k := range keys {
+		if k.verify(h.MD5, h.DSig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("VerifyCVD: %s: invalid signature", path)
+}
+
+// InstallCVD verifies the downloaded database container at path with VerifyCVD and moves it
+// into dbdir, replacing the previous version atomically. Containers failing verification are
+// moved into the quarantine directory, if not empty, for inspection, and removed otherwise.
+func InstallCVD(path, dbdir, quarantine string, keys ...*CVDKey) error {
+	if verr := VerifyCVD(path, keys...); verr != nil {
+		if quarantine == "" {
+			os.Remove(path)
+			return fmt.Errorf("InstallCVD: %v", verr)
+		}
+		if err := moveFile(path, filepath.Join(quarantine, filepath.Base(path))); err != nil {
+			return fmt.Errorf("InstallCVD: %v, and quarantine failed: %v", verr, err)
+		}
+		return fmt.Errorf("InstallCVD: %v, quarantined", verr)
+	}
+	if err := moveFile(path, filepath.Join(dbdir, filepath.Base(path))); err != nil {
+		return fmt.Errorf("InstallCVD: %v", err)
+	}
+	return nil
+}
+
+// moveFile renames src to dst, copying it to a temporary file in the directory of dst first
+// if they are on different file systems, so that dst is replaced atomically
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// DatabaseStatus is the state of a database container of a directory
+type DatabaseStatus struct {
+	Path   string
+	Header *CVDHeader // nil if the header cannot be read
+	Err    error      // why the container cannot be loaded, nil if it is sound
+}
+
+// VerifyDatabaseDir checks the database containers of dir before they are loaded: .cvd files
+// with VerifyCVD, against the keys of the official databases or any of keys, and .cld and
+// .cud files, which carry no signature, for complete content. Empty and truncated files left
+// by interrupted updates fail. The status of every container is returned, by name; an error
+// is returned only if dir cannot be read.
+func VerifyDatabaseDir(dir string, keys ...*CVDKey) ([]DatabaseStatus, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyDatabaseDir: %v", err)
+	}
+	var sts []DatabaseStatus
+	for _, fi := range entries {
+		ext := filepath.Ext(fi.Name())
+		if ext != ".cvd" && ext != ".cld" && ext != ".cud" {
+			continue
+		}
+		st := DatabaseStatus{Path: filepath.Join(dir, fi.Name())}
+		switch {
+		case fi.Size() == 0:
+			st.Err = fmt.Errorf("%s: empty", st.Path)
+		case fi.Size() <= cvdHeaderSize:
+			st.Err = fmt.Errorf("%s: truncated", st.Path)
+		}
+		if st.Err == nil {
+			st.Header, st.Err = ReadCVDHeader(st.Path)
+		}
+		if st.Err == nil {
+			switch ext {
+			case ".cvd":
+				if st.Err = VerifyCVD(st.Path); st.Err != nil && len(keys) > 0 {
+					st.Err = VerifyCVD(st.Path, keys...)
+				}
+			case ".cld":
+				st.Err = checkCVDContent(st.Path, false)
+			case ".cud":
+				st.Err = checkCVDContent(st.Path, true)
+			}
+		}
+		sts = append(sts, st)
+	}
+	sort.Slice(sts, func(i, j int) bool { return sts[i].Path < sts[j].Path })
+	return sts, nil
+}
+
+// checkCVDContent reads the tar archive following the header of a container, compressed or
+// not, to its end
+func checkCVDContent(path string, compressed bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = io.NewSectionReader(f, cvdHeaderSize, 1<<62)
+	if compressed {
+		z, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		r = z
+	}
+	tr := tar.NewReader(r)
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, tr)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+}
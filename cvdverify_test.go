@@ -0,0 +1,158 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSignedCVD writes a container with content signed with key
+func writeSignedCVD(t *testing.T, path string, content []byte, key *rsa.PrivateKey) {
+	sum := md5.Sum(content)
+	s := new(big.Int).Exp(new(big.Int).SetBytes(sum[:]), key.D, key.N)
+	var dsig []byte
+	for s.Sign() > 0 {
+		dsig = append(dsig, cvdSigAlphabet[s.Int64()&63])
+		s.Rsh(s, 6)
+	}
+	h := fmt.Sprintf("ClamAV-VDB:16 Oct 2026 07:52 -0400:7:1:90:%s:%s:builder:1792150320", hex.EncodeToString(sum[:]), dsig)
+	h += strings.Repeat(" ", cvdHeaderSize-len(h))
+	if err := ioutil.WriteFile(path, append([]byte(h), content...), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestVerifyCVD(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key := &CVDKey{N: priv.N, E: priv.E}
+	otherKey := &CVDKey{N: other.N, E: other.E}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "private.cvd")
+	writeSignedCVD(t, path, []byte("database content"), priv)
+	if err := VerifyCVD(path, otherKey, key); err != nil {
+		t.Errorf("VerifyCVD: %v", err)
+	}
+	if err := VerifyCVD(path, otherKey); err == nil {
+		t.Errorf("VerifyCVD: signature of another key accepted")
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	b[len(b)-1] ^= 1
+	ioutil.WriteFile(path, b, 0644)
+	if err := VerifyCVD(path, key); err == nil {
+		t.Errorf("VerifyCVD: altered content accepted")
+	}
+}
+
+func TestInstallCVD(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key := &CVDKey{N: priv.N, E: priv.E}
+	dl, dbdir, quarantine := t.TempDir(), t.TempDir(), t.TempDir()
+
+	writeSignedCVD(t, filepath.Join(dbdir, "private.cvd"), []byte("old"), priv)
+	path := filepath.Join(dl, "private.cvd")
+	writeSignedCVD(t, path, []byte("new"), priv)
+	if err := InstallCVD(path, dbdir, quarantine, key); err != nil {
+		t.Fatalf("InstallCVD: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dbdir, "private.cvd")); !strings.HasSuffix(string(b), "new") {
+		t.Errorf("InstallCVD: database not replaced")
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	writeSignedCVD(t, path, []byte("forged"), other)
+	if err := InstallCVD(path, dbdir, quarantine, key); err == nil {
+		t.Fatalf("InstallCVD: forged database installed")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dbdir, "private.cvd")); !strings.HasSuffix(string(b), "new") {
+		t.Errorf("InstallCVD: database replaced by a forged one")
+	}
+	if _, err := os.Stat(filepath.Join(quarantine, "private.cvd")); err != nil {
+		t.Errorf("InstallCVD: forged database not quarantined: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("InstallCVD: forged database left in place")
+	}
+}
+
+// tarBytes returns a tar archive of one file
+func tarBytes(t *testing.T, name, data string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))})
+	tw.Write([]byte(data))
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyDatabaseDir(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	dir := t.TempDir()
+	writeSignedCVD(t, filepath.Join(dir, "private.cvd"), []byte("signed content"), priv)
+	ioutil.WriteFile(filepath.Join(dir, "bytecode.cvd"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "local.ndb"), nil, 0644)
+
+	cld := tarBytes(t, "daily.ndb", strings.Repeat("Sig:0:*:414243\n", 100))
+	for name, content := range map[string][]byte{
+		"daily.cld":        cld,
+		"main.cld":         cld[:700],
+		"safebrowsing.cud": gzipBytes(t, cld),
+	} {
+		writeCVD(t, dir, name, 7)
+		f, _ := os.OpenFile(filepath.Join(dir, name), os.O_APPEND|os.O_WRONLY, 0)
+		f.Write(content)
+		f.Close()
+	}
+
+	sts, err := VerifyDatabaseDir(dir, &CVDKey{N: priv.N, E: priv.E})
+	if err != nil {
+		t.Fatalf("VerifyDatabaseDir: %v", err)
+	}
+	want := map[string]bool{"bytecode.cvd": false, "daily.cld": true, "main.cld": false, "private.cvd": true, "safebrowsing.cud": true}
+	if len(sts) != len(want) {
+		t.Fatalf("VerifyDatabaseDir: %d containers, want %d", len(sts), len(want))
+	}
+	for _, st := range sts {
+		name := filepath.Base(st.Path)
+		if ok := st.Err == nil; ok != want[name] {
+			t.Errorf("VerifyDatabaseDir: %s: %v", name, st.Err)
+		}
+		if st.Err == nil && st.Header == nil {
+			t.Errorf("VerifyDatabaseDir: %s: no header", name)
+		}
+	}
+
+	if _, err := VerifyDatabaseDir(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("VerifyDatabaseDir: missing directory accepted")
+	}
+}
@@ -87,6 +87,9 @@ const (
 	EngineBytecodeSecurity             = C.CL_ENGINE_BYTECODE_SECURITY // uint32_t
 	EngineBytecodeTimeout              = C.CL_ENGINE_BYTECODE_TIMEOUT  // uint32_t
 	EngineBytecodeMode                 = C.CL_ENGINE_BYTECODE_MODE     // uint32_t
+	EngineMaxScantime                  = C.CL_ENGINE_MAX_SCANTIME      // uint32_t, in milliseconds
+	EngineDisablePeStats               = C.CL_ENGINE_DISABLE_PE_STATS  // uint32_t
+	EngineStatsTimeout                 = C.CL_ENGINE_STATS_TIMEOUT     // uint32_t, in seconds
 )
 
 // BytecodeSecurity models security settings for the bytecode scanner
@@ -155,6 +158,12 @@ const (
 	ScanGeneralHeuristics = 0x4
 	// ScanGeneralHeuristicsPrecendence allow heuristic match to take precedence
 	ScanGeneralHeuristicsPrecendence = 0x8
+	// ScanGeneralUnprivileged scanner may not have read access to files (libclamav 0.104)
+	ScanGeneralUnprivileged = 0x10
+	// ScanGeneralStoreHTMLURIs record the URIs found in HTML in the metadata (libclamav 1.3)
+	ScanGeneralStoreHTMLURIs = 0x20
+	// ScanGeneralStorePDFURIs record the URIs found in PDFs in the metadata (libclamav 1.3)
+	ScanGeneralStorePDFURIs = 0x40
 
 	// parsing capabilities options
 	ScanParseArchive = 0x1
@@ -167,19 +176,27 @@ const (
 	ScanParseOle2    = 0x80
 	ScanParseHTML    = 0x100
 	ScanParsePE      = 0x200
+	ScanParseOneNote = 0x400 // libclamav 1.1
+
+	// ScanParseImage parses images, such as for ScanHeuristicBrokenMedia (libclamav 1.0)
+	ScanParseImage = 0x800
+	// ScanParseImageFuzzyHash computes the fuzzy hash of images, for ImageSignatures (libclamav 1.0)
+	ScanParseImageFuzzyHash = 0x1000
 
 	// heuristic alerting options
-	ScanHeuristicBroken                = 0x2   // alert on broken PE and broken ELF files
-	ScanHeuristicExceedsMax            = 0x4   // alert when files exceed scan limits (filesize, max scansize, or max recursion depth)
-	ScanHeuristicPhishingSSLMismatch   = 0x8   // alert on SSL mismatches
-	ScanHeuristicPhishingCloak         = 0x10  // alert on cloaked URLs in emails
-	ScanHeuristicMacros                = 0x20  // alert on OLE2 files containing macros
-	ScanHeuristicEncryptedArchive      = 0x40  // alert if archive is encrypted (rar, zip, etc)
-	ScanHeuristicEncryptedDoc          = 0x80  // alert if a document is encrypted (pdf, docx, etc)
-	ScanHeuristicPartitionIntxn        = 0x100 // alert if partition table size doesn't make sense
-	ScanHeuristicStructure             = 0x200 // data loss prevention options, i.e. alert when detecting personal information
-	ScanHeuristicStructuredSSNNormal   = 0x400 // alert when detecting social security numbers
-	ScanHeuristicStructuredSSNStripped = 0x800 // alert when detecting stripped social security numbers
+	ScanHeuristicBroken                = 0x2    // alert on broken PE and broken ELF files
+	ScanHeuristicExceedsMax            = 0x4    // alert when files exceed scan limits (filesize, max scansize, or max recursion depth)
+	ScanHeuristicPhishingSSLMismatch   = 0x8    // alert on SSL mismatches
+	ScanHeuristicPhishingCloak         = 0x10   // alert on cloaked URLs in emails
+	ScanHeuristicMacros                = 0x20   // alert on OLE2 files containing macros
+	ScanHeuristicEncryptedArchive      = 0x40   // alert if archive is encrypted (rar, zip, etc)
+	ScanHeuristicEncryptedDoc          = 0x80   // alert if a document is encrypted (pdf, docx, etc)
+	ScanHeuristicPartitionIntxn        = 0x100  // alert if partition table size doesn't make sense
+	ScanHeuristicStructure             = 0x200  // data loss prevention options, i.e. alert when detecting personal information
+	ScanHeuristicStructuredSSNNormal   = 0x400  // alert when detecting social security numbers
+	ScanHeuristicStructuredSSNStripped = 0x800  // alert when detecting stripped social security numbers
+	ScanHeuristicStructuredCC          = 0x1000 // alert when detecting credit card numbers (libclamav 0.103)
+	ScanHeuristicBrokenMedia           = 0x2000 // alert on broken JPEG, TIFF, GIF and PNG files (libclamav 0.103)
 
 	// mail scanning options
 	ScanMailPartialMessage = 0x1
@@ -0,0 +1,117 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Causes of a DatabaseError, when the database at fault could be found
+var (
+	ErrNoDatabases       = errors.New("no databases found")
+	ErrEmptyDatabase     = errors.New("empty database")
+	ErrCorruptDatabase   = errors.New("corrupt database")
+	ErrDatabaseTooRecent = errors.New("database requires a newer libclamav")
+)
+
+// DatabaseError is the error of Load when libclamav fails to load the databases. It names the
+// database at fault, when it could be found, so that services can tell the operator what to
+// fix rather than only that a database is malformed.
+type DatabaseError struct {
+	Path string    // the database at fault, or the path loaded if none could be found
+	Code ErrorCode // returned by libclamav
+
+	// Err is ErrNoDatabases, ErrEmptyDatabase, ErrCorruptDatabase or ErrDatabaseTooRecent,
+	// possibly wrapped with details, or the error of libclamav
+	Err error
+}
+
+func (e *DatabaseError) Error() string {
+	return fmt.Sprintf("Load: %s: %v", e.Path, e.Err)
+}
+
+func (e *DatabaseError) Unwrap() error {
+	return e.Err
+}
+
+// databaseExts are the extensions of the files libclamav loads from a database directory
+var databaseExts = map[string]bool{
+	".cvd": true, ".cld": true, ".cud": true,
+	".db": true, ".hdb": true, ".hdu": true, ".hsb": true, ".hsu": true, ".mdb": true,
+	".mdu": true, ".msb": true, ".msu": true, ".ndb": true, ".ndu": true, ".ldb": true,
+	".ldu": true, ".sdb": true, ".zmd": true, ".rmd": true, ".idb": true, ".fp": true,
+	".sfp": true, ".gdb": true, ".pdb": true, ".wdb": true, ".cbc": true, ".cdb": true,
+	".cat": true, ".crb": true, ".ftm": true, ".info": true, ".cfg": true, ".ign": true,
+	".ign2": true, ".imp": true, ".yar": true, ".yara": true, ".pwdb": true,
+}
+
+// databaseError diagnoses why libclamav failed with code to load the database or the
+// directory of databases at path
+func databaseError(path string, code ErrorCode) *DatabaseError {
+	e := &DatabaseError{Path: path, Code: code, Err: errors.New(StrError(code))}
+	fi, err := os.Stat(path)
+	if err != nil {
+		e.Err = err
+		return e
+	}
+	paths := []string{path}
+	if fi.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			e.Err = err
+			return e
+		}
+		paths = paths[:0]
+		for _, fi := range entries {
+			if !fi.IsDir() && databaseExts[filepath.Ext(fi.Name())] {
+				paths = append(paths, filepath.Join(path, fi.Name()))
+			}
+		}
+		if len(paths) == 0 {
+			e.Err = ErrNoDatabases
+			return e
+		}
+		sort.Strings(paths)
+	}
+	for _, p := range paths {
+		if err := checkDatabase(p); err != nil {
+			e.Path, e.Err = p, err
+			break
+		}
+	}
+	return e
+}
+
+// checkDatabase returns the reason libclamav cannot load the database at path, if it can tell
+func checkDatabase(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		return ErrEmptyDatabase
+	}
+	ext := filepath.Ext(path)
+	if ext != ".cvd" && ext != ".cld" && ext != ".cud" {
+		return nil
+	}
+	h, err := ReadCVDHeader(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptDatabase, err)
+	}
+	if h.Flevel > Retflevel() {
+		return fmt.Errorf("%w: functionality level %d, libclamav has %d", ErrDatabaseTooRecent, h.Flevel, Retflevel())
+	}
+	// containers are gzipped but for the uncompressed .cld of incremental updates
+	if err := checkCVDContent(path, ext != ".cld"); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptDatabase, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDatabaseError(t *testing.T) {
+	dir := t.TempDir()
+	if err := databaseError(dir, Eopen); err.Err != ErrNoDatabases || err.Path != dir {
+		t.Errorf("databaseError: empty directory: %v", err)
+	}
+	if err := databaseError(filepath.Join(dir, "missing"), Eopen); !os.IsNotExist(err.Err) {
+		t.Errorf("databaseError: missing directory: %v", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "local.ndb"), []byte("Test:0:*:414243\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "README"), nil, 0644)
+	if err := databaseError(dir, Emalfdb); err.Path != dir || err.Code != Emalfdb || err.Err.Error() != StrError(Emalfdb) {
+		t.Errorf("databaseError: nothing wrong found: %v", err)
+	}
+
+	writeCVD(t, dir, "main.cvd", 62)
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "main.cvd"))
+	ioutil.WriteFile(filepath.Join(dir, "main.cvd"), []byte(strings.Replace(string(b), ":2000:90:", ":2000:9999:", 1)), 0644)
+	writeCVD(t, dir, "daily.cld", 7)
+	ioutil.WriteFile(filepath.Join(dir, "bytecode.cvd"), nil, 0644)
+	for _, want := range []struct {
+		name string
+		err  error
+	}{
+		{"bytecode.cvd", ErrEmptyDatabase},
+		{"main.cvd", ErrDatabaseTooRecent},
+	} {
+		var err error = databaseError(dir, Emalfdb)
+		var dberr *DatabaseError
+		if !errors.As(err, &dberr) || !errors.Is(err, want.err) || dberr.Path != filepath.Join(dir, want.name) {
+			t.Errorf("databaseError: %v, want %v of %s", err, want.err, want.name)
+		}
+		os.Remove(filepath.Join(dir, want.name))
+	}
+
+	cld := tarBytes(t, "daily.ndb", strings.Repeat("Sig:0:*:414243\n", 100))
+	writeCVD(t, dir, "daily.cld", 7)
+	f, _ := os.OpenFile(filepath.Join(dir, "daily.cld"), os.O_APPEND|os.O_WRONLY, 0)
+	f.Write(cld[:700])
+	f.Close()
+	if err := databaseError(dir, Emalfdb); !errors.Is(err, ErrCorruptDatabase) || !strings.Contains(err.Error(), "daily.cld") {
+		t.Errorf("databaseError: truncated database: %v", err)
+	}
+}
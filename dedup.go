@@ -0,0 +1,81 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DedupScanner is a Scanner that coalesces concurrent scans of identical content, such as the
+// same attachment sent to many recipients at once: while a scan is in progress, scans of data
+// with the same SHA-256 wait for it and share its result instead of scanning again. Only scans
+// running at the same time are coalesced, results are not cached.
+//
+// The data has to be hashed before scanning, so it is held in memory or, if large, in a
+// temporary file unless it is read from an io.ReadSeeker.
+type DedupScanner struct {
+	Scanner Scanner
+
+	mu    sync.Mutex
+	calls map[[sha256.Size]byte]*dedupCall
+}
+
+// dedupCall is a scan in progress
+type dedupCall struct {
+	done chan struct{}
+	res  *ScanResult
+	err  error
+}
+
+// Scan scans the data read from r, or waits for a scan of the same data in progress
+func (s *DedupScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	rs, cleanup, err := replayable(r)
+	if err != nil {
+		return nil, fmt.Errorf("DedupScanner: %v", err)
+	}
+	defer cleanup()
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("DedupScanner: %v", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, rs); err != nil {
+		return nil, fmt.Errorf("DedupScanner: %v", err)
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("DedupScanner: %v", err)
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+
+	s.mu.Lock()
+	if c, ok := s.calls[key]; ok {
+		s.mu.Unlock()
+		<-c.done
+		if c.err != nil {
+			return nil, c.err
+		}
+		res := *c.res
+		res.Name = name
+		return &res, nil
+	}
+	c := &dedupCall{done: make(chan struct{})}
+	if s.calls == nil {
+		s.calls = map[[sha256.Size]byte]*dedupCall{}
+	}
+	s.calls[key] = c
+	s.mu.Unlock()
+
+	c.res, c.err = s.Scanner.Scan(rs, name)
+
+	s.mu.Lock()
+	delete(s.calls, key)
+	s.mu.Unlock()
+	close(c.done)
+	return c.res, c.err
+}
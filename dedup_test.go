@@ -0,0 +1,70 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingScanner counts the scans it runs, holding them until release is closed
+type blockingScanner struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (s *blockingScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	atomic.AddInt32(&s.calls, 1)
+	<-s.release
+	return eicarScanner{}.Scan(r, name)
+}
+
+func TestDedupScanner(t *testing.T) {
+	bs := &blockingScanner{release: make(chan struct{})}
+	s := &DedupScanner{Scanner: bs}
+
+	var wg sync.WaitGroup
+	results := make([]*ScanResult, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := s.Scan(bytes.NewReader(eicar), fmt.Sprintf("copy%d", i))
+			if err != nil {
+				t.Errorf("Scan: %v", err)
+			}
+			results[i] = res
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if res, err := s.Scan(bytes.NewReader([]byte("other")), "other"); err != nil || res.Virus != "" {
+			t.Errorf("Scan: other: %+v %v", res, err)
+		}
+	}()
+
+	// let all scans reach the scanner or wait for it
+	time.Sleep(50 * time.Millisecond)
+	close(bs.release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&bs.calls); n != 2 {
+		t.Errorf("Scan: %d scans run, want 2", n)
+	}
+	for i, res := range results {
+		if res == nil || res.Virus != "Eicar-Test-Signature" || res.Name != fmt.Sprintf("copy%d", i) {
+			t.Errorf("Scan: copy%d: %+v", i, res)
+		}
+	}
+	if len(s.calls) != 0 {
+		t.Errorf("Scan: %d scans left in flight", len(s.calls))
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#include <sys/stat.h>
+#include <unistd.h>
+
+static long long pread_fd(int fd, void *buf, size_t count, long long offset)
+{
+	return pread(fd, buf, count, (off_t)offset);
+}
+
+static long long size_fd(int fd)
+{
+	struct stat st;
+	if (fstat(fd, &st) < 0)
+		return -1;
+	return st.st_size;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// descReader reads a file descriptor passed to a callback with pread from the start, leaving
+// its offset alone. The descriptor belongs to libclamav and must not be closed.
+type descReader struct {
+	fd  int
+	off int64
+}
+
+func (r *descReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := C.pread_fd(C.int(r.fd), unsafe.Pointer(&p[0]), C.size_t(len(p)), C.longlong(r.off))
+	switch {
+	case n < 0:
+		return 0, fmt.Errorf("pread: fd %d failed", r.fd)
+	case n == 0:
+		return 0, io.EOF
+	}
+	r.off += int64(n)
+	return int(n), nil
+}
+
+// descSize returns the size of the file open as fd, -1 if unknown
+func descSize(fd int) int64 {
+	return int64(C.size_fd(C.int(fd)))
+}
@@ -0,0 +1,59 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Detector is an auxiliary detection an EngineScanner runs on the objects it scans, on the same
+// data as libclamav, such as custom heuristics or entropy checks, to extend detection without
+// a scanning loop of its own
+type Detector interface {
+	// Detect inspects the size bytes of r, of the type libclamav determined, such as
+	// "CL_TYPE_PDF", returning the names of what it detected, none if nothing. It may be
+	// called from several goroutines at once.
+	Detect(r io.ReaderAt, size int64, fileType string) ([]string, error)
+}
+
+// DetectorFunc adapts a function to the Detector interface
+type DetectorFunc func(r io.ReaderAt, size int64, fileType string) ([]string, error)
+
+// Detect calls f
+func (f DetectorFunc) Detect(r io.ReaderAt, size int64, fileType string) ([]string, error) {
+	return f(r, size, fileType)
+}
+
+// ClamAVDetector is the Detector of the detections of libclamav itself
+const ClamAVDetector = "clamav"
+
+// Detection is something detected in an object, by libclamav or by a Detector
+type Detection struct {
+	Detector string // ClamAVDetector or the name of the Detector
+	Name     string
+}
+
+// runDetectors runs the detectors, by name, on the object, returning what those before a
+// failing one found along with its error
+func runDetectors(detectors map[string]Detector, r io.ReaderAt, size int64, fileType string) ([]Detection, error) {
+	var found []Detection
+	names := make([]string, 0, len(detectors))
+	for name := range detectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		detected, err := detectors[name].Detect(r, size, fileType)
+		if err != nil {
+			return found, fmt.Errorf("detector %s: %v", name, err)
+		}
+		for _, d := range detected {
+			found = append(found, Detection{name, d})
+		}
+	}
+	return found, nil
+}
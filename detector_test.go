@@ -0,0 +1,59 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDetectors(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	// detects objects starting with "xx", and reports their size
+	var sizes []int64
+	xx := DetectorFunc(func(r io.ReaderAt, size int64, fileType string) ([]string, error) {
+		sizes = append(sizes, size)
+		b := make([]byte, 2)
+		if _, err := r.ReadAt(b, 0); err != nil || string(b) != "xx" {
+			return nil, nil
+		}
+		return []string{"Custom.XX"}, nil
+	})
+	always := DetectorFunc(func(io.ReaderAt, int64, string) ([]string, error) { return []string{"Custom.Always"}, nil })
+	s := &EngineScanner{Engine: eng, Options: stdopts, Detectors: map[string]Detector{"xx": xx, "always": always}}
+
+	res, err := s.Scan(bytes.NewReader(eicar), "eicar")
+	want := []Detection{{ClamAVDetector, res.Virus}, {"always", "Custom.Always"}}
+	if err != nil || res.Virus == "" || len(res.Detections) != 2 || res.Detections[0] != want[0] || res.Detections[1] != want[1] {
+		t.Errorf("Scan: eicar: %+v %v", res, err)
+	}
+
+	big := bytes.Repeat([]byte("x"), readerMemoryLimit+1)
+	delete(s.Detectors, "always")
+	res, err = s.Scan(bytes.NewReader(big), "big")
+	if err != nil || res.Virus != "Custom.XX" || len(res.Detections) != 1 || res.Detections[0] != (Detection{"xx", "Custom.XX"}) {
+		t.Errorf("Scan: spooled: %+v %v", res, err)
+	}
+	if len(sizes) != 2 || sizes[1] != int64(len(big)) {
+		t.Errorf("Detect: sizes %v", sizes)
+	}
+
+	s.Detectors["broken"] = DetectorFunc(func(io.ReaderAt, int64, string) ([]string, error) { return nil, errors.New("broken") })
+	if _, err := s.Scan(bytes.NewReader([]byte("clean")), "clean"); err == nil {
+		t.Errorf("Scan: detector error not reported")
+	}
+	// a broken detector does not hide the detection of libclamav
+	res, err = s.Scan(bytes.NewReader(eicar), "eicar")
+	if err == nil || res == nil || res.Virus != "Eicar-Test-Signature" {
+		t.Errorf("Scan: eicar with a broken detector: %+v %v", res, err)
+	}
+}
@@ -0,0 +1,538 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DirScanner scans the regular files of directory trees, for bulk scans of file servers, backup
+// volumes and the like. The tree is enumerated first, then files are scanned smallest first, so
+// that millions of tiny files are not held up behind a few huge archives, which can be given
+// workers of their own. Files with several hard links, common on backup and mail store volumes,
+// are scanned once, under the first path found. On Windows, the alternate data streams of NTFS
+// files, where malware often hides, are scanned too, as path:stream.
+type DirScanner struct {
+	Engine  *Engine
+	Options *ScanOptions
+
+	// Filter returns why a file is not to be scanned, such as "excluded" or "too large", or an
+	// empty string to scan it. All files are scanned if nil.
+	Filter func(path string, fi fs.FileInfo) string
+
+	// DryRun enumerates the files that would be scanned, with their sizes, and those filtered
+	// out, without scanning anything, to validate filters before a long job. Engine may be nil.
+	DryRun bool
+
+	// Workers is the number of files scanned concurrently, one if zero
+	Workers int
+
+	// Files of LargeFileSize bytes or more are scanned by LargeWorkers dedicated workers, if
+	// both are set, rather than by the others
+	LargeFileSize int64
+	LargeWorkers  int
+
+	// SizeBuckets are the upper bounds, in increasing order, of the size buckets statistics are
+	// kept for, DefaultSizeBuckets if nil. A last bucket holds the larger files.
+	SizeBuckets []int64
+
+	// FollowSymlinks descends into the directories symbolic links point to, and scans the files
+	// they point to. Every directory is entered once: links back to a directory being walked
+	// are reported as SymlinkLoopSkipped, other links to a directory walked already as
+	// DirVisitedSkipped.
+	FollowSymlinks bool
+
+	// SparseExtents scans only the allocated extents of sparse files, such as the disk images
+	// of virtual machines, rather than reading their holes as zeros. The extents are scanned as
+	// RawScanner scans disks, in chunks scanned as objects of their own, so that files are found
+	// by their content wherever they lie in the image. Files are scanned whole where the system
+	// cannot tell their extents.
+	SparseExtents bool
+
+	// Remediation, if not nil, is the policy applied to the files viruses are found in. Files
+	// that changed while they were scanned are only reported, since the verdict may not be
+	// theirs.
+	Remediation *RemediationPolicy
+}
+
+// DefaultSizeBuckets are the default size buckets of directory scan statistics
+var DefaultSizeBuckets = []int64{64 << 10, 1 << 20, 16 << 20}
+
+// DirResult is the outcome for a file of a directory tree, or for a directory that was not
+// entered
+type DirResult struct {
+	Path    string
+	Size    int64
+	Scanned bool   // false if filtered out, or in a dry run
+	Skipped string // reason the file was filtered out, or HardLinkSkipped
+	LinkOf  string // for a file or directory seen already, the path it was first found under
+	Virus   string
+	Err     error // the file could not be read or scanned
+
+	// Sparse is set for the sparse files scanned by their extents, of which Allocated bytes
+	// were read
+	Sparse    bool
+	Allocated int64
+
+	// Changed is set if the size, modification time or inode of the file changed while it was
+	// scanned, or it was removed: the verdict may not be that of the file now on disk
+	Changed bool
+
+	// Remediation is the action taken on the file by the Remediation policy, nil if none was
+	// attempted
+	Remediation *Remediation
+}
+
+// HardLinkSkipped is the reason of the files not scanned because they are hard links to a file
+// scanned already
+const HardLinkSkipped = "hard link"
+
+// SymlinkLoopSkipped and DirVisitedSkipped are the reasons of the directories not entered when
+// following symbolic links, because they are being walked or were walked already
+const (
+	SymlinkLoopSkipped = "symlink loop"
+	DirVisitedSkipped  = "directory visited"
+)
+
+// DeadlineSkipped is the reason of the files not scanned because the context of the scan was
+// done before their turn
+const DeadlineSkipped = "deadline"
+
+// DirStats are the totals of a directory scan
+type DirStats struct {
+	Files    int64 // files scanned, or to be scanned in a dry run
+	Bytes    int64 // their total size
+	Infected int64
+	Errors   int64
+	Changed  int64 // files that changed while they were scanned
+
+	// Holes is the size of the holes of the sparse files scanned by their extents, which were
+	// not read
+	Holes int64
+
+	// Skipped counts the files filtered out by reason
+	Skipped map[string]int64
+
+	// Buckets are the statistics of the files scanned by size
+	Buckets []SizeBucketStats
+}
+
+// SizeBucketStats are the statistics of the files scanned in a size bucket
+type SizeBucketStats struct {
+	MaxSize  int64 // upper bound of the bucket, -1 for the last one
+	Files    int64
+	Bytes    int64
+	Duration time.Duration // total time spent scanning, summed over workers
+}
+
+// Throughput returns the bytes scanned per second of scanning time
+func (b *SizeBucketStats) Throughput() float64 {
+	if b.Duration <= 0 {
+		return 0
+	}
+	return float64(b.Bytes) / b.Duration.Seconds()
+}
+
+// dirFile is a file to be scanned
+type dirFile struct {
+	path   string
+	size   int64
+	sparse bool
+}
+
+// Scan scans the regular files below root, calling report, if not nil, for every one of them,
+// from one goroutine at a time. Scanning continues past files that cannot be read or scanned;
+// an error is returned only if root itself cannot be walked.
+func (s *DirScanner) Scan(root string, report func(*DirResult)) (*DirStats, error) {
+	return s.ScanContext(context.Background(), root, report)
+}
+
+// ScanContext is like Scan, within the deadline of ctx, if it has one, so that "scan this
+// mailbox within a minute" can be expressed. Before each file, the time left is divided among
+// the files left to scan and set as the EngineMaxScantime of the engine, so that no file takes
+// the time of the others; the setting of the engine is restored afterwards, and applies to
+// the other scans of the engine meanwhile. Files whose turn comes once ctx is done are
+// reported as DeadlineSkipped.
+func (s *DirScanner) ScanContext(ctx context.Context, root string, report func(*DirResult)) (*DirStats, error) {
+	st := &DirStats{Skipped: map[string]int64{}}
+	bounds := s.SizeBuckets
+	if bounds == nil {
+		bounds = DefaultSizeBuckets
+	}
+	for _, b := range bounds {
+		st.Buckets = append(st.Buckets, SizeBucketStats{MaxSize: b})
+	}
+	st.Buckets = append(st.Buckets, SizeBucketStats{MaxSize: -1})
+	if report == nil {
+		report = func(*DirResult) {}
+	}
+
+	var files []dirFile
+	links := map[[2]uint64]string{}
+	w := &dirWalk{follow: s.FollowSymlinks, visited: map[string]string{}, ancestors: map[string]bool{}}
+	w.fail = func(path string, err error) {
+		st.Errors++
+		report(&DirResult{Path: path, Err: err})
+	}
+	w.skip = func(path, why, linkOf string) {
+		st.Skipped[why]++
+		report(&DirResult{Path: path, Skipped: why, LinkOf: linkOf})
+	}
+	w.file = func(path string, fi fs.FileInfo) {
+		if s.Filter != nil {
+			if why := s.Filter(path, fi); why != "" {
+				st.Skipped[why]++
+				report(&DirResult{Path: path, Size: fi.Size(), Skipped: why})
+				return
+			}
+		}
+		if id, ok := fileID(fi); ok {
+			if first, seen := links[id]; seen {
+				st.Skipped[HardLinkSkipped]++
+				report(&DirResult{Path: path, Size: fi.Size(), Skipped: HardLinkSkipped, LinkOf: first})
+				return
+			}
+			links[id] = path
+		}
+		add := func(path string, size int64, sparse bool) {
+			st.Files++
+			st.Bytes += size
+			if s.DryRun {
+				report(&DirResult{Path: path, Size: size})
+				return
+			}
+			files = append(files, dirFile{path, size, sparse})
+		}
+		add(path, fi.Size(), s.SparseExtents && isSparse(fi))
+		streams, err := fileStreams(path)
+		if err != nil {
+			w.fail(path, err)
+		}
+		for _, a := range streams {
+			add(path+a.name, a.size, false)
+		}
+	}
+	err := w.root(root)
+	if err != nil {
+		return st, fmt.Errorf("DirScanner: %v", err)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].size < files[j].size })
+	small, large := files, []dirFile(nil)
+	if s.LargeFileSize > 0 && s.LargeWorkers > 0 {
+		n := sort.Search(len(files), func(i int) bool { return files[i].size >= s.LargeFileSize })
+		small, large = files[:n], files[n:]
+	}
+	var b *dirBudget
+	if deadline, ok := ctx.Deadline(); ok && len(files) > 0 {
+		b = &dirBudget{engine: s.Engine, deadline: deadline, workers: workers(s.Workers), left: len(files)}
+		if len(large) > 0 {
+			b.workers += workers(s.LargeWorkers)
+		}
+		if prev, err := s.Engine.GetNum(EngineMaxScantime); err == nil {
+			defer s.Engine.SetNum(EngineMaxScantime, prev)
+		}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	s.dispatch(ctx, b, &wg, small, s.Workers, st, &mu, report)
+	s.dispatch(ctx, b, &wg, large, s.LargeWorkers, st, &mu, report)
+	wg.Wait()
+	return st, nil
+}
+
+// workers returns the number of workers of a pool of n, at least one
+func workers(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// dirBudget divides the time left before the deadline of a directory scan among the files left
+type dirBudget struct {
+	engine   *Engine
+	deadline time.Time
+	workers  int
+
+	mu    sync.Mutex
+	left  int
+	limit uint64 // EngineMaxScantime set last
+}
+
+// next sets the scan time limit of the engine for the next file, and returns it
+func (b *dirBudget) next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// each worker has the time left for the files it has left
+	n := b.left
+	if n < b.workers {
+		n = b.workers
+	}
+	b.left--
+	d := time.Until(b.deadline) * time.Duration(b.workers) / time.Duration(n)
+	ms := uint64(d / time.Millisecond)
+	if ms < 1 {
+		// zero would disable the limit
+		ms = 1
+	}
+	if ms != b.limit {
+		b.engine.SetNum(EngineMaxScantime, ms)
+		b.limit = ms
+	}
+	return d
+}
+
+// dispatch scans files with n workers, in order
+func (s *DirScanner) dispatch(ctx context.Context, b *dirBudget, wg *sync.WaitGroup, files []dirFile, n int, st *DirStats, mu *sync.Mutex, report func(*DirResult)) {
+	n = workers(n)
+	work := make(chan dirFile)
+	go func() {
+		for _, f := range files {
+			work <- f
+		}
+		close(work)
+	}()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range work {
+				if ctx.Err() != nil {
+					mu.Lock()
+					st.Files--
+					st.Bytes -= f.size
+					st.Skipped[DeadlineSkipped]++
+					report(&DirResult{Path: f.path, Size: f.size, Skipped: DeadlineSkipped})
+					mu.Unlock()
+					continue
+				}
+				if b != nil {
+					b.next()
+				}
+				t := time.Now()
+				r := s.scanFile(f)
+				d := time.Since(t)
+
+				mu.Lock()
+				b := &st.Buckets[len(st.Buckets)-1]
+				for i := range st.Buckets {
+					if st.Buckets[i].MaxSize >= 0 && r.Size <= st.Buckets[i].MaxSize {
+						b = &st.Buckets[i]
+						break
+					}
+				}
+				b.Files++
+				b.Bytes += r.Size
+				b.Duration += d
+				if r.Changed {
+					st.Changed++
+				}
+				if r.Sparse {
+					st.Holes += r.Size - r.Allocated
+				}
+				if r.Virus != "" {
+					st.Infected++
+				} else if r.Err != nil {
+					st.Errors++
+				}
+				report(r)
+				mu.Unlock()
+			}
+		}()
+	}
+}
+
+// scanFile scans a single file
+func (s *DirScanner) scanFile(df dirFile) *DirResult {
+	r := &DirResult{Path: df.path, Size: df.size, Scanned: true}
+	before, err := statFile(df.path)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	f, err := os.Open(df.path)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer f.Close()
+	scanned := false
+	if df.sparse {
+		if ext, err := dataExtents(f, df.size, DefaultRawOverlap); err == nil {
+			s.scanExtents(r, f, ext)
+			scanned = true
+		}
+	}
+	if !scanned {
+		r.Virus, _, r.Err = s.Engine.ScanDesc(df.path, int(f.Fd()), s.Options)
+		if r.Virus != "" {
+			r.Err = nil
+		}
+	}
+
+	after, err := statFile(df.path)
+	r.Changed = err != nil || after != before
+	if r.Virus != "" && !r.Changed && s.Remediation != nil {
+		r.Remediation = s.Remediation.Remediate(f, df.path, r.Virus)
+	}
+	return r
+}
+
+// fileState is what tells whether a file changed
+type fileState struct {
+	size  int64
+	mtime int64
+	ino   uint64
+}
+
+// statFile returns the state of the file at path
+func statFile(path string) (fileState, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{fi.Size(), fi.ModTime().UnixNano(), fileInode(fi)}, nil
+}
+
+// scanExtents scans the extents ext of the sparse file f, recording the first virus found, or
+// else the first error, in r
+func (s *DirScanner) scanExtents(r *DirResult, f *os.File, ext []extent) {
+	r.Sparse = true
+	raw := &RawScanner{Engine: s.Engine, Options: s.Options}
+	for _, e := range ext {
+		r.Allocated += e.len
+		raw.ScanReaderAt(io.NewSectionReader(f, e.off, e.len), e.len, r.Path, func(d *RawDetection) {
+			if d.Virus != "" && r.Virus == "" {
+				r.Virus = d.Virus
+			} else if d.Err != nil && r.Err == nil {
+				r.Err = fmt.Errorf("DirScanner: %s at %d: %v", r.Path, e.off+d.Offset, d.Err)
+			}
+		})
+	}
+	if r.Virus != "" {
+		r.Err = nil
+	}
+}
+
+// fileStream is an alternate data stream of a file
+type fileStream struct {
+	name string // ":name:$DATA", appended to the path of the file to open the stream
+	size int64
+}
+
+// dirWalk walks a directory tree in lexical order, entering every directory once
+type dirWalk struct {
+	follow    bool
+	visited   map[string]string // resolved path of the directories entered, to their path
+	ancestors map[string]bool   // resolved path of the directories being walked
+
+	file func(path string, fi fs.FileInfo)
+	fail func(path string, err error)
+	skip func(path, why, linkOf string)
+}
+
+// root walks the tree at root, or visits root if it is a file
+func (w *dirWalk) root(root string) error {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if fi.Mode().IsRegular() {
+			w.file(root, fi)
+		}
+		return nil
+	}
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	if real, err = filepath.Abs(real); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	w.visited[real] = root
+	w.ancestors[real] = true
+	w.entries(root, real, entries)
+	return nil
+}
+
+// dir walks the directory at path, whose resolved path is real
+func (w *dirWalk) dir(path, real string) {
+	if w.ancestors[real] {
+		w.skip(path, SymlinkLoopSkipped, w.visited[real])
+		return
+	}
+	if first, ok := w.visited[real]; ok {
+		w.skip(path, DirVisitedSkipped, first)
+		return
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		w.fail(path, err)
+		return
+	}
+	if w.follow {
+		w.visited[real] = path
+	}
+	w.ancestors[real] = true
+	w.entries(path, real, entries)
+	delete(w.ancestors, real)
+}
+
+// entries visits the entries of the directory at path
+func (w *dirWalk) entries(path, real string, entries []fs.DirEntry) {
+	for _, d := range entries {
+		p := filepath.Join(path, d.Name())
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			if !w.follow {
+				continue
+			}
+			fi, err := os.Stat(p)
+			if err != nil {
+				w.fail(p, err)
+				continue
+			}
+			if fi.Mode().IsRegular() {
+				w.file(p, fi)
+				continue
+			}
+			if !fi.IsDir() {
+				continue
+			}
+			target, err := filepath.EvalSymlinks(p)
+			if err == nil {
+				target, err = filepath.Abs(target)
+			}
+			if err != nil {
+				w.fail(p, err)
+				continue
+			}
+			w.dir(p, target)
+		case d.IsDir():
+			w.dir(p, filepath.Join(real, d.Name()))
+		case d.Type().IsRegular():
+			fi, err := d.Info()
+			if err != nil {
+				w.fail(p, err)
+				continue
+			}
+			w.file(p, fi)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTree writes files, by path relative to dir
+func writeTree(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+}
+
+func TestDirScanner(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{
+		"docs/readme.txt":  []byte("hello"),
+		"docs/eicar.com":   eicar,
+		"cache/blob.tmp":   []byte("temporary"),
+		"media/movie.mkv":  make([]byte, 4096),
+		"media/poster.png": []byte("png"),
+	})
+	s := &DirScanner{Engine: eng, Options: stdopts, Filter: func(path string, fi fs.FileInfo) string {
+		if strings.HasSuffix(path, ".tmp") {
+			return "excluded"
+		}
+		if fi.Size() > 1024 {
+			return "too large"
+		}
+		return ""
+	}}
+
+	s.DryRun = true
+	var results []*DirResult
+	st, err := s.Scan(dir, func(r *DirResult) { results = append(results, r) })
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 5 || st.Files != 3 || st.Bytes != int64(5+len(eicar)+3) || st.Infected != 0 ||
+		st.Skipped["excluded"] != 1 || st.Skipped["too large"] != 1 {
+		t.Errorf("Scan: dry run %+v", st)
+	}
+	for _, r := range results {
+		if r.Scanned {
+			t.Errorf("Scan: %s scanned in a dry run", r.Path)
+		}
+	}
+
+	s.DryRun = false
+	infected := ""
+	st, err = s.Scan(dir, func(r *DirResult) {
+		if r.Virus != "" {
+			infected = r.Path
+		}
+	})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if st.Files != 3 || st.Infected != 1 || infected != filepath.Join(dir, "docs/eicar.com") {
+		t.Errorf("Scan: %+v, infected %q", st, infected)
+	}
+
+	if _, err := s.Scan(filepath.Join(dir, "missing"), nil); err == nil {
+		t.Errorf("Scan: missing root accepted")
+	}
+}
+
+func TestDirScannerBuckets(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{
+		"a/huge.zip":  make([]byte, 8192),
+		"a/tiny.txt":  []byte("x"),
+		"b/small.txt": make([]byte, 100),
+		"b/big.iso":   make([]byte, 4096),
+		"eicar.com":   eicar,
+	})
+
+	// smallest first with a single worker
+	s := &DirScanner{Engine: eng, Options: stdopts, SizeBuckets: []int64{1024}}
+	var sizes []int64
+	st, err := s.Scan(dir, func(r *DirResult) { sizes = append(sizes, r.Size) })
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	for i := 1; i < len(sizes); i++ {
+		if sizes[i] < sizes[i-1] {
+			t.Errorf("Scan: sizes scanned in order %v", sizes)
+			break
+		}
+	}
+	if len(st.Buckets) != 2 || st.Buckets[0].Files != 3 || st.Buckets[1].Files != 2 || st.Buckets[1].MaxSize != -1 ||
+		st.Buckets[1].Bytes != 8192+4096 {
+		t.Errorf("Scan: buckets %+v", st.Buckets)
+	}
+
+	s.Workers, s.LargeFileSize, s.LargeWorkers = 2, 1024, 1
+	if st, err = s.Scan(dir, nil); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if st.Files != 5 || st.Infected != 1 || st.Buckets[0].Files+st.Buckets[1].Files != 5 {
+		t.Errorf("Scan: %+v", st)
+	}
+}
+
+func TestDirScannerHardLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are not tracked on windows")
+	}
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"backup.0/mail": eicar, "backup.0/other": []byte("x")})
+	os.MkdirAll(filepath.Join(dir, "backup.1"), 0755)
+	if err := os.Link(filepath.Join(dir, "backup.0/mail"), filepath.Join(dir, "backup.1/mail")); err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+
+	var dup *DirResult
+	st, err := (&DirScanner{Engine: eng, Options: stdopts}).Scan(dir, func(r *DirResult) {
+		if r.Skipped != "" {
+			dup = r
+		}
+	})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if st.Files != 2 || st.Infected != 1 || st.Skipped[HardLinkSkipped] != 1 {
+		t.Errorf("Scan: %+v", st)
+	}
+	if dup == nil || dup.Path != filepath.Join(dir, "backup.1/mail") || dup.LinkOf != filepath.Join(dir, "backup.0/mail") {
+		t.Errorf("Scan: duplicate reported as %+v", dup)
+	}
+}
+
+func TestDirScannerSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on windows")
+	}
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"a/eicar.com": eicar, "b/readme": []byte("x")})
+	for link, target := range map[string]string{
+		"a/loop":   "..",   // back to the root
+		"b/alias":  "../a", // to a directory walked already
+		"b/eicar":  "../a/eicar.com",
+		"b/broken": "../missing",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatalf("Symlink: %v", err)
+		}
+	}
+
+	s := &DirScanner{Engine: eng, Options: stdopts}
+	st, err := s.Scan(dir, nil)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if st.Files != 2 || st.Infected != 1 || st.Errors != 0 {
+		t.Errorf("Scan: not following links %+v", st)
+	}
+
+	s.FollowSymlinks = true
+	var loop *DirResult
+	st, err = s.Scan(dir, func(r *DirResult) {
+		if r.Skipped == SymlinkLoopSkipped {
+			loop = r
+		}
+	})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if st.Files != 3 || st.Infected != 2 || st.Errors != 1 || st.Skipped[SymlinkLoopSkipped] != 1 || st.Skipped[DirVisitedSkipped] != 1 {
+		t.Errorf("Scan: following links %+v", st)
+	}
+	if loop == nil || loop.Path != filepath.Join(dir, "a/loop") || loop.LinkOf != dir {
+		t.Errorf("Scan: loop reported as %+v", loop)
+	}
+}
+
+func TestDirScannerDeadline(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"a": []byte("a"), "b": eicar, "c": []byte("c")})
+	s := &DirScanner{Engine: eng, Options: stdopts}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	st, err := s.ScanContext(ctx, dir, nil)
+	if err != nil || st.Files != 3 || st.Infected != 1 || len(st.Skipped) != 0 {
+		t.Errorf("ScanContext: %+v %v", st, err)
+	}
+
+	cancel()
+	var skipped []string
+	st, err = s.ScanContext(ctx, dir, func(r *DirResult) { skipped = append(skipped, r.Skipped) })
+	if err != nil || st.Files != 0 || st.Bytes != 0 || st.Skipped[DeadlineSkipped] != 3 || len(skipped) != 3 || skipped[0] != DeadlineSkipped {
+		t.Errorf("ScanContext: past the deadline: %+v %v", st, err)
+	}
+}
+
+func TestDirBudget(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	// 10 files left for 2 workers: each file gets a fifth of the time left
+	b := &dirBudget{engine: eng, deadline: time.Now().Add(10 * time.Second), workers: 2, left: 10}
+	if d := b.next(); d < 1900*time.Millisecond || d > 2*time.Second {
+		t.Errorf("next: %v for the first file", d)
+	}
+	b.left = 1
+	if d := b.next(); d < 9*time.Second {
+		t.Errorf("next: %v for the last file", d)
+	}
+	if b.limit == 0 || b.left != 0 {
+		t.Errorf("next: %+v", b)
+	}
+}
+
+func TestDirScannerSparse(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "disk.img")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	const size, off = 1 << 30, 512<<20 + 100
+	err = f.Truncate(size)
+	if err == nil {
+		_, err = f.WriteAt(eicar, off)
+	}
+	f.Close()
+	if err != nil {
+		t.Fatalf("sparse file: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || !isSparse(fi) {
+		t.Skip("no sparse files here")
+	}
+
+	var res *DirResult
+	st, err := (&DirScanner{Engine: eng, Options: stdopts, SparseExtents: true}).Scan(dir, func(r *DirResult) { res = r })
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if res == nil || !res.Sparse || res.Virus == "" || res.Allocated >= 64<<20 {
+		t.Fatalf("Scan: %+v", res)
+	}
+	if st.Infected != 1 || st.Holes != size-res.Allocated {
+		t.Errorf("Scan: %+v", st)
+	}
+}
+
+func TestDirScannerChanged(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"a": []byte("hello"), "b": eicar})
+	st, err := (&DirScanner{Engine: eng, Options: stdopts}).Scan(dir, func(r *DirResult) {
+		if r.Changed {
+			t.Errorf("Scan: %+v", r)
+		}
+	})
+	if err != nil || st.Changed != 0 {
+		t.Fatalf("Scan: %+v %v", st, err)
+	}
+
+	path := filepath.Join(dir, "a")
+	before, _ := statFile(path)
+	if after, err := statFile(path); err != nil || after != before {
+		t.Errorf("statFile: %+v %v, was %+v", after, err, before)
+	}
+	// replaced by a file of the same size and time
+	tmp := filepath.Join(dir, "a.new")
+	ioutil.WriteFile(tmp, []byte("world"), 0644)
+	mtime := time.Unix(0, before.mtime)
+	os.Chtimes(tmp, mtime, mtime)
+	os.Rename(tmp, path)
+	if after, err := statFile(path); err != nil || after == before && runtime.GOOS != "windows" {
+		t.Errorf("statFile: replaced file unchanged: %+v %v", after, err)
+	}
+	ioutil.WriteFile(path, []byte("longer"), 0644)
+	if after, err := statFile(path); err != nil || after == before {
+		t.Errorf("statFile: grown file unchanged: %+v %v", after, err)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package clamav
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID returns the device and inode numbers of a file with several hard links
+func fileID(fi fs.FileInfo) (id [2]uint64, linked bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return id, false
+	}
+	return [2]uint64{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+// fileStreams returns nothing: only NTFS files have alternate data streams
+func fileStreams(path string) ([]fileStream, error) {
+	return nil, nil
+}
+
+// isSparse reports whether fewer blocks are allocated to a file than its size takes
+func isSparse(fi fs.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int64(st.Blocks)*512 < fi.Size()
+}
+
+// fileInode returns the inode number of a file
+func fileInode(fi fs.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
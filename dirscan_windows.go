@@ -0,0 +1,74 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io/fs"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// fileID does not identify files: the file index of NTFS is only available from an open handle,
+// and hard links are rare on Windows
+func fileID(fi fs.FileInfo) (id [2]uint64, linked bool) {
+	return id, false
+}
+
+var (
+	kernel32            = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStream = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStream  = kernel32.NewProc("FindNextStreamW")
+)
+
+// win32FindStreamData is a WIN32_FIND_STREAM_DATA
+type win32FindStreamData struct {
+	size int64
+	name [syscall.MAX_PATH + 36]uint16
+}
+
+// fileStreams returns the alternate data streams of the file at path, named ":name:$DATA"
+func fileStreams(path string) ([]fileStream, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var data win32FindStreamData
+	h, _, e := procFindFirstStream.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		if e == syscall.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, e
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+
+	var streams []fileStream
+	for {
+		// the unnamed stream "::$DATA" is the content of the file
+		if name := syscall.UTF16ToString(data.name[:]); !strings.HasPrefix(name, "::") {
+			streams = append(streams, fileStream{name, data.size})
+		}
+		ok, _, e := procFindNextStream.Call(h, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if e == syscall.ERROR_HANDLE_EOF {
+				return streams, nil
+			}
+			return streams, e
+		}
+	}
+}
+
+// isSparse reports no file as sparse: the extents of sparse NTFS files are not queried, so
+// they are scanned whole
+func isSparse(fi fs.FileInfo) bool {
+	return false
+}
+
+// fileInode returns zero: the file index of NTFS is only available from an open handle, so
+// changes are told by size and modification time alone
+func fileInode(fi fs.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,101 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// DiskPartition is a file system contained in a disk image. FS is usually provided by a disk
+// image library (go-diskfs, for example), or is an os.DirFS of the directory the partition of the
+// guest has been mounted on.
+type DiskPartition struct {
+	Index int    // partition number in the image
+	Name  string // partition or file system label, if any
+	FS    fs.FS
+}
+
+// DiskOpener opens the file systems contained in a raw, qcow2, VMDK or other disk image. The
+// returned function is called once scanning completes and should release any resources (or
+// mounts) the opener acquired.
+type DiskOpener func(image string) ([]DiskPartition, func() error, error)
+
+// DiskDetection locates a virus, or a file that could not be scanned, inside a disk image
+type DiskDetection struct {
+	Partition int    // partition number in the image
+	Name      string // partition label
+	Path      string // path of the file inside the partition
+	Virus     string // virus name, empty if the file could not be scanned
+	Err       error  // reason the file could not be scanned
+}
+
+// ScanDiskImage scans every regular file in every partition of a disk image. The image is
+// opened by open, which is responsible for understanding the image and file system formats.
+func (e *Engine) ScanDiskImage(image string, open DiskOpener, opts *ScanOptions) ([]DiskDetection, error) {
+	parts, closer, err := open(image)
+	if err != nil {
+		return nil, fmt.Errorf("ScanDiskImage: %v", err)
+	}
+	if closer != nil {
+		defer closer()
+	}
+
+	var found []DiskDetection
+	for _, p := range parts {
+		err := e.ScanFS(p.FS, ".", opts, func(path, virus string, err error) {
+			found = append(found, DiskDetection{Partition: p.Index, Name: p.Name, Path: path, Virus: virus, Err: err})
+		})
+		if err != nil {
+			return found, fmt.Errorf("ScanDiskImage: partition %d: %v", p.Index, err)
+		}
+	}
+	return found, nil
+}
+
+// ScanFS scans every regular file below root in fsys. Report is called for every infected file
+// and for every file that could not be read or scanned; scanning continues past such files. An
+// error is returned only if root itself cannot be walked.
+func (e *Engine) ScanFS(fsys fs.FS, root string, opts *ScanOptions, report func(path, virus string, err error)) error {
+	return fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			report(path, "", err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		virus, err := e.scanFSFile(fsys, path, opts)
+		if virus != "" || err != nil {
+			report(path, virus, err)
+		}
+		return nil
+	})
+}
+
+// scanFSFile scans a single file of fsys, directly from its descriptor when fsys is backed by
+// the operating system.
+func (e *Engine) scanFSFile(fsys fs.FS, path string, opts *ScanOptions) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var virus string
+	if osf, ok := f.(*os.File); ok {
+		virus, _, err = e.ScanDesc(path, int(osf.Fd()), opts)
+	} else {
+		virus, _, err = e.ScanReader(f, path, opts)
+	}
+	if virus != "" {
+		return virus, nil
+	}
+	return "", err
+}
@@ -0,0 +1,62 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestScanDiskImage(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	closed := false
+	open := func(image string) ([]DiskPartition, func() error, error) {
+		if image != "disk.img" {
+			t.Errorf("DiskOpener: image %q, want disk.img", image)
+		}
+		parts := []DiskPartition{
+			{Index: 1, Name: "boot", FS: fstest.MapFS{"vmlinuz": {Data: []byte("kernel")}}},
+			{Index: 2, Name: "root", FS: fstest.MapFS{
+				"etc/hosts":         {Data: []byte("127.0.0.1 localhost")},
+				"home/user/eicar":   {Data: eicar},
+				"home/user/profile": {Data: []byte("PATH=/bin")},
+			}},
+		}
+		return parts, func() error { closed = true; return nil }, nil
+	}
+
+	found, err := eng.ScanDiskImage("disk.img", open, stdopts)
+	if err != nil {
+		t.Fatalf("ScanDiskImage: %v", err)
+	}
+	if !closed {
+		t.Errorf("ScanDiskImage: image not closed")
+	}
+	for _, d := range found {
+		if d.Err != nil {
+			t.Errorf("ScanDiskImage: %d:%s: %v", d.Partition, d.Path, d.Err)
+		} else if d.Partition != 2 || d.Name != "root" || d.Path != "home/user/eicar" {
+			t.Errorf("ScanDiskImage: unexpected detection %+v", d)
+		}
+	}
+}
+
+func TestScanDiskImageOpenError(t *testing.T) {
+	eng := New()
+	defer eng.Free()
+
+	open := func(string) ([]DiskPartition, func() error, error) {
+		return nil, nil, errors.New("unsupported image format")
+	}
+	if _, err := eng.ScanDiskImage("disk.vdi", open, stdopts); err == nil {
+		t.Errorf("ScanDiskImage: open error not returned")
+	}
+}
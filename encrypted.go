@@ -0,0 +1,46 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "strings"
+
+// EncryptedObject is an encrypted archive member or document found in a scanned object, whose
+// content could not be inspected. Upload policies can quarantine such objects whether or not
+// ScanHeuristicEncryptedArchive or ScanHeuristicEncryptedDoc alert on them.
+type EncryptedObject struct {
+	Name      string // of the archive member, if known
+	Container string // type of the archive holding it, or of the document, e.g. "CL_TYPE_ZIP"
+	Alert     string // the Heuristics.Encrypted alert reporting it, if any
+}
+
+// encryptedObjects merges the encrypted objects found during a scan: the archive members
+// reported by the meta callback, the object alerted on as virus, and the objects marked as
+// encrypted in the metadata m, if not nil
+func encryptedObjects(found []EncryptedObject, virus, fileType string, m *Metadata) []EncryptedObject {
+	objs := append([]EncryptedObject(nil), found...)
+	if strings.HasPrefix(virus, "Heuristics.Encrypted.") {
+		objs = append(objs, EncryptedObject{Container: fileType, Alert: virus})
+	}
+	if m == nil || m.Archive == nil {
+		return objs
+	}
+	m.Archive.Walk(func(r *ArchiveReport) bool {
+		if !r.Encrypted {
+			return true
+		}
+		for i, o := range objs {
+			if r.Name != "" && o.Name == r.Name {
+				return true
+			}
+			if o.Alert != "" && o.Name == "" && r.Depth == 0 {
+				objs[i].Container = r.FileType
+				return true
+			}
+		}
+		objs = append(objs, EncryptedObject{Name: r.Name, Container: r.FileType})
+		return true
+	})
+	return objs
+}
@@ -0,0 +1,64 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+func TestEngineScannerEncrypted(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	s := &EngineScanner{Engine: eng, Options: stdopts}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// the encryption flag, as set by zip -e
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "secret.docx", Flags: 1})
+	w.Write([]byte("ciphertext"))
+	zw.Close()
+	res, err := s.Scan(&buf, "secret.zip")
+	if err != nil || len(res.Encrypted) != 1 || res.Encrypted[0] != (EncryptedObject{Name: "secret.docx", Container: "CL_TYPE_ZIP"}) {
+		t.Errorf("Scan: encrypted zip: %+v %v", res, err)
+	}
+	if res, err := s.Scan(bytes.NewReader(eicar), "eicar"); err != nil || res.Encrypted != nil {
+		t.Errorf("Scan: eicar: %+v %v", res, err)
+	}
+}
+
+func TestEncryptedObjects(t *testing.T) {
+	m, err := parseMetadata(`{"Magic": "CLAMJSONv0", "FileType": "CL_TYPE_ZIP", "Viruses": ["Heuristics.Encrypted.Zip"],
+		"ContainedObjects": [
+			{"FileName": "a.pdf", "FileType": "CL_TYPE_PDF", "PDFStats": {"Encrypted": true}},
+			{"FileName": "b.doc", "FileType": "CL_TYPE_MSOLE2", "Encrypted": true},
+			{"FileName": "c.txt", "FileType": "CL_TYPE_TEXT_ASCII"}
+		]}`)
+	if err != nil {
+		t.Fatalf("parseMetadata: %v", err)
+	}
+	found := []EncryptedObject{{Name: "b.doc", Container: "CL_TYPE_ZIP"}}
+	got := encryptedObjects(found, "Heuristics.Encrypted.Zip", "", m)
+	want := []EncryptedObject{
+		{Name: "b.doc", Container: "CL_TYPE_ZIP"},
+		{Container: "CL_TYPE_ZIP", Alert: "Heuristics.Encrypted.Zip"},
+		{Name: "a.pdf", Container: "CL_TYPE_PDF"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("encryptedObjects: %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("encryptedObjects: %+v, want %+v", got[i], want[i])
+		}
+	}
+	if got := encryptedObjects(nil, "Eicar-Test-Signature", "CL_TYPE_TEXT_ASCII", nil); got != nil {
+		t.Errorf("encryptedObjects: %+v for a virus", got)
+	}
+}
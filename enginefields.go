@@ -0,0 +1,136 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "fmt"
+
+// FieldKind is the type of value held by an engine field
+type FieldKind int
+
+// Engine field kinds
+const (
+	FieldNum FieldKind = iota
+	FieldString
+)
+
+func (k FieldKind) String() string {
+	if k == FieldString {
+		return "string"
+	}
+	return "num"
+}
+
+// every engine field known to this package, in libclamav order. The Engine constants of
+// dat.go, such as EngineDisablePeStats, are the same fields as their unprefixed equivalents,
+// such as DisablePEStats, and share their entries.
+var engineFields = []struct {
+	name  string
+	field EngineField
+	kind  FieldKind
+}{
+	{"MaxScansize", MaxScansize, FieldNum},
+	{"MaxFilesize", MaxFilesize, FieldNum},
+	{"MaxRecursion", MaxRecursion, FieldNum},
+	{"MaxFiles", MaxFiles, FieldNum},
+	{"MinCCCount", MinCCCount, FieldNum},
+	{"MinSSNCount", MinSSNCount, FieldNum},
+	{"PuaCategories", PuaCategories, FieldString},
+	{"DbOptions", DbOptions, FieldNum},
+	{"DbVersion", DbVersion, FieldNum},
+	{"DbTime", DbTime, FieldNum},
+	{"AcOnly", AcOnly, FieldNum},
+	{"AcMindepth", AcMindepth, FieldNum},
+	{"AcMaxdepth", AcMaxdepth, FieldNum},
+	{"Tmpdir", Tmpdir, FieldString},
+	{"Keeptmp", Keeptmp, FieldNum},
+	{"BytecodeSecurity", BytecodeSecurityField, FieldNum},
+	{"BytecodeTimeout", BytecodeTimeout, FieldNum},
+	{"BytecodeMode", BytecodeModeField, FieldNum},
+	{"MaxEmbeddedpe", MaxEmbeddedpe, FieldNum},
+	{"MaxHtmlnormalize", MaxHtmlnormalize, FieldNum},
+	{"MaxHtmlnotags", MaxHtmlnotags, FieldNum},
+	{"MaxScriptnormalize", MaxScriptnormalize, FieldNum},
+	{"MaxZiptypercg", MaxZiptypercg, FieldNum},
+	{"Forcetodisk", Forcetodisk, FieldNum},
+	{"DisableCache", DisableCache, FieldNum},
+	{"DisablePEStats", DisablePEStats, FieldNum},
+	{"StatsTimeout", StatsTimeout, FieldNum},
+	{"MaxPartitions", MaxPartitions, FieldNum},
+	{"MaxIconspe", MaxIconspe, FieldNum},
+	{"MaxScantime", EngineMaxScantime, FieldNum},
+}
+
+func (f EngineField) String() string {
+	for _, ef := range engineFields {
+		if ef.field == f {
+			return ef.name
+		}
+	}
+	return fmt.Sprintf("EngineField(%d)", int(f))
+}
+
+// LookupEngineField returns the field with the given name, as reported by EngineFields
+func LookupEngineField(name string) (EngineField, FieldKind, bool) {
+	for _, ef := range engineFields {
+		if ef.name == name {
+			return ef.field, ef.kind, true
+		}
+	}
+	return 0, 0, false
+}
+
+// EngineFieldValue is the current value of one engine field. Num is set for numeric fields
+// and Str for string fields; Err records a failure to read the field
+type EngineFieldValue struct {
+	Name  string
+	Field EngineField
+	Kind  FieldKind
+	Num   uint64
+	Str   string
+	Err   error
+}
+
+// Value returns the value formatted for display
+func (v EngineFieldValue) Value() string {
+	if v.Kind == FieldString {
+		return v.Str
+	}
+	return fmt.Sprint(v.Num)
+}
+
+// EngineFields returns every known engine field with its current value in e, so that
+// configuration dumps and diffs need not hardcode the field list. A nil engine returns
+// the names and kinds only
+func EngineFields(e *Engine) []EngineFieldValue {
+	vals := make([]EngineFieldValue, len(engineFields))
+	for i, ef := range engineFields {
+		v := EngineFieldValue{Name: ef.name, Field: ef.field, Kind: ef.kind}
+		if e != nil {
+			if ef.kind == FieldString {
+				v.Str, v.Err = e.GetString(ef.field)
+			} else {
+				v.Num, v.Err = e.GetNum(ef.field)
+			}
+		}
+		vals[i] = v
+	}
+	return vals
+}
+
+// DiffEngineFields returns the fields of b whose value differs from a, for example to show
+// what a configuration change did to an engine
+func DiffEngineFields(a, b []EngineFieldValue) []EngineFieldValue {
+	old := make(map[EngineField]EngineFieldValue, len(a))
+	for _, v := range a {
+		old[v.Field] = v
+	}
+	var diff []EngineFieldValue
+	for _, v := range b {
+		if o, ok := old[v.Field]; !ok || o.Num != v.Num || o.Str != v.Str {
+			diff = append(diff, v)
+		}
+	}
+	return diff
+}
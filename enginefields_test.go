@@ -0,0 +1,79 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "testing"
+
+func TestEngineFields(t *testing.T) {
+	seen := map[string]bool{}
+	for _, v := range EngineFields(nil) {
+		if seen[v.Name] {
+			t.Errorf("duplicate field %s", v.Name)
+		}
+		seen[v.Name] = true
+		f, k, ok := LookupEngineField(v.Name)
+		if !ok || f != v.Field || k != v.Kind {
+			t.Errorf("LookupEngineField(%q) = %d, %v, %v; want %d, %v", v.Name, f, k, ok, v.Field, v.Kind)
+		}
+		if v.Field.String() != v.Name {
+			t.Errorf("String() = %q, want %q", v.Field.String(), v.Name)
+		}
+	}
+	if _, _, ok := LookupEngineField("NoSuchField"); ok {
+		t.Errorf("LookupEngineField found NoSuchField")
+	}
+
+	if err := Init(InitDefault); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	eng := New()
+	defer eng.Free()
+	if err := eng.SetNum(MaxScansize, 1<<20); err != nil {
+		t.Fatalf("SetNum: %v", err)
+	}
+	for _, v := range EngineFields(eng) {
+		if v.Err != nil {
+			t.Errorf("%s: %v", v.Name, v.Err)
+		}
+		if v.Field == MaxScansize {
+			want, _ := eng.GetNum(MaxScansize)
+			if v.Num != want || v.Kind != FieldNum {
+				t.Errorf("MaxScansize = %d (%v), want %d", v.Num, v.Kind, want)
+			}
+		}
+	}
+}
+
+func TestEngineFieldsComplete(t *testing.T) {
+	for _, f := range []EngineField{
+		EngineMaxScansize, EngineMaxFilesize, EngineMaxRecursion, EngineMaxFiles, EngineMinCcCount,
+		EngineMinSsnCount, EnginePuaCategories, EngineDbOptions, EngineDbVersion, EngineDbTime,
+		EngineAcOnly, EngineAcMindepth, EngineAcMaxdepth, EngineTmpdir, EngineKeeptmp,
+		EngineBytecodeSecurity, EngineBytecodeTimeout, EngineBytecodeMode, EngineMaxScantime,
+		EngineDisablePeStats, EngineStatsTimeout,
+	} {
+		if _, _, ok := LookupEngineField(f.String()); !ok {
+			t.Errorf("field %v missing from the table", f)
+		}
+	}
+}
+
+func TestDiffEngineFields(t *testing.T) {
+	a := []EngineFieldValue{
+		{Name: "MaxFiles", Field: MaxFiles, Num: 10},
+		{Name: "Tmpdir", Field: Tmpdir, Kind: FieldString, Str: "/tmp"},
+	}
+	b := []EngineFieldValue{
+		{Name: "MaxFiles", Field: MaxFiles, Num: 10},
+		{Name: "Tmpdir", Field: Tmpdir, Kind: FieldString, Str: "/var/tmp"},
+	}
+	d := DiffEngineFields(a, b)
+	if len(d) != 1 || d[0].Field != Tmpdir || d[0].Value() != "/var/tmp" {
+		t.Errorf("DiffEngineFields = %+v, want Tmpdir only", d)
+	}
+	if d := DiffEngineFields(a, a); len(d) != 0 {
+		t.Errorf("DiffEngineFields(a, a) = %+v, want none", d)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrEnginePoolClosed is returned by the methods of an EnginePool once it is closed
+var ErrEnginePoolClosed = errors.New("engine pool closed")
+
+// EngineBuilder builds a compiled engine, returning the number of signatures it loaded
+type EngineBuilder func() (*Engine, uint, error)
+
+// LoadEngine returns a builder loading the databases at path with dbopts. Configure, if not
+// nil, is called on every new engine before loading, to apply settings such as those of a
+// ClamdConfig.
+func LoadEngine(path string, dbopts uint, configure func(*Engine) error) EngineBuilder {
+	return func() (*Engine, uint, error) {
+		e := New()
+		if configure != nil {
+			if err := configure(e); err != nil {
+				e.Free()
+				return nil, 0, err
+			}
+		}
+		sigs, err := e.Load(path, dbopts)
+		if err == nil {
+			err = e.Compile()
+		}
+		if err != nil {
+			e.Free()
+			return nil, 0, err
+		}
+		return e, sigs, nil
+	}
+}
+
+// EngineSample is a sample an engine is checked against
+type EngineSample struct {
+	Name  string
+	Data  []byte
+	Virus string // for samples to detect, the detection expected, any if empty
+}
+
+// EngineCheck validates a freshly built engine before it serves scans, to catch broken,
+// truncated or mismatched databases
+type EngineCheck struct {
+	// MinSignatures is the fewest signatures the engine may have
+	MinSignatures uint
+
+	// MaxDrop is the largest fraction of the signatures of the engine being replaced that
+	// may be lost, 0.5 for half of them; any number may be lost if zero
+	MaxDrop float64
+
+	Detect  []EngineSample // samples the engine must detect, such as EICAR
+	Clean   []EngineSample // samples it must not detect
+	Options *ScanOptions
+}
+
+// Check validates the engine e with sigs signatures, replacing an engine with prev signatures
+// (zero if none)
+func (c *EngineCheck) Check(e *Engine, sigs, prev uint) error {
+	if sigs < c.MinSignatures {
+		return fmt.Errorf("Check: %d signatures, at least %d expected", sigs, c.MinSignatures)
+	}
+	if c.MaxDrop > 0 && float64(sigs) < float64(prev)*(1-c.MaxDrop) {
+		return fmt.Errorf("Check: %d signatures, down from %d", sigs, prev)
+	}
+	for _, s := range c.Detect {
+		virus, _, err := e.ScanBytes(s.Data, s.Name, c.Options)
+		if virus == "" {
+			return fmt.Errorf("Check: %s not detected: %v", s.Name, err)
+		}
+		if s.Virus != "" && virus != s.Virus {
+			return fmt.Errorf("Check: %s detected as %s, %s expected", s.Name, virus, s.Virus)
+		}
+	}
+	for _, s := range c.Clean {
+		if virus, _, _ := e.ScanBytes(s.Data, s.Name, c.Options); virus != "" {
+			return fmt.Errorf("Check: %s detected as %s", s.Name, virus)
+		}
+	}
+	return nil
+}
+
+// EnginePool serves scans from a compiled engine and replaces it when databases are reloaded.
+// A new engine is checked before it is swapped in; if it cannot be built or fails the check,
+// the previous engine keeps serving scans and the failure is recorded in the status. Scans in
+// progress keep a reference to the engine they started with, which is freed once they are all
+// done.
+type EnginePool struct {
+	build   EngineBuilder
+	check   *EngineCheck
+	options *ScanOptions
+
+	reload sync.Mutex // serializes reloads
+	mu     sync.RWMutex
+	engine *Engine
+	status EnginePoolStatus
+	closed bool
+
+	// workers bounds the scans in progress, no limit if zero
+	wmu      sync.Mutex
+	wcond    *sync.Cond
+	workers  int
+	inflight int
+	tuner    *autotuner
+}
+
+// EnginePoolStatus describes the engine of a pool and its reloads
+type EnginePoolStatus struct {
+	Signatures  uint         // of the engine serving scans
+	Memory      EngineMemory // estimated, see MemoryEstimate
+	Loaded      time.Time    // when it was swapped in
+	Reloads     int          // successful reloads
+	Failures    int          // failed reloads
+	LastError   error        // of the last failed reload
+	LastFailure time.Time
+
+	Workers  int // scans allowed at once, no limit if zero
+	Inflight int // scans in progress
+}
+
+// NewEnginePool builds the first engine of a pool, which must pass check, if not nil, and
+// scans with opts
+func NewEnginePool(build EngineBuilder, check *EngineCheck, opts *ScanOptions) (*EnginePool, error) {
+	p := &EnginePool{build: build, check: check, options: opts}
+	p.wcond = sync.NewCond(&p.wmu)
+	e, sigs, err := p.buildChecked(0)
+	if err != nil {
+		return nil, fmt.Errorf("NewEnginePool: %v", err)
+	}
+	p.engine = e
+	p.status = EnginePoolStatus{Signatures: sigs, Loaded: time.Now()}
+	p.status.Memory, _ = e.MemoryEstimate()
+	return p, nil
+}
+
+// buildChecked builds and checks a new engine
+func (p *EnginePool) buildChecked(prev uint) (*Engine, uint, error) {
+	e, sigs, err := p.build()
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.check != nil {
+		if err := p.check.Check(e, sigs, prev); err != nil {
+			e.Free()
+			return nil, 0, err
+		}
+	}
+	return e, sigs, nil
+}
+
+// Reload builds and checks a new engine and swaps it in, or keeps the current one if that
+// fails
+func (p *EnginePool) Reload() error {
+	p.reload.Lock()
+	defer p.reload.Unlock()
+	p.mu.RLock()
+	prev, closed := p.status.Signatures, p.closed
+	p.mu.RUnlock()
+	if closed {
+		return ErrEnginePoolClosed
+	}
+
+	e, sigs, err := p.buildChecked(prev)
+	p.mu.Lock()
+	if err == nil && p.closed {
+		e.Free()
+		err = ErrEnginePoolClosed
+	}
+	if err != nil {
+		p.status.Failures++
+		p.status.LastError = err
+		p.status.LastFailure = time.Now()
+		p.mu.Unlock()
+		return fmt.Errorf("Reload: %v", err)
+	}
+	old := p.engine
+	p.engine = e
+	p.status.Signatures = sigs
+	p.status.Memory, _ = e.MemoryEstimate()
+	p.status.Loaded = time.Now()
+	p.status.Reloads++
+	p.mu.Unlock()
+	old.Free()
+	return nil
+}
+
+// Acquire returns the engine serving scans, referenced until release is called
+func (p *EnginePool) Acquire() (e *Engine, release func(), err error) {
+	e, _, release, err = p.acquire()
+	return e, release, err
+}
+
+// acquire is Acquire, also returning the number of signatures of the engine
+func (p *EnginePool) acquire() (e *Engine, sigs uint, release func(), err error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, 0, nil, ErrEnginePoolClosed
+	}
+	e = p.engine
+	if err := e.Addref(); err != nil {
+		return nil, 0, nil, fmt.Errorf("Acquire: %v", err)
+	}
+	var once sync.Once
+	return e, p.status.Signatures, func() { once.Do(func() { e.Free() }) }, nil
+}
+
+// With calls fn with the engine serving scans, referenced until fn returns or panics, so that
+// neither a reload nor Close frees the engine while fn uses it. fn must not keep the engine.
+// The error is that of ctx if it is done before fn is called.
+func (p *EnginePool) With(ctx context.Context, fn func(e *Engine) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e, release, err := p.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(e)
+}
+
+// Scan scans the data read from r with the engine serving scans, waiting for a worker if the
+// pool has as many scans in progress as it has workers
+func (p *EnginePool) Scan(r io.Reader, name string) (*ScanResult, error) {
+	if err := p.startWorker(); err != nil {
+		return nil, err
+	}
+	defer p.stopWorker()
+	p.mu.RLock()
+	opts := p.options
+	p.mu.RUnlock()
+	var res *ScanResult
+	err := p.With(context.Background(), func(e *Engine) error {
+		var err error
+		res, err = (&EngineScanner{Engine: e, Options: opts}).Scan(r, name)
+		return err
+	})
+	return res, err
+}
+
+// SetOptions sets the options of the scans started from now on
+func (p *EnginePool) SetOptions(opts *ScanOptions) {
+	p.mu.Lock()
+	p.options = opts
+	p.mu.Unlock()
+}
+
+// SetWorkers sets the number of scans the pool runs at once, no limit if zero. Scans in
+// excess wait for one in progress to end.
+func (p *EnginePool) SetWorkers(n int) {
+	p.wmu.Lock()
+	p.workers = n
+	p.wmu.Unlock()
+	p.wcond.Broadcast()
+}
+
+// startWorker waits for a worker to be available and takes it
+func (p *EnginePool) startWorker() error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	for p.workers > 0 && p.inflight >= p.workers {
+		p.wcond.Wait()
+		p.mu.RLock()
+		closed := p.closed
+		p.mu.RUnlock()
+		if closed {
+			return ErrEnginePoolClosed
+		}
+	}
+	p.inflight++
+	return nil
+}
+
+// stopWorker gives back the worker of a scan
+func (p *EnginePool) stopWorker() {
+	p.wmu.Lock()
+	p.inflight--
+	p.wmu.Unlock()
+	p.wcond.Signal()
+}
+
+// Status returns the status of the pool
+func (p *EnginePool) Status() EnginePoolStatus {
+	p.mu.RLock()
+	st := p.status
+	p.mu.RUnlock()
+	p.wmu.Lock()
+	st.Workers, st.Inflight = p.workers, p.inflight
+	p.wmu.Unlock()
+	return st
+}
+
+// Close releases the engine of the pool, which is freed once the scans in progress are done,
+// and stops autotuning
+func (p *EnginePool) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		p.engine.Free()
+	}
+	p.mu.Unlock()
+	p.wmu.Lock()
+	if p.tuner != nil {
+		p.tuner.stop()
+		p.tuner = nil
+	}
+	p.wmu.Unlock()
+	// fail the scans waiting for a worker
+	p.wcond.Broadcast()
+	return nil
+}
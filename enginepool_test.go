@@ -0,0 +1,133 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEnginePoolReload(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	eng.Free()
+	load := LoadEngine(DBDir(), DbStdopt, nil)
+	sigs, fail := uint(1000), error(nil)
+	build := func() (*Engine, uint, error) {
+		if fail != nil {
+			return nil, 0, fail
+		}
+		e, _, err := load()
+		return e, sigs, err
+	}
+	check := &EngineCheck{
+		MinSignatures: 100,
+		MaxDrop:       0.5,
+		Detect:        []EngineSample{{Name: "eicar.com", Data: eicar}},
+		Clean:         []EngineSample{{Name: "clean.txt", Data: []byte("clean")}},
+		Options:       stdopts,
+	}
+	p, err := NewEnginePool(build, check, stdopts)
+	if err != nil {
+		t.Fatalf("NewEnginePool: %v", err)
+	}
+	defer p.Close()
+
+	scan := func() {
+		res, err := p.Scan(bytes.NewReader(eicar), "eicar.com")
+		if err != nil || res.Virus == "" {
+			t.Errorf("Scan: %+v, %v", res, err)
+		}
+	}
+	scan()
+
+	sigs = 900
+	if err := p.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	// a truncated database, a broken one and an engine missing EICAR are rejected
+	sigs = 300
+	if err := p.Reload(); err == nil {
+		t.Errorf("Reload: signature drop accepted")
+	}
+	sigs, fail = 900, errors.New("daily.cvd: broken database")
+	if err := p.Reload(); err == nil {
+		t.Errorf("Reload: build failure ignored")
+	}
+	fail = nil
+	check.Detect[0].Virus = "Some.Other.Signature"
+	if err := p.Reload(); err == nil {
+		t.Errorf("Reload: wrong detection accepted")
+	}
+	scan()
+
+	st := p.Status()
+	if st.Signatures != 900 || st.Reloads != 1 || st.Failures != 3 || st.LastError == nil {
+		t.Errorf("Status: %+v", st)
+	}
+
+	p.Close()
+	if _, err := p.Scan(bytes.NewReader(eicar), "eicar.com"); err != ErrEnginePoolClosed {
+		t.Errorf("Scan: got %v after Close", err)
+	}
+	if err := p.Reload(); err != ErrEnginePoolClosed {
+		t.Errorf("Reload: got %v after Close", err)
+	}
+}
+
+func TestEnginePoolWith(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	eng.Free()
+	p, err := NewEnginePool(LoadEngine(DBDir(), DbStdopt, nil), nil, stdopts)
+	if err != nil {
+		t.Fatalf("NewEnginePool: %v", err)
+	}
+	defer p.Close()
+
+	refs := func(e *Engine) int {
+		engineMemory.Lock()
+		defer engineMemory.Unlock()
+		if st := engineMemory.m[e]; st != nil {
+			return st.refs
+		}
+		return 0
+	}
+	var held *Engine
+	err = p.With(context.Background(), func(e *Engine) error {
+		held = e
+		if virus, _, err := e.ScanBytes(eicar, "eicar.com", stdopts); virus == "" {
+			t.Errorf("ScanBytes: %v", err)
+		}
+		return errors.New("done")
+	})
+	if err == nil || err.Error() != "done" {
+		t.Errorf("With: %v", err)
+	}
+	before := refs(held)
+
+	// the reference is released by a panic too
+	func() {
+		defer func() { recover() }()
+		p.With(context.Background(), func(e *Engine) error { panic("scan failed") })
+	}()
+	if n := refs(held); n != before {
+		t.Errorf("With: %d references after a panic, want %d", n, before)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	if err := p.With(ctx, func(e *Engine) error { called = true; return nil }); err != context.Canceled || called {
+		t.Errorf("With: canceled context: %v, called %v", err, called)
+	}
+}
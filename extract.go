@@ -0,0 +1,171 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractedObject is an object ClamAV unpacked from a scanned object, copied by Extract
+type ExtractedObject struct {
+	File     string // name of the copy in the directory of the extraction
+	FileType string // e.g. "CL_TYPE_MSEXE"
+	Size     int64
+	Hashes   *Hashes
+
+	// Name is the name of the object in its container and Parents those of its containers,
+	// outermost first, as found in the archive report of the scan; empty if the object was
+	// not found in it
+	Name    string
+	Parents []string
+	Depth   int // of the object in the archive report, zero if not found in it
+}
+
+// Extraction is the result of Extract
+type Extraction struct {
+	Dir     string
+	Result  *ScanResult
+	Objects []ExtractedObject // in the order ClamAV unpacked them
+}
+
+// Extract scans the data read from r with opts, copying every object ClamAV unpacks from it,
+// such as archive members or files embedded in documents, into dir, for analysts to use ClamAV
+// as an extraction tool. Unlike leaving the temporary files of libclamav with Keeptmp, which
+// are named at random and mixed with those of every other scan, the copies are listed with
+// their type and hashes, and located in the tree of containers of the archive report, which
+// is why metadata collection is added to opts. Objects beyond the scan limits of the engine
+// are neither unpacked nor copied.
+func (e *Engine) Extract(r io.Reader, name string, opts *ScanOptions, dir string) (*Extraction, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("Extract: %v", errNilOptions)
+	}
+	if strings.IndexByte(name, 0) >= 0 {
+		return nil, fmt.Errorf("Extract: %q: NUL byte in string", name)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Extract: %v", err)
+	}
+	o := *opts
+	o.General |= ScanGeneralCollectMetadata
+	x := &Extraction{Dir: dir}
+	var copyErr error
+	s := &EngineScanner{Engine: e, Options: &o}
+	s.extract = func(fd int, fileType string) {
+		obj, err := copyDesc(fd, dir, fmt.Sprintf("%04d", len(x.Objects)))
+		if err != nil {
+			if copyErr == nil {
+				copyErr = err
+			}
+			return
+		}
+		obj.FileType = fileType
+		x.Objects = append(x.Objects, *obj)
+	}
+	res, err := s.Scan(r, name)
+	if err != nil {
+		return nil, fmt.Errorf("Extract: %v", err)
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("Extract: %v", copyErr)
+	}
+	x.Result = res
+	if res.Metadata != nil && res.Metadata.Archive != nil {
+		locateObjects(res.Metadata.Archive, x.Objects)
+	}
+	return x, nil
+}
+
+// copyDesc copies the object open as fd to the file name of dir
+func copyDesc(fd int, dir, name string) (*ExtractedObject, error) {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	h := newHasher()
+	n, err := io.Copy(io.MultiWriter(f, h), &descReader{fd: fd})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &ExtractedObject{File: name, Size: n, Hashes: h.sum()}, nil
+}
+
+// locateObjects finds the objects in the archive report by their MD5, setting their names,
+// their parents and their depth
+func locateObjects(report *ArchiveReport, objs []ExtractedObject) {
+	byMD5 := map[string][]int{}
+	for i := range objs {
+		byMD5[objs[i].Hashes.MD5] = append(byMD5[objs[i].Hashes.MD5], i)
+	}
+	var walk func(r *ArchiveReport, parents []string)
+	walk = func(r *ArchiveReport, parents []string) {
+		if r.Depth > 0 && r.MD5 != "" {
+			// identical objects are matched in order
+			if is := byMD5[r.MD5]; len(is) > 0 {
+				o := &objs[is[0]]
+				o.Name, o.Depth = r.Name, r.Depth
+				o.Parents = append([]string(nil), parents...)
+				byMD5[r.MD5] = is[1:]
+			}
+		}
+		parents = append(parents, r.Name)
+		for _, c := range r.Contained {
+			walk(c, parents[:len(parents):len(parents)])
+		}
+	}
+	walk(report, nil)
+}
+
+// Copy copies the objects selected, all of them if selected is nil, into dst, returning the
+// paths of the copies. Copies are named after the objects when they have a name, and after
+// their file in the extraction otherwise.
+func (x *Extraction) Copy(dst string, selected func(*ExtractedObject) bool) ([]string, error) {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return nil, fmt.Errorf("Copy: %v", err)
+	}
+	var paths []string
+	for i := range x.Objects {
+		o := &x.Objects[i]
+		if selected != nil && !selected(o) {
+			continue
+		}
+		name := o.File
+		if base := filepath.Base(filepath.FromSlash(o.Name)); o.Name != "" && base != "." && base != ".." && base != string(filepath.Separator) {
+			// names come from the scanned data, only their last element is used
+			name = o.File + "-" + base
+		}
+		p := filepath.Join(dst, name)
+		if err := copyFile(filepath.Join(x.Dir, o.File), p); err != nil {
+			return paths, fmt.Errorf("Copy: %v", err)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// copyFile copies the file src to dst, which must not exist
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
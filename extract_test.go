@@ -0,0 +1,78 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// stored, with its sizes in the local header
+	w, _ := zw.CreateRaw(&zip.FileHeader{Name: "dir/eicar.com", Method: zip.Store, CRC32: crc32.ChecksumIEEE(eicar),
+		CompressedSize64: uint64(len(eicar)), UncompressedSize64: uint64(len(eicar))})
+	w.Write(eicar)
+	zw.Close()
+
+	x, err := eng.Extract(bytes.NewReader(buf.Bytes()), "sample.zip", stdopts, filepath.Join(t.TempDir(), "x"))
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	sum := newHasher()
+	sum.Write(eicar)
+	if x.Result.Virus == "" || len(x.Objects) != 1 || x.Objects[0].Size != int64(len(eicar)) || *x.Objects[0].Hashes != *sum.sum() {
+		t.Fatalf("Extract: %+v, %+v", x.Result, x.Objects)
+	}
+
+	x.Objects[0].Name = "../dir/eicar.com"
+	paths, err := x.Copy(t.TempDir(), func(o *ExtractedObject) bool { return o.Size > 0 })
+	if err != nil || len(paths) != 1 || filepath.Base(paths[0]) != "0000-eicar.com" {
+		t.Fatalf("Copy: %v, %v", paths, err)
+	}
+	if b, _ := ioutil.ReadFile(paths[0]); !bytes.Equal(b, eicar) {
+		t.Errorf("Copy: %q", b)
+	}
+
+	if _, err := eng.Extract(bytes.NewReader(eicar), "eicar.com", nil, t.TempDir()); err == nil {
+		t.Errorf("Extract: nil options accepted")
+	}
+	if _, err := eng.Extract(bytes.NewReader(eicar), "eicar\x00.com", stdopts, t.TempDir()); err == nil {
+		t.Errorf("Extract: name with a NUL byte accepted")
+	}
+}
+
+func TestLocateObjects(t *testing.T) {
+	report := &ArchiveReport{Name: "outer.zip", Contained: []*ArchiveReport{
+		{Name: "inner.tar", Depth: 1, MD5: "a", Contained: []*ArchiveReport{
+			{Name: "doc.pdf", Depth: 2, MD5: "b"},
+			{Name: "copy.pdf", Depth: 2, MD5: "b"},
+		}},
+	}}
+	objs := []ExtractedObject{{Hashes: &Hashes{MD5: "a"}}, {Hashes: &Hashes{MD5: "b"}}, {Hashes: &Hashes{MD5: "b"}}, {Hashes: &Hashes{MD5: "c"}}}
+	locateObjects(report, objs)
+	want := []struct {
+		name, parents string
+		depth         int
+	}{{"inner.tar", "[outer.zip]", 1}, {"doc.pdf", "[outer.zip inner.tar]", 2}, {"copy.pdf", "[outer.zip inner.tar]", 2}, {"", "[]", 0}}
+	for i, w := range want {
+		o := objs[i]
+		if o.Name != w.name || fmt.Sprint(o.Parents) != w.parents || o.Depth != w.depth {
+			t.Errorf("locateObjects: %d: %+v, want %+v", i, o, w)
+		}
+	}
+}
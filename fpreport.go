@@ -0,0 +1,132 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// FalsePositiveReport gathers what the ClamAV team asks for when a detection is reported as a
+// false positive: the sample hashes, the signature, and the versions of the engine and the
+// databases that produced the detection
+type FalsePositiveReport struct {
+	Signature string // as reported, e.g. "Win.Trojan.Agent-1234"
+	FileName  string
+	FileType  string `json:",omitempty"`
+	Size      int64
+	Hashes    Hashes
+
+	Engine    string // libclamav version
+	Flevel    uint   // functionality level
+	Databases []CVDHeader
+
+	// Metadata is the JSON metadata of the scan, if it was collected
+	Metadata json.RawMessage `json:",omitempty"`
+
+	Comment string `json:",omitempty"` // the operator's notes
+	Created time.Time
+}
+
+// NewFalsePositiveReport prepares a report for the detection in res, reading the sample to
+// hash it. The versions of the databases are read from dbdir, DBDir() if empty.
+func NewFalsePositiveReport(res *ScanResult, sample io.Reader, dbdir string) (*FalsePositiveReport, error) {
+	if res.Virus == "" {
+		return nil, fmt.Errorf("NewFalsePositiveReport: %s: no detection", res.Name)
+	}
+	if dbdir == "" {
+		dbdir = DBDir()
+	}
+	dbs, err := DatabaseVersions(dbdir)
+	if err != nil {
+		return nil, fmt.Errorf("NewFalsePositiveReport: %v", err)
+	}
+	h := newHasher()
+	size, err := io.Copy(h, sample)
+	if err != nil {
+		return nil, fmt.Errorf("NewFalsePositiveReport: %v", err)
+	}
+
+	r := &FalsePositiveReport{
+		Signature: res.Virus,
+		FileName:  res.Name,
+		FileType:  res.FileType,
+		Size:      size,
+		Hashes:    *h.sum(),
+		Engine:    Retver(),
+		Flevel:    Retflevel(),
+		Databases: dbs,
+		Created:   time.Now().UTC(),
+	}
+	if res.Metadata != nil {
+		r.Metadata = res.Metadata.JSON
+	}
+	return r, nil
+}
+
+// SignatureName returns the name of the signature as written in the databases, without the
+// ".UNOFFICIAL" suffix ClamAV appends to the names of third party signatures
+func (r *FalsePositiveReport) SignatureName() string {
+	return strings.TrimSuffix(r.Signature, ".UNOFFICIAL")
+}
+
+// Ign2 returns a line for a local .ign2 database, which disables the signature until it is
+// fixed upstream
+func (r *FalsePositiveReport) Ign2() string {
+	return r.SignatureName() + "\n"
+}
+
+// WriteBundle writes a zip archive with the report as report.json, the metadata as
+// metadata.json and a local.ign2 entry, and, if sample is not nil, the sample itself under
+// sample/, as attached to a false positive submission
+func (r *FalsePositiveReport) WriteBundle(w io.Writer, sample io.Reader) error {
+	z := zip.NewWriter(w)
+	report, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return fmt.Errorf("WriteBundle: %v", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"report.json", report},
+		{"metadata.json", r.Metadata},
+		{"local.ign2", []byte(r.Ign2())},
+	}
+	for _, f := range files {
+		if len(f.data) == 0 {
+			continue
+		}
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return fmt.Errorf("WriteBundle: %v", err)
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return fmt.Errorf("WriteBundle: %v", err)
+		}
+	}
+	if sample != nil {
+		name := path.Base(strings.Replace(r.FileName, "\\", "/", -1))
+		if name == "." || name == "/" {
+			name = r.Hashes.SHA256
+		}
+		fw, err := z.Create("sample/" + name)
+		if err != nil {
+			return fmt.Errorf("WriteBundle: %v", err)
+		}
+		if _, err := io.Copy(fw, sample); err != nil {
+			return fmt.Errorf("WriteBundle: %v", err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		return fmt.Errorf("WriteBundle: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFalsePositiveReport(t *testing.T) {
+	dir := t.TempDir()
+	writeCVD(t, dir, "daily.cvd", 27431)
+
+	sample := []byte("harmless installer")
+	res := &ScanResult{Name: `C:\Downloads\setup.exe`, Virus: "Win.Trojan.Test-1.UNOFFICIAL", FileType: "CL_TYPE_MSEXE",
+		Metadata: &Metadata{JSON: json.RawMessage(`{"Magic":"CLAMJSONv0"}`)}}
+	r, err := NewFalsePositiveReport(res, bytes.NewReader(sample), dir)
+	if err != nil {
+		t.Fatalf("NewFalsePositiveReport: %v", err)
+	}
+	if r.Size != int64(len(sample)) || r.Hashes.SHA256 != fmt.Sprintf("%x", sha256.Sum256(sample)) {
+		t.Errorf("NewFalsePositiveReport: sample: %+v", r)
+	}
+	if len(r.Databases) != 1 || r.Databases[0].Version != 27431 || r.Engine != Retver() {
+		t.Errorf("NewFalsePositiveReport: versions: %+v", r)
+	}
+	if r.Ign2() != "Win.Trojan.Test-1\n" {
+		t.Errorf("Ign2: %q", r.Ign2())
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteBundle(&buf, bytes.NewReader(sample)); err != nil {
+		t.Fatalf("WriteBundle: %v", err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("WriteBundle: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range z.File {
+		rc, _ := f.Open()
+		data, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	var got FalsePositiveReport
+	if err := json.Unmarshal([]byte(files["report.json"]), &got); err != nil || got.Hashes != r.Hashes || got.Signature != r.Signature {
+		t.Errorf("WriteBundle: report.json: %+v %v", got, err)
+	}
+	if files["metadata.json"] != `{"Magic":"CLAMJSONv0"}` || files["local.ign2"] != r.Ign2() || files["sample/setup.exe"] != string(sample) || len(files) != 4 {
+		t.Errorf("WriteBundle: files %q", files)
+	}
+
+	if _, err := NewFalsePositiveReport(&ScanResult{Name: "clean"}, bytes.NewReader(nil), dir); err == nil {
+		t.Errorf("NewFalsePositiveReport: clean result accepted")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FreshclamConfig is the configuration of a freshclam.conf file, for database updates to take
+// over from freshclam without duplicating its configuration
+type FreshclamConfig struct {
+	DatabaseDirectory string // DBDir() unless configured
+
+	// DatabaseMirror are the mirrors of the official databases, tried in order, unless
+	// PrivateMirror is set, which replaces them
+	DatabaseMirror []string
+	PrivateMirror  []string
+
+	// DatabaseCustomURL are the URLs of third party databases, http(s):// or file://
+	DatabaseCustomURL []string
+
+	ExtraDatabase   []string // optional official databases to update, e.g. "safebrowsing"
+	ExcludeDatabase []string // official databases not to update, e.g. "bytecode"
+
+	Checks          int    // updates per day
+	DNSDatabaseInfo string // TXT record announcing the current versions, empty if disabled
+	ScriptedUpdates bool   // download .cdiff patches rather than complete databases
+	MaxAttempts     int
+
+	HTTPProxyServer   string
+	HTTPProxyPort     int
+	HTTPProxyUsername string
+	HTTPProxyPassword string
+	HTTPUserAgent     string
+
+	ConnectTimeout time.Duration
+	ReceiveTimeout time.Duration
+
+	// CABundle is a PEM file of additional roots to authenticate servers with, such as the
+	// root of a TLS inspecting proxy. It has no freshclam.conf directive.
+	CABundle string
+
+	// AllowedHosts restricts downloads to these hosts, see HostAllowed. It has no
+	// freshclam.conf directive.
+	AllowedHosts []string
+
+	// Other has the values of the directives not listed above, such as UpdateLogFile or
+	// NotifyClamd
+	Other map[string][]string
+}
+
+// ReadFreshclamConfig reads a freshclam.conf file
+func ReadFreshclamConfig(path string) (*FreshclamConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFreshclamConfig: %v", err)
+	}
+	defer f.Close()
+	c, err := ParseFreshclamConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFreshclamConfig: %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// ParseFreshclamConfig parses the directives of a freshclam.conf file, starting from the
+// defaults of freshclam
+func ParseFreshclamConfig(r io.Reader) (*FreshclamConfig, error) {
+	c := &FreshclamConfig{
+		DatabaseDirectory: DBDir(),
+		Checks:            12,
+		DNSDatabaseInfo:   "current.cvd.clamav.net",
+		ScriptedUpdates:   true,
+		MaxAttempts:       3,
+		ConnectTimeout:    30 * time.Second,
+		ReceiveTimeout:    60 * time.Second,
+		Other:             map[string][]string{},
+	}
+	if err := parseConf(r, c.set); err != nil {
+		return nil, err
+	}
+	if len(c.DatabaseMirror) == 0 {
+		c.DatabaseMirror = []string{"database.clamav.net"}
+	}
+	return c, nil
+}
+
+// set applies a directive
+func (c *FreshclamConfig) set(name, value string) error {
+	var err error
+	switch name {
+	case "DatabaseDirectory":
+		c.DatabaseDirectory = value
+	case "DatabaseMirror":
+		c.DatabaseMirror = append(c.DatabaseMirror, value)
+	case "PrivateMirror":
+		c.PrivateMirror = append(c.PrivateMirror, value)
+	case "DatabaseCustomURL":
+		c.DatabaseCustomURL = append(c.DatabaseCustomURL, value)
+	case "ExtraDatabase":
+		c.ExtraDatabase = append(c.ExtraDatabase, value)
+	case "ExcludeDatabase":
+		c.ExcludeDatabase = append(c.ExcludeDatabase, value)
+	case "Checks":
+		if c.Checks, err = strconv.Atoi(value); err == nil && (c.Checks < 0 || c.Checks > 50) {
+			err = fmt.Errorf("%d checks per day, between 0 and 50 expected", c.Checks)
+		}
+	case "DNSDatabaseInfo":
+		if strings.EqualFold(value, "no") {
+			value = ""
+		}
+		c.DNSDatabaseInfo = value
+	case "ScriptedUpdates":
+		c.ScriptedUpdates, err = parseClamdBool(value)
+	case "MaxAttempts":
+		c.MaxAttempts, err = strconv.Atoi(value)
+	case "HTTPProxyServer":
+		c.HTTPProxyServer = value
+	case "HTTPProxyPort":
+		c.HTTPProxyPort, err = strconv.Atoi(value)
+	case "HTTPProxyUsername":
+		c.HTTPProxyUsername = value
+	case "HTTPProxyPassword":
+		c.HTTPProxyPassword = value
+	case "HTTPUserAgent":
+		c.HTTPUserAgent = value
+	case "ConnectTimeout", "ReceiveTimeout":
+		var n uint64
+		if n, err = parseClamdNumber(value, false); err == nil {
+			if name == "ConnectTimeout" {
+				c.ConnectTimeout = time.Duration(n) * time.Second
+			} else {
+				c.ReceiveTimeout = time.Duration(n) * time.Second
+			}
+		}
+	default:
+		c.Other[name] = append(c.Other[name], value)
+	}
+	return err
+}
+
+// Mirrors returns the mirrors to download the official databases from
+func (c *FreshclamConfig) Mirrors() []string {
+	if len(c.PrivateMirror) > 0 {
+		return c.PrivateMirror
+	}
+	return c.DatabaseMirror
+}
+
+// CheckInterval returns the time between two updates, zero if updates are disabled
+func (c *FreshclamConfig) CheckInterval() time.Duration {
+	if c.Checks <= 0 {
+		return 0
+	}
+	return 24 * time.Hour / time.Duration(c.Checks)
+}
@@ -0,0 +1,62 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFreshclamConf = `
+DatabaseDirectory /srv/clamav/db
+UpdateLogFile /var/log/clamav/freshclam.log
+DatabaseMirror db.local.clamav.net
+DatabaseMirror database.clamav.net
+DatabaseCustomURL https://signatures.example.com/example.ndb
+DatabaseCustomURL file:///srv/signatures/local.hdb
+ExcludeDatabase bytecode
+Checks 24
+DNSDatabaseInfo no
+ScriptedUpdates no
+HTTPProxyServer proxy.example.com
+HTTPProxyPort 3128
+HTTPProxyUsername scanner
+HTTPProxyPassword secret
+ConnectTimeout 10
+NotifyClamd /etc/clamav/clamd.conf
+`
+
+func TestParseFreshclamConfig(t *testing.T) {
+	c, err := ParseFreshclamConfig(strings.NewReader(testFreshclamConf))
+	if err != nil {
+		t.Fatalf("ParseFreshclamConfig: %v", err)
+	}
+	if c.DatabaseDirectory != "/srv/clamav/db" || !reflect.DeepEqual(c.Mirrors(), []string{"db.local.clamav.net", "database.clamav.net"}) ||
+		len(c.DatabaseCustomURL) != 2 || c.ExcludeDatabase[0] != "bytecode" {
+		t.Errorf("ParseFreshclamConfig: databases %+v", c)
+	}
+	if c.CheckInterval() != time.Hour || c.DNSDatabaseInfo != "" || c.ScriptedUpdates || c.MaxAttempts != 3 {
+		t.Errorf("ParseFreshclamConfig: updates %+v", c)
+	}
+	if c.HTTPProxyServer != "proxy.example.com" || c.HTTPProxyPort != 3128 || c.HTTPProxyUsername != "scanner" ||
+		c.HTTPProxyPassword != "secret" || c.ConnectTimeout != 10*time.Second || c.ReceiveTimeout != time.Minute {
+		t.Errorf("ParseFreshclamConfig: connection %+v", c)
+	}
+	if len(c.Other) != 2 || c.Other["NotifyClamd"][0] != "/etc/clamav/clamd.conf" {
+		t.Errorf("ParseFreshclamConfig: other directives %v", c.Other)
+	}
+
+	c, err = ParseFreshclamConfig(strings.NewReader("PrivateMirror mirror.example.com\n"))
+	if err != nil || !reflect.DeepEqual(c.Mirrors(), []string{"mirror.example.com"}) || c.CheckInterval() != 2*time.Hour {
+		t.Errorf("ParseFreshclamConfig: defaults: %+v %v", c, err)
+	}
+	for _, conf := range []string{"Example\n", "Checks 100\n", "ScriptedUpdates sometimes\n"} {
+		if _, err := ParseFreshclamConfig(strings.NewReader(conf)); err == nil {
+			t.Errorf("ParseFreshclamConfig: %q accepted", conf)
+		}
+	}
+}
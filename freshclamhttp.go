@@ -0,0 +1,110 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HTTPClient returns a client for database downloads, going through the configured proxy, with
+// its credentials, or the proxy of the environment (HTTPS_PROXY) if none is configured.
+// Servers are authenticated with the system roots and those in CABundle, and only the hosts of
+// AllowedHosts, if any, are contacted, redirects included.
+func (c *FreshclamConfig) HTTPClient() (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if c.HTTPProxyServer != "" {
+		u, err := c.proxyURL()
+		if err != nil {
+			return nil, fmt.Errorf("HTTPClient: %v", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	var roots *x509.CertPool
+	if c.CABundle != "" {
+		pem, err := ioutil.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("HTTPClient: %v", err)
+		}
+		if roots, err = x509.SystemCertPool(); err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("HTTPClient: %s: no certificates", c.CABundle)
+		}
+	}
+
+	t := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: c.ConnectTimeout}).DialContext,
+		TLSHandshakeTimeout:   c.ConnectTimeout,
+		ResponseHeaderTimeout: c.ReceiveTimeout,
+		TLSClientConfig:       &tls.Config{RootCAs: roots},
+	}
+	return &http.Client{Transport: &updateTransport{c: c, next: t}}, nil
+}
+
+// proxyURL returns the URL of the configured proxy, with its credentials
+func (c *FreshclamConfig) proxyURL() (*url.URL, error) {
+	server := c.HTTPProxyServer
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	if c.HTTPProxyPort != 0 {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(c.HTTPProxyPort))
+	}
+	if c.HTTPProxyUsername != "" {
+		u.User = url.UserPassword(c.HTTPProxyUsername, c.HTTPProxyPassword)
+	}
+	return u, nil
+}
+
+// HostAllowed reports whether downloads from host are allowed: it is listed in AllowedHosts,
+// or is a subdomain of a name listed with a leading dot, such as ".clamav.net"
+func (c *FreshclamConfig) HostAllowed(host string) bool {
+	if len(c.AllowedHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, a := range c.AllowedHosts {
+		a = strings.ToLower(a)
+		if host == a || strings.HasPrefix(a, ".") && (strings.HasSuffix(host, a) || host == a[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateTransport enforces AllowedHosts and HTTPUserAgent on every request
+type updateTransport struct {
+	c    *FreshclamConfig
+	next http.RoundTripper
+}
+
+func (t *updateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" || !t.c.HostAllowed(req.URL.Hostname()) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%s: host not allowed", req.URL.Redacted())
+	}
+	if t.c.HTTPUserAgent != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.c.HTTPUserAgent)
+	}
+	return t.next.RoundTrip(req)
+}
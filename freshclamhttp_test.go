@@ -0,0 +1,82 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFreshclamHTTPClient(t *testing.T) {
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.UserAgent()))
+	}))
+	defer mirror.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mirror.Certificate().Raw}), 0644)
+
+	c, err := ParseFreshclamConfig(strings.NewReader("HTTPUserAgent clamav-test\n"))
+	if err != nil {
+		t.Fatalf("ParseFreshclamConfig: %v", err)
+	}
+	c.CABundle = bundle
+	c.AllowedHosts = []string{"127.0.0.1"}
+	client, err := c.HTTPClient()
+	if err != nil {
+		t.Fatalf("HTTPClient: %v", err)
+	}
+	resp, err := client.Get(mirror.URL + "/daily.cvd")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "clamav-test" {
+		t.Errorf("Get: user agent %q", body)
+	}
+
+	c.AllowedHosts = []string{".clamav.net"}
+	if client, err = c.HTTPClient(); err != nil {
+		t.Fatalf("HTTPClient: %v", err)
+	}
+	if _, err := client.Get(mirror.URL + "/daily.cvd"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Get: host outside the allowlist: %v", err)
+	}
+	if !c.HostAllowed("database.clamav.net") || !c.HostAllowed("clamav.net") || c.HostAllowed("clamav.net.example.com") {
+		t.Errorf("HostAllowed: %v", c.AllowedHosts)
+	}
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic c2Nhbm5lcjpzZWNyZXQ=" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+	u, _ := url.Parse(proxy.URL)
+	c.HTTPProxyServer = u.Hostname()
+	c.HTTPProxyPort, _ = strconv.Atoi(u.Port())
+	c.HTTPProxyUsername, c.HTTPProxyPassword = "scanner", "secret"
+	if client, err = c.HTTPClient(); err != nil {
+		t.Fatalf("HTTPClient: %v", err)
+	}
+	resp, err = client.Get("http://database.clamav.net/daily.cvd")
+	if err != nil {
+		t.Fatalf("Get: through proxy: %v", err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "proxied http://database.clamav.net/daily.cvd" {
+		t.Errorf("Get: through proxy: %d %q", resp.StatusCode, body)
+	}
+}
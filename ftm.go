@@ -0,0 +1,127 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FileTypeMagic is an entry of a file type magic database (.ftm), which tells ClamAV how to
+// recognize a file type. Custom entries route proprietary formats to the right parser, such as
+// CL_TYPE_ZIP for a zip based format with its own magic, or skip them with CL_TYPE_IGNORED.
+type FileTypeMagic struct {
+	// Pattern makes Magic a body-based signature pattern, as in .ndb databases, matched at
+	// Offset; otherwise the hex encoded bytes of Magic are compared at Offset
+	Pattern bool
+	Offset  string // "0" if empty; "*" or ndb style offsets with Pattern
+	Magic   string // hex encoded
+	Name    string // description, for debug output
+
+	Required string // type an object must have been given to match, "CL_TYPE_ANY" if empty
+	Type     string // type assigned, e.g. "CL_TYPE_ZIP"
+
+	// MinFlevel and MaxFlevel restrict the entry to libclamav functionality levels, if not zero
+	MinFlevel, MaxFlevel uint
+}
+
+// String returns the entry as a line of a .ftm database
+func (m FileTypeMagic) String() string {
+	kind, offset, required := 0, m.Offset, m.Required
+	if m.Pattern {
+		kind = 1
+	}
+	if offset == "" {
+		offset = "0"
+	}
+	if required == "" {
+		required = "CL_TYPE_ANY"
+	}
+	s := fmt.Sprintf("%d:%s:%s:%s:%s:%s", kind, offset, m.Magic, m.Name, required, m.Type)
+	switch {
+	case m.MaxFlevel != 0:
+		s += fmt.Sprintf(":%d:%d", m.MinFlevel, m.MaxFlevel)
+	case m.MinFlevel != 0:
+		s += fmt.Sprintf(":%d", m.MinFlevel)
+	}
+	return s
+}
+
+// Validate checks the entry before it is handed to libclamav, which only logs invalid entries
+func (m FileTypeMagic) Validate() error {
+	switch {
+	case m.Magic == "":
+		return fmt.Errorf("FileTypeMagic: %s: empty magic", m.Name)
+	case strings.ContainsAny(m.Name, ":\n"):
+		return fmt.Errorf("FileTypeMagic: %q: invalid name", m.Name)
+	case !strings.HasPrefix(m.Type, "CL_TYPE_"):
+		return fmt.Errorf("FileTypeMagic: %s: invalid type %q", m.Name, m.Type)
+	case m.Required != "" && !strings.HasPrefix(m.Required, "CL_TYPE_"):
+		return fmt.Errorf("FileTypeMagic: %s: invalid required type %q", m.Name, m.Required)
+	case m.MaxFlevel != 0 && m.MaxFlevel < m.MinFlevel:
+		return fmt.Errorf("FileTypeMagic: %s: invalid functionality level range", m.Name)
+	}
+	if m.Pattern {
+		if strings.ContainsAny(m.Magic+m.Offset, ":\n") {
+			return fmt.Errorf("FileTypeMagic: %s: invalid pattern", m.Name)
+		}
+		return nil
+	}
+	if m.Offset != "" {
+		if _, err := strconv.ParseUint(m.Offset, 10, 32); err != nil {
+			return fmt.Errorf("FileTypeMagic: %s: invalid offset %q", m.Name, m.Offset)
+		}
+	}
+	if len(m.Magic)%2 != 0 || strings.Trim(strings.ToLower(m.Magic), "0123456789abcdef") != "" {
+		return fmt.Errorf("FileTypeMagic: %s: magic is not hex encoded", m.Name)
+	}
+	return nil
+}
+
+// ParseFileTypeMagic parses a line of a .ftm database
+func ParseFileTypeMagic(line string) (FileTypeMagic, error) {
+	f := strings.Split(strings.TrimSpace(line), ":")
+	if len(f) < 6 || len(f) > 8 {
+		return FileTypeMagic{}, fmt.Errorf("ParseFileTypeMagic: %q: invalid entry", line)
+	}
+	m := FileTypeMagic{Offset: f[1], Magic: f[2], Name: f[3], Required: f[4], Type: f[5]}
+	switch f[0] {
+	case "0":
+	case "1":
+		m.Pattern = true
+	default:
+		return FileTypeMagic{}, fmt.Errorf("ParseFileTypeMagic: %q: unsupported magic type %s", line, f[0])
+	}
+	for i, p := range []*uint{&m.MinFlevel, &m.MaxFlevel} {
+		if len(f) <= 6+i {
+			break
+		}
+		n, err := strconv.ParseUint(f[6+i], 10, 32)
+		if err != nil {
+			return FileTypeMagic{}, fmt.Errorf("ParseFileTypeMagic: %q: invalid functionality level", line)
+		}
+		*p = uint(n)
+	}
+	return m, nil
+}
+
+// LoadFileTypes loads custom file type magic into the engine, before Compile. Like those of
+// the official daily.ftm, the entries are consulted in addition to the built-in file types.
+func (e *Engine) LoadFileTypes(magic []FileTypeMagic, dbopts uint) (uint, error) {
+	var db strings.Builder
+	for _, m := range magic {
+		if err := m.Validate(); err != nil {
+			return 0, fmt.Errorf("LoadFileTypes: %v", err)
+		}
+		db.WriteString(m.String())
+		db.WriteByte('\n')
+	}
+	n, err := e.loadDatabases(map[string]string{"custom.ftm": db.String()}, dbopts)
+	if err != nil {
+		return 0, fmt.Errorf("LoadFileTypes: %v", err)
+	}
+	return n, nil
+}
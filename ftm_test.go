@@ -0,0 +1,54 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "testing"
+
+func TestFileTypeMagic(t *testing.T) {
+	for _, line := range []string{
+		"0:0:504b0304:Acme package:CL_TYPE_ANY:CL_TYPE_ZIP",
+		"1:*:414345{4}4d4150:Acme map:CL_TYPE_ANY:CL_TYPE_IGNORED:90",
+		"0:4:cafe:Acme blob:CL_TYPE_BINARY_DATA:CL_TYPE_IGNORED:90:200",
+	} {
+		m, err := ParseFileTypeMagic(line)
+		if err != nil {
+			t.Errorf("ParseFileTypeMagic: %v", err)
+			continue
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("Validate: %s: %v", line, err)
+		}
+		if m.String() != line {
+			t.Errorf("String: got %q, want %q", m.String(), line)
+		}
+	}
+	if s := (FileTypeMagic{Magic: "cafe", Name: "x", Type: "CL_TYPE_ZIP"}).String(); s != "0:0:cafe:x:CL_TYPE_ANY:CL_TYPE_ZIP" {
+		t.Errorf("String: defaults: %q", s)
+	}
+
+	for _, m := range []FileTypeMagic{
+		{Magic: "cafe", Name: "no type"},
+		{Magic: "xyz", Name: "not hex", Type: "CL_TYPE_ZIP"},
+		{Magic: "cafe", Name: "a:b", Type: "CL_TYPE_ZIP"},
+		{Magic: "cafe", Offset: "*", Name: "wildcard offset", Type: "CL_TYPE_ZIP"},
+		{Magic: "cafe", Name: "levels", Type: "CL_TYPE_ZIP", MinFlevel: 100, MaxFlevel: 90},
+	} {
+		if m.Validate() == nil {
+			t.Errorf("Validate: %s: invalid entry accepted", m.Name)
+		}
+	}
+	if _, err := ParseFileTypeMagic("4:0:cafe:partition:CL_TYPE_ANY:CL_TYPE_PART_ANY"); err == nil {
+		t.Errorf("ParseFileTypeMagic: unsupported magic type accepted")
+	}
+
+	eng := New()
+	defer eng.Free()
+	if _, err := eng.LoadFileTypes([]FileTypeMagic{{Magic: "cafe", Name: "Acme", Type: "CL_TYPE_IGNORED"}}, DbStdopt); err != nil {
+		t.Errorf("LoadFileTypes: %v", err)
+	}
+	if _, err := eng.LoadFileTypes([]FileTypeMagic{{Magic: "cafe", Name: "Acme"}}, DbStdopt); err == nil {
+		t.Errorf("LoadFileTypes: invalid entry loaded")
+	}
+}
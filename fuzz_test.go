@@ -0,0 +1,111 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The fuzz targets feed arbitrary input through the wrapper into libclamav. Run them with, for
+// example, go test -fuzz FuzzScanBytes; without -fuzz only the seeds are run, as tests.
+
+func FuzzScanBytes(f *testing.F) {
+	eng, err := testInitAll()
+	if err != nil {
+		f.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	f.Add(eicar, int64(0), int64(len(eicar)))
+	f.Add([]byte{}, int64(0), int64(0))
+	f.Add([]byte("%PDF-1.7\n1 0 obj<</OpenAction<</S/JavaScript>>>>endobj"), int64(-1), int64(5))
+	f.Add([]byte("PK\x03\x04\x14\x00\x00\x00\x08\x00"), int64(4), int64(1<<62))
+
+	f.Fuzz(func(t *testing.T, data []byte, off, n int64) {
+		virus, _, err := eng.ScanBytes(data, "fuzz", stdopts)
+		if virus != "" && err == nil {
+			t.Errorf("ScanBytes: %s without error", virus)
+		}
+		fmap := OpenMemory(data)
+		if fmap == nil {
+			if len(data) != 0 {
+				t.Fatalf("OpenMemory: nil map of %d bytes", len(data))
+			}
+			if _, _, err := eng.ScanMapCb(nil, "fuzz", stdopts, nil); err == nil {
+				t.Errorf("ScanMapCb: nil map scanned")
+			}
+			return
+		}
+		defer CloseMemory(fmap)
+		_, _, err = eng.ScanMapRange(fmap, off, n, "fuzz", stdopts, nil)
+		if valid := off >= 0 && n >= 0 && off <= int64(len(data)) && n <= int64(len(data))-off; !valid && err == nil {
+			t.Errorf("ScanMapRange: range %d+%d of %d bytes scanned", off, n, len(data))
+		}
+	})
+}
+
+func FuzzMetadata(f *testing.F) {
+	f.Add(`{"Magic":"CLAMJSONv0","RootFileType":"CL_TYPE_ZIP","FileName":"a.zip","FileSize":"0x10",` +
+		`"ContainedObjects":[{"FileType":"CL_TYPE_MSOLE2","HasMacros":1,"Viruses":["Heuristics.Encrypted.Zip"]}]}`)
+	f.Add(`{"PE":{"NumberOfSections":-1,"TimeDateStamp":1e300,"Sections":[1]},"PDFStats":{"PageCount":"x","Encrypted":true}}`)
+	f.Add(`[]`)
+	f.Add(`{"URIs":[null,1,"http://example.com/"],"EmbeddedObjects":[[]]}`)
+
+	f.Fuzz(func(t *testing.T, s string) {
+		m, err := parseMetadata(s)
+		if err != nil {
+			return
+		}
+		res := &ScanResult{Metadata: m}
+		res.HasMacros()
+		if m.Archive != nil {
+			m.Archive.MaxDepth()
+		}
+		encryptedObjects(nil, "", m.RootFileType, m)
+	})
+}
+
+func FuzzLoad(f *testing.F) {
+	if err := Init(InitDefault); err != nil {
+		f.Fatalf("Init: %v", err)
+	}
+	exts := []string{"ndb", "hdb", "ldb", "cvd", "cld", "ign2", "fp", "yar", "idb", "pdb"}
+	f.Add([]byte("Test:0:*:414243\n"), uint8(0))
+	f.Add([]byte("44d88612fea8a8f36de82e1278abb02f:68:Eicar-Test-Signature\n"), uint8(1))
+	f.Add([]byte("Test;Engine:51-255,Target:0;0;414243\n"), uint8(2))
+	f.Add([]byte("ClamAV-VDB:16 Oct 2026 07:52 -0400:27431:2069437:90:x:y:builder:1792150320"), uint8(3))
+	f.Add([]byte("rule r { condition: true }"), uint8(8))
+
+	f.Fuzz(func(t *testing.T, data []byte, ext uint8) {
+		parseCVDHeader(data)
+		path := filepath.Join(t.TempDir(), "fuzz."+exts[int(ext)%len(exts)])
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		eng := New()
+		defer eng.Free()
+		if _, err := eng.Load(path, DbStdopt); err == nil {
+			eng.Compile()
+		}
+	})
+}
+
+func TestNulStrings(t *testing.T) {
+	eng := New()
+	defer eng.Free()
+	if _, err := eng.Load(DBDir()+"\x00/elsewhere", DbStdopt); err == nil {
+		t.Errorf("Load: path with a NUL byte loaded")
+	}
+	if _, _, err := eng.ScanFile("/etc/passwd\x00.txt", stdopts); err == nil {
+		t.Errorf("ScanFile: path with a NUL byte scanned")
+	}
+	if err := eng.SetString(EngineTmpdir, "/tmp\x00/x"); err == nil {
+		t.Errorf("SetString: string with a NUL byte set")
+	}
+	if _, _, err := eng.ScanDesc("fuzz", 0, nil); err == nil {
+		t.Errorf("ScanDesc: nil options")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// IconSignature is an entry of an icon database (.idb): the fuzzy hash of the icon of PE files,
+// in two groups that logical signatures match with their IconGroup1 and IconGroup2 attributes
+type IconSignature struct {
+	Name           string
+	Group1, Group2 string
+	Hash           string // 124 hex digits, as printed by sigtool --print-icon-hash
+}
+
+// ImageSignature detects the images whose fuzzy hash, as computed with
+// ScanParseImageFuzzyHash, is Hash, such as the logo used by a phishing campaign (libclamav 1.0)
+type ImageSignature struct {
+	Name string
+	Hash string // 16 hex digits, as in Metadata.ImageFuzzyHashes
+
+	// Distance is the Hamming distance within which hashes match. libclamav only supports 0,
+	// exact matches, so far.
+	Distance int
+}
+
+// imageFlevel is the functionality level of libclamav 1.0, which introduced image fuzzy hashes
+const imageFlevel = 150
+
+// FuzzyHashList holds icon and image fuzzy hash signatures, to be managed from the
+// configuration of a service rather than as database files
+type FuzzyHashList struct {
+	Icons  []IconSignature
+	Images []ImageSignature
+}
+
+// validHex reports whether s is n hex digits
+func validHex(s string, n int) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == n
+}
+
+// validName reports whether s can be used as a field of a signature
+func validName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, ":;#\n")
+}
+
+// IDB returns the icon signatures as a .idb database
+func (l *FuzzyHashList) IDB() (string, error) {
+	var b strings.Builder
+	for _, s := range l.Icons {
+		if !validName(s.Name) || !validName(s.Group1) || !validName(s.Group2) || !validHex(s.Hash, 124) {
+			return "", fmt.Errorf("IDB: invalid icon signature %+v", s)
+		}
+		fmt.Fprintf(&b, "%s:%s:%s:%s\n", s.Name, s.Group1, s.Group2, strings.ToLower(s.Hash))
+	}
+	return b.String(), nil
+}
+
+// LDB returns the image signatures as a logical signature database
+func (l *FuzzyHashList) LDB() (string, error) {
+	var b strings.Builder
+	for _, s := range l.Images {
+		if !validName(s.Name) || !validHex(s.Hash, 16) || s.Distance < 0 {
+			return "", fmt.Errorf("LDB: invalid image signature %+v", s)
+		}
+		fmt.Fprintf(&b, "%s;Engine:%d-255,Target:0;0;fuzzy_img#%s#%d\n", s.Name, imageFlevel, strings.ToLower(s.Hash), s.Distance)
+	}
+	return b.String(), nil
+}
+
+// LoadFuzzyHashList loads the signatures of l into the engine, before Compile, in addition to
+// the databases already loaded. Icon signatures only match through logical signatures
+// referring to their groups; image signatures need ScanParseImage and
+// ScanParseImageFuzzyHash in the scan options.
+func (e *Engine) LoadFuzzyHashList(l *FuzzyHashList, dbopts uint) (uint, error) {
+	files := map[string]string{}
+	idb, err := l.IDB()
+	if err != nil {
+		return 0, fmt.Errorf("LoadFuzzyHashList: %v", err)
+	}
+	ldb, err := l.LDB()
+	if err != nil {
+		return 0, fmt.Errorf("LoadFuzzyHashList: %v", err)
+	}
+	if idb != "" {
+		files["local.idb"] = idb
+	}
+	if ldb != "" {
+		files["local-images.ldb"] = ldb
+	}
+	if len(files) == 0 {
+		return 0, nil
+	}
+	n, err := e.loadDatabases(files, dbopts)
+	if err != nil {
+		return 0, fmt.Errorf("LoadFuzzyHashList: %v", err)
+	}
+	return n, nil
+}
+
+// FuzzyHashMatch describes the detection of an object by an image signature
+type FuzzyHashMatch struct {
+	Signature ImageSignature
+	Hash      string // of the image matched, among the hashes in the metadata
+	Distance  int    // between Hash and the hash of the signature, -1 if Hash is not known
+}
+
+// Match returns the details of the detection reported in res if it is one of the image
+// signatures of l, nil otherwise. The matched image is only known if the scan collected the
+// metadata, with ScanGeneralCollectMetadata.
+func (l *FuzzyHashList) Match(res *ScanResult) *FuzzyHashMatch {
+	if res == nil || res.Virus == "" {
+		return nil
+	}
+	virus := strings.TrimSuffix(res.Virus, ".UNOFFICIAL")
+	for _, s := range l.Images {
+		if s.Name != virus {
+			continue
+		}
+		m := &FuzzyHashMatch{Signature: s, Distance: -1}
+		if res.Metadata != nil {
+			for _, h := range res.Metadata.ImageFuzzyHashes {
+				if d := hammingDistance(h, s.Hash); d >= 0 && d <= s.Distance && (m.Distance < 0 || d < m.Distance) {
+					m.Hash, m.Distance = h, d
+				}
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+// hammingDistance returns the number of bits differing in hex hashes a and b, -1 if they are
+// not comparable
+func hammingDistance(a, b string) int {
+	x, err1 := hex.DecodeString(a)
+	y, err2 := hex.DecodeString(b)
+	if err1 != nil || err2 != nil || len(x) != len(y) {
+		return -1
+	}
+	d := 0
+	for i := range x {
+		for v := x[i] ^ y[i]; v != 0; v &= v - 1 {
+			d++
+		}
+	}
+	return d
+}
@@ -0,0 +1,64 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFuzzyHashList(t *testing.T) {
+	icon := strings.Repeat("0a", 62)
+	l := &FuzzyHashList{
+		Icons:  []IconSignature{{Name: "FakeAV", Group1: "ADOBE", Group2: "PDF", Hash: icon}},
+		Images: []ImageSignature{{Name: "Phish.Logo.Bank-1", Hash: "AF2AD01ED42993C7"}},
+	}
+	idb, err := l.IDB()
+	if want := "FakeAV:ADOBE:PDF:" + icon + "\n"; err != nil || idb != want {
+		t.Errorf("IDB: got %q %v, want %q", idb, err, want)
+	}
+	ldb, err := l.LDB()
+	if want := "Phish.Logo.Bank-1;Engine:150-255,Target:0;0;fuzzy_img#af2ad01ed42993c7#0\n"; err != nil || ldb != want {
+		t.Errorf("LDB: got %q %v, want %q", ldb, err, want)
+	}
+
+	eng := New()
+	defer eng.Free()
+	if _, err := eng.LoadFuzzyHashList(l, DbStdopt); err != nil {
+		t.Errorf("LoadFuzzyHashList: %v", err)
+	}
+	for _, bad := range []*FuzzyHashList{
+		{Icons: []IconSignature{{Name: "x", Group1: "a", Group2: "b", Hash: "0a0a"}}},
+		{Icons: []IconSignature{{Name: "x:y", Group1: "a", Group2: "b", Hash: icon}}},
+		{Images: []ImageSignature{{Name: "x", Hash: "af2ad01ed42993cz"}}},
+		{Images: []ImageSignature{{Name: "x", Hash: "af2ad01ed42993c7", Distance: -1}}},
+	} {
+		if _, err := eng.LoadFuzzyHashList(bad, DbStdopt); err == nil {
+			t.Errorf("LoadFuzzyHashList: invalid signatures loaded: %+v", bad)
+		}
+	}
+
+	res := &ScanResult{Virus: "Phish.Logo.Bank-1.UNOFFICIAL", Metadata: &Metadata{ImageFuzzyHashes: []string{"0000000000000000", "af2ad01ed42993c7"}}}
+	m := l.Match(res)
+	if m == nil || m.Hash != "af2ad01ed42993c7" || m.Distance != 0 || m.Signature.Name != "Phish.Logo.Bank-1" {
+		t.Errorf("Match: %+v", m)
+	}
+	if m := l.Match(&ScanResult{Virus: "Phish.Logo.Bank-1"}); m == nil || m.Distance != -1 {
+		t.Errorf("Match: without metadata: %+v", m)
+	}
+	if m := l.Match(&ScanResult{Virus: "Eicar-Test-Signature"}); m != nil {
+		t.Errorf("Match: other signature: %+v", m)
+	}
+	if d := hammingDistance("ff00", "0f01"); d != 5 {
+		t.Errorf("hammingDistance = %d, want 5", d)
+	}
+}
+
+func TestParseMetadataImageFuzzyHash(t *testing.T) {
+	m, err := parseMetadata(`{"RootFileType":"CL_TYPE_HTML","ImageFuzzyHash":["af2ad01ed42993c7"],"EmbeddedObjects":[{"FileType":"CL_TYPE_PNG","ImageFuzzyHash":"0123456789abcdef"}]}`)
+	if err != nil || len(m.ImageFuzzyHashes) != 2 || m.ImageFuzzyHashes[0] != "af2ad01ed42993c7" || m.ImageFuzzyHashes[1] != "0123456789abcdef" {
+		t.Errorf("parseMetadata: %+v %v", m, err)
+	}
+}
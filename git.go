@@ -0,0 +1,242 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// GitDetection locates a virus found in a git repository
+type GitDetection struct {
+	Blob   string // object id of the infected blob
+	Commit string // first commit found to contain the blob
+	Path   string // path of the blob in that commit
+	Virus  string // virus name
+}
+
+// ScanGitRepo scans the blobs in the git repository at dir. If history is false only the tree of
+// HEAD is scanned, otherwise every blob reachable from any ref in the repository is. Each blob is
+// scanned once and detections are reported against the oldest commit and the path the blob was
+// first found under. The git command must be installed.
+func (e *Engine) ScanGitRepo(dir string, history bool, opts *ScanOptions) ([]GitDetection, error) {
+	var found []GitDetection
+	err := gitBlobs(dir, history, func(blob, commit, path string, r io.Reader) error {
+		virus, _, err := e.ScanReader(r, path, opts)
+		if virus != "" {
+			found = append(found, GitDetection{Blob: blob, Commit: commit, Path: path, Virus: virus})
+		} else if err != nil {
+			return fmt.Errorf("%s (%s): %v", path, blob, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return found, fmt.Errorf("ScanGitRepo: %v", err)
+	}
+	return found, nil
+}
+
+// gitBlobs calls fn with the content of every distinct blob reachable from HEAD, or from all refs
+// if history is set. Commits are visited oldest first. Symbolic links and submodules are skipped.
+func gitBlobs(dir string, history bool, fn func(blob, commit, path string, r io.Reader) error) error {
+	args := []string{"rev-parse", "--verify", "HEAD"}
+	if history {
+		args = []string{"rev-list", "--reverse", "--all"}
+	}
+	out, err := gitCommand(dir, args...).Output()
+	if err != nil {
+		return gitError(err)
+	}
+
+	b, err := newGitBatch(dir)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	seen := map[string]bool{}
+	for _, commit := range strings.Fields(string(out)) {
+		var tree string
+		err := b.read(commit, func(typ string, r io.Reader) error {
+			var err error
+			tree, err = commitTree(r)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("commit %s: %v", commit, err)
+		}
+		if err := b.walkTree(tree, "", commit, seen, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitTree returns the id of the root tree of a commit object
+func commitTree(r io.Reader) (string, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "tree ") {
+			return strings.TrimPrefix(s.Text(), "tree "), nil
+		}
+		if s.Text() == "" {
+			break
+		}
+	}
+	return "", errors.New("no tree in commit")
+}
+
+// gitBatch reads objects through a long running "git cat-file --batch"
+type gitBatch struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+func newGitBatch(dir string) (*gitBatch, error) {
+	cmd := gitCommand(dir, "cat-file", "--batch")
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, gitError(err)
+	}
+	return &gitBatch{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+func (b *gitBatch) close() {
+	b.in.Close()
+	b.cmd.Wait()
+}
+
+// read looks up an object and passes its type and content to fn. Whatever fn leaves unread
+// is discarded.
+func (b *gitBatch) read(oid string, fn func(typ string, r io.Reader) error) error {
+	if _, err := fmt.Fprintln(b.in, oid); err != nil {
+		return err
+	}
+	line, err := b.out.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	f := strings.Fields(line)
+	if len(f) != 3 {
+		return fmt.Errorf("object %s: %s", oid, strings.TrimSpace(line))
+	}
+	size, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("object %s: bad size %q", oid, f[2])
+	}
+
+	r := io.LimitReader(b.out, size)
+	ferr := fn(f[1], r)
+	// skip the rest of the object and its trailing newline so the stream stays in sync
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	if _, err := b.out.Discard(1); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// walkTree visits the blobs of a tree not seen before, recursing into subtrees
+func (b *gitBatch) walkTree(tree, prefix, commit string, seen map[string]bool, fn func(blob, commit, path string, r io.Reader) error) error {
+	if seen[tree] {
+		return nil
+	}
+	seen[tree] = true
+
+	var entries []gitTreeEntry
+	err := b.read(tree, func(typ string, r io.Reader) error {
+		if typ != "tree" {
+			return fmt.Errorf("object %s is a %s, not a tree", tree, typ)
+		}
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		entries, err = parseGitTree(buf, len(tree)/2)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, ent := range entries {
+		path := prefix + ent.name
+		switch ent.mode {
+		case "40000":
+			if err := b.walkTree(ent.oid, path+"/", commit, seen, fn); err != nil {
+				return err
+			}
+		case "100644", "100755", "100664":
+			if seen[ent.oid] {
+				continue
+			}
+			seen[ent.oid] = true
+			err := b.read(ent.oid, func(typ string, r io.Reader) error {
+				return fn(ent.oid, commit, path, r)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		// symbolic links (120000) and submodules (160000) have no content of their own
+	}
+	return nil
+}
+
+// gitTreeEntry is a single entry of a tree object
+type gitTreeEntry struct {
+	mode, name, oid string
+}
+
+// parseGitTree decodes the binary content of a tree object, where each entry is
+// "<mode> <name>\0<raw object id>"
+func parseGitTree(buf []byte, idLen int) ([]gitTreeEntry, error) {
+	var entries []gitTreeEntry
+	for len(buf) > 0 {
+		sp := bytes.IndexByte(buf, ' ')
+		nul := bytes.IndexByte(buf, 0)
+		if sp < 0 || nul < sp || len(buf) < nul+1+idLen {
+			return nil, errors.New("malformed tree object")
+		}
+		entries = append(entries, gitTreeEntry{
+			mode: string(buf[:sp]),
+			name: string(buf[sp+1 : nul]),
+			oid:  hex.EncodeToString(buf[nul+1 : nul+1+idLen]),
+		})
+		buf = buf[nul+1+idLen:]
+	}
+	return entries, nil
+}
+
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	return cmd
+}
+
+// gitError includes the error output of a failed git command in err
+func gitError(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return fmt.Errorf("git: %s", bytes.TrimSpace(ee.Stderr))
+	}
+	return fmt.Errorf("git: %v", err)
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testGitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "clamav")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	git := func(args ...string) {
+		cmd := gitCommand(dir, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, data string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	git("init", "-q")
+	write("a.txt", "first")
+	git("add", ".")
+	git("commit", "-q", "-m", "one")
+	write("a.txt", "second")
+	write("dir/b.txt", "shared")
+	write("c.txt", "shared")
+	git("add", ".")
+	git("commit", "-q", "-m", "two")
+	return dir
+}
+
+func TestGitBlobs(t *testing.T) {
+	dir := testGitRepo(t)
+	defer os.RemoveAll(dir)
+
+	for _, history := range []bool{false, true} {
+		var got []string
+		err := gitBlobs(dir, history, func(blob, commit, path string, r io.Reader) error {
+			b, err := ioutil.ReadAll(r)
+			got = append(got, path+"="+string(b))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("gitBlobs: %v", err)
+		}
+		want := []string{"a.txt=second", "c.txt=shared"}
+		if history {
+			want = []string{"a.txt=first", "a.txt=second", "c.txt=shared"}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("gitBlobs(history=%v): %q, want %q", history, got, want)
+		}
+	}
+}
+
+func TestGitBlobsNotARepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "clamav")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = gitBlobs(dir, false, func(blob, commit, path string, r io.Reader) error { return nil })
+	if err == nil {
+		t.Errorf("gitBlobs: no error outside a repository")
+	}
+}
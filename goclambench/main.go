@@ -0,0 +1,282 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+// Goclambench scans a corpus of files and reports the throughput, the latency percentiles and
+// the memory of the scans, so that hardware can be sized for a workload and sets of scan
+// options compared on the same corpus. Option sets are given as clamd.conf files, with the
+// -config flag repeated for every set; without one, the defaults of clamd are benchmarked.
+//
+// Every set is run on an engine of its own: the corpus is scanned once to warm up the page
+// cache and the engine, unless -warmup=false, then -passes times, measured.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import "github.com/mirtchovski/clamav"
+
+var workers = flag.Int("workers", runtime.NumCPU(), "number of concurrent scans")
+var passes = flag.Int("passes", 1, "number of measured passes over the corpus")
+var warmup = flag.Bool("warmup", true, "scan the corpus once before measuring")
+var db = flag.String("db", "", "virus definition database, that of the configuration if empty")
+var jsonOut = flag.Bool("json", false, "write the reports as JSON")
+var configs configList
+
+// configList are the clamd.conf files given with -config
+type configList []string
+
+func (c *configList) String() string     { return strings.Join(*c, ",") }
+func (c *configList) Set(v string) error { *c = append(*c, v); return nil }
+
+// file is a file of the corpus
+type file struct {
+	path string
+	size int64
+}
+
+// Report is the outcome of the benchmark of an option set
+type Report struct {
+	Config     string
+	Workers    int
+	Files      int64
+	Bytes      int64
+	Infected   int64
+	Errors     int64
+	Elapsed    time.Duration
+	FilesPerS  float64
+	MBPerS     float64
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	Signatures uint
+	LoadTime   time.Duration
+	EngineMB   float64 // estimated memory of the engine
+	PeakRSSMB  float64 // peak resident memory of the process so far, where known
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-config clamd.conf ...] path [...]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	flag.Var(&configs, "config", "clamd.conf of an option set to benchmark, repeatable")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "error: missing path")
+		usage()
+	}
+	if *workers < 1 || *passes < 1 {
+		fmt.Fprintln(os.Stderr, "error: -workers and -passes must be at least 1")
+		usage()
+	}
+	if err := clamav.Init(clamav.InitDefault); err != nil {
+		log.Fatalf("can not initialize ClamAV: %v", err)
+	}
+
+	corpus, err := walk(flag.Args())
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(corpus) == 0 {
+		log.Fatalf("no files to scan")
+	}
+	var total int64
+	for _, f := range corpus {
+		total += f.size
+	}
+	log.Printf("corpus: %d files, %d bytes", len(corpus), total)
+
+	sets := configs
+	if len(sets) == 0 {
+		sets = configList{""}
+	}
+	var reports []*Report
+	for _, path := range sets {
+		r, err := bench(path, corpus)
+		if err != nil {
+			log.Fatalf("%s: %v", name(path), err)
+		}
+		reports = append(reports, r)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(reports); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	fmt.Printf("%-24s %8s %10s %10s %10s %10s %10s %10s %10s %10s\n",
+		"config", "files", "files/s", "MB/s", "p50", "p90", "p99", "max", "engineMB", "peakMB")
+	for _, r := range reports {
+		fmt.Printf("%-24s %8d %10.1f %10.2f %10s %10s %10s %10s %10.1f %10.1f\n", r.Config, r.Files, r.FilesPerS,
+			r.MBPerS, round(r.P50), round(r.P90), round(r.P99), round(r.Max), r.EngineMB, r.PeakRSSMB)
+	}
+}
+
+// name returns the name of the option set of the clamd.conf at path
+func name(path string) string {
+	if path == "" {
+		return "defaults"
+	}
+	return path
+}
+
+// round rounds d for display
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// walk returns the regular files below paths
+func walk(paths []string) ([]file, error) {
+	var corpus []file
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				corpus = append(corpus, file{path, fi.Size()})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return corpus, nil
+}
+
+// bench benchmarks the option set of the clamd.conf at path, the defaults if empty
+func bench(path string, corpus []file) (*Report, error) {
+	var conf *clamav.ClamdConfig
+	var err error
+	if path == "" {
+		conf, err = clamav.ParseClamdConfig(strings.NewReader(""))
+	} else {
+		conf, err = clamav.ReadClamdConfig(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dbdir := conf.DatabaseDirectory
+	if *db != "" {
+		dbdir = *db
+	}
+	start := time.Now()
+	engine, sigs, err := clamav.LoadEngine(dbdir, conf.DBOptions, conf.Apply)()
+	if err != nil {
+		return nil, err
+	}
+	defer engine.Free()
+	r := &Report{Config: name(path), Workers: *workers, Signatures: sigs, LoadTime: time.Since(start)}
+	if m, ok := engine.MemoryEstimate(); ok {
+		r.EngineMB = float64(m.Bytes) / (1 << 20)
+	}
+	log.Printf("%s: %d signatures loaded in %v", r.Config, sigs, r.LoadTime.Round(time.Millisecond))
+
+	if *warmup {
+		run(engine, &conf.Options, corpus, nil)
+	}
+	var latencies []time.Duration
+	start = time.Now()
+	for i := 0; i < *passes; i++ {
+		latencies = append(latencies, run(engine, &conf.Options, corpus, r)...)
+	}
+	r.Elapsed = time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.P50, r.P90, r.P99 = percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99)
+	r.Max = latencies[len(latencies)-1]
+	r.FilesPerS = float64(r.Files) / r.Elapsed.Seconds()
+	r.MBPerS = float64(r.Bytes) / (1 << 20) / r.Elapsed.Seconds()
+	r.PeakRSSMB = float64(peakRSS()) / (1 << 20)
+	return r, nil
+}
+
+// run scans the corpus with the workers, counting the outcomes in r if not nil, and returns
+// the latencies of the scans
+func run(engine *clamav.Engine, opts *clamav.ScanOptions, corpus []file, r *Report) []time.Duration {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	latencies := make([]time.Duration, 0, len(corpus))
+	work := make(chan file)
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range work {
+				t := time.Now()
+				virus, _, err := engine.ScanFile(f.path, opts)
+				d := time.Since(t)
+				mu.Lock()
+				latencies = append(latencies, d)
+				if r != nil {
+					r.Files++
+					r.Bytes += f.size
+					if virus != "" {
+						r.Infected++
+					} else if err != nil {
+						r.Errors++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, f := range corpus {
+		work <- f
+	}
+	close(work)
+	wg.Wait()
+	return latencies
+}
+
+// percentile returns the p-th percentile of the sorted durations d
+func percentile(d []time.Duration, p int) time.Duration {
+	i := (len(d)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return d[i]
+}
+
+// peakRSS returns the peak resident memory of the process in bytes, zero where unknown
+func peakRSS() int64 {
+	b, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) == 3 && f[0] == "VmHWM:" {
+			kb, _ := strconv.ParseInt(f[1], 10, 64)
+			return kb << 10
+		}
+	}
+	return 0
+}
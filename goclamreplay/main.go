@@ -0,0 +1,113 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+// Goclamreplay scans again the objects of the verdicts of a result store with a new database
+// or configuration, and reports the objects whose verdict changed: new detections, dropped
+// detections, renamed detections and objects that can no longer be scanned. It is meant to
+// review database updates before they are promoted; it exits with status 1 if any verdict
+// changed, 2 on errors.
+//
+// The objects are read from a corpus directory, where they are named by their SHA-256, such as
+// the quarantine directory of a remediation policy.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+import "github.com/mirtchovski/clamav"
+
+var store = flag.String("store", "", "result store holding the previous verdicts")
+var corpus = flag.String("corpus", "", "directory of the objects, named by SHA-256")
+var db = flag.String("db", "", "virus definition database to replay with, that of the configuration if empty")
+var config = flag.String("config", "", "clamd.conf to replay with, the defaults of clamd if empty")
+var jsonOut = flag.Bool("json", false, "write the report as JSON")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s -store results.jsonl -corpus dir [-db dir] [-config clamd.conf]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+	if *store == "" || *corpus == "" || flag.NArg() != 0 {
+		usage()
+	}
+	if err := clamav.Init(clamav.InitDefault); err != nil {
+		log.Printf("can not initialize ClamAV: %v", err)
+		os.Exit(2)
+	}
+
+	rep, err := replay()
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(2)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.Encode(rep)
+	} else {
+		for _, c := range rep.Changes {
+			switch c.Kind {
+			case clamav.VerdictFailed:
+				fmt.Printf("%-8s %s %s: %s (was %s)\n", c.Kind, c.SHA256, c.Target, c.Error, verdict(c.Before))
+			default:
+				fmt.Printf("%-8s %s %s: %s -> %s\n", c.Kind, c.SHA256, c.Target, verdict(c.Before), verdict(c.After))
+			}
+		}
+		fmt.Printf("replayed %d, unchanged %d, changed %d, missing %d\n", rep.Replayed, rep.Unchanged, len(rep.Changes), rep.Missing)
+	}
+	if len(rep.Changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+// verdict returns the verdict of a virus name
+func verdict(virus string) string {
+	if virus == "" {
+		return "clean"
+	}
+	return virus
+}
+
+// replay replays the store with the engine of the configuration
+func replay() (*clamav.ReplayReport, error) {
+	var conf *clamav.ClamdConfig
+	var err error
+	if *config == "" {
+		conf, err = clamav.ParseClamdConfig(strings.NewReader(""))
+	} else {
+		conf, err = clamav.ReadClamdConfig(*config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dbdir := conf.DatabaseDirectory
+	if *db != "" {
+		dbdir = *db
+	}
+	engine, sigs, err := clamav.LoadEngine(dbdir, conf.DBOptions, conf.Apply)()
+	if err != nil {
+		return nil, err
+	}
+	defer engine.Free()
+	log.Printf("%d signatures loaded from %s", sigs, dbdir)
+
+	s, err := clamav.OpenResultStore(*store)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	verdicts := s.Query(func(*clamav.StoredResult) bool { return true })
+	return clamav.Replay(&clamav.EngineScanner{Engine: engine, Options: &conf.Options}, clamav.CorpusDir(*corpus), verdicts)
+}
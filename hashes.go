@@ -0,0 +1,44 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// Hashes are the hex encoded digests of a scanned object. libclamav only hashes the objects it
+// detects (for the hash callback), so scanners compute them while reading the data they scan,
+// sparing callers a second pass over every file.
+type Hashes struct {
+	MD5    string
+	SHA1   string
+	SHA256 string
+}
+
+// hasher computes Hashes of the data written to it
+type hasher struct {
+	io.Writer
+	md5, sha1, sha256 hash.Hash
+}
+
+func newHasher() *hasher {
+	h := &hasher{md5: md5.New(), sha1: sha1.New(), sha256: sha256.New()}
+	h.Writer = io.MultiWriter(h.md5, h.sha1, h.sha256)
+	return h
+}
+
+// sum returns the hashes of the data written so far
+func (h *hasher) sum() *Hashes {
+	return &Hashes{
+		MD5:    hex.EncodeToString(h.md5.Sum(nil)),
+		SHA1:   hex.EncodeToString(h.sha1.Sum(nil)),
+		SHA256: hex.EncodeToString(h.sha256.Sum(nil)),
+	}
+}
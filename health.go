@@ -0,0 +1,107 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HealthHandler serves the liveness (/healthz) and readiness (/readyz) probes of a service
+// scanning with an EnginePool, such as a ClamdServer, for Kubernetes or load balancers. Probes
+// reply 200 and "ok", or 503 and the problems found, one per line. It can be mounted on any
+// prefix, the probes being told apart by the end of the path.
+type HealthHandler struct {
+	Pool *EnginePool
+
+	// Watchdog, if not nil, fails the liveness probe once it reports too many hung scans, for
+	// the service to be restarted
+	Watchdog *Watchdog
+
+	// DatabaseDir and MaxAge, if set, fail the readiness probe when the newest database
+	// container of the directory was built longer than MaxAge ago, as when updates stopped
+	DatabaseDir string
+	MaxAge      time.Duration
+
+	// Check, if not nil, is run against the engine serving scans on every readiness probe, as
+	// a self-test; its samples should be small
+	Check *EngineCheck
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var problems []string
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/healthz"):
+		problems = h.Live()
+	case strings.HasSuffix(r.URL.Path, "/readyz"):
+		problems = h.Ready()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// Live returns the problems the service cannot recover from without a restart
+func (h *HealthHandler) Live() []string {
+	if h.Watchdog != nil && !h.Watchdog.Healthy() {
+		return []string{fmt.Sprintf("%d scans hung", h.Watchdog.Stats().Hung)}
+	}
+	return nil
+}
+
+// Ready returns the problems preventing the service from serving scans
+func (h *HealthHandler) Ready() []string {
+	problems := h.Live()
+	if h.Pool == nil {
+		return append(problems, "no engine pool")
+	}
+	// the signatures are those of the engine checked, even if a reload swaps it meanwhile
+	e, sigs, release, err := h.Pool.acquire()
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	defer release()
+	if h.DatabaseDir != "" && h.MaxAge > 0 {
+		if age, err := databaseAge(h.DatabaseDir); err != nil {
+			problems = append(problems, err.Error())
+		} else if age > h.MaxAge {
+			problems = append(problems, fmt.Sprintf("databases %v old", age.Round(time.Minute)))
+		}
+	}
+	if h.Check != nil {
+		if err := h.Check.Check(e, sigs, 0); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// databaseAge returns the age of the newest database container in dir
+func databaseAge(dir string) (time.Duration, error) {
+	hs, err := DatabaseVersions(dir)
+	if err != nil {
+		return 0, err
+	}
+	var newest time.Time
+	for _, h := range hs {
+		if h.Built.After(newest) {
+			newest = h.Built
+		}
+	}
+	if newest.IsZero() {
+		return 0, fmt.Errorf("no database containers in %s", dir)
+	}
+	return time.Since(newest), nil
+}
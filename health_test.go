@@ -0,0 +1,68 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	p, err := NewEnginePool(LoadEngine(DBDir(), DbStdopt, nil), nil, stdopts)
+	if err != nil {
+		t.Fatalf("NewEnginePool: %v", err)
+	}
+	dir := t.TempDir()
+	writeCVD(t, dir, "daily.cld", 27431)
+	h := &HealthHandler{
+		Pool:        p,
+		DatabaseDir: dir,
+		MaxAge:      5 * 365 * 24 * time.Hour,
+		Check:       &EngineCheck{Detect: []EngineSample{{Name: "eicar.com", Data: eicar}}, Options: stdopts},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	probe := func(path string, code int) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Errorf("%s: %d %q, want %d", path, resp.StatusCode, body, code)
+		}
+	}
+	probe("/healthz", http.StatusOK)
+	probe("/readyz", http.StatusOK)
+	probe("/status", http.StatusNotFound)
+
+	// a database built in 2001
+	hdr := "ClamAV-VDB:09 Sep 2001 01:46 +0000:27000:2000:90:0123456789abcdef0123456789abcdef:dsig:builder:1000000000"
+	ioutil.WriteFile(filepath.Join(dir, "daily.cld"), []byte(hdr+strings.Repeat(" ", cvdHeaderSize-len(hdr))), 0644)
+	if problems := h.Ready(); len(problems) != 1 || !strings.HasPrefix(problems[0], "databases") {
+		t.Errorf("Ready: %q with stale databases", problems)
+	}
+
+	// the check is given the signatures of the engine serving scans
+	h.DatabaseDir = ""
+	h.Check.MinSignatures = p.Status().Signatures + 1
+	if problems := h.Ready(); len(problems) != 1 || !strings.Contains(problems[0], "signatures") {
+		t.Errorf("Ready: %q with too few signatures", problems)
+	}
+	h.Check.MinSignatures = 0
+
+	p.Close()
+	probe("/healthz", http.StatusOK)
+	probe("/readyz", http.StatusServiceUnavailable)
+	h.Pool = nil
+	probe("/readyz", http.StatusServiceUnavailable)
+}
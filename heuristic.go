@@ -0,0 +1,53 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "fmt"
+
+// HeuristicScanOptions returns scan options parsing every file type and running all the
+// heuristics of libclamav: broken and malformed files, encrypted archives and documents,
+// macros, phishing, and the structured data (DLP) checks for credit card and social security
+// numbers
+func HeuristicScanOptions() *ScanOptions {
+	return &ScanOptions{
+		General: ScanGeneralHeuristics,
+		Parse:   ^uint32(0),
+		Heuristic: ScanHeuristicBroken | ScanHeuristicPhishingSSLMismatch | ScanHeuristicPhishingCloak |
+			ScanHeuristicMacros | ScanHeuristicEncryptedArchive | ScanHeuristicEncryptedDoc |
+			ScanHeuristicPartitionIntxn | ScanHeuristicStructure | ScanHeuristicStructuredSSNNormal |
+			ScanHeuristicStructuredSSNStripped | ScanHeuristicStructuredCC | ScanHeuristicBrokenMedia,
+	}
+}
+
+// HeuristicEngine returns a builder of engines loaded with no signature databases, for the
+// environments only wanting the structural checks of HeuristicScanOptions, at a fraction of
+// the memory the official databases take. The phishing checks only apply to the links of
+// phishing, if not nil, since libclamav takes the sites to protect from the databases.
+// Configure, if not nil, is called on every new engine before loading, as with LoadEngine.
+func HeuristicEngine(phishing *PhishingList, configure func(*Engine) error) EngineBuilder {
+	return func() (*Engine, uint, error) {
+		e := New()
+		if configure != nil {
+			if err := configure(e); err != nil {
+				e.Free()
+				return nil, 0, err
+			}
+		}
+		var sigs uint
+		var err error
+		if phishing != nil {
+			sigs, err = e.LoadPhishingList(phishing, DbPhishing|DbPhishingUrls)
+		}
+		if err == nil {
+			// libclamav compiles an engine without signatures as well
+			err = e.Compile()
+		}
+		if err != nil {
+			e.Free()
+			return nil, 0, fmt.Errorf("HeuristicEngine: %v", err)
+		}
+		return e, sigs, nil
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeuristicEngine(t *testing.T) {
+	if err := Init(InitDefault); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	configured := false
+	p, err := NewEnginePool(HeuristicEngine(nil, func(*Engine) error { configured = true; return nil }), nil, HeuristicScanOptions())
+	if err != nil {
+		t.Fatalf("NewEnginePool: %v", err)
+	}
+	defer p.Close()
+	if st := p.Status(); !configured || st.Signatures != 0 {
+		t.Errorf("HeuristicEngine: %+v", st)
+	}
+	if res, err := p.Scan(strings.NewReader("%PDF-1.4\n%%EOF\n"), "doc.pdf"); err != nil || res.Virus != "" {
+		t.Errorf("Scan: %+v %v", res, err)
+	}
+
+	l := &PhishingList{}
+	l.Protect("bank.example")
+	e, _, err := HeuristicEngine(l, nil)()
+	if err != nil {
+		t.Fatalf("HeuristicEngine: %v", err)
+	}
+	e.Free()
+
+	l.Protect("")
+	if _, _, err := HeuristicEngine(l, nil)(); err == nil {
+		t.Errorf("HeuristicEngine: invalid phishing list accepted")
+	}
+	if o := HeuristicScanOptions(); o.General&ScanGeneralHeuristics == 0 || o.Heuristic&ScanHeuristicStructuredCC == 0 {
+		t.Errorf("HeuristicScanOptions: %+v", o)
+	}
+}
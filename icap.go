@@ -0,0 +1,146 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ICAPClient is a Scanner that offloads scanning to an ICAP (RFC 3507) antivirus service, such
+// as a commercial scanning appliance or c-icap. Data is submitted as the body of an HTTP
+// response in a RESPMOD request, using a new connection for every scan.
+//
+// Detections are recognized through the X-Infection-Found, X-Virus-ID and X-Violations-Found
+// response headers used by common services. A "204 No Content" reply, or a modified response
+// without any of these headers, is reported as clean.
+type ICAPClient struct {
+	URL     *url.URL      // service URL, icap://host[:port]/service
+	Timeout time.Duration // deadline for a complete scan, no limit if zero
+}
+
+// NewICAPClient returns a client for the ICAP service at rawurl
+func NewICAPClient(rawurl string) (*ICAPClient, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("NewICAPClient: %v", err)
+	}
+	if u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("NewICAPClient: %s: not an icap:// URL", rawurl)
+	}
+	return &ICAPClient{URL: u}, nil
+}
+
+// Scan submits the data read from r to the ICAP service
+func (c *ICAPClient) Scan(r io.Reader, name string) (*ScanResult, error) {
+	addr := c.URL.Host
+	if c.URL.Port() == "" {
+		addr = net.JoinHostPort(c.URL.Hostname(), "1344")
+	}
+	conn, err := net.DialTimeout("tcp", addr, c.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("ICAP: %v", err)
+	}
+	defer conn.Close()
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	if err := c.writeRequest(conn, r, name); err != nil {
+		return nil, fmt.Errorf("ICAP: %v", err)
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	status, err := tp.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("ICAP: reading response: %v", err)
+	}
+	hdr, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("ICAP: reading response: %v", err)
+	}
+
+	f := strings.SplitN(status, " ", 3)
+	if len(f) < 2 || !strings.HasPrefix(f[0], "ICAP/") {
+		return nil, fmt.Errorf("ICAP: malformed status line %q", status)
+	}
+	switch f[1] {
+	case "204":
+		return &ScanResult{Name: name}, nil
+	case "200":
+		return &ScanResult{Name: name, Virus: icapThreat(hdr)}, nil
+	}
+	return nil, fmt.Errorf("ICAP: %s", status)
+}
+
+// writeRequest sends a RESPMOD request encapsulating the data read from r
+func (c *ICAPClient) writeRequest(conn net.Conn, r io.Reader, name string) error {
+	reqHdr := fmt.Sprintf("GET /%s HTTP/1.1\r\nHost: %s\r\n\r\n", url.PathEscape(name), c.URL.Hostname())
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", c.URL)
+	fmt.Fprintf(w, "Host: %s\r\n", c.URL.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Connection: close\r\n")
+	fmt.Fprintf(w, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHdr), len(reqHdr)+len(resHdr))
+	w.WriteString(reqHdr)
+	w.WriteString(resHdr)
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	return w.Flush()
+}
+
+// icapThreat extracts the name of the threat from the headers of a modified ICAP response
+func icapThreat(hdr textproto.MIMEHeader) string {
+	// X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;
+	if v := hdr.Get("X-Infection-Found"); v != "" {
+		for _, p := range strings.Split(v, ";") {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "Threat=") {
+				return strings.TrimPrefix(p, "Threat=")
+			}
+		}
+		return "unknown"
+	}
+	if v := hdr.Get("X-Virus-ID"); v != "" {
+		return strings.TrimSpace(v)
+	}
+	// X-Violations-Found: count, then filename, threat, id and disposition per violation,
+	// folded into a single line
+	if v := hdr.Get("X-Violations-Found"); v != "" {
+		f := strings.Fields(v)
+		if n, err := strconv.Atoi(f[0]); err == nil && n == 0 {
+			return ""
+		}
+		if len(f) >= 3 {
+			return f[2]
+		}
+		return "unknown"
+	}
+	return ""
+}
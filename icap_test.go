@@ -0,0 +1,93 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// fakeICAP answers RESPMOD requests, flagging bodies containing the EICAR string
+func fakeICAP(t *testing.T, infected string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			tp := textproto.NewReader(bufio.NewReader(conn))
+			line, _ := tp.ReadLine()
+			hdr, _ := tp.ReadMIMEHeader()
+			if !strings.HasPrefix(line, "RESPMOD icap://") || !strings.Contains(hdr.Get("Encapsulated"), "res-body=") {
+				conn.Write([]byte("ICAP/1.0 400 Bad Request\r\n\r\n"))
+				conn.Close()
+				continue
+			}
+			// skip the encapsulated request and response headers
+			for i := 0; i < 2; i++ {
+				tp.ReadLine()
+				tp.ReadMIMEHeader()
+			}
+			body, _ := ioutil.ReadAll(httputil.NewChunkedReader(tp.R))
+			if bytes.Contains(body, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+				io.WriteString(conn, "ICAP/1.0 200 OK\r\n"+infected+"Encapsulated: null-body=0\r\n\r\n")
+			} else {
+				io.WriteString(conn, "ICAP/1.0 204 No Content\r\n\r\n")
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestICAPClient(t *testing.T) {
+	headers := []string{
+		"X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\n",
+		"X-Virus-ID: Eicar-Test-Signature\r\n",
+		"X-Violations-Found: 1\r\n\teicar.com\r\n\tEicar-Test-Signature\r\n\t0\r\n\t0\r\n",
+	}
+	for _, h := range headers {
+		l := fakeICAP(t, h)
+		c, err := NewICAPClient("icap://" + l.Addr().String() + "/avscan")
+		if err != nil {
+			t.Fatalf("NewICAPClient: %v", err)
+		}
+
+		res, err := c.Scan(bytes.NewReader(eicar), "eicar.com")
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		if res.Virus != "Eicar-Test-Signature" {
+			t.Errorf("Scan: virus %q with %q", res.Virus, h)
+		}
+		res, err = c.Scan(strings.NewReader("hello"), "hello.txt")
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		if res.Virus != "" || res.Name != "hello.txt" {
+			t.Errorf("Scan: clean data reported as %+v", res)
+		}
+		l.Close()
+	}
+}
+
+func TestNewICAPClient(t *testing.T) {
+	for _, u := range []string{"http://localhost/avscan", "icap:///avscan", "::"} {
+		if _, err := NewICAPClient(u); err == nil {
+			t.Errorf("NewICAPClient: %q accepted", u)
+		}
+	}
+}
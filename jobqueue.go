@@ -0,0 +1,360 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors of a JobQueue
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrJobQueueClosed = errors.New("job queue closed")
+)
+
+// JobState is the state of a scan job
+type JobState string
+
+// States of a scan job
+const (
+	JobQueued  JobState = "queued"
+	JobRunning JobState = "running"
+	JobDone    JobState = "done"   // scanned, infected or not
+	JobFailed  JobState = "failed" // could not be scanned after all attempts
+)
+
+// DefaultJobAttempts is the number of attempts of a job when JobQueue.MaxAttempts is zero
+const DefaultJobAttempts = 3
+
+// DefaultCallbackTimeout bounds the callbacks of a JobQueue when CallbackTimeout is zero
+const DefaultCallbackTimeout = 30 * time.Second
+
+// JobRequest is a file to scan asynchronously
+type JobRequest struct {
+	Path    string
+	Options *ScanOptions `json:",omitempty"` // those of the pool if nil
+
+	// Callback, if set, is the URL the finished job is POSTed to, as JSON
+	Callback string `json:",omitempty"`
+}
+
+// Job is a scan job of a JobQueue
+type Job struct {
+	ID       string
+	Request  JobRequest
+	State    JobState
+	Attempts int
+	Result   *ResultRecord `json:",omitempty"` // once done
+	Error    string        `json:",omitempty"` // of the last attempt
+	Created  time.Time
+	Updated  time.Time
+
+	// CallbackError is the error of the callback, if it failed
+	CallbackError string `json:",omitempty"`
+}
+
+// JobQueue runs scan jobs in the background, for asynchronous scanning APIs taking files too
+// large to be scanned within a request. Jobs are persisted as JSON files in a directory, so
+// that they survive restarts: jobs queued or running when the queue was closed, or when the
+// process died, are run again when it is reopened. Jobs failing to scan are retried, and can
+// be looked up by ID until they are removed.
+type JobQueue struct {
+	// MaxAttempts is the number of times a job is attempted, DefaultJobAttempts if zero
+	MaxAttempts int
+
+	// RetryDelay is the time before a failed attempt is retried
+	RetryDelay time.Duration
+
+	// Client posts the callbacks, one with a timeout of CallbackTimeout if nil
+	Client *http.Client
+
+	// CallbackTimeout bounds each callback when Client is nil, DefaultCallbackTimeout if zero,
+	// so that an unresponsive callback URL does not hold up a worker
+	CallbackTimeout time.Duration
+
+	dir  string
+	pool *EnginePool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    map[string]*Job
+	pending []string
+	workers sync.WaitGroup
+	closed  bool
+}
+
+// OpenJobQueue opens the queue of jobs persisted in dir, scanning with pool once started
+func OpenJobQueue(dir string, pool *EnginePool) (*JobQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("OpenJobQueue: %v", err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("OpenJobQueue: %v", err)
+	}
+	q := &JobQueue{dir: dir, pool: pool, jobs: map[string]*Job{}}
+	q.cond = sync.NewCond(&q.mu)
+	var pending []*Job
+	for _, fi := range entries {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("OpenJobQueue: %v", err)
+		}
+		j := &Job{}
+		if err := json.Unmarshal(b, j); err != nil {
+			return nil, fmt.Errorf("OpenJobQueue: %s: %v", fi.Name(), err)
+		}
+		q.jobs[j.ID] = j
+		if j.State == JobQueued || j.State == JobRunning {
+			j.State = JobQueued
+			pending = append(pending, j)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Created.Before(pending[j].Created) })
+	for _, j := range pending {
+		q.pending = append(q.pending, j.ID)
+	}
+	return q, nil
+}
+
+// Start starts workers running the jobs
+func (q *JobQueue) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+}
+
+// Submit persists and queues a new job
+func (q *JobQueue) Submit(req JobRequest) (*Job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("Submit: %v", err)
+	}
+	now := time.Now()
+	j := &Job{ID: hex.EncodeToString(id), Request: req, State: JobQueued, Created: now, Updated: now}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrJobQueueClosed
+	}
+	if err := q.save(j); err != nil {
+		return nil, fmt.Errorf("Submit: %v", err)
+	}
+	q.jobs[j.ID] = j
+	q.pending = append(q.pending, j.ID)
+	q.cond.Signal()
+	c := *j
+	return &c, nil
+}
+
+// Job returns the job of the given ID
+func (q *JobQueue) Job(id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	c := *j
+	return &c, nil
+}
+
+// Remove forgets a finished job
+func (q *JobQueue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.State != JobDone && j.State != JobFailed {
+		return fmt.Errorf("Remove: job %s is %s", id, j.State)
+	}
+	delete(q.jobs, id)
+	if err := os.Remove(q.path(id)); err != nil {
+		return fmt.Errorf("Remove: %v", err)
+	}
+	return nil
+}
+
+// Close waits for the jobs running to finish and stops the workers. Queued jobs stay persisted,
+// to be run when the queue is opened again.
+func (q *JobQueue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.workers.Wait()
+	return nil
+}
+
+// path returns the path of the file of job id
+func (q *JobQueue) path(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// save persists j, replacing its file atomically; q.mu must be held
+func (q *JobQueue) save(j *Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(q.dir, "job")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), q.path(j.ID))
+}
+
+// update applies fn to job id and persists it
+func (q *JobQueue) update(id string, fn func(*Job)) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.jobs[id]
+	fn(j)
+	j.Updated = time.Now()
+	// the job is run again after a restart if its state could not be saved
+	q.save(j)
+	c := *j
+	return &c
+}
+
+// next waits for a job to run, returning false once the queue is closed
+func (q *JobQueue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return "", false
+	}
+	id := q.pending[0]
+	q.pending = q.pending[1:]
+	return id, true
+}
+
+func (q *JobQueue) work() {
+	defer q.workers.Done()
+	for {
+		id, ok := q.next()
+		if !ok {
+			return
+		}
+		q.run(id)
+	}
+}
+
+// run makes an attempt at job id
+func (q *JobQueue) run(id string) {
+	j := q.update(id, func(j *Job) {
+		j.State = JobRunning
+		j.Attempts++
+	})
+	res, err := q.scan(j.Request)
+	max := q.MaxAttempts
+	if max <= 0 {
+		max = DefaultJobAttempts
+	}
+	j = q.update(id, func(j *Job) {
+		switch {
+		case err == nil:
+			j.State, j.Result, j.Error = JobDone, newResultRecord(res, nil), ""
+		case j.Attempts < max:
+			j.State, j.Error = JobQueued, err.Error()
+		default:
+			j.State, j.Error = JobFailed, err.Error()
+		}
+	})
+	if j.State == JobQueued {
+		q.retry(id)
+		return
+	}
+	if j.Request.Callback != "" {
+		if err := q.callback(j); err != nil {
+			q.update(id, func(j *Job) { j.CallbackError = err.Error() })
+		}
+	}
+}
+
+// retry queues job id again after RetryDelay
+func (q *JobQueue) retry(id string) {
+	time.AfterFunc(q.RetryDelay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.pending = append(q.pending, id)
+		q.cond.Signal()
+	})
+}
+
+// scan scans the file of req
+func (q *JobQueue) scan(req JobRequest) (*ScanResult, error) {
+	f, err := os.Open(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if req.Options == nil {
+		return q.pool.Scan(f, req.Path)
+	}
+	var res *ScanResult
+	err = q.pool.With(context.Background(), func(e *Engine) error {
+		res, err = (&EngineScanner{Engine: e, Options: req.Options}).Scan(f, req.Path)
+		return err
+	})
+	return res, err
+}
+
+// callback posts the finished job j to its callback URL
+func (q *JobQueue) callback(j *Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	client := q.Client
+	if client == nil {
+		timeout := q.CallbackTimeout
+		if timeout <= 0 {
+			timeout = DefaultCallbackTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	resp, err := client.Post(j.Request.Callback, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("callback: %s", resp.Status)
+	}
+	return nil
+}
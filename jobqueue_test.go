@@ -0,0 +1,113 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJobQueue(t *testing.T) {
+	p, err := NewEnginePool(LoadEngine(DBDir(), DbStdopt, nil), nil, stdopts)
+	if err != nil {
+		t.Fatalf("NewEnginePool: %v", err)
+	}
+	defer p.Close()
+	files := t.TempDir()
+	infected := filepath.Join(files, "eicar.com")
+	ioutil.WriteFile(infected, eicar, 0644)
+	dir := filepath.Join(t.TempDir(), "jobs")
+
+	called := make(chan *Job, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j := &Job{}
+		json.NewDecoder(r.Body).Decode(j)
+		called <- j
+	}))
+	defer srv.Close()
+
+	// jobs submitted before the workers start are persisted, and run once the queue is reopened
+	q, err := OpenJobQueue(dir, p)
+	if err != nil {
+		t.Fatalf("OpenJobQueue: %v", err)
+	}
+	done, err := q.Submit(JobRequest{Path: infected, Options: stdopts, Callback: srv.URL})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	failed, _ := q.Submit(JobRequest{Path: filepath.Join(files, "missing")})
+	q.Close()
+	if _, err := q.Submit(JobRequest{Path: infected}); err != ErrJobQueueClosed {
+		t.Errorf("Submit: %v after Close", err)
+	}
+
+	q, err = OpenJobQueue(dir, p)
+	if err != nil {
+		t.Fatalf("OpenJobQueue: %v", err)
+	}
+	defer q.Close()
+	q.MaxAttempts = 2
+	q.CallbackTimeout = 100 * time.Millisecond
+	q.Start(2)
+
+	select {
+	case j := <-called:
+		if j.ID != done.ID || j.State != JobDone || j.Result == nil || j.Result.Virus == "" {
+			t.Errorf("callback: %+v", j)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("callback: not called")
+	}
+	wait := func(id string, state JobState) *Job {
+		for i := 0; i < 1000; i++ {
+			j, err := q.Job(id)
+			if err != nil {
+				t.Fatalf("Job: %v", err)
+			}
+			if j.State == state {
+				return j
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Job: %s never %s", id, state)
+		return nil
+	}
+	if j := wait(failed.ID, JobFailed); j.Attempts != 2 || j.Error == "" {
+		t.Errorf("Job: %+v", j)
+	}
+
+	if err := q.Remove(done.ID); err != nil {
+		t.Errorf("Remove: %v", err)
+	}
+	if _, err := q.Job(done.ID); err != ErrJobNotFound {
+		t.Errorf("Job: %v after Remove", err)
+	}
+
+	// a callback URL not answering does not hold up the worker
+	hung := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer slow.Close()
+	defer close(hung)
+	j, err := q.Submit(JobRequest{Path: infected, Options: stdopts, Callback: slow.URL})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if j, _ = q.Job(j.ID); j.CallbackError != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if j.CallbackError == "" {
+		t.Errorf("callback: no error after the timeout: %+v", j)
+	}
+}
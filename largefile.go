@@ -0,0 +1,36 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#include <stddef.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFileTooLarge is the error of scans of objects larger than libclamav can map on this
+// platform, where size_t is 32 bits. The limits of the engine, such as EngineMaxFilesize,
+// apply to the objects that can be mapped.
+var ErrFileTooLarge = errors.New("object too large to scan on this platform")
+
+// maxMapSize is the size of the largest object libclamav can map
+const maxMapSize = uint64(^C.size_t(0))
+
+// checkMapSize returns ErrFileTooLarge if an object of size bytes cannot be mapped
+func checkMapSize(size int64) error {
+	if uint64(size) > maxMapSize {
+		return fmt.Errorf("%w: %d bytes", ErrFileTooLarge, size)
+	}
+	return nil
+}
+
+// ScannedBytes returns the bytes of a count of scanned data returned by the scan functions, in
+// CountPrecision units, without overflowing uint on 32-bit platforms past 4GiB
+func ScannedBytes(scanned uint) uint64 {
+	return uint64(scanned) * CountPrecision
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+// sparseFile creates a sparse file of size bytes holding data at off
+func sparseFile(t *testing.T, size, off int64, data []byte) *os.File {
+	f, err := ioutil.TempFile(t.TempDir(), "large")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if err := f.Truncate(size); err != nil {
+		t.Skipf("Truncate: %v", err)
+	}
+	if _, err := f.WriteAt(data, off); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	return f
+}
+
+func TestLargeFileOffsets(t *testing.T) {
+	const size, off int64 = 5 << 30, 4<<30 + 10
+	f := sparseFile(t, size, off, []byte("past 4GiB"))
+	if n := descSize(int(f.Fd())); n != size {
+		t.Errorf("descSize: %d, want %d", n, size)
+	}
+	buf := make([]byte, 9)
+	r := &descReader{fd: int(f.Fd()), off: off}
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "past 4GiB" {
+		t.Errorf("descReader: read %q at %d: %v", buf[:n], off, err)
+	}
+
+	err := checkMapSize(size)
+	if maxMapSize < uint64(size) && !errors.Is(err, ErrFileTooLarge) || maxMapSize >= uint64(size) && err != nil {
+		t.Errorf("checkMapSize(%d): %v", size, err)
+	}
+}
+
+// TestLargeFileScan scans a file of more than 4GiB, which takes a while and, with some builds
+// of libclamav, as much memory; it only runs if CLAMAV_LARGE_FILE_TEST is set
+func TestLargeFileScan(t *testing.T) {
+	if os.Getenv("CLAMAV_LARGE_FILE_TEST") == "" {
+		t.Skip("CLAMAV_LARGE_FILE_TEST not set")
+	}
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	const size = 5 << 30
+	for _, f := range []EngineField{EngineMaxFilesize, EngineMaxScansize} {
+		if err := eng.SetNum(f, size); err != nil {
+			t.Fatalf("SetNum: %v", err)
+		}
+	}
+	f := sparseFile(t, size, size-int64(len(eicar)), eicar)
+	res, err := (&EngineScanner{Engine: eng, Options: stdopts}).Scan(f, filepath.Base(f.Name()))
+	if maxMapSize < size {
+		if !errors.Is(err, ErrFileTooLarge) {
+			t.Errorf("Scan: %+v %v, want ErrFileTooLarge", res, err)
+		}
+		return
+	}
+	if err != nil || res.Virus == "" {
+		t.Errorf("Scan: %+v %v", res, err)
+	}
+}
+
+func TestScannedBytes(t *testing.T) {
+	// 8GiB in units, which overflows a 32-bit uint once multiplied
+	if n := ScannedBytes(2 << 20); n != 8<<30 {
+		t.Errorf("ScannedBytes: %d", n)
+	}
+}
+
+func TestAtomicAlignment(t *testing.T) {
+	// 64-bit atomic operations fault on 32-bit platforms unless their operand is aligned, which
+	// is only guaranteed for the first word of an allocated struct
+	if off := unsafe.Offsetof(Allowlist{}.hits); off != 0 {
+		t.Errorf("Allowlist.hits at offset %d", off)
+	}
+	if off := unsafe.Offsetof(SkipPolicy{}.scanned); off != 0 {
+		t.Errorf("SkipPolicy.scanned at offset %d", off)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#include <clamav.h>
+#include <stdlib.h>
+
+cl_error_t progress_cgo(size_t total_items, size_t now_completed, void *context);
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"unsafe"
+)
+
+//export progressCallback
+func progressCallback(total, completed C.size_t, key unsafe.Pointer) C.cl_error_t {
+	if ctx, ok := findContext(key).(context.Context); ok && ctx.Err() != nil {
+		// libclamav gives up on any other result
+		return C.CL_BREAK
+	}
+	return C.CL_SUCCESS
+}
+
+// LoadContext is like Load, giving up once ctx is done, so that a service being stopped
+// during startup need not wait for the databases to load. libclamav checks for cancellation
+// between databases, a single large database is loaded to its end. The engine must be freed
+// after a cancelled load.
+func (e *Engine) LoadContext(ctx context.Context, path string, dbopts uint) (uint, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, &DatabaseError{Path: path, Code: Break, Err: err}
+	}
+	key := setContext(ctx)
+	defer deleteContext(key)
+	ne := (*C.struct_cl_engine)(e)
+	C.cl_engine_set_clcb_sigload_progress(ne, (C.clcb_progress)(unsafe.Pointer(C.progress_cgo)), key)
+	defer C.cl_engine_set_clcb_sigload_progress(ne, nil, nil)
+
+	n, err := e.Load(path, dbopts)
+	if cerr := ctx.Err(); err != nil && cerr != nil {
+		code := ErrorCode(Break)
+		if de, ok := err.(*DatabaseError); ok {
+			code = de.Code
+		}
+		return 0, &DatabaseError{Path: path, Code: code, Err: cerr}
+	}
+	return n, err
+}
+
+// CompileContext is like Compile, giving up once ctx is done. libclamav checks for
+// cancellation between the steps of the compilation. The engine must be freed after a
+// cancelled compilation.
+func (e *Engine) CompileContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Compile: %w", err)
+	}
+	key := setContext(ctx)
+	defer deleteContext(key)
+	ne := (*C.struct_cl_engine)(e)
+	C.cl_engine_set_clcb_engine_compile_progress(ne, (C.clcb_progress)(unsafe.Pointer(C.progress_cgo)), key)
+	defer C.cl_engine_set_clcb_engine_compile_progress(ne, nil, nil)
+
+	err := e.Compile()
+	if cerr := ctx.Err(); err != nil && cerr != nil {
+		return fmt.Errorf("Compile: %w", cerr)
+	}
+	return err
+}
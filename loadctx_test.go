@@ -0,0 +1,40 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLoadContext(t *testing.T) {
+	if err := Init(InitDefault); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	eng := New()
+	defer eng.Free()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var de *DatabaseError
+	if _, err := eng.LoadContext(ctx, DBDir(), DbStdopt); !errors.Is(err, context.Canceled) || !errors.As(err, &de) {
+		t.Errorf("LoadContext: cancelled: %v", err)
+	}
+	if err := eng.CompileContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("CompileContext: cancelled: %v", err)
+	}
+
+	if _, err := eng.LoadContext(context.Background(), DBDir(), DbStdopt); err != nil {
+		t.Fatalf("LoadContext: %v", err)
+	}
+	if err := eng.CompileContext(context.Background()); err != nil {
+		t.Fatalf("CompileContext: %v", err)
+	}
+	if _, err := (&EngineScanner{Engine: eng, Options: stdopts}).Scan(bytes.NewReader(eicar), "eicar"); err != nil {
+		t.Errorf("Scan: %v", err)
+	}
+}
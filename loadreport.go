@@ -0,0 +1,152 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DatabaseLoad is the loading of one database file by LoadDir
+type DatabaseLoad struct {
+	Path       string
+	Type       string // extension of the file, such as "cvd" or "ldb"
+	Signatures uint
+	Duration   time.Duration
+}
+
+// LoadWarning is a warning or error libclamav logged loading a database, such as about a
+// duplicate signature or one requiring a newer functionality level
+type LoadWarning struct {
+	Path     string // of the database
+	Severity Msg
+	Message  string
+}
+
+// LoadReport describes the loading of a database directory, so that slow startups can be
+// attributed to the files at fault, such as a huge custom logical signature database
+type LoadReport struct {
+	Databases  []DatabaseLoad // in the order they were loaded
+	Signatures uint
+	Duration   time.Duration
+
+	// Warnings are those libclamav logged while loading the databases, in order. They are
+	// still logged to stderr, or passed to the callback of SetMsgCallback.
+	Warnings []LoadWarning
+}
+
+// Slowest returns the n databases that took the longest to load, slowest first
+func (r *LoadReport) Slowest(n int) []DatabaseLoad {
+	dbs := append([]DatabaseLoad(nil), r.Databases...)
+	sort.SliceStable(dbs, func(i, j int) bool { return dbs[i].Duration > dbs[j].Duration })
+	if n < len(dbs) {
+		dbs = dbs[:n]
+	}
+	return dbs
+}
+
+// LoadDir loads the databases of dir one file at a time, as Load would load the directory at
+// once, reporting the signatures and the time each contributed. Errors are of type
+// *DatabaseError, naming the file that failed to load; the report lists the files loaded
+// before it, and the warnings up to the failure.
+//
+// libclamav does not tell which load a message is logged by, so the loads of LoadDir run one
+// at a time across engines, and messages logged meanwhile by scans without a context are
+// reported as well.
+func (e *Engine) LoadDir(dir string, dbopts uint) (*LoadReport, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, &DatabaseError{Path: dir, Code: Eopen, Err: err}
+	}
+	var paths []string
+	for _, fi := range entries {
+		if !fi.IsDir() && databaseExts[filepath.Ext(fi.Name())] {
+			paths = append(paths, filepath.Join(dir, fi.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, &DatabaseError{Path: dir, Code: Eopen, Err: ErrNoDatabases}
+	}
+	sort.SliceStable(paths, func(i, j int) bool { return loadOrder(paths[i]) < loadOrder(paths[j]) })
+
+	r := &LoadReport{}
+	start := time.Now()
+	defer func() { r.Duration = time.Since(start) }()
+	for _, p := range paths {
+		t := time.Now()
+		var sigs uint
+		warnings, err := captureLoad(p, func() (err error) {
+			sigs, err = e.Load(p, dbopts)
+			return err
+		})
+		r.Warnings = append(r.Warnings, warnings...)
+		if err != nil {
+			return r, err
+		}
+		r.Databases = append(r.Databases, DatabaseLoad{
+			Path:       p,
+			Type:       strings.TrimPrefix(filepath.Ext(p), "."),
+			Signatures: sigs,
+			Duration:   time.Since(t),
+		})
+		r.Signatures += sigs
+	}
+	return r, nil
+}
+
+// loadCapture collects the messages libclamav logs during a load, one load at a time
+var loadCapture struct {
+	serial sync.Mutex // held for the duration of the load
+	sync.Mutex
+	active   bool
+	path     string
+	warnings []LoadWarning
+}
+
+// captureLoad calls load, returning the warnings libclamav logs meanwhile for the database at path
+func captureLoad(path string, load func() error) ([]LoadWarning, error) {
+	hookMsg()
+	loadCapture.serial.Lock()
+	defer loadCapture.serial.Unlock()
+	loadCapture.Lock()
+	loadCapture.active, loadCapture.path, loadCapture.warnings = true, path, nil
+	loadCapture.Unlock()
+
+	err := load()
+
+	loadCapture.Lock()
+	defer loadCapture.Unlock()
+	warnings := loadCapture.warnings
+	loadCapture.active, loadCapture.warnings = false, nil
+	return warnings, err
+}
+
+// loadMessage records a message libclamav logged outside of a scan, if a load is captured
+func loadMessage(m Msg, msg string) {
+	loadCapture.Lock()
+	defer loadCapture.Unlock()
+	if !loadCapture.active || m < MsgWarn {
+		return
+	}
+	loadCapture.warnings = append(loadCapture.warnings, LoadWarning{loadCapture.path, m, strings.TrimSpace(msg)})
+}
+
+// loadOrder returns the rank of the database at path in the order libclamav loads a directory
+// in: the ignore lists first, so that they apply to the signatures loaded next, then the daily
+// database, whose configuration applies to the others, then the others
+func loadOrder(path string) int {
+	name := filepath.Base(path)
+	switch ext := filepath.Ext(name); {
+	case ext == ".ign" || ext == ".ign2":
+		return 0
+	case strings.TrimSuffix(name, ext) == "daily" && (ext == ".cvd" || ext == ".cld" || ext == ".cud"):
+		return 1
+	}
+	return 2
+}
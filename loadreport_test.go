@@ -0,0 +1,108 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	writeCVD(t, dir, "main.cvd", 62)
+	writeCVD(t, dir, "daily.cld", 27431)
+	ioutil.WriteFile(filepath.Join(dir, "local.ign2"), []byte("Eicar-Test-Signature\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "custom.ldb"), []byte("Test;Engine:51-255,Target:0;0;414243\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a database"), 0644)
+
+	eng := New()
+	defer eng.Free()
+	r, err := eng.LoadDir(dir, DbStdopt)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	var got []string
+	for _, db := range r.Databases {
+		got = append(got, filepath.Base(db.Path)+":"+db.Type)
+	}
+	want := []string{"local.ign2:ign2", "daily.cld:cld", "custom.ldb:ldb", "main.cvd:cvd"}
+	if len(got) != len(want) {
+		t.Fatalf("LoadDir: loaded %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("LoadDir: loaded %v, want %v", got, want)
+			break
+		}
+	}
+	if len(r.Slowest(2)) != 2 || r.Slowest(1)[0].Duration < r.Slowest(2)[1].Duration {
+		t.Errorf("LoadDir: %+v", r)
+	}
+	if len(r.Warnings) != 0 {
+		t.Errorf("LoadDir: warnings %+v", r.Warnings)
+	}
+
+	if _, err := eng.LoadDir(t.TempDir(), DbStdopt); !errors.Is(err, ErrNoDatabases) {
+		t.Errorf("LoadDir: empty directory: %v", err)
+	}
+}
+
+func TestLoadWarnings(t *testing.T) {
+	var logged []string
+	SetMsgCallback(func(m Msg, full, msg string, context interface{}) {
+		logged = append(logged, full)
+	})
+	defer func() { setCallbackFunc("msg", nil) }()
+
+	// the messages go through the callback installed in libclamav
+	logMessage(MsgWarn, "LibClamAV Warning: not during a load\n", "not during a load\n")
+	fail := errors.New("fail")
+	warnings, err := captureLoad("/db/custom.ldb", func() error {
+		logMessage(MsgInfoVerbose, "verbose\n", "verbose\n")
+		logMessage(MsgWarn, "LibClamAV Warning: cli_loadldb: Signature for Test uses an old flevel\n", "cli_loadldb: Signature for Test uses an old flevel\n")
+		logMessage(NsgError, "LibClamAV Error: Problem parsing database at line 2\n", "Problem parsing database at line 2\n")
+		return fail
+	})
+	if err != fail {
+		t.Errorf("captureLoad: %v", err)
+	}
+	want := []LoadWarning{
+		{"/db/custom.ldb", MsgWarn, "cli_loadldb: Signature for Test uses an old flevel"},
+		{"/db/custom.ldb", NsgError, "Problem parsing database at line 2"},
+	}
+	if len(warnings) != len(want) || warnings[0] != want[0] || warnings[1] != want[1] {
+		t.Errorf("captureLoad: %+v, want %+v", warnings, want)
+	}
+	logMessage(MsgWarn, "LibClamAV Warning: after the load\n", "after the load\n")
+	if warnings, _ := captureLoad("/db/daily.cld", func() error { return nil }); len(warnings) != 0 {
+		t.Errorf("captureLoad: %+v", warnings)
+	}
+	if len(logged) != 5 || logged[2] != "LibClamAV Warning: cli_loadldb: Signature for Test uses an old flevel\n" {
+		t.Errorf("SetMsgCallback: logged %q", logged)
+	}
+}
+
+func TestLoadMessagesLogged(t *testing.T) {
+	f, err := ioutil.TempFile(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	defer f.Close()
+	stderr := os.Stderr
+	os.Stderr = f
+	defer func() { os.Stderr = stderr }()
+
+	// without a callback, messages of every severity are printed as libclamav does
+	logMessage(MsgInfoVerbose, "LibClamAV info: verbose\n", "verbose\n")
+	logMessage(MsgWarn, "LibClamAV Warning: warning\n", "warning\n")
+	os.Stderr = stderr
+	buf, err := ioutil.ReadFile(f.Name())
+	if want := "LibClamAV info: verbose\nLibClamAV Warning: warning\n"; string(buf) != want || err != nil {
+		t.Errorf("logged %q %v, want %q", buf, err, want)
+	}
+}
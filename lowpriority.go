@@ -0,0 +1,138 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrLowPriorityClosed is returned by LowPriority.Do once the LowPriority is closed
+var ErrLowPriorityClosed = errors.New("low priority worker closed")
+
+// LowPriority runs work, such as background scans of whole file systems, on a thread of its own
+// at a lowered CPU and I/O priority, one call at a time, and paces the calls so that the
+// primary workload of a shared host is not degraded. Priorities are per thread on Linux only;
+// elsewhere calls are paced but run at normal priority. Cgroup weights, which apply to the
+// whole process, are left to the service manager (CPUWeight= and IOWeight= with systemd).
+type LowPriority struct {
+	// Nice is the CPU niceness of the worker thread, 19 (the lowest priority) if zero
+	Nice int
+
+	// IOIdle puts the worker thread in the idle I/O scheduling class, where it is only served
+	// when no other process uses the disk. Otherwise its I/O priority follows Nice.
+	IOIdle bool
+
+	// DutyCycle is the fraction of time spent working: after a call that took d, the next one
+	// starts no sooner than d*(1/DutyCycle-1) later. Calls are not paced if zero or one.
+	DutyCycle float64
+
+	mu     sync.Mutex
+	work   chan func() // never closed, calls racing with Close give up on done
+	done   chan struct{}
+	err    error // lowering the priority failed
+	closed bool
+}
+
+// Do calls fn on the low priority thread, once the previous calls are done and paced
+func (p *LowPriority) Do(fn func()) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrLowPriorityClosed
+	}
+	if p.work == nil {
+		p.start()
+	}
+	work, done, err := p.work, p.done, p.err
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	finished := make(chan struct{})
+	select {
+	case work <- func() {
+		defer close(finished)
+		fn()
+	}:
+	case <-done:
+		return ErrLowPriorityClosed
+	}
+	<-finished
+	return nil
+}
+
+// start starts the worker, called with p.mu held
+func (p *LowPriority) start() {
+	p.work, p.done = make(chan func()), make(chan struct{})
+	work, done := p.work, p.done
+	started := make(chan error)
+	go func() {
+		// the thread is never unlocked, so that it exits with the worker rather than run
+		// other goroutines at a lowered priority
+		runtime.LockOSThread()
+		nice := p.Nice
+		if nice == 0 {
+			nice = 19
+		}
+		if err := lowerThreadPriority(nice, p.IOIdle); err != nil {
+			started <- err
+			return
+		}
+		close(started)
+		for {
+			var fn func()
+			select {
+			case fn = <-work:
+			case <-done:
+				return
+			}
+			t := time.Now()
+			fn()
+			if p.DutyCycle > 0 && p.DutyCycle < 1 {
+				d := time.Since(t)
+				time.Sleep(time.Duration(float64(d) * (1/p.DutyCycle - 1)))
+			}
+		}
+	}()
+	if err := <-started; err != nil {
+		p.err = err
+	}
+}
+
+// Close stops the worker once the calls in progress are done
+func (p *LowPriority) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed && p.done != nil {
+		close(p.done)
+	}
+	p.closed = true
+	return nil
+}
+
+// Scanner returns a Scanner scanning with s on the low priority thread. Only scans done in
+// process, such as those of an EngineScanner, run at the lowered priority; the scans of a
+// remote scanner are paced only.
+func (p *LowPriority) Scanner(s Scanner) Scanner {
+	return &lowPriorityScanner{p: p, s: s}
+}
+
+type lowPriorityScanner struct {
+	p *LowPriority
+	s Scanner
+}
+
+func (l *lowPriorityScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	var res *ScanResult
+	var err error
+	if derr := l.p.Do(func() { res, err = l.s.Scan(r, name) }); derr != nil {
+		return nil, derr
+	}
+	return res, err
+}
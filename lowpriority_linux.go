@@ -0,0 +1,36 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// from linux/ioprio.h
+const (
+	ioprioWhoProcess = 1
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lowerThreadPriority sets the niceness and the I/O priority of the calling thread, which must
+// be locked to its goroutine
+func lowerThreadPriority(nice int, ioIdle bool) error {
+	tid := syscall.Gettid()
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil {
+		return fmt.Errorf("setpriority: %v", err)
+	}
+	// the best effort levels 0 to 7 map to niceness -20 to 19
+	prio := ioprioClassBE<<ioprioClassShift | (nice+20)/5
+	if ioIdle {
+		prio = ioprioClassIdle << ioprioClassShift
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+		return fmt.Errorf("ioprio_set: %v", errno)
+	}
+	return nil
+}
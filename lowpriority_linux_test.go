@@ -0,0 +1,25 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestLowPriorityThread(t *testing.T) {
+	p := &LowPriority{Nice: 10}
+	defer p.Close()
+	var prio int
+	var err error
+	p.Do(func() { prio, err = syscall.Getpriority(syscall.PRIO_PROCESS, syscall.Gettid()) })
+	// the system call returns 20 - niceness
+	if err != nil || 20-prio != 10 {
+		t.Errorf("Getpriority: niceness %d, %v", 20-prio, err)
+	}
+	if prio, err = syscall.Getpriority(syscall.PRIO_PROCESS, syscall.Gettid()); err != nil || 20-prio == 10 {
+		t.Errorf("Getpriority: niceness %d outside the worker, %v", 20-prio, err)
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package clamav
+
+// lowerThreadPriority does nothing: priorities are not per thread on this system, and lowering
+// those of the process would slow down all of it
+func lowerThreadPriority(nice int, ioIdle bool) error {
+	return nil
+}
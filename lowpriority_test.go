@@ -0,0 +1,60 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestLowPriority(t *testing.T) {
+	p := &LowPriority{IOIdle: true, DutyCycle: 0.5}
+	defer p.Close()
+
+	res, err := p.Scanner(eicarScanner{}).Scan(bytes.NewReader(eicar), "eicar.com")
+	if err != nil || res.Virus != "Eicar-Test-Signature" {
+		t.Fatalf("Scan: %+v, %v", res, err)
+	}
+
+	// the second call waits as long as the first took
+	var starts []time.Time
+	for i := 0; i < 2; i++ {
+		if err := p.Do(func() {
+			starts = append(starts, time.Now())
+			time.Sleep(50 * time.Millisecond)
+		}); err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+	}
+	if d := starts[1].Sub(starts[0]); d < 100*time.Millisecond {
+		t.Errorf("Do: calls %v apart, not paced", d)
+	}
+
+	p.Close()
+	if err := p.Do(func() {}); err != ErrLowPriorityClosed {
+		t.Errorf("Do: got %v after Close", err)
+	}
+}
+
+func TestLowPriorityCloseRace(t *testing.T) {
+	p := &LowPriority{}
+	busy, release := make(chan struct{}), make(chan struct{})
+	go p.Do(func() {
+		close(busy)
+		<-release
+	})
+	<-busy
+
+	// a call waiting for the worker while the LowPriority is closed
+	errs := make(chan error)
+	go func() { errs <- p.Do(func() {}) }()
+	time.Sleep(10 * time.Millisecond)
+	p.Close()
+	close(release)
+	if err := <-errs; err != ErrLowPriorityClosed {
+		t.Errorf("Do: got %v racing with Close", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "sync"
+
+// EngineMemory is the estimate of the memory an engine takes
+type EngineMemory struct {
+	Bytes    int64 // in total
+	Loaded   int64 // by the databases loaded
+	Compiled int64 // by the compilation of the engine
+}
+
+// engineMemory records the memory estimates of the engines, along with their references, so
+// that they are forgotten once the engines are freed
+var engineMemory = struct {
+	sync.Mutex
+	m map[*Engine]*engineMemoryState
+}{m: map[*Engine]*engineMemoryState{}}
+
+type engineMemoryState struct {
+	EngineMemory
+	measured bool
+	refs     int // counted from the first Addref, before any measurement
+}
+
+// measureMemory calls fn, adding the growth of the resident memory of the process meanwhile
+// to the estimate of e as selected by field
+func (e *Engine) measureMemory(field func(*EngineMemory) *int64, fn func() error) error {
+	before, ok := residentMemory()
+	err := fn()
+	after, ok2 := residentMemory()
+	if !ok || !ok2 {
+		return err
+	}
+	d := after - before
+	if d < 0 {
+		// the runtime gave memory back to the system meanwhile
+		d = 0
+	}
+	engineMemory.Lock()
+	defer engineMemory.Unlock()
+	st := engineMemory.m[e]
+	if st == nil {
+		st = &engineMemoryState{refs: 1}
+		engineMemory.m[e] = st
+	}
+	st.measured = true
+	*field(&st.EngineMemory) += d
+	st.Bytes += d
+	return err
+}
+
+// MemoryEstimate returns the approximate memory e takes, from the growth of the resident
+// memory of the process during its Load and Compile calls. Libclamav keeps no count of the
+// memory of an engine, so the estimate includes whatever else the process allocated
+// meanwhile: it is accurate when engines are loaded one at a time, at startup or by an
+// EnginePool. The result is false where the resident memory cannot be sampled, or if no
+// databases were loaded.
+func (e *Engine) MemoryEstimate() (EngineMemory, bool) {
+	engineMemory.Lock()
+	defer engineMemory.Unlock()
+	if st := engineMemory.m[e]; st != nil && st.measured {
+		return st.EngineMemory, true
+	}
+	return EngineMemory{}, false
+}
+
+// refMemory counts a reference to e taken with Addref, or released with Free if delta is
+// negative, forgetting the estimate of e along with its last reference. An engine without a
+// state has a single reference.
+func (e *Engine) refMemory(delta int) {
+	engineMemory.Lock()
+	defer engineMemory.Unlock()
+	st := engineMemory.m[e]
+	if st == nil {
+		if delta <= 0 {
+			return
+		}
+		st = &engineMemoryState{refs: 1}
+		engineMemory.m[e] = st
+	}
+	if st.refs += delta; st.refs <= 0 || st.refs == 1 && !st.measured {
+		delete(engineMemory.m, e)
+	}
+}
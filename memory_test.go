@@ -0,0 +1,66 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"testing"
+)
+
+func TestMemoryEstimate(t *testing.T) {
+	if _, ok := residentMemory(); !ok {
+		t.Skip("resident memory not available")
+	}
+	dir := t.TempDir()
+	writeCVD(t, dir, "main.cvd", 62)
+
+	eng := New()
+	if _, ok := eng.MemoryEstimate(); ok {
+		t.Errorf("MemoryEstimate: estimate of an engine not loaded")
+	}
+	if _, err := eng.Load(dir, DbStdopt); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := eng.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	m, ok := eng.MemoryEstimate()
+	if !ok || m.Bytes != m.Loaded+m.Compiled || m.Loaded < 0 || m.Compiled < 0 {
+		t.Errorf("MemoryEstimate: %+v %v", m, ok)
+	}
+
+	// the estimate is kept until the last reference is freed
+	eng.Addref()
+	eng.Free()
+	if _, ok := eng.MemoryEstimate(); !ok {
+		t.Errorf("MemoryEstimate: estimate forgotten with a reference left")
+	}
+	eng.Free()
+	if _, ok := eng.MemoryEstimate(); ok {
+		t.Errorf("MemoryEstimate: estimate of a freed engine")
+	}
+}
+
+func TestMemoryEstimateAddrefFirst(t *testing.T) {
+	if _, ok := residentMemory(); !ok {
+		t.Skip("resident memory not available")
+	}
+	dir := t.TempDir()
+	writeCVD(t, dir, "main.cvd", 62)
+
+	// a reference taken before the databases are loaded keeps the estimate too
+	eng := New()
+	eng.Addref()
+	if _, err := eng.Load(dir, DbStdopt); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	eng.Free()
+	if _, ok := eng.MemoryEstimate(); !ok {
+		t.Errorf("MemoryEstimate: estimate forgotten with a reference left")
+	}
+	eng.Free()
+	if _, ok := eng.MemoryEstimate(); ok {
+		t.Errorf("MemoryEstimate: estimate of a freed engine")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata is the information ClamAV collects about an object and the objects it contains when
+// scanning with ScanGeneralCollectMetadata, the properties clamscan writes with --gen-json
+type Metadata struct {
+	// JSON is the complete metadata, for the properties not parsed below
+	JSON json.RawMessage
+
+	RootFileType string // e.g. "CL_TYPE_MSOLE2"
+
+	// VBAMacros and XLMMacros report macros in the object or any document it contains,
+	// whether or not a signature or ScanHeuristicMacros alerted on them
+	VBAMacros bool
+	XLMMacros bool
+
+	// PDFs describes the PDF documents found, the object itself first if it is one
+	PDFs []PDFMetadata
+
+	// PE describes the object if it is a PE file
+	PE *PEMetadata
+
+	// URLs lists, without duplicates, the links found in HTML, mail and PDF content, when
+	// scanning with ScanGeneralStoreHTMLURIs or ScanGeneralStorePDFURIs
+	URLs []string
+
+	// Archive is the tree of objects unpacked from the object
+	Archive *ArchiveReport
+
+	// ImageFuzzyHashes are the fuzzy hashes of the images found, when scanning with
+	// ScanParseImageFuzzyHash, to be matched with ImageSignatures
+	ImageFuzzyHashes []string
+}
+
+// PEMetadata is the header information of a PE file, as reported by ClamAV in "PE"
+type PEMetadata struct {
+	Machine       string // e.g. "Intel 80386"
+	Subsystem     string // e.g. "WINDOWS_GUI"
+	Sections      int
+	EntryPoint    uint64
+	TimeDateStamp time.Time
+
+	// Authenticode is the state of the signature of the file, checked by EngineScanner
+	Authenticode AuthenticodeStatus
+
+	// Fields has all the properties ClamAV reports, including those above
+	Fields map[string]interface{}
+}
+
+// PDFMetadata is the structure of a PDF document, as reported by ClamAV in "PDFStats"
+type PDFMetadata struct {
+	Version   string
+	Encrypted bool
+
+	// the document information dictionary
+	Author, Creator, Producer, Title, Subject string
+
+	Pages         int
+	JavaScript    int // objects with JavaScript
+	OpenActions   int // actions run when the document is opened
+	Launches      int // actions launching programs
+	EmbeddedFiles int
+
+	// Counts has all the counters ClamAV reports, such as "ObjectCount", "XFACount" or
+	// "InvalidObjectCount", including those above
+	Counts map[string]int
+}
+
+// parseMetadata parses the JSON metadata passed to the file properties callback
+func parseMetadata(s string) (*Metadata, error) {
+	var props map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &props); err != nil {
+		return nil, err
+	}
+	m := &Metadata{JSON: json.RawMessage(s)}
+	m.RootFileType, _ = props["RootFileType"].(string)
+	if fields, ok := props["PE"].(map[string]interface{}); ok {
+		m.PE = parsePE(fields)
+	}
+	m.Archive = parseArchiveReport(props, 0)
+	m.walk(props)
+	return m, nil
+}
+
+// walk collects the properties of obj and of the objects nested in it
+func (m *Metadata) walk(obj interface{}) {
+	switch obj := obj.(type) {
+	case map[string]interface{}:
+		for k, v := range obj {
+			switch k {
+			case "HasMacros", "ContainsMacros":
+				m.VBAMacros = m.VBAMacros || isTrue(v)
+			case "HasXLM", "HasXLMMacros":
+				m.XLMMacros = m.XLMMacros || isTrue(v)
+			}
+		}
+		if stats, ok := obj["PDFStats"].(map[string]interface{}); ok {
+			m.PDFs = append(m.PDFs, parsePDFStats(stats))
+		}
+		switch h := obj["ImageFuzzyHash"].(type) {
+		case string:
+			m.ImageFuzzyHashes = append(m.ImageFuzzyHashes, h)
+		case []interface{}:
+			for _, v := range h {
+				if s, ok := v.(string); ok {
+					m.ImageFuzzyHashes = append(m.ImageFuzzyHashes, s)
+				}
+			}
+		}
+		for _, k := range []string{"URIs", "URLs"} {
+			if urls, ok := obj[k].([]interface{}); ok {
+				m.addURLs(urls)
+			}
+		}
+
+		// then the nested objects, in a stable order
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			m.walk(obj[k])
+		}
+	case []interface{}:
+		for _, v := range obj {
+			m.walk(v)
+		}
+	}
+}
+
+// addURLs records the string values of urls not seen yet
+func (m *Metadata) addURLs(urls []interface{}) {
+	for _, u := range urls {
+		s, ok := u.(string)
+		if !ok || s == "" {
+			continue
+		}
+		seen := false
+		for _, v := range m.URLs {
+			if v == s {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			m.URLs = append(m.URLs, s)
+		}
+	}
+}
+
+// parsePDFStats parses the "PDFStats" properties of a PDF document
+func parsePDFStats(stats map[string]interface{}) PDFMetadata {
+	p := PDFMetadata{Counts: map[string]int{}}
+	for k, v := range stats {
+		switch v := v.(type) {
+		case float64:
+			if strings.HasSuffix(k, "Count") {
+				p.Counts[k] = int(v)
+			}
+		case string:
+			switch k {
+			case "PDFVersion":
+				p.Version = v
+			case "Author":
+				p.Author = v
+			case "Creator":
+				p.Creator = v
+			case "Producer":
+				p.Producer = v
+			case "Title":
+				p.Title = v
+			case "Subject":
+				p.Subject = v
+			}
+		}
+	}
+	p.Encrypted = isTrue(stats["Encrypted"])
+	p.Pages = p.Counts["PageCount"]
+	p.JavaScript = p.Counts["JavaScriptObjectCount"]
+	p.OpenActions = p.Counts["OpenActionCount"]
+	p.Launches = p.Counts["LaunchCount"]
+	p.EmbeddedFiles = p.Counts["EmbeddedFileCount"]
+	return p
+}
+
+// parsePE parses the "PE" properties of a PE file
+func parsePE(fields map[string]interface{}) *PEMetadata {
+	p := &PEMetadata{Fields: fields}
+	p.Machine, _ = fields["Machine"].(string)
+	p.Subsystem, _ = fields["Subsystem"].(string)
+	p.Sections = int(jsonNumber(fields["NumberOfSections"]))
+	if p.Sections == 0 {
+		if s, ok := fields["Sections"].([]interface{}); ok {
+			p.Sections = len(s)
+		}
+	}
+	p.EntryPoint = jsonNumber(fields["EntryPoint"])
+	if ts := jsonNumber(fields["TimeDateStamp"]); ts != 0 {
+		p.TimeDateStamp = time.Unix(int64(ts), 0).UTC()
+	}
+	return p
+}
+
+// jsonNumber returns the value of v, a number or a string such as "0x1000", zero if neither
+func jsonNumber(v interface{}) uint64 {
+	switch v := v.(type) {
+	case float64:
+		return uint64(v)
+	case string:
+		n, _ := strconv.ParseUint(v, 0, 64)
+		return n
+	}
+	return 0
+}
+
+// isTrue reports whether a JSON value is true, ClamAV writing some flags as numbers
+func isTrue(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	}
+	return false
+}
+
+// macroAlert is the name prefix of ScanHeuristicMacros alerts, e.g.
+// "Heuristics.OLE2.ContainsMacros.VBA" or "Heuristics.OLE2.ContainsMacros.XLM"
+const macroAlert = "Heuristics.OLE2.ContainsMacros"
+
+// HasMacros reports whether the object contains VBA or XLM macros, according to its metadata or
+// to a ScanHeuristicMacros alert
+func (r *ScanResult) HasMacros() bool {
+	if strings.HasPrefix(r.Virus, macroAlert) {
+		return true
+	}
+	return r.Metadata != nil && (r.Metadata.VBAMacros || r.Metadata.XLMMacros)
+}
@@ -0,0 +1,77 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"testing"
+)
+
+const pdfMetadata = `{
+  "Magic": "CLAMJSONv0",
+  "RootFileType": "CL_TYPE_PDF",
+  "FileType": "CL_TYPE_PDF",
+  "PDFStats": {
+    "PDFVersion": "1.7",
+    "Author": "mallory",
+    "Encrypted": true,
+    "JavaScriptObjectCount": 2,
+    "OpenActionCount": 1,
+    "EmbeddedFileCount": 1,
+    "PageCount": 3,
+    "ObjectCount": 42
+  },
+  "ContainedObjects": [{
+    "FileType": "CL_TYPE_PDF",
+    "PDFStats": {"PDFVersion": "1.4", "PageCount": 1}
+  }]
+}`
+
+func TestParseMetadataPDF(t *testing.T) {
+	m, err := parseMetadata(pdfMetadata)
+	if err != nil {
+		t.Fatalf("parseMetadata: %v", err)
+	}
+	if len(m.PDFs) != 2 {
+		t.Fatalf("parseMetadata: %d PDFs, want 2", len(m.PDFs))
+	}
+	p := m.PDFs[0]
+	if p.Version != "1.7" || p.Author != "mallory" || !p.Encrypted || p.JavaScript != 2 || p.OpenActions != 1 ||
+		p.EmbeddedFiles != 1 || p.Pages != 3 || p.Counts["ObjectCount"] != 42 {
+		t.Errorf("parseMetadata: outer PDF: %+v", p)
+	}
+	if p := m.PDFs[1]; p.Version != "1.4" || p.Encrypted || p.Pages != 1 {
+		t.Errorf("parseMetadata: embedded PDF: %+v", p)
+	}
+}
+
+func TestParseMetadataInvalid(t *testing.T) {
+	if _, err := parseMetadata("{"); err == nil {
+		t.Errorf("parseMetadata: invalid JSON accepted")
+	}
+}
+
+func TestParseMetadataURLs(t *testing.T) {
+	m, err := parseMetadata(`{
+  "Magic": "CLAMJSONv0",
+  "RootFileType": "CL_TYPE_MAIL",
+  "ContainedObjects": [
+    {"FileType": "CL_TYPE_HTML", "URIs": ["https://example.com/login", "http://evil.test/x"]},
+    {"FileType": "CL_TYPE_PDF", "URIs": ["http://evil.test/x", "https://example.org/"]}
+  ]
+}`)
+	if err != nil {
+		t.Fatalf("parseMetadata: %v", err)
+	}
+	want := []string{"https://example.com/login", "http://evil.test/x", "https://example.org/"}
+	if len(m.URLs) != len(want) {
+		t.Fatalf("parseMetadata: URLs %q, want %q", m.URLs, want)
+	}
+	for i := range want {
+		if m.URLs[i] != want[i] {
+			t.Errorf("parseMetadata: URLs %q, want %q", m.URLs, want)
+			break
+		}
+	}
+}
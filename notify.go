@@ -0,0 +1,247 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of Notification
+const (
+	NotifyDetection    = "detection"
+	NotifyReloadFailed = "reload failed"
+)
+
+// Notification is an event operators are told about by Notifiers
+type Notification struct {
+	Kind  string
+	Time  time.Time
+	Host  string
+	Name  string // of the object the virus was found in
+	Virus string
+	Err   error // of the failed reload
+}
+
+// Summary returns a line describing the notification
+func (n *Notification) Summary() string {
+	switch n.Kind {
+	case NotifyDetection:
+		return fmt.Sprintf("%s found in %s on %s", n.Virus, n.Name, n.Host)
+	case NotifyReloadFailed:
+		return fmt.Sprintf("reload failed on %s: %v", n.Host, n.Err)
+	}
+	return fmt.Sprintf("%s on %s", n.Kind, n.Host)
+}
+
+// MarshalJSON encodes the notification with its error as a string
+func (n *Notification) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Kind  string
+		Time  time.Time
+		Host  string
+		Name  string `json:",omitempty"`
+		Virus string `json:",omitempty"`
+		Error string `json:",omitempty"`
+	}{Kind: n.Kind, Time: n.Time, Host: n.Host, Name: n.Name, Virus: n.Virus}
+	if n.Err != nil {
+		v.Error = n.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// newNotification returns a notification of kind, now, on this host
+func newNotification(kind string) *Notification {
+	host, _ := os.Hostname()
+	return &Notification{Kind: kind, Time: time.Now(), Host: host}
+}
+
+// Notifier delivers notifications, see EmailNotifier and WebhookNotifier
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// EmailNotifier mails notifications through an SMTP server
+type EmailNotifier struct {
+	Addr string    // of the SMTP server, as host:port
+	Auth smtp.Auth // nil for none
+	From string
+	To   []string
+}
+
+// Notify mails n
+func (e *EmailNotifier) Notify(n *Notification) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	// the summary holds names chosen by senders of samples, which must not add headers
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[clamav] "+n.Summary()))
+	fmt.Fprintf(&b, "Date: %s\r\n\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "%s\r\n\r\nTime: %s\r\n", n.Summary(), n.Time.Format(time.RFC3339))
+	if err := smtp.SendMail(e.Addr, e.Auth, e.From, e.To, []byte(b.String())); err != nil {
+		return fmt.Errorf("EmailNotifier: %v", err)
+	}
+	return nil
+}
+
+// WebhookFormat is the payload a WebhookNotifier posts
+type WebhookFormat int
+
+// Formats of WebhookNotifier
+const (
+	WebhookJSON  WebhookFormat = iota // the Notification, as JSON
+	WebhookSlack                      // a message for a Slack incoming webhook
+	WebhookTeams                      // a message card for a Microsoft Teams incoming webhook
+)
+
+// WebhookNotifier posts notifications to a chat webhook, or any URL taking JSON
+type WebhookNotifier struct {
+	URL    string
+	Format WebhookFormat
+
+	// Client posts the notifications, one with a timeout of DefaultNotifyTimeout if nil
+	Client *http.Client
+}
+
+// DefaultNotifyTimeout bounds the posts of a WebhookNotifier without a Client
+const DefaultNotifyTimeout = 30 * time.Second
+
+var webhookClient = &http.Client{Timeout: DefaultNotifyTimeout}
+
+// Notify posts n
+func (w *WebhookNotifier) Notify(n *Notification) error {
+	var v interface{} = n
+	switch w.Format {
+	case WebhookSlack:
+		v = map[string]string{"text": n.Summary()}
+	case WebhookTeams:
+		v = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  n.Summary(),
+			"text":     n.Summary(),
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("WebhookNotifier: %v", err)
+	}
+	client := w.Client
+	if client == nil {
+		client = webhookClient
+	}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("WebhookNotifier: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("WebhookNotifier: %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyAll delivers n with every notifier, passing their errors to onError if not nil
+func notifyAll(ns []Notifier, n *Notification, onError func(error)) {
+	for _, nt := range ns {
+		if err := nt.Notify(n); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// ErrNotifyQueueFull is passed to the OnError of a NotifyingScanner for the detections dropped
+// because the notifiers did not keep up
+var ErrNotifyQueueFull = errors.New("notification queue full")
+
+// DefaultNotifyQueue is the number of detections a NotifyingScanner holds for its notifiers
+// when QueueSize is zero
+const DefaultNotifyQueue = 100
+
+// NotifyingScanner is a Scanner notifying the detections of another, for example that of a
+// ClamdServer. Notifications are delivered in the background, one at a time, so that slow
+// notifiers do not hold up scans; detections beyond QueueSize waiting for delivery are
+// dropped rather than piling up.
+type NotifyingScanner struct {
+	Scanner   Scanner
+	Notifiers []Notifier
+
+	// OnError, if not nil, is called with the errors of the notifiers
+	OnError func(error)
+
+	// QueueSize is the number of detections waiting for delivery, DefaultNotifyQueue if zero
+	QueueSize int
+
+	once, closeOnce sync.Once
+	queue           chan *Notification
+	done            chan struct{}
+}
+
+// Scan scans the data read from r with the scanner, notifying the virus found if any
+func (s *NotifyingScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	res, err := s.Scanner.Scan(r, name)
+	if res != nil && res.Virus != "" {
+		n := newNotification(NotifyDetection)
+		n.Name, n.Virus = name, res.Virus
+		s.once.Do(s.start)
+		select {
+		case s.queue <- n:
+		default:
+			if s.OnError != nil {
+				s.OnError(fmt.Errorf("NotifyingScanner: %s in %s: %w", n.Virus, n.Name, ErrNotifyQueueFull))
+			}
+		}
+	}
+	return res, err
+}
+
+// start starts the goroutine delivering the queued notifications
+func (s *NotifyingScanner) start() {
+	size := s.QueueSize
+	if size <= 0 {
+		size = DefaultNotifyQueue
+	}
+	s.queue, s.done = make(chan *Notification, size), make(chan struct{})
+	go func() {
+		for {
+			select {
+			case n := <-s.queue:
+				notifyAll(s.Notifiers, n, s.OnError)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the delivery of notifications, dropping those still queued
+func (s *NotifyingScanner) Close() error {
+	s.once.Do(s.start)
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// NotifyReloadFailures returns a function for the OnReload of a Reloader, delivering the
+// failed reloads with ns and passing their errors to onError, if not nil
+func NotifyReloadFailures(onError func(error), ns ...Notifier) func(error) {
+	return func(err error) {
+		if err == nil {
+			return
+		}
+		n := newNotification(NotifyReloadFailed)
+		n.Err = err
+		notifyAll(ns, n, onError)
+	}
+}
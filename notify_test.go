@@ -0,0 +1,149 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chanNotifier sends the notifications to a channel
+type chanNotifier chan *Notification
+
+func (c chanNotifier) Notify(n *Notification) error {
+	c <- n
+	return nil
+}
+
+func TestNotifyingScanner(t *testing.T) {
+	c := make(chanNotifier, 1)
+	s := &NotifyingScanner{Scanner: eicarScanner{}, Notifiers: []Notifier{c}}
+	if _, err := s.Scan(strings.NewReader("clean"), "clean.txt"); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	res, err := s.Scan(bytes.NewReader(eicar), "upload.com")
+	if err != nil || res.Virus == "" {
+		t.Fatalf("Scan: %+v %v", res, err)
+	}
+	select {
+	case n := <-c:
+		if n.Kind != NotifyDetection || n.Name != "upload.com" || n.Virus != res.Virus || n.Time.IsZero() {
+			t.Errorf("Scan: notified %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Scan: detection not notified")
+	}
+	select {
+	case n := <-c:
+		t.Errorf("Scan: notified %+v", n)
+	default:
+	}
+
+	s.Close()
+
+	onReload := NotifyReloadFailures(nil, c)
+	onReload(nil)
+	onReload(errors.New("daily.cld: malformed database"))
+	if n := <-c; n.Kind != NotifyReloadFailed || !strings.Contains(n.Summary(), "malformed") {
+		t.Errorf("NotifyReloadFailures: notified %+v", n)
+	}
+}
+
+// blockingNotifier holds every notification until released
+type blockingNotifier chan struct{}
+
+func (b blockingNotifier) Notify(n *Notification) error {
+	<-b
+	return nil
+}
+
+func TestNotifyingScannerQueueFull(t *testing.T) {
+	b := make(blockingNotifier)
+	errs := make(chan error, 10)
+	s := &NotifyingScanner{Scanner: eicarScanner{}, Notifiers: []Notifier{b}, QueueSize: 1, OnError: func(err error) { errs <- err }}
+	defer s.Close()
+	defer close(b)
+	// one detection being delivered, one queued, the rest dropped
+	for i := 0; i < 4; i++ {
+		if _, err := s.Scan(bytes.NewReader(eicar), "upload.com"); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrNotifyQueueFull) {
+			t.Errorf("OnError: %v", err)
+		}
+	default:
+		t.Errorf("Scan: detections beyond the queue not reported")
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Errorf("webhook: %v", err)
+		}
+		got = append(got, v)
+	}))
+	defer srv.Close()
+
+	n := &Notification{Kind: NotifyReloadFailed, Host: "scanner1", Err: errors.New("no databases")}
+	for _, f := range []WebhookFormat{WebhookJSON, WebhookSlack, WebhookTeams} {
+		if err := (&WebhookNotifier{URL: srv.URL, Format: f}).Notify(n); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+	if len(got) != 3 || got[0]["Error"] != "no databases" || got[0]["Host"] != "scanner1" ||
+		got[1]["text"] != n.Summary() || got[2]["@type"] != "MessageCard" || got[2]["text"] != n.Summary() {
+		t.Errorf("Notify: posted %v", got)
+	}
+
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer fail.Close()
+	if err := (&WebhookNotifier{URL: fail.URL}).Notify(n); err == nil {
+		t.Errorf("Notify: refused post succeeded")
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	mta := &fakeMTA{}
+	go mta.serve(l)
+
+	e := &EmailNotifier{Addr: l.Addr().String(), From: "clamav@example.com", To: []string{"ops@example.com"}}
+	n := &Notification{Kind: NotifyDetection, Time: time.Now(), Host: "scanner1", Name: "invoice.doc", Virus: "Doc.Dropper.Agent"}
+	if err := e.Notify(n); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	msgs := mta.relayed()
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "Subject: [clamav] Doc.Dropper.Agent found in invoice.doc on scanner1") {
+		t.Errorf("Notify: mailed %q", msgs)
+	}
+
+	// the name of a sample cannot add headers
+	n.Name = "invoice.doc\r\nBcc: victim@example.com"
+	if err := e.Notify(n); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if msgs := mta.relayed(); len(msgs) != 2 || strings.Contains(strings.SplitN(msgs[1], "\n\n", 2)[0], "\nBcc:") {
+		t.Errorf("Notify: mailed %q", msgs)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ImageDetection locates a virus found while scanning a container image
+type ImageDetection struct {
+	Image string // repository tag, or manifest digest for untagged images
+	Layer string // layer path inside the image archive
+	Path  string // path of the infected file inside the layer
+	Virus string // virus name
+}
+
+// imageLayer is a layer tarball inside an image archive along with the images using it
+type imageLayer struct {
+	name   string
+	images []string
+}
+
+// maxImageMetadata limits the size of the JSON documents read from an image archive
+const maxImageMetadata = 4 << 20
+
+// ScanImage scans every regular file in every layer of a container image archive, as written
+// by "docker save" or an OCI image layout packed in a tar file. Layers may be uncompressed or
+// gzip compressed. Each layer is scanned once, even if several images in the archive share it.
+// Files are reported with the path they have inside their layer, so a virus in a lower layer
+// is found even when an upper layer deletes or replaces the file.
+func (e *Engine) ScanImage(archive string, opts *ScanOptions) ([]ImageDetection, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, fmt.Errorf("ScanImage: %v", err)
+	}
+	defer f.Close()
+
+	layers, err := imageLayers(f)
+	if err != nil {
+		return nil, fmt.Errorf("ScanImage: %v", err)
+	}
+
+	var found []ImageDetection
+	for _, l := range layers {
+		r, err := openTarEntry(f, l.name)
+		if err != nil {
+			return found, fmt.Errorf("ScanImage: %s: %v", l.name, err)
+		}
+		err = e.scanLayer(r, opts, func(name, virus string) {
+			for _, img := range l.images {
+				found = append(found, ImageDetection{Image: img, Layer: l.name, Path: name, Virus: virus})
+			}
+		})
+		if err != nil {
+			return found, fmt.Errorf("ScanImage: %s: %v", l.name, err)
+		}
+	}
+	return found, nil
+}
+
+// scanLayer scans all regular files in a (possibly compressed) layer tarball
+func (e *Engine) scanLayer(r io.Reader, opts *ScanOptions, detected func(name, virus string)) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return errors.New("zstd compressed layers are not supported")
+	default:
+		r = br
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		virus, _, err := e.ScanReader(tr, hdr.Name, opts)
+		if virus != "" {
+			detected(hdr.Name, virus)
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+	}
+}
+
+// dockerManifest is an entry of manifest.json in "docker save" archives
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// ociDescriptor references a blob of an OCI image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ociManifest covers both OCI image indexes and image manifests
+type ociManifest struct {
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// imageLayers lists the layers of all images in an image archive, in the order the images
+// reference them.
+func imageLayers(f io.ReadSeeker) ([]*imageLayer, error) {
+	var layers []*imageLayer
+	seen := map[string]*imageLayer{}
+	add := func(image, name string) {
+		name = path.Clean(name)
+		l, ok := seen[name]
+		if !ok {
+			l = &imageLayer{name: name}
+			seen[name] = l
+			layers = append(layers, l)
+		}
+		l.images = append(l.images, image)
+	}
+
+	// prefer the docker manifest, newer docker versions write both formats
+	var dm []dockerManifest
+	err := readTarJSON(f, "manifest.json", &dm)
+	if err == nil {
+		for _, m := range dm {
+			image := m.Config
+			if len(m.RepoTags) > 0 {
+				image = m.RepoTags[0]
+			}
+			for _, l := range m.Layers {
+				add(image, l)
+			}
+		}
+		return layers, nil
+	}
+	if err != errNoTarEntry {
+		return nil, fmt.Errorf("manifest.json: %v", err)
+	}
+
+	var index ociManifest
+	if err := readTarJSON(f, "index.json", &index); err != nil {
+		if err == errNoTarEntry {
+			return nil, errors.New("not a docker or OCI image archive")
+		}
+		return nil, fmt.Errorf("index.json: %v", err)
+	}
+	if err := ociLayers(f, "", index.Manifests, add, 0); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// ociLayers resolves the manifests referenced by an OCI index into layer blobs. Nested indexes,
+// such as multi-platform images, are followed to a limited depth.
+func ociLayers(f io.ReadSeeker, image string, descs []ociDescriptor, add func(image, name string), depth int) error {
+	if depth > 2 {
+		return errors.New("image indexes nested too deep")
+	}
+	for _, d := range descs {
+		name := image
+		if name == "" {
+			name = d.Annotations["org.opencontainers.image.ref.name"]
+		}
+		if name == "" {
+			name = d.Digest
+		}
+		blob, err := ociBlob(d.Digest)
+		if err != nil {
+			return err
+		}
+		var m ociManifest
+		if err := readTarJSON(f, blob, &m); err != nil {
+			return fmt.Errorf("%s: %v", blob, err)
+		}
+		if len(m.Manifests) > 0 {
+			if err := ociLayers(f, name, m.Manifests, add, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, l := range m.Layers {
+			lb, err := ociBlob(l.Digest)
+			if err != nil {
+				return err
+			}
+			add(name, lb)
+		}
+	}
+	return nil
+}
+
+// ociBlob returns the path of the blob with the given digest in an OCI image layout
+func ociBlob(digest string) (string, error) {
+	i := strings.Index(digest, ":")
+	if i <= 0 || i == len(digest)-1 || strings.ContainsAny(digest, "/\\") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return "blobs/" + digest[:i] + "/" + digest[i+1:], nil
+}
+
+var errNoTarEntry = errors.New("no such entry in archive")
+
+// readTarJSON decodes the JSON document stored under name in the tar archive f
+func readTarJSON(f io.ReadSeeker, name string, v interface{}) error {
+	r, err := openTarEntry(f, name)
+	if err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(r, maxImageMetadata))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// openTarEntry rewinds the tar archive f and returns a reader for the entry stored under name.
+// Symbolic links inside the archive are followed, as docker uses them to share layers.
+func openTarEntry(f io.ReadSeeker, name string) (io.Reader, error) {
+	for links := 0; links < 8; links++ {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(f)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil, errNoTarEntry
+			}
+			if err != nil {
+				return nil, err
+			}
+			if path.Clean(hdr.Name) != path.Clean(name) {
+				continue
+			}
+			if hdr.Typeflag == tar.TypeSymlink {
+				name = path.Join(path.Dir(path.Clean(name)), hdr.Linkname)
+				break
+			}
+			return tr, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: too many levels of symbolic links", name)
+}
@@ -0,0 +1,158 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type tarEntry struct {
+	name, link string
+	body       []byte
+}
+
+func writeTar(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.link
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("tar header: %v", err)
+		}
+		if _, err := tw.Write(e.body); err != nil && e.link == "" {
+			t.Fatalf("tar write: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageLayersDocker(t *testing.T) {
+	layer := writeTar(t, []tarEntry{{name: "eicar.com", body: eicar}})
+	archive := writeTar(t, []tarEntry{
+		{name: "blobs/sha256/aaaa", body: layer},
+		{name: "aaaa/layer.tar", link: "../blobs/sha256/aaaa"},
+		{name: "manifest.json", body: []byte(`[
+			{"Config": "cfg.json", "RepoTags": ["app:1"], "Layers": ["aaaa/layer.tar"]},
+			{"Config": "cfg2.json", "Layers": ["aaaa/layer.tar"]}]`)},
+	})
+
+	r := bytes.NewReader(archive)
+	layers, err := imageLayers(r)
+	if err != nil {
+		t.Fatalf("imageLayers: %v", err)
+	}
+	if len(layers) != 1 {
+		t.Fatalf("imageLayers: %d layers, want 1", len(layers))
+	}
+	if want := []string{"app:1", "cfg2.json"}; !reflect.DeepEqual(layers[0].images, want) {
+		t.Errorf("imageLayers: images %v, want %v", layers[0].images, want)
+	}
+
+	lr, err := openTarEntry(r, layers[0].name)
+	if err != nil {
+		t.Fatalf("openTarEntry: %v", err)
+	}
+	b, _ := ioutil.ReadAll(lr)
+	if !bytes.Equal(b, layer) {
+		t.Errorf("openTarEntry: symlinked layer not resolved")
+	}
+}
+
+func TestImageLayersOCI(t *testing.T) {
+	archive := writeTar(t, []tarEntry{
+		{name: "oci-layout", body: []byte(`{"imageLayoutVersion": "1.0.0"}`)},
+		{name: "index.json", body: []byte(`{"manifests": [{"digest": "sha256:1111",
+			"annotations": {"org.opencontainers.image.ref.name": "latest"}}]}`)},
+		{name: "blobs/sha256/1111", body: []byte(`{"manifests": [{"digest": "sha256:2222"}]}`)},
+		{name: "blobs/sha256/2222", body: []byte(`{"layers": [{"digest": "sha256:3333"}, {"digest": "sha256:4444"}]}`)},
+	})
+
+	layers, err := imageLayers(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("imageLayers: %v", err)
+	}
+	var names []string
+	for _, l := range layers {
+		names = append(names, l.name)
+		if len(l.images) != 1 || l.images[0] != "latest" {
+			t.Errorf("imageLayers: %s images %v, want [latest]", l.name, l.images)
+		}
+	}
+	if want := []string{"blobs/sha256/3333", "blobs/sha256/4444"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("imageLayers: %v, want %v", names, want)
+	}
+}
+
+func TestImageLayersInvalid(t *testing.T) {
+	archive := writeTar(t, []tarEntry{
+		{name: "index.json", body: []byte(`{"manifests": [{"digest": "../../etc/passwd"}]}`)},
+	})
+	if _, err := imageLayers(bytes.NewReader(archive)); err == nil {
+		t.Errorf("imageLayers: invalid digest accepted")
+	}
+	if _, err := imageLayers(bytes.NewReader(writeTar(t, nil))); err == nil {
+		t.Errorf("imageLayers: empty archive accepted")
+	}
+}
+
+func TestScanImage(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	layer := gzipBytes(t, writeTar(t, []tarEntry{
+		{name: "bin/clean", body: []byte("hello")},
+		{name: "tmp/eicar.com", body: eicar},
+	}))
+	archive := writeTar(t, []tarEntry{
+		{name: "bbbb/layer.tar", body: layer},
+		{name: "manifest.json", body: []byte(`[{"Config": "cfg.json", "RepoTags": ["app:1"], "Layers": ["bbbb/layer.tar"]}]`)},
+	})
+	dir, err := ioutil.TempDir("", "clamav")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "image.tar")
+	if err := ioutil.WriteFile(path, archive, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	found, err := eng.ScanImage(path, stdopts)
+	if err != nil {
+		t.Fatalf("ScanImage: %v", err)
+	}
+	want := []ImageDetection{{Image: "app:1", Layer: "bbbb/layer.tar", Path: "tmp/eicar.com", Virus: "Eicar-Test-Signature"}}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("ScanImage: %+v, want %+v", found, want)
+	}
+}
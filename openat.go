@@ -0,0 +1,112 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package clamav
+
+/*
+#include <fcntl.h>
+#include <stdlib.h>
+
+static int openat_nofollow(int dirfd, const char *name, int dir)
+{
+	int flags = O_RDONLY | O_NOFOLLOW | O_NONBLOCK | O_CLOEXEC;
+	if (dir)
+		flags |= O_DIRECTORY;
+	return openat(dirfd, name, flags);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// OpenAt opens path, relative to the directory open as dirfd, without following symbolic links
+// in any of its components and without leaving the directory: absolute paths and ".." are
+// refused. Only regular files are opened. Scanners of drop folders or on-access scanners can
+// then scan and act on the file through the returned descriptor, which cannot be swapped for
+// another file once opened, unlike a path.
+func OpenAt(dirfd int, path string) (*os.File, error) {
+	if path == "" || strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("OpenAt: %q: not a relative path", path)
+	}
+	elems := strings.Split(path, "/")
+	fd := dirfd
+	for i, elem := range elems {
+		last := i == len(elems)-1
+		switch {
+		case elem == "..":
+			closeDir(fd, dirfd)
+			return nil, fmt.Errorf("OpenAt: %q: path leaves the directory", path)
+		case (elem == "" || elem == ".") && !last:
+			continue
+		case elem == "" || elem == ".":
+			closeDir(fd, dirfd)
+			return nil, fmt.Errorf("OpenAt: %q: not a file", path)
+		}
+
+		next, err := openNoFollow(fd, elem, !last)
+		closeDir(fd, dirfd)
+		if err != nil {
+			return nil, fmt.Errorf("OpenAt: %q: %v", path, err)
+		}
+		fd = next
+	}
+
+	f := os.NewFile(uintptr(fd), path)
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("OpenAt: %v", err)
+	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("OpenAt: %q: not a regular file", path)
+	}
+	return f, nil
+}
+
+// openNoFollow opens name in the directory fd, refusing symbolic links
+func openNoFollow(fd int, name string, dir bool) (int, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	var cdir C.int
+	if dir {
+		cdir = 1
+	}
+	n, err := C.openat_nofollow(C.int(fd), cname, cdir)
+	if n < 0 {
+		return -1, err
+	}
+	return int(n), nil
+}
+
+// closeDir closes the intermediate directory fd, unless it is the caller's dirfd
+func closeDir(fd, dirfd int) {
+	if fd != dirfd {
+		os.NewFile(uintptr(fd), "").Close()
+	}
+}
+
+// ScanAt scans path, relative to the directory open as dirfd, opening it with OpenAt so that
+// the file scanned is the one found in the directory even if its path is swapped concurrently
+func (e *Engine) ScanAt(dirfd int, path string, opts *ScanOptions) (string, uint, error) {
+	return e.ScanAtCb(dirfd, path, opts, nil)
+}
+
+// ScanAtCb scans path like ScanAt, passing context to the callbacks
+func (e *Engine) ScanAtCb(dirfd int, path string, opts *ScanOptions, context interface{}) (string, uint, error) {
+	f, err := OpenAt(dirfd, path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return e.ScanDescCb(path, int(f.Fd()), opts, context)
+}
@@ -0,0 +1,59 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package clamav
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanAt(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "sub", "eicar"), eicar, 0644)
+	ioutil.WriteFile(filepath.Join(root, "clean"), []byte("clean"), 0644)
+	ioutil.WriteFile(filepath.Join(outside, "eicar"), eicar, 0644)
+	os.Symlink(filepath.Join(outside, "eicar"), filepath.Join(root, "link"))
+	os.Symlink(outside, filepath.Join(root, "linkdir"))
+
+	dir, err := os.Open(root)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer dir.Close()
+	dirfd := int(dir.Fd())
+
+	virus, _, _ := eng.ScanAt(dirfd, "sub/eicar", stdopts)
+	if virus != "Eicar-Test-Signature" {
+		t.Errorf("ScanAt: sub/eicar: virus %q", virus)
+	}
+	if virus, _, err := eng.ScanAt(dirfd, "./clean", stdopts); virus != "" || err != nil {
+		t.Errorf("ScanAt: clean: %q %v", virus, err)
+	}
+	for _, path := range []string{"link", "linkdir/eicar", "../" + filepath.Base(outside) + "/eicar", "/etc/hosts", "sub", "sub/", "missing"} {
+		if _, _, err := eng.ScanAt(dirfd, path, stdopts); err == nil {
+			t.Errorf("ScanAt: %s: no error", path)
+		}
+	}
+
+	// the descriptor is still usable by the caller
+	if f, err := OpenAt(dirfd, "clean"); err != nil {
+		t.Errorf("OpenAt: %v", err)
+	} else {
+		f.Close()
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scanOption describes an option bit and the libclamav release that introduced it
+type scanOption struct {
+	field string
+	bit   uint32
+	name  string
+	since [2]int // major, minor
+}
+
+var scanOptions = []scanOption{
+	{"General", ScanGeneralAllmatches, "ScanGeneralAllmatches", [2]int{0, 101}},
+	{"General", ScanGeneralCollectMetadata, "ScanGeneralCollectMetadata", [2]int{0, 101}},
+	{"General", ScanGeneralHeuristics, "ScanGeneralHeuristics", [2]int{0, 101}},
+	{"General", ScanGeneralHeuristicsPrecendence, "ScanGeneralHeuristicsPrecendence", [2]int{0, 101}},
+	{"General", ScanGeneralUnprivileged, "ScanGeneralUnprivileged", [2]int{0, 104}},
+	{"General", ScanGeneralStoreHTMLURIs, "ScanGeneralStoreHTMLURIs", [2]int{1, 3}},
+	{"General", ScanGeneralStorePDFURIs, "ScanGeneralStorePDFURIs", [2]int{1, 3}},
+	{"Parse", ScanParseArchive, "ScanParseArchive", [2]int{0, 101}},
+	{"Parse", ScanParseElf, "ScanParseElf", [2]int{0, 101}},
+	{"Parse", ScanParsePdf, "ScanParsePdf", [2]int{0, 101}},
+	{"Parse", ScanParseSwf, "ScanParseSwf", [2]int{0, 101}},
+	{"Parse", ScanParseHwp3, "ScanParseHwp3", [2]int{0, 101}},
+	{"Parse", ScanParseXMLDocs, "ScanParseXMLDocs", [2]int{0, 101}},
+	{"Parse", ScanParseMail, "ScanParseMail", [2]int{0, 101}},
+	{"Parse", ScanParseOle2, "ScanParseOle2", [2]int{0, 101}},
+	{"Parse", ScanParseHTML, "ScanParseHTML", [2]int{0, 101}},
+	{"Parse", ScanParsePE, "ScanParsePE", [2]int{0, 101}},
+	{"Parse", ScanParseOneNote, "ScanParseOneNote", [2]int{1, 1}},
+	{"Parse", ScanParseImage, "ScanParseImage", [2]int{1, 0}},
+	{"Parse", ScanParseImageFuzzyHash, "ScanParseImageFuzzyHash", [2]int{1, 0}},
+	{"Heuristic", ScanHeuristicBroken, "ScanHeuristicBroken", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicExceedsMax, "ScanHeuristicExceedsMax", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicPhishingSSLMismatch, "ScanHeuristicPhishingSSLMismatch", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicPhishingCloak, "ScanHeuristicPhishingCloak", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicMacros, "ScanHeuristicMacros", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicEncryptedArchive, "ScanHeuristicEncryptedArchive", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicEncryptedDoc, "ScanHeuristicEncryptedDoc", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicPartitionIntxn, "ScanHeuristicPartitionIntxn", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicStructure, "ScanHeuristicStructure", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicStructuredSSNNormal, "ScanHeuristicStructuredSSNNormal", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicStructuredSSNStripped, "ScanHeuristicStructuredSSNStripped", [2]int{0, 101}},
+	{"Heuristic", ScanHeuristicStructuredCC, "ScanHeuristicStructuredCC", [2]int{0, 103}},
+	{"Heuristic", ScanHeuristicBrokenMedia, "ScanHeuristicBrokenMedia", [2]int{0, 103}},
+	{"Mail", ScanMailPartialMessage, "ScanMailPartialMessage", [2]int{0, 101}},
+	{"Dev", ScanDevCollectSHA, "ScanDevCollectSHA", [2]int{0, 101}},
+	{"Dev", ScanDevCollectPerformanceInfo, "ScanDevCollectPerformanceInfo", [2]int{0, 101}},
+}
+
+// Validate checks the options against the linked libclamav and, if e is not nil, the settings
+// of the engine. It returns a warning for every requested behavior that libclamav would
+// silently ignore: option bits unknown to this package or newer than the linked version, and
+// options without effect in combination with others.
+func (o *ScanOptions) Validate(e *Engine) []string {
+	warnings := o.unsupported()
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	if o.Heuristic != 0 && o.General&ScanGeneralHeuristics == 0 {
+		warn("Heuristic options have no effect without ScanGeneralHeuristics")
+	}
+	if o.General&ScanGeneralHeuristicsPrecendence != 0 && o.General&ScanGeneralHeuristics == 0 {
+		warn("ScanGeneralHeuristicsPrecendence has no effect without ScanGeneralHeuristics")
+	}
+	for _, opt := range []struct {
+		bit  uint32
+		name string
+	}{
+		{ScanGeneralStoreHTMLURIs, "ScanGeneralStoreHTMLURIs"},
+		{ScanGeneralStorePDFURIs, "ScanGeneralStorePDFURIs"},
+	} {
+		if o.General&opt.bit != 0 && o.General&ScanGeneralCollectMetadata == 0 {
+			warn("%s has no effect without ScanGeneralCollectMetadata", opt.name)
+		}
+	}
+	if o.Parse&ScanParseImageFuzzyHash != 0 && o.Parse&ScanParseImage == 0 {
+		warn("ScanParseImageFuzzyHash has no effect without ScanParseImage")
+	}
+	if o.Dev&ScanDevCollectPerformanceInfo != 0 && o.General&ScanGeneralCollectMetadata == 0 {
+		warn("ScanDevCollectPerformanceInfo has no effect without ScanGeneralCollectMetadata")
+	}
+	if o.Heuristic&ScanHeuristicPhishingSSLMismatch != 0 || o.Heuristic&ScanHeuristicPhishingCloak != 0 {
+		if o.Parse&(ScanParseMail|ScanParseHTML) == 0 {
+			warn("phishing heuristics have no effect without ScanParseMail or ScanParseHTML")
+		}
+	}
+
+	if e != nil && o.Heuristic&ScanHeuristicExceedsMax != 0 {
+		unlimited := true
+		for _, f := range []EngineField{MaxScansize, MaxFilesize, MaxRecursion, MaxFiles} {
+			if n, err := e.GetNum(f); err != nil || n != 0 {
+				unlimited = false
+			}
+		}
+		if unlimited {
+			warn("ScanHeuristicExceedsMax has no effect, the engine has no scan limits")
+		}
+	}
+	return warnings
+}
+
+// unsupported describes the option bits unknown to this package or newer than the linked
+// libclamav, which libclamav ignores
+func (o *ScanOptions) unsupported() []string {
+	var problems []string
+	ver := Retver()
+	major, minor, verOK := parseLibVersion(ver)
+	fields := []struct {
+		name string
+		bits uint32
+	}{{"General", o.General}, {"Parse", o.Parse}, {"Heuristic", o.Heuristic}, {"Mail", o.Mail}, {"Dev", o.Dev}}
+	for _, f := range fields {
+		for bit := uint32(1); bit != 0; bit <<= 1 {
+			if f.bits&bit == 0 {
+				continue
+			}
+			opt := lookupScanOption(f.name, bit)
+			switch {
+			case opt == nil:
+				problems = append(problems, fmt.Sprintf("%s option %#x is unknown", f.name, bit))
+			case verOK && (major < opt.since[0] || major == opt.since[0] && minor < opt.since[1]):
+				problems = append(problems, fmt.Sprintf("%s requires libclamav %d.%d, linked version is %s (functionality level %d)", opt.name, opt.since[0], opt.since[1], ver, Retflevel()))
+			}
+		}
+	}
+	return problems
+}
+
+// lookupScanOption returns the description of bit in the named field, nil if unknown
+func lookupScanOption(field string, bit uint32) *scanOption {
+	for i := range scanOptions {
+		if scanOptions[i].field == field && scanOptions[i].bit == bit {
+			return &scanOptions[i]
+		}
+	}
+	return nil
+}
+
+// parseLibVersion parses a libclamav version such as "0.103.8" or "1.0.1-devel"
+func parseLibVersion(v string) (major, minor int, ok bool) {
+	f := strings.SplitN(v, ".", 3)
+	if len(f) < 2 {
+		return 0, 0, false
+	}
+	major, err1 := strconv.Atoi(f[0])
+	minor, err2 := strconv.Atoi(strings.SplitN(f[1], "-", 2)[0])
+	return major, minor, err1 == nil && err2 == nil
+}
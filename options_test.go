@@ -0,0 +1,58 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	opts := &ScanOptions{
+		General:   ScanGeneralAllmatches | ScanGeneralHeuristics,
+		Parse:     ScanParseArchive | ScanParsePdf | ScanParseMail | ScanParseOle2,
+		Heuristic: ScanHeuristicMacros | ScanHeuristicPhishingCloak,
+	}
+	if w := opts.Validate(nil); len(w) != 0 {
+		t.Errorf("Validate: %q", w)
+	}
+
+	opts = &ScanOptions{
+		Parse:     0x80000000,
+		Heuristic: ScanHeuristicMacros,
+	}
+	w := opts.Validate(nil)
+	if len(w) != 2 || !strings.Contains(w[0], "unknown") || !strings.Contains(w[1], "ScanGeneralHeuristics") {
+		t.Errorf("Validate: %q", w)
+	}
+
+	// pretend every option was introduced in a far future release
+	major, minor, ok := parseLibVersion(Retver())
+	if !ok {
+		t.Skipf("unknown libclamav version %q", Retver())
+	}
+	saved := scanOptions
+	defer func() { scanOptions = saved }()
+	scanOptions = []scanOption{{"General", ScanGeneralAllmatches, "ScanGeneralAllmatches", [2]int{major, minor + 1}}}
+	w = (&ScanOptions{General: ScanGeneralAllmatches}).Validate(nil)
+	if len(w) != 1 || !strings.Contains(w[0], fmt.Sprintf("requires libclamav %d.%d", major, minor+1)) {
+		t.Errorf("Validate: newer option: %q", w)
+	}
+}
+
+func TestParseLibVersion(t *testing.T) {
+	for v, want := range map[string][3]int{
+		"0.103.8":     {0, 103, 1},
+		"1.0.1-devel": {1, 0, 1},
+		"0.104-rc":    {0, 104, 1},
+		"devel":       {0, 0, 0},
+	} {
+		major, minor, ok := parseLibVersion(v)
+		if major != want[0] || minor != want[1] || ok != (want[2] == 1) {
+			t.Errorf("parseLibVersion(%q) = %d, %d, %v", v, major, minor, ok)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"unsafe"
+)
+
+// CallbackPanicError is the error of a scan during which a callback panicked. Panics are not
+// let unwind through libclamav, which would abort the process: the callback is stopped, the
+// scan of the object aborted and the engine remains usable.
+type CallbackPanicError struct {
+	Callback string      // such as "precache" or "msg"
+	Value    interface{} // passed to panic
+	Stack    []byte      // of the goroutine when it panicked
+}
+
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("%s callback panicked: %v", e.Callback, e.Value)
+}
+
+// callbackPanics holds the first panic recovered in the callbacks of each scan, by context key
+var callbackPanics sync.Map
+
+// detectedByCallback is the virus name libclamav reports for objects a callback stopped
+const detectedByCallback = "Detected.By.Callback"
+
+// recoverCallback, deferred by the callbacks libclamav calls, recovers a panic of the callback
+// named name, records it for the scan of key and calls abort, if not nil, to stop the scan.
+// Panics out of any scan, such as of the message callback while loading databases, are
+// printed to standard error.
+func recoverCallback(name string, key unsafe.Pointer, abort func()) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	err := &CallbackPanicError{Callback: name, Value: v, Stack: debug.Stack()}
+	if abort != nil {
+		abort()
+	}
+	if key == nil {
+		fmt.Fprintf(os.Stderr, "clamav: %v\n%s", err, err.Stack)
+		return
+	}
+	callbackPanics.LoadOrStore(key, err)
+}
+
+// takePanic returns the panic recovered in the callbacks of the scan of key, nil if none
+func takePanic(key unsafe.Pointer) error {
+	if v, ok := callbackPanics.LoadAndDelete(key); ok {
+		return v.(*CallbackPanicError)
+	}
+	return nil
+}
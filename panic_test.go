@@ -0,0 +1,44 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCallbackPanic(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	eng.SetPreCacheCallback(func(fd int, ftype string, context interface{}) ErrorCode {
+		panic("callback bug")
+	})
+	defer func() { setCallbackFunc("precache", nil) }()
+
+	s := &EngineScanner{Engine: eng, Options: stdopts}
+	_, err = s.Scan(bytes.NewReader(eicar), "eicar")
+	var perr *CallbackPanicError
+	if !errors.As(err, &perr) || perr.Callback != "precache" || perr.Value != "callback bug" || len(perr.Stack) == 0 {
+		t.Fatalf("Scan: %v, want a CallbackPanicError", err)
+	}
+	res, err := eng.ScanBatch([][]byte{eicar, []byte("clean")}, []string{"a", "b"}, stdopts)
+	if err != nil || len(res) != 2 || !errors.As(res[0].Err, &perr) || !errors.As(res[1].Err, &perr) {
+		t.Errorf("ScanBatch: %+v %v", res, err)
+	}
+
+	// the engine remains usable
+	setCallbackFunc("precache", nil)
+	if res, err := s.Scan(bytes.NewReader(eicar), "eicar"); err != nil || res.Virus == "" {
+		t.Errorf("Scan: after panic: %+v %v", res, err)
+	}
+	if err := takePanic(nil); err != nil {
+		t.Errorf("takePanic: %v", err)
+	}
+}
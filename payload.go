@@ -0,0 +1,125 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrPayloadTooLarge is returned for payloads decoding to more than the size limit
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// DefaultMaxPayloadSize is the limit of the decoded size of payloads when none is given
+const DefaultMaxPayloadSize = 32 << 20
+
+// DecodeBase64 decodes a base64 payload of at most max bytes, DefaultMaxPayloadSize if zero, as
+// found in JSON or form fields. Line breaks and spaces are ignored, and both the standard and
+// the URL-safe alphabets are accepted, padded or not.
+func DecodeBase64(s string, max int64) ([]byte, error) {
+	if max <= 0 {
+		max = DefaultMaxPayloadSize
+	}
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, s)
+	if int64(len(s)) > (max+2)/3*4 {
+		return nil, ErrPayloadTooLarge
+	}
+	enc := base64.StdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.URLEncoding
+	}
+	if len(s)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	b, err := enc.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeBase64: %v", err)
+	}
+	if int64(len(b)) > max {
+		return nil, ErrPayloadTooLarge
+	}
+	return b, nil
+}
+
+// ParseDataURI decodes a data URI (RFC 2397) of at most max bytes, DefaultMaxPayloadSize if
+// zero, returning its media type and its data
+func ParseDataURI(uri string, max int64) (mediaType string, data []byte, err error) {
+	if max <= 0 {
+		max = DefaultMaxPayloadSize
+	}
+	if len(uri) < 5 || !strings.EqualFold(uri[:5], "data:") {
+		return "", nil, errors.New("ParseDataURI: not a data URI")
+	}
+	comma := strings.IndexByte(uri, ',')
+	if comma < 0 {
+		return "", nil, errors.New("ParseDataURI: no data")
+	}
+	mediaType, payload := uri[5:comma], uri[comma+1:]
+	encoded := false
+	if strings.HasSuffix(strings.ToLower(mediaType), ";base64") {
+		mediaType, encoded = mediaType[:len(mediaType)-len(";base64")], true
+	}
+	if mediaType == "" || strings.HasPrefix(mediaType, ";") {
+		mediaType = "text/plain" + mediaType
+		if !strings.Contains(mediaType, "charset=") {
+			mediaType += ";charset=US-ASCII"
+		}
+	}
+	if encoded {
+		// base64 data may be percent-encoded too, such as in URLs
+		if strings.IndexByte(payload, '%') >= 0 {
+			if payload, err = url.PathUnescape(payload); err != nil {
+				return "", nil, fmt.Errorf("ParseDataURI: %v", err)
+			}
+		}
+		data, err = DecodeBase64(payload, max)
+		if err == ErrPayloadTooLarge {
+			return "", nil, err
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("ParseDataURI: %v", err)
+		}
+		return mediaType, data, nil
+	}
+	if int64(len(payload)) > 3*max {
+		return "", nil, ErrPayloadTooLarge
+	}
+	s, err := url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("ParseDataURI: %v", err)
+	}
+	if int64(len(s)) > max {
+		return "", nil, ErrPayloadTooLarge
+	}
+	return mediaType, []byte(s), nil
+}
+
+// ScanBase64 decodes a base64 payload as DecodeBase64 does and scans it with s
+func ScanBase64(s Scanner, payload, name string, max int64) (*ScanResult, error) {
+	b, err := DecodeBase64(payload, max)
+	if err != nil {
+		return nil, err
+	}
+	return s.Scan(bytes.NewReader(b), name)
+}
+
+// ScanDataURI decodes a data URI as ParseDataURI does and scans its data with s
+func ScanDataURI(s Scanner, uri, name string, max int64) (*ScanResult, error) {
+	_, b, err := ParseDataURI(uri, max)
+	if err != nil {
+		return nil, err
+	}
+	return s.Scan(bytes.NewReader(b), name)
+}
@@ -0,0 +1,68 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBase64(t *testing.T) {
+	data := []byte("\xff\xfe binary ?>")
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		s := enc.EncodeToString(data)
+		// wrapped as in MIME
+		s = s[:4] + "\r\n" + s[4:]
+		if b, err := DecodeBase64(s, 0); err != nil || !bytes.Equal(b, data) {
+			t.Errorf("DecodeBase64: %q = %q, %v", s, b, err)
+		}
+	}
+	if _, err := DecodeBase64(base64.StdEncoding.EncodeToString(data), int64(len(data)-1)); err != ErrPayloadTooLarge {
+		t.Errorf("DecodeBase64: %v over the limit", err)
+	}
+	if _, err := DecodeBase64("not base64!", 0); err == nil {
+		t.Errorf("DecodeBase64: invalid payload accepted")
+	}
+}
+
+func TestParseDataURI(t *testing.T) {
+	for _, c := range []struct {
+		uri, mediaType, data string
+	}{
+		{"data:,A%20brief%20note", "text/plain;charset=US-ASCII", "A brief note"},
+		{"data:;charset=utf-8,caf%C3%A9", "text/plain;charset=utf-8", "café"},
+		{"data:image/png;base64,iVBORw0KGgo=", "image/png", "\x89PNG\r\n\x1a\n"},
+		{"DATA:application/octet-stream;BASE64,aVZCT1J3MEtHZ28%3D", "application/octet-stream", "iVBORw0KGgo"},
+	} {
+		mt, data, err := ParseDataURI(c.uri, 0)
+		if err != nil || mt != c.mediaType || string(data) != c.data {
+			t.Errorf("ParseDataURI: %s = %q, %q, %v", c.uri, mt, data, err)
+		}
+	}
+	for _, uri := range []string{"http://example.com/", "data:text/plain", "data:;base64,***"} {
+		if _, _, err := ParseDataURI(uri, 0); err == nil {
+			t.Errorf("ParseDataURI: %s accepted", uri)
+		}
+	}
+	if _, _, err := ParseDataURI("data:,"+strings.Repeat("a", 11), 10); err != ErrPayloadTooLarge {
+		t.Errorf("ParseDataURI: %v over the limit", err)
+	}
+}
+
+func TestScanDataURI(t *testing.T) {
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(eicar)
+	if res, err := ScanDataURI(eicarScanner{}, uri, "upload", 0); err != nil || res.Virus == "" {
+		t.Errorf("ScanDataURI: %+v, %v", res, err)
+	}
+	if res, err := ScanDataURI(eicarScanner{}, "data:,"+url.PathEscape(string(eicar)), "upload", 0); err != nil || res.Virus == "" {
+		t.Errorf("ScanDataURI: %+v, %v", res, err)
+	}
+	if res, err := ScanBase64(eicarScanner{}, base64.StdEncoding.EncodeToString(eicar), "upload", 0); err != nil || res.Virus == "" {
+		t.Errorf("ScanBase64: %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PhishingRule is an entry of a phishing database. The phishing heuristics compare the URL a link
+// points to, the real URL, with the URL displayed in the text of the link.
+type PhishingRule struct {
+	// Hostname makes Real and Displayed host names, matched exactly, rather than regular
+	// expressions matched against the whole URLs
+	Hostname bool
+
+	Real      string // real URL or host name, ignored for protected host names
+	Displayed string // displayed URL or host name
+
+	// MinFlevel and MaxFlevel restrict the rule to libclamav functionality levels, if not zero
+	MinFlevel, MaxFlevel uint
+}
+
+// flevel returns the functionality level suffix of the rule, if any
+func (r PhishingRule) flevel() string {
+	switch {
+	case r.MaxFlevel != 0:
+		return fmt.Sprintf(":%d-%d", r.MinFlevel, r.MaxFlevel)
+	case r.MinFlevel != 0:
+		return fmt.Sprintf(":%d-", r.MinFlevel)
+	}
+	return ""
+}
+
+func (r PhishingRule) validate(protected bool) error {
+	fields := []string{r.Displayed}
+	if !protected || !r.Hostname {
+		fields = append(fields, r.Real)
+	}
+	for _, f := range fields {
+		if f == "" || strings.ContainsAny(f, ":\n") {
+			return fmt.Errorf("invalid rule %+v", r)
+		}
+	}
+	if r.MaxFlevel != 0 && r.MaxFlevel < r.MinFlevel {
+		return fmt.Errorf("invalid functionality level range in %+v", r)
+	}
+	return nil
+}
+
+// PhishingList holds the phishing rules of a mail or web gateway, to be managed from its
+// configuration rather than as database files
+type PhishingList struct {
+	// Allowed links are not reported even though their real and displayed URLs differ, such
+	// as links through the tracking domains of a newsletter service (a .wdb database)
+	Allowed []PhishingRule
+
+	// Protected links are the only ones checked: those displaying the URLs or domains of the
+	// organization or of the services its users are targeted with (a .pdb database)
+	Protected []PhishingRule
+}
+
+// Allow adds an allowed link to host real displaying host displayed
+func (l *PhishingList) Allow(real, displayed string) {
+	l.Allowed = append(l.Allowed, PhishingRule{Hostname: true, Real: real, Displayed: displayed})
+}
+
+// Protect adds a protected host name
+func (l *PhishingList) Protect(host string) {
+	l.Protected = append(l.Protected, PhishingRule{Hostname: true, Displayed: host})
+}
+
+// WDB returns the allowed links as a .wdb database
+func (l *PhishingList) WDB() (string, error) {
+	var b strings.Builder
+	for _, r := range l.Allowed {
+		if err := r.validate(false); err != nil {
+			return "", fmt.Errorf("WDB: %v", err)
+		}
+		kind := "X"
+		if r.Hostname {
+			kind = "M"
+		}
+		fmt.Fprintf(&b, "%s:%s:%s%s\n", kind, r.Real, r.Displayed, r.flevel())
+	}
+	return b.String(), nil
+}
+
+// PDB returns the protected links as a .pdb database
+func (l *PhishingList) PDB() (string, error) {
+	var b strings.Builder
+	for _, r := range l.Protected {
+		if err := r.validate(true); err != nil {
+			return "", fmt.Errorf("PDB: %v", err)
+		}
+		if r.Hostname {
+			fmt.Fprintf(&b, "H:%s%s\n", r.Displayed, r.flevel())
+		} else {
+			fmt.Fprintf(&b, "R:%s:%s%s\n", r.Real, r.Displayed, r.flevel())
+		}
+	}
+	return b.String(), nil
+}
+
+// LoadPhishingList loads the rules of l into the engine, before Compile, in addition to the
+// phishing databases already loaded. DbPhishingUrls is added to dbopts, without which
+// libclamav ignores phishing databases.
+func (e *Engine) LoadPhishingList(l *PhishingList, dbopts uint) (uint, error) {
+	files := map[string]string{}
+	wdb, err := l.WDB()
+	if err != nil {
+		return 0, fmt.Errorf("LoadPhishingList: %v", err)
+	}
+	pdb, err := l.PDB()
+	if err != nil {
+		return 0, fmt.Errorf("LoadPhishingList: %v", err)
+	}
+	if wdb != "" {
+		files["local.wdb"] = wdb
+	}
+	if pdb != "" {
+		files["local.pdb"] = pdb
+	}
+	if len(files) == 0 {
+		return 0, nil
+	}
+	n, err := e.loadDatabases(files, dbopts|DbPhishingUrls)
+	if err != nil {
+		return 0, fmt.Errorf("LoadPhishingList: %v", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "testing"
+
+func TestPhishingList(t *testing.T) {
+	l := &PhishingList{}
+	l.Allow("click.newsletter.example", "www.example.com")
+	l.Allowed = append(l.Allowed, PhishingRule{Real: `.+\.example\.net([/?].*)?`, Displayed: `.+\.example\.com([/?].*)?`, MinFlevel: 17})
+	l.Protect("bank.example")
+	l.Protected = append(l.Protected, PhishingRule{Real: `.+\.bank\.example`, Displayed: `.+\.bank\.example`, MinFlevel: 17, MaxFlevel: 200})
+
+	wdb, err := l.WDB()
+	if want := "M:click.newsletter.example:www.example.com\nX:.+\\.example\\.net([/?].*)?:.+\\.example\\.com([/?].*)?:17-\n"; err != nil || wdb != want {
+		t.Errorf("WDB: got %q %v, want %q", wdb, err, want)
+	}
+	pdb, err := l.PDB()
+	if want := "H:bank.example\nR:.+\\.bank\\.example:.+\\.bank\\.example:17-200\n"; err != nil || pdb != want {
+		t.Errorf("PDB: got %q %v, want %q", pdb, err, want)
+	}
+
+	eng := New()
+	defer eng.Free()
+	if _, err := eng.LoadPhishingList(l, DbStdopt); err != nil {
+		t.Errorf("LoadPhishingList: %v", err)
+	}
+
+	for _, bad := range []*PhishingList{
+		{Allowed: []PhishingRule{{Hostname: true, Displayed: "www.example.com"}}},
+		{Protected: []PhishingRule{{Hostname: true, Displayed: "bank.example:443"}}},
+		{Protected: []PhishingRule{{Hostname: true, Displayed: "bank.example", MinFlevel: 20, MaxFlevel: 10}}},
+	} {
+		if _, err := eng.LoadPhishingList(bad, DbStdopt); err == nil {
+			t.Errorf("LoadPhishingList: invalid rules loaded: %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrProcessMemory is the error of the process memory helpers on systems without /proc
+var ErrProcessMemory = errors.New("process memory cannot be read on this system")
+
+// MemoryRegion is a mapping of the address space of a process, as listed in /proc/pid/maps
+type MemoryRegion struct {
+	Start uint64
+	End   uint64
+	Perms string // such as "r-xp"
+	Path  string // of the mapped file, or a pseudo-path such as "[heap]", empty if anonymous
+}
+
+// Executable reports whether the region can be executed
+func (m MemoryRegion) Executable() bool {
+	return strings.IndexByte(m.Perms, 'x') >= 0
+}
+
+// Anonymous reports whether the region maps no file, as do the heap and the stack
+func (m MemoryRegion) Anonymous() bool {
+	return m.Path == "" || strings.HasPrefix(m.Path, "[")
+}
+
+func (m MemoryRegion) String() string {
+	return fmt.Sprintf("%x-%x %s %s", m.Start, m.End, m.Perms, m.Path)
+}
+
+// AnonymousExecutable returns the regions that are both anonymous and executable, where code
+// injected into a process usually lies, but for those of the kernel such as "[vdso]"
+func AnonymousExecutable(regions []MemoryRegion) []MemoryRegion {
+	var found []MemoryRegion
+	for _, m := range regions {
+		if m.Executable() && m.Anonymous() && m.Path != "[vdso]" && m.Path != "[vsyscall]" {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+// RegionDetection is a virus found in a region of the memory of a process, or a range of it
+// that could not be read or scanned
+type RegionDetection struct {
+	Region  MemoryRegion
+	Address uint64 // of the data the signature matched, or of the range that failed
+	Length  int64
+	Virus   string
+	Err     error
+}
+
+// ScanRegions scans the given regions of the memory of process pid, as found by
+// ProcessRegions, for targeted incident response where scanning the whole process would take
+// too long. Regions are scanned as RawScanner does, with its ChunkSize, Overlap and Resolution,
+// since large mappings may hold several objects. An error is returned only if the memory of
+// the process cannot be opened, usually for lack of the ptrace permission on it.
+func (s *RawScanner) ScanRegions(pid int, regions []MemoryRegion) ([]RegionDetection, error) {
+	mem, err := openProcessMemory(pid)
+	if err != nil {
+		return nil, fmt.Errorf("ScanRegions: %v", err)
+	}
+	defer mem.Close()
+	var found []RegionDetection
+	for _, m := range regions {
+		name := fmt.Sprintf("pid%d@%x", pid, m.Start)
+		r := io.NewSectionReader(mem, int64(m.Start), int64(m.End-m.Start))
+		s.ScanReaderAt(r, r.Size(), name, func(d *RawDetection) {
+			found = append(found, RegionDetection{
+				Region:  m,
+				Address: m.Start + uint64(d.Offset),
+				Length:  d.Length,
+				Virus:   d.Virus,
+				Err:     d.Err,
+			})
+		})
+	}
+	return found, nil
+}
+
+// processMemory is the memory of a process, read at its addresses
+type processMemory interface {
+	io.ReaderAt
+	io.Closer
+}
@@ -0,0 +1,54 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProcessRegions returns the memory regions of process pid
+func ProcessRegions(pid int) ([]MemoryRegion, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, fmt.Errorf("ProcessRegions: %v", err)
+	}
+	defer f.Close()
+	var regions []MemoryRegion
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// start-end perms offset dev inode [path]
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		addrs := strings.SplitN(fields[0], "-", 2)
+		if len(addrs) != 2 {
+			continue
+		}
+		start, err1 := strconv.ParseUint(addrs[0], 16, 64)
+		end, err2 := strconv.ParseUint(addrs[1], 16, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		m := MemoryRegion{Start: start, End: end, Perms: fields[1]}
+		if len(fields) > 5 {
+			m.Path = strings.Join(fields[5:], " ")
+		}
+		regions = append(regions, m)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("ProcessRegions: %v", err)
+	}
+	return regions, nil
+}
+
+// openProcessMemory opens the memory of process pid, whose offsets are its addresses
+func openProcessMemory(pid int) (processMemory, error) {
+	return os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+}
@@ -0,0 +1,54 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestScanRegions(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	// an anonymous executable mapping holding a virus, as injected code would
+	mem, err := syscall.Mmap(-1, 0, 1<<20, syscall.PROT_READ|syscall.PROT_WRITE|syscall.PROT_EXEC, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		t.Skipf("Mmap: %v", err)
+	}
+	defer syscall.Munmap(mem)
+	copy(mem[300000:], eicar)
+	addr := uint64(uintptr(unsafe.Pointer(&mem[0])))
+
+	regions, err := ProcessRegions(os.Getpid())
+	if err != nil {
+		t.Fatalf("ProcessRegions: %v", err)
+	}
+	var target []MemoryRegion
+	for _, m := range AnonymousExecutable(regions) {
+		if m.Start <= addr && addr < m.End {
+			target = append(target, m)
+		}
+	}
+	if len(target) != 1 {
+		t.Fatalf("AnonymousExecutable: mapping at %x not found in %v", addr, regions)
+	}
+
+	s := &RawScanner{Engine: eng, Options: stdopts, ChunkSize: 256 << 10, Overlap: 4096, Resolution: 4096}
+	found, err := s.ScanRegions(os.Getpid(), target)
+	if err != nil {
+		t.Fatalf("ScanRegions: %v", err)
+	}
+	virus := addr + 300000
+	if len(found) != 1 || found[0].Virus == "" || found[0].Region != target[0] ||
+		found[0].Address >= virus+uint64(len(eicar)) || found[0].Address+uint64(found[0].Length) <= virus {
+		t.Errorf("ScanRegions: %+v, virus at %x", found, virus)
+	}
+}
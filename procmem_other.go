@@ -0,0 +1,18 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package clamav
+
+// ProcessRegions returns ErrProcessMemory: there is no /proc/pid/maps on this system
+func ProcessRegions(pid int) ([]MemoryRegion, error) {
+	return nil, ErrProcessMemory
+}
+
+// openProcessMemory returns ErrProcessMemory: there is no /proc/pid/mem on this system
+func openProcessMemory(pid int) (processMemory, error) {
+	return nil, ErrProcessMemory
+}
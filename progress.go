@@ -0,0 +1,191 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#include <clamav.h>
+#include <stdlib.h>
+
+extern off_t pread_cgo(void* handle, void *buf, size_t count, off_t offset);
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// ScanPhase is the phase of a scan started with StartScan
+type ScanPhase int
+
+// Phases of a scan, in order
+const (
+	ScanReading    ScanPhase = iota // the engine is reading the file
+	ScanInspecting                  // the file was read, the engine is scanning the objects it extracted
+	ScanDone
+)
+
+func (p ScanPhase) String() string {
+	switch p {
+	case ScanReading:
+		return "reading"
+	case ScanInspecting:
+		return "inspecting"
+	case ScanDone:
+		return "done"
+	}
+	return fmt.Sprintf("ScanPhase(%d)", int(p))
+}
+
+// ScanProgress is the progress of a scan started with StartScan
+type ScanProgress struct {
+	Phase ScanPhase
+	Bytes int64 // of the file read by the engine so far
+	Total int64 // size of the file
+}
+
+// ScanHandle is a scan running in the background, started with StartScan
+type ScanHandle struct {
+	f       *os.File
+	key     unsafe.Pointer
+	updates chan ScanProgress
+	done    chan struct{}
+
+	mu       sync.Mutex
+	progress ScanProgress
+	reported int64
+
+	virus   string
+	scanned uint
+	err     error
+}
+
+// scanHandles are the scans of StartScan, by the handle their file is mapped with
+var scanHandles = struct {
+	sync.Mutex
+	m map[unsafe.Pointer]*ScanHandle
+}{m: map[unsafe.Pointer]*ScanHandle{}}
+
+// StartScan starts scanning the file at path in the background, reading it through a map whose
+// reads report the progress of the scan. This lets applications show the progress of scans of
+// large files, such as multi-gigabyte archives, with Progress or Updates. Libclamav reports
+// nothing of the objects it extracts from the file, so the scan stays in the ScanInspecting
+// phase until they are all scanned.
+func (e *Engine) StartScan(path string, opts *ScanOptions) (*ScanHandle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("StartScan: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("StartScan: %v", err)
+	}
+	if err := checkMapSize(fi.Size()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("StartScan: %w", err)
+	}
+	key := C.malloc(1)
+	if key == nil {
+		panic("C malloc")
+	}
+	h := &ScanHandle{
+		f:        f,
+		key:      key,
+		updates:  make(chan ScanProgress, 1),
+		done:     make(chan struct{}),
+		progress: ScanProgress{Total: fi.Size()},
+	}
+	scanHandles.Lock()
+	scanHandles.m[key] = h
+	scanHandles.Unlock()
+
+	go func() {
+		defer h.finish()
+		if fi.Size() == 0 {
+			// nothing to scan
+			return
+		}
+		fmap := (*Fmap)(C.cl_fmap_open_handle(key, 0, C.size_t(fi.Size()), (C.clcb_pread)(unsafe.Pointer(C.pread_cgo)), 1))
+		if fmap == nil {
+			h.err = fmt.Errorf("StartScan: cannot map %s", path)
+			return
+		}
+		defer fmap.Close()
+		h.virus, h.scanned, h.err = e.ScanMapCb(fmap, path, opts, nil)
+	}()
+	return h, nil
+}
+
+// finish records the end of the scan
+func (h *ScanHandle) finish() {
+	scanHandles.Lock()
+	delete(scanHandles.m, h.key)
+	scanHandles.Unlock()
+	C.free(h.key)
+	h.f.Close()
+
+	h.mu.Lock()
+	h.progress.Phase = ScanDone
+	h.send()
+	h.mu.Unlock()
+	close(h.updates)
+	close(h.done)
+}
+
+// pread reads the file for the engine, recording how much of it was read
+func (h *ScanHandle) pread(buf []byte, offset int64) int64 {
+	n, err := h.f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return -1
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p := &h.progress
+	if end := offset + int64(n); end > p.Bytes {
+		p.Bytes = end
+	}
+	if p.Bytes >= p.Total && p.Phase == ScanReading {
+		p.Phase = ScanInspecting
+		h.send()
+	} else if p.Bytes > h.reported && p.Bytes-h.reported >= p.Total/100 {
+		// report every percent at most
+		h.send()
+	}
+	return int64(n)
+}
+
+// send offers the progress to Updates, replacing the progress not received yet
+func (h *ScanHandle) send() {
+	select {
+	case <-h.updates:
+	default:
+	}
+	h.updates <- h.progress
+	h.reported = h.progress.Bytes
+}
+
+// Progress returns the current progress of the scan
+func (h *ScanHandle) Progress() ScanProgress {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.progress
+}
+
+// Updates returns a channel receiving the progress of the scan as it changes. Updates not
+// received in time are replaced by later ones, the last one is of the ScanDone phase, and the
+// channel is closed once the scan completes.
+func (h *ScanHandle) Updates() <-chan ScanProgress {
+	return h.updates
+}
+
+// Wait waits for the scan to complete and returns its results, as for ScanFile
+func (h *ScanHandle) Wait() (string, uint, error) {
+	<-h.done
+	return h.virus, h.scanned, h.err
+}
@@ -0,0 +1,50 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartScan(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	data := append(bytes.Repeat([]byte("x"), 1<<20), eicar...)
+	path := filepath.Join(t.TempDir(), "large")
+	ioutil.WriteFile(path, data, 0644)
+	h, err := eng.StartScan(path, stdopts)
+	if err != nil {
+		t.Fatalf("StartScan: %v", err)
+	}
+	var last ScanProgress
+	n := 0
+	for p := range h.Updates() {
+		if p.Bytes < last.Bytes || p.Phase < last.Phase || p.Total != int64(len(data)) {
+			t.Errorf("Updates: %+v after %+v", p, last)
+		}
+		last = p
+		n++
+	}
+	if n == 0 || last.Phase != ScanDone || last.Bytes != int64(len(data)) {
+		t.Errorf("Updates: %d updates, last %+v", n, last)
+	}
+	if virus, _, err := h.Wait(); virus == "" || err == nil {
+		t.Errorf("Wait: %q %v", virus, err)
+	}
+	if p := h.Progress(); p != last {
+		t.Errorf("Progress: %+v, want %+v", p, last)
+	}
+
+	if _, err := eng.StartScan(filepath.Join(t.TempDir(), "missing"), stdopts); err == nil {
+		t.Errorf("StartScan: missing file accepted")
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ResponseScanner scans the bodies of HTTP responses passing through a reverse proxy and
+// replaces infected ones with a block page. Responses are held in memory until they have been
+// scanned, so MaxSize should be set to a value the proxy can afford per concurrent request.
+type ResponseScanner struct {
+	Scanner Scanner
+
+	// MaxSize is the largest response body that is scanned. Larger responses are passed
+	// through unscanned, or blocked if BlockOversize is set.
+	MaxSize       int64
+	BlockOversize bool
+
+	// ContentTypes restricts scanning to responses of the listed media types. An entry ending
+	// in "/" matches a whole type, e.g. "application/". All responses are scanned if empty.
+	ContentTypes []string
+
+	// BlockPage is executed with the request URL and the virus name to produce the body sent
+	// in place of an infected response. A plain default page is used if nil.
+	BlockPage *template.Template
+
+	// Detected is called, if set, for every blocked response
+	Detected func(req *http.Request, virus string)
+}
+
+// DefaultMaxResponseSize is the response size limit used when ResponseScanner.MaxSize is zero
+const DefaultMaxResponseSize = 32 << 20
+
+var defaultBlockPage = template.Must(template.New("block").Parse(`<!DOCTYPE html>
+<html><head><title>Download blocked</title></head>
+<body><h1>Download blocked</h1>
+<p>The content at {{.URL}} has been blocked because it contains {{.Virus}}.</p>
+</body></html>
+`))
+
+// NewScanningProxy returns a reverse proxy to target that scans response bodies with s
+func NewScanningProxy(target *url.URL, s *ResponseScanner) *httputil.ReverseProxy {
+	p := httputil.NewSingleHostReverseProxy(target)
+	p.ModifyResponse = s.ModifyResponse
+	return p
+}
+
+// ModifyResponse scans resp and replaces it with a block page if it is infected. It can be
+// used as the ModifyResponse hook of any httputil.ReverseProxy. Scan errors are returned, so
+// the proxy fails closed and answers with a bad gateway error.
+func (s *ResponseScanner) ModifyResponse(resp *http.Response) error {
+	if resp.Body == nil || resp.Body == http.NoBody || !s.scannable(resp.Header.Get("Content-Type")) {
+		return nil
+	}
+	max := s.MaxSize
+	if max <= 0 {
+		max = DefaultMaxResponseSize
+	}
+	if resp.ContentLength > max {
+		return s.oversize(resp, nil)
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return fmt.Errorf("ResponseScanner: reading response: %v", err)
+	}
+	if int64(len(buf)) > max {
+		return s.oversize(resp, buf)
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+
+	res, err := s.Scanner.Scan(bytes.NewReader(buf), resp.Request.URL.Path)
+	if err != nil {
+		return fmt.Errorf("ResponseScanner: %s: %v", resp.Request.URL, err)
+	}
+	if res.Virus != "" {
+		return s.block(resp, res.Virus)
+	}
+	return nil
+}
+
+// scannable reports whether responses with the given content type should be scanned
+func (s *ResponseScanner) scannable(contentType string) bool {
+	return mediaTypeAllowed(s.ContentTypes, contentType)
+}
+
+// mediaTypeAllowed reports whether contentType is of one of the media types of types, an entry
+// ending in "/" matching a whole type, or any if types is empty
+func mediaTypeAllowed(types []string, contentType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// unknown or missing content types could be anything
+		return true
+	}
+	for _, t := range types {
+		if mt == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// oversize handles a response too large to be scanned, of which buf has already been read
+func (s *ResponseScanner) oversize(resp *http.Response, buf []byte) error {
+	if s.BlockOversize {
+		return s.block(resp, "oversized content")
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), resp.Body), resp.Body}
+	return nil
+}
+
+// block replaces resp with the block page
+func (s *ResponseScanner) block(resp *http.Response, virus string) error {
+	if s.Detected != nil {
+		s.Detected(resp.Request, virus)
+	}
+	page := s.BlockPage
+	if page == nil {
+		page = defaultBlockPage
+	}
+	var body bytes.Buffer
+	err := page.Execute(&body, struct{ URL, Virus string }{resp.Request.URL.String(), virus})
+	if err != nil {
+		return fmt.Errorf("ResponseScanner: block page: %v", err)
+	}
+
+	resp.Body.Close()
+	resp.StatusCode = http.StatusForbidden
+	resp.Status = "403 " + http.StatusText(http.StatusForbidden)
+	resp.Header = http.Header{}
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header.Set("Content-Length", strconv.Itoa(body.Len()))
+	resp.Header.Set("Cache-Control", "no-store")
+	resp.ContentLength = int64(body.Len())
+	resp.Body = ioutil.NopCloser(&body)
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestScanningProxy(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	big := bytes.Repeat([]byte("a"), 2048)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/clean":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("hello"))
+		case "/eicar", "/eicar.png":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			if r.URL.Path == "/eicar.png" {
+				w.Header().Set("Content-Type", "image/png")
+			}
+			w.Write(eicar)
+		case "/big":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Write(big)
+		}
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	var detected []string
+	s := &ResponseScanner{
+		Scanner:      &EngineScanner{Engine: eng, Options: stdopts},
+		MaxSize:      1024,
+		ContentTypes: []string{"application/", "text/html"},
+		Detected:     func(r *http.Request, virus string) { detected = append(detected, r.URL.Path) },
+	}
+	proxy := httptest.NewServer(NewScanningProxy(target, s))
+	defer proxy.Close()
+
+	get := func(path string) (int, []byte) {
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, b
+	}
+
+	if code, body := get("/clean"); code != http.StatusOK || string(body) != "hello" {
+		t.Errorf("clean: %d %q", code, body)
+	}
+	if code, _ := get("/eicar"); code != http.StatusForbidden {
+		t.Errorf("eicar: status %d, want %d", code, http.StatusForbidden)
+	}
+	// not a scanned content type
+	if code, body := get("/eicar.png"); code != http.StatusOK || !bytes.Equal(body, eicar) {
+		t.Errorf("eicar.png: %d %q", code, body)
+	}
+	if code, body := get("/big"); code != http.StatusOK || !bytes.Equal(body, big) {
+		t.Errorf("big: oversized response not passed through: %d, %d bytes", code, len(body))
+	}
+	s.BlockOversize = true
+	if code, _ := get("/big"); code != http.StatusForbidden {
+		t.Errorf("big: oversized response not blocked: %d", code)
+	}
+	if len(detected) != 2 || detected[0] != "/eicar" || detected[1] != "/big" {
+		t.Errorf("Detected: %v", detected)
+	}
+}
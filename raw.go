@@ -0,0 +1,137 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Defaults of RawScanner
+const (
+	DefaultRawChunkSize = 16 << 20
+	DefaultRawOverlap   = 1 << 20
+)
+
+// RawScanner scans raw block devices and disk or partition images in chunks, without mounting
+// them, for the forensic triage of disks whose file systems cannot be trusted or read. Each
+// chunk is scanned as an object of its own, so that files are found by the signatures and
+// embedded type detection of their content, wherever they lie on the disk.
+type RawScanner struct {
+	Engine  *Engine
+	Options *ScanOptions
+
+	// ChunkSize is the size of the chunks scanned, DefaultRawChunkSize if zero
+	ChunkSize int64
+
+	// Overlap is the size of the data scanned at the end of a chunk and again at the start of
+	// the next one, so that objects across the boundary are found whole in one of them;
+	// DefaultRawOverlap if zero
+	Overlap int64
+
+	// Resolution, if not zero, narrows down detections by scanning halves of their range, as
+	// long as the virus is found in one of them, to ranges of about Resolution bytes holding
+	// the data the signature matched
+	Resolution int64
+}
+
+// RawDetection locates a virus on a disk, or a range that could not be read or scanned
+type RawDetection struct {
+	Offset int64
+	Length int64
+	Virus  string // virus name, empty if the range could not be read or scanned
+	Err    error
+}
+
+// Scan scans the block device or image at path, calling report for every detection and every
+// range that could not be read or scanned, in increasing order of offset. Scanning continues
+// past such ranges; an error is returned only if path cannot be opened.
+func (s *RawScanner) Scan(path string, report func(*RawDetection)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("RawScanner: %v", err)
+	}
+	defer f.Close()
+	// block devices have no size of their own, their end is found by seeking
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("RawScanner: %v", err)
+	}
+	s.ScanReaderAt(f, size, path, report)
+	return nil
+}
+
+// ScanReaderAt scans the size bytes of r, named name for the scan callbacks, as Scan does
+func (s *RawScanner) ScanReaderAt(r io.ReaderAt, size int64, name string, report func(*RawDetection)) {
+	chunk, overlap := s.ChunkSize, s.Overlap
+	if chunk <= 0 {
+		chunk = DefaultRawChunkSize
+	}
+	if overlap <= 0 {
+		overlap = DefaultRawOverlap
+	}
+	if overlap >= chunk {
+		overlap = chunk / 2
+	}
+
+	var last *RawDetection
+	buf := make([]byte, chunk)
+	for off := int64(0); off < size; off += chunk - overlap {
+		n := chunk
+		if size-off < n {
+			n = size - off
+		}
+		d := s.scanChunk(r, buf[:n], off, name)
+		// a virus in the overlap is found in both chunks
+		if d != nil && (last == nil || d.Virus != last.Virus || d.Virus == "" || d.Offset >= last.Offset+last.Length) {
+			report(d)
+			last = d
+		}
+		if off+n >= size {
+			break
+		}
+	}
+}
+
+// scanChunk scans the chunk of len(buf) bytes at off of r, returning what it found, if
+// anything
+func (s *RawScanner) scanChunk(r io.ReaderAt, buf []byte, off int64, name string) *RawDetection {
+	d := &RawDetection{Offset: off, Length: int64(len(buf))}
+	if _, err := r.ReadAt(buf, off); err != nil && err != io.EOF {
+		d.Err = err
+		return d
+	}
+	fmap := FmapOpenMemory(buf)
+	if fmap == nil {
+		return nil
+	}
+	defer fmap.Close()
+	virus, _, err := s.Engine.ScanMapCb(fmap, name, s.Options, nil)
+	if virus == "" {
+		if err != nil {
+			d.Err = err
+			return d
+		}
+		return nil
+	}
+	d.Virus = virus
+
+	// narrow down the range while the virus is found in one half of it
+	lo, hi := int64(0), int64(len(buf))
+	for s.Resolution > 0 && hi-lo > s.Resolution {
+		mid := lo + (hi-lo)/2
+		if v, _, _ := s.Engine.ScanMapRange(fmap, lo, mid-lo, name, s.Options, nil); v == virus {
+			hi = mid
+		} else if v, _, _ := s.Engine.ScanMapRange(fmap, mid, hi-mid, name, s.Options, nil); v == virus {
+			lo = mid
+		} else {
+			// the virus spans both halves
+			break
+		}
+	}
+	d.Offset, d.Length = off+lo, hi-lo
+	return d
+}
@@ -0,0 +1,67 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// failingReaderAt fails to read the range [bad, bad+1M)
+type failingReaderAt struct {
+	data []byte
+	bad  int64
+}
+
+func (r failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.bad+1<<20 && off+int64(len(p)) > r.bad {
+		return 0, errors.New("bad sector")
+	}
+	return copy(p, r.data[off:]), nil
+}
+
+func TestRawScanner(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	// one virus well inside a chunk, one across the boundary of the first two chunks
+	disk := make([]byte, 8<<20)
+	at := []int64{3<<20 + 12345, 1<<20 - 10}
+	for _, o := range at {
+		copy(disk[o:], eicar)
+	}
+	path := filepath.Join(t.TempDir(), "disk.img")
+	ioutil.WriteFile(path, disk, 0644)
+
+	s := &RawScanner{Engine: eng, Options: stdopts, ChunkSize: 1 << 20, Overlap: 64 << 10, Resolution: 4096}
+	var found []*RawDetection
+	if err := s.Scan(path, func(d *RawDetection) { found = append(found, d) }); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("Scan: %d detections, want 2: %+v", len(found), found)
+	}
+	for i, d := range found {
+		o := at[len(at)-1-i]
+		if d.Virus == "" || d.Offset >= o+int64(len(eicar)) || d.Offset+d.Length <= o || d.Length > 2*4096 {
+			t.Errorf("Scan: %+v for the virus at %d", d, o)
+		}
+	}
+
+	found = nil
+	s.ScanReaderAt(failingReaderAt{disk, 6 << 20}, int64(len(disk)), "disk", func(d *RawDetection) { found = append(found, d) })
+	if len(found) < 3 || found[2].Err == nil || found[2].Virus != "" {
+		t.Errorf("ScanReaderAt: %+v", found)
+	}
+
+	if err := s.Scan(filepath.Join(t.TempDir(), "missing"), nil); err == nil {
+		t.Errorf("Scan: missing device accepted")
+	}
+}
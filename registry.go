@@ -0,0 +1,326 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ImageRegistry is a registry speaking the OCI distribution API, such as Docker Hub, that
+// ScanRegistryImage pulls images from
+type ImageRegistry struct {
+	// Client fetches manifests, tokens and layers, one without a time limit if nil: layers
+	// can take long to download, bound pulls with the context of ScanRegistryImage instead
+	Client *http.Client
+
+	// Username and Password, if set, authenticate with the registry or its token service;
+	// pulls are anonymous otherwise
+	Username, Password string
+
+	// PlainHTTP pulls over HTTP rather than HTTPS, for registries on the local network
+	PlainHTTP bool
+}
+
+// DockerHubRegistry is the registry of references without a registry host, such as "alpine"
+const DockerHubRegistry = "registry-1.docker.io"
+
+// Media types of the manifests a registry may return
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// imageRepository matches the repository names of the distribution API
+var imageRepository = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+// ScanRegistryImage pulls the image ref, such as "alpine:3.19", "ghcr.io/org/app@sha256:..."
+// or "registry.local:5000/app", from reg, or anonymously from its registry over HTTPS if reg
+// is nil, and scans every regular file of every layer as ScanImage does. Layers are streamed
+// to the scan rather than stored, and checked against their digest. Every platform of
+// multi-platform images is scanned, each layer once. Detections are reported with ref as
+// the image and the digest of the layer.
+func (e *Engine) ScanRegistryImage(ctx context.Context, reg *ImageRegistry, ref string, opts *ScanOptions) ([]ImageDetection, error) {
+	if reg == nil {
+		reg = &ImageRegistry{}
+	}
+	host, repo, reference, err := parseImageRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("ScanRegistryImage: %v", err)
+	}
+	p := &registryPull{reg: reg, ctx: ctx, host: host, repo: repo}
+	var layers []string
+	seen := map[string]bool{}
+	if err := p.layers(reference, 0, func(digest string) {
+		if !seen[digest] {
+			seen[digest] = true
+			layers = append(layers, digest)
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("ScanRegistryImage: %s: %v", ref, err)
+	}
+
+	var found []ImageDetection
+	for _, digest := range layers {
+		err := p.scanBlob(e, digest, opts, func(name, virus string) {
+			found = append(found, ImageDetection{Image: ref, Layer: digest, Path: name, Virus: virus})
+		})
+		if err != nil {
+			return found, fmt.Errorf("ScanRegistryImage: %s: %v", digest, err)
+		}
+	}
+	return found, nil
+}
+
+// parseImageRef splits an image reference into the registry host, the repository and the tag
+// or digest, completing the references of Docker Hub as docker does
+func parseImageRef(ref string) (host, repo, reference string, err error) {
+	name := ref
+	reference = "latest"
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+		if _, err := ociBlob(reference); err != nil {
+			return "", "", "", err
+		}
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, reference = name[:i], name[i+1:]
+	}
+	host = DockerHubRegistry
+	if i := strings.Index(name, "/"); i > 0 && (strings.ContainsAny(name[:i], ".:") || name[:i] == "localhost") {
+		host, name = name[:i], name[i+1:]
+	} else if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if !imageRepository.MatchString(name) || reference == "" || strings.ContainsAny(reference, "/?#") {
+		return "", "", "", fmt.Errorf("invalid image reference %q", ref)
+	}
+	return host, name, reference, nil
+}
+
+// registryPull is the state of the pull of an image
+type registryPull struct {
+	reg   *ImageRegistry
+	ctx   context.Context
+	host  string
+	repo  string
+	token string // bearer token, once obtained
+}
+
+// layers calls add with the digests of the layers of the manifest reference, following the
+// manifests of image indexes to a limited depth
+func (p *registryPull) layers(reference string, depth int, add func(digest string)) error {
+	if depth > 2 {
+		return errors.New("image indexes nested too deep")
+	}
+	resp, err := p.get("/manifests/"+reference, strings.Join(manifestMediaTypes, ", "))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImageMetadata))
+	if err != nil {
+		return err
+	}
+	var m ociManifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return fmt.Errorf("manifest %s: %v", reference, err)
+	}
+	for _, d := range m.Manifests {
+		if _, err := ociBlob(d.Digest); err != nil {
+			return err
+		}
+		if err := p.layers(d.Digest, depth+1, add); err != nil {
+			return err
+		}
+	}
+	for _, l := range m.Layers {
+		if _, err := ociBlob(l.Digest); err != nil {
+			return err
+		}
+		add(l.Digest)
+	}
+	return nil
+}
+
+// scanBlob downloads the layer digest and scans it, failing if its content does not match
+// the digest
+func (p *registryPull) scanBlob(e *Engine, digest string, opts *ScanOptions, detected func(name, virus string)) error {
+	var h hash.Hash
+	if strings.HasPrefix(digest, "sha256:") {
+		h = sha256.New()
+	}
+	resp, err := p.get("/blobs/"+digest, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var r io.Reader = resp.Body
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+	if err := e.scanLayer(r, opts, detected); err != nil {
+		return err
+	}
+	if h == nil {
+		return nil
+	}
+	// the end of the tar archive may be followed by padding
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	if sum := "sha256:" + hex.EncodeToString(h.Sum(nil)); sum != digest {
+		return fmt.Errorf("content does not match the digest, got %s", sum)
+	}
+	return nil
+}
+
+// get fetches path under the repository, authenticating as the registry asks
+func (p *registryPull) get(path, accept string) (*http.Response, error) {
+	scheme := "https"
+	if p.reg.PlainHTTP {
+		scheme = "http"
+	}
+	u := scheme + "://" + p.host + "/v2/" + p.repo + path
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(p.ctx)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if p.token != "" {
+			req.Header.Set("Authorization", "Bearer "+p.token)
+		} else if p.reg.Username != "" {
+			req.SetBasicAuth(p.reg.Username, p.reg.Password)
+		}
+		resp, err := p.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := p.authenticate(challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", path, resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+// authenticate obtains a bearer token from the token service named by challenge, the
+// WWW-Authenticate header of a refused request
+func (p *registryPull) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch {
+	case strings.EqualFold(scheme, "basic") && p.reg.Username != "":
+		return errors.New("registry refused the credentials")
+	case !strings.EqualFold(scheme, "bearer") || params["realm"] == "":
+		return fmt.Errorf("unsupported authentication %q", challenge)
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || (realm.Scheme != "https" && realm.Scheme != "http") {
+		return fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	q := realm.Query()
+	for _, k := range []string{"service", "scope"} {
+		if v := params[k]; v != "" {
+			q.Set(k, v)
+		}
+	}
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(p.ctx)
+	if p.reg.Username != "" {
+		req.SetBasicAuth(p.reg.Username, p.reg.Password)
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token: %s", resp.Status)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxImageMetadata)).Decode(&tok); err != nil {
+		return fmt.Errorf("token: %v", err)
+	}
+	if p.token = tok.Token; p.token == "" {
+		p.token = tok.AccessToken
+	}
+	if p.token == "" {
+		return errors.New("token: none issued")
+	}
+	return nil
+}
+
+func (p *registryPull) client() *http.Client {
+	if p.reg.Client != nil {
+		return p.reg.Client
+	}
+	return http.DefaultClient
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and parameters
+func parseChallenge(h string) (string, map[string]string) {
+	h = strings.TrimSpace(h)
+	i := strings.IndexByte(h, ' ')
+	if i < 0 {
+		return h, nil
+	}
+	scheme, rest := h[:i], h[i+1:]
+	params := map[string]string{}
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				val, rest = rest[1:], ""
+			} else {
+				val, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			val, rest = rest[:comma], rest[comma:]
+		} else {
+			val, rest = rest, ""
+		}
+		params[key] = val
+	}
+	return scheme, params
+}
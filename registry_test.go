@@ -0,0 +1,126 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImageRef(t *testing.T) {
+	for _, c := range []struct {
+		ref, host, repo, reference string
+	}{
+		{"alpine", DockerHubRegistry, "library/alpine", "latest"},
+		{"alpine:3.19", DockerHubRegistry, "library/alpine", "3.19"},
+		{"org/app", DockerHubRegistry, "org/app", "latest"},
+		{"ghcr.io/org/app@sha256:abcd", "ghcr.io", "org/app", "sha256:abcd"},
+		{"localhost:5000/app:v1", "localhost:5000", "app", "v1"},
+		{"localhost/app", "localhost", "app", "latest"},
+	} {
+		host, repo, reference, err := parseImageRef(c.ref)
+		if err != nil || host != c.host || repo != c.repo || reference != c.reference {
+			t.Errorf("parseImageRef(%q) = %q, %q, %q, %v", c.ref, host, repo, reference, err)
+		}
+	}
+	for _, ref := range []string{"", "App", "app@latest", "app@sha256:a/b", "host.io/../app"} {
+		if _, _, _, err := parseImageRef(ref); err == nil {
+			t.Errorf("parseImageRef(%q) accepted", ref)
+		}
+	}
+}
+
+// testRegistry serves an image index of two platforms sharing a layer, behind a token service
+func testRegistry(t *testing.T, blobs map[string][]byte) *httptest.Server {
+	digest := func(b []byte) string { return fmt.Sprintf("sha256:%x", sha256.Sum256(b)) }
+	var layers []string
+	for _, name := range []string{"base", "app"} {
+		d := digest(blobs[name])
+		blobs[d] = blobs[name]
+		layers = append(layers, fmt.Sprintf(`{"digest": %q}`, d))
+	}
+	manifest := []byte(`{"layers": [` + strings.Join(layers, ", ") + `]}`)
+	other := []byte(`{"layers": [` + layers[0] + `]}`)
+	blobs[digest(manifest)], blobs[digest(other)] = manifest, other
+	index := []byte(fmt.Sprintf(`{"manifests": [{"digest": %q}, {"digest": %q}]}`, digest(manifest), digest(other)))
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:org/app:pull" {
+				http.Error(w, "bad scope", http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:org/app:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch p := strings.TrimPrefix(r.URL.Path, "/v2/org/app/"); {
+		case p == "manifests/v1":
+			w.Write(index)
+		case strings.HasPrefix(p, "manifests/"):
+			w.Write(blobs[strings.TrimPrefix(p, "manifests/")])
+		case strings.HasPrefix(p, "blobs/") && blobs[strings.TrimPrefix(p, "blobs/")] != nil:
+			w.Write(blobs[strings.TrimPrefix(p, "blobs/")])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv
+}
+
+func TestScanRegistryImage(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	base := writeTar(t, []tarEntry{{name: "bin/sh", body: []byte("clean")}})
+	app := gzipBytes(t, writeTar(t, []tarEntry{{name: "srv/eicar.com", body: eicar}}))
+	srv := testRegistry(t, map[string][]byte{"base": base, "app": app})
+	defer srv.Close()
+
+	reg := &ImageRegistry{PlainHTTP: true}
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/org/app:v1"
+	found, err := eng.ScanRegistryImage(context.Background(), reg, ref, stdopts)
+	if err != nil {
+		t.Fatalf("ScanRegistryImage: %v", err)
+	}
+	want := []ImageDetection{{Image: ref, Layer: fmt.Sprintf("sha256:%x", sha256.Sum256(app)), Path: "srv/eicar.com", Virus: "Eicar-Test-Signature"}}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("ScanRegistryImage: %+v, want %+v", found, want)
+	}
+
+	if _, err := eng.ScanRegistryImage(context.Background(), reg, strings.TrimPrefix(srv.URL, "http://")+"/org/app:v2", stdopts); err == nil {
+		t.Errorf("ScanRegistryImage: missing tag pulled")
+	}
+}
+
+func TestScanRegistryImageDigest(t *testing.T) {
+	eng := New()
+	defer eng.Free()
+
+	layer := writeTar(t, []tarEntry{{name: "bin/sh", body: []byte("clean")}})
+	blobs := map[string][]byte{"base": layer, "app": layer}
+	srv := testRegistry(t, blobs)
+	defer srv.Close()
+	// a layer served with content other than its digest
+	blobs[fmt.Sprintf("sha256:%x", sha256.Sum256(layer))] = writeTar(t, []tarEntry{{name: "bin/sh", body: []byte("tampered")}})
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/org/app:v1"
+	if _, err := eng.ScanRegistryImage(context.Background(), &ImageRegistry{PlainHTTP: true}, ref, stdopts); err == nil || !strings.Contains(err.Error(), "digest") {
+		t.Errorf("ScanRegistryImage: tampered layer: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// LogFile is a log file that can be reopened, so that it can be rotated by renaming it
+type LogFile struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// OpenLogFile opens the log file at path for appending, creating it if needed
+func OpenLogFile(path string) (*LogFile, error) {
+	l := &LogFile{path: path}
+	if err := l.Reopen(); err != nil {
+		return nil, fmt.Errorf("OpenLogFile: %v", err)
+	}
+	return l, nil
+}
+
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Write(p)
+}
+
+// Reopen closes the log file and opens its path again, keeping the file open if that fails
+func (l *LogFile) Reopen() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.f
+	l.f = f
+	l.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Close closes the log file
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Reloader is the reload path of a service: it reads its clamd.conf again, reopens its logs
+// and reloads its databases through its EnginePool, as clamd does on SIGHUP. Connections are
+// not dropped: the scans in progress finish with the engine they started with, and the new
+// configuration applies to the scans started after the reload.
+type Reloader struct {
+	// ConfigPath is the clamd.conf read on every reload, none if empty
+	ConfigPath string
+
+	// Pool is reloaded, with the scan options of the configuration if there is one. Its
+	// builder should be the one of the reloader, see EngineBuilder, for the other settings of
+	// the configuration to apply to the new engine.
+	Pool *EnginePool
+
+	// Logs are reopened
+	Logs []*LogFile
+
+	// OnConfig, if not nil, is called with every configuration read, for the settings of the
+	// servers of the service such as IdleTimeout. A configuration OnConfig fails on is not used.
+	OnConfig func(*ClamdConfig) error
+
+	// OnReload, if not nil, is called after every reload triggered by a signal, with its error
+	OnReload func(error)
+
+	reload sync.Mutex // serializes reloads
+	mu     sync.Mutex
+	config *ClamdConfig
+}
+
+// Config returns the configuration last read, reading it if it was not yet
+func (r *Reloader) Config() (*ClamdConfig, error) {
+	r.mu.Lock()
+	c := r.config
+	r.mu.Unlock()
+	if c != nil || r.ConfigPath == "" {
+		return c, nil
+	}
+	c, err := ReadClamdConfig(r.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if r.config == nil {
+		r.config = c
+	}
+	c = r.config
+	r.mu.Unlock()
+	return c, nil
+}
+
+// EngineBuilder returns a builder loading the databases of the configuration last read with
+// its settings, for the EnginePool of the reloader
+func (r *Reloader) EngineBuilder() EngineBuilder {
+	return func() (*Engine, uint, error) {
+		c, err := r.Config()
+		if err != nil {
+			return nil, 0, err
+		}
+		if c == nil {
+			return LoadEngine(DBDir(), DbStdopt, nil)()
+		}
+		return LoadEngine(c.DatabaseDirectory, c.DBOptions, c.Apply)()
+	}
+}
+
+// Reload reads the configuration, reopens the logs and reloads the databases. A configuration
+// that cannot be read is not used, but the other steps are still taken, so that a typo in
+// clamd.conf does not hold back database updates; the first error is returned.
+func (r *Reloader) Reload() error {
+	r.reload.Lock()
+	defer r.reload.Unlock()
+	var first error
+	fail := func(step string, err error) {
+		if first == nil {
+			first = fmt.Errorf("Reload: %s: %v", step, err)
+		}
+	}
+
+	if r.ConfigPath != "" {
+		c, err := ReadClamdConfig(r.ConfigPath)
+		if err == nil && r.OnConfig != nil {
+			err = r.OnConfig(c)
+		}
+		if err != nil {
+			fail("configuration", err)
+		} else {
+			r.mu.Lock()
+			r.config = c
+			r.mu.Unlock()
+		}
+	}
+	for _, l := range r.Logs {
+		if err := l.Reopen(); err != nil {
+			fail("logs", err)
+		}
+	}
+	if r.Pool != nil {
+		if err := r.Pool.Reload(); err != nil {
+			fail("databases", err)
+		} else if c, _ := r.Config(); c != nil {
+			opts := c.Options
+			r.Pool.SetOptions(&opts)
+		}
+	}
+	return first
+}
+
+// HandleSignals reloads on SIGHUP until stop is called
+func (r *Reloader) HandleSignals() (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				err := r.Reload()
+				if r.OnReload != nil {
+					r.OnReload(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
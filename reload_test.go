@@ -0,0 +1,71 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "clamd.conf")
+	write := func(s string) {
+		if err := ioutil.WriteFile(conf, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(fmt.Sprintf("DatabaseDirectory %s\nStreamMaxLength 10M\n", DBDir()))
+	logPath := filepath.Join(dir, "clamd.log")
+	log, err := OpenLogFile(logPath)
+	if err != nil {
+		t.Fatalf("OpenLogFile: %v", err)
+	}
+	defer log.Close()
+
+	var streamMax int64
+	r := &Reloader{
+		ConfigPath: conf,
+		Logs:       []*LogFile{log},
+		OnConfig:   func(c *ClamdConfig) error { streamMax = c.StreamMaxLength; return nil },
+	}
+	p, err := NewEnginePool(r.EngineBuilder(), nil, stdopts)
+	if err != nil {
+		t.Fatalf("NewEnginePool: %v", err)
+	}
+	defer p.Close()
+	r.Pool = p
+
+	// rotated by renaming, as logrotate does
+	fmt.Fprintln(log, "before")
+	os.Rename(logPath, logPath+".1")
+	write(fmt.Sprintf("DatabaseDirectory %s\nStreamMaxLength 20M\n", DBDir()))
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	fmt.Fprintln(log, "after")
+	if b, _ := ioutil.ReadFile(logPath); string(b) != "after\n" {
+		t.Errorf("Reload: log %q, want the lines written after the reload", b)
+	}
+	if streamMax != 20<<20 || p.Status().Reloads != 1 {
+		t.Errorf("Reload: StreamMaxLength %d, status %+v", streamMax, p.Status())
+	}
+	if res, err := p.Scan(bytes.NewReader(eicar), "eicar.com"); err != nil || res.Virus == "" {
+		t.Errorf("Scan: %+v, %v", res, err)
+	}
+
+	// a broken configuration is not used, the databases are still reloaded
+	write("StreamMaxLength lots\n")
+	if err := r.Reload(); err == nil {
+		t.Errorf("Reload: broken configuration accepted")
+	}
+	if c, _ := r.Config(); c.StreamMaxLength != 20<<20 || p.Status().Reloads != 2 {
+		t.Errorf("Reload: configuration %+v, status %+v", c, p.Status())
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Action is what is done to a file a virus was found in
+type Action int
+
+// Actions of a RemediationPolicy
+const (
+	ActionReport     Action = iota // leave the file alone
+	ActionQuarantine               // move the file into the quarantine directory
+	ActionDelete                   // remove the file
+	ActionStrip                    // remove all permissions from the file
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionReport:
+		return "report"
+	case ActionQuarantine:
+		return "quarantine"
+	case ActionDelete:
+		return "delete"
+	case ActionStrip:
+		return "strip"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// ErrFileReplaced is the error of the actions not taken because the path of a file no longer
+// names the file scanned
+var ErrFileReplaced = errors.New("file replaced since it was scanned")
+
+// RemediationRule applies an action to the detections of viruses whose names match
+type RemediationRule struct {
+	// Match is a pattern, as of path.Match, of the names of the viruses the rule applies to,
+	// such as "PUA.*" or "Heuristics.Encrypted.*"; the rule applies to all if empty
+	Match  string
+	Action Action
+}
+
+// RemediationPolicy decides what is done to the files viruses are found in, by the category
+// of the virus, as told by its name. Actions are taken on the descriptor the file was scanned
+// through, so that they apply to the file scanned even if its path is swapped meanwhile: its
+// content is quarantined from the descriptor, its permissions are removed from the descriptor,
+// and its name is only removed, from the directory it is in, if it still names the file
+// scanned rather than another file or a symbolic link.
+type RemediationPolicy struct {
+	// Rules are tried in order, the first one matching applies; files matching none are only
+	// reported
+	Rules []RemediationRule
+
+	// QuarantineDir is the directory quarantined files are moved into, under their SHA256
+	// hash. It is required by ActionQuarantine.
+	QuarantineDir string
+}
+
+// Remediation is the action taken on a file
+type Remediation struct {
+	Action Action
+	Dest   string // for ActionQuarantine, the path the file was moved to
+	Err    error  // the action failed, in part or in full
+}
+
+// Action returns the action of the first rule matching virus
+func (p *RemediationPolicy) Action(virus string) Action {
+	for _, r := range p.Rules {
+		if ok, _ := path.Match(r.Match, virus); ok || r.Match == "" {
+			return r.Action
+		}
+	}
+	return ActionReport
+}
+
+// Remediate takes the action of the policy for virus on the file open as f, found at name,
+// which must be the descriptor the file was scanned through
+func (p *RemediationPolicy) Remediate(f *os.File, name, virus string) *Remediation {
+	m := &Remediation{Action: p.Action(virus)}
+	switch m.Action {
+	case ActionQuarantine:
+		m.Dest, m.Err = p.quarantine(f)
+		if m.Err == nil {
+			m.Err = unlinkScanned(f, name)
+		}
+	case ActionDelete:
+		m.Err = unlinkScanned(f, name)
+	case ActionStrip:
+		m.Err = f.Chmod(0)
+	}
+	if m.Err != nil {
+		m.Err = fmt.Errorf("Remediate: %s %s: %w", m.Action, name, m.Err)
+	}
+	return m
+}
+
+// quarantine copies the content of f into the quarantine directory, returning its path there
+func (p *RemediationPolicy) quarantine(f *os.File) (string, error) {
+	if p.QuarantineDir == "" {
+		return "", errors.New("no quarantine directory")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := newHasher()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, fi.Size())); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(p.QuarantineDir, h.sum().SHA256)
+	q, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if os.IsExist(err) {
+		// the same content was quarantined already
+		return dest, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(q, io.NewSectionReader(f, 0, fi.Size()))
+	if err == nil {
+		err = q.Sync()
+	}
+	if cerr := q.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	return dest, nil
+}
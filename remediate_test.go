@@ -0,0 +1,104 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRemediationPolicy(t *testing.T) {
+	p := &RemediationPolicy{Rules: []RemediationRule{
+		{Match: "PUA.*", Action: ActionReport},
+		{Match: "Heuristics.Encrypted.*", Action: ActionStrip},
+		{Action: ActionQuarantine},
+	}}
+	for virus, want := range map[string]Action{
+		"PUA.Win.Tool.Packed":         ActionReport,
+		"Heuristics.Encrypted.Zip":    ActionStrip,
+		"Win.Test.EICAR_HDB-1":        ActionQuarantine,
+		"Heuristics.Phishing.Email.1": ActionQuarantine,
+	} {
+		if got := p.Action(virus); got != want {
+			t.Errorf("Action(%q): %v, want %v", virus, got, want)
+		}
+	}
+	if got := (&RemediationPolicy{}).Action("Win.Test.EICAR_HDB-1"); got != ActionReport {
+		t.Errorf("Action: no rules: %v", got)
+	}
+}
+
+func TestDirScannerRemediation(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir, qdir := t.TempDir(), t.TempDir()
+	writeTree(t, dir, map[string][]byte{"eicar.com": eicar, "clean.txt": []byte("hello")})
+	p := &RemediationPolicy{Rules: []RemediationRule{{Action: ActionQuarantine}}, QuarantineDir: qdir}
+	var res *DirResult
+	_, err = (&DirScanner{Engine: eng, Options: stdopts, Remediation: p}).Scan(dir, func(r *DirResult) {
+		if r.Virus != "" {
+			res = r
+		} else if r.Remediation != nil {
+			t.Errorf("Scan: clean file remediated: %+v", r)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if res == nil || res.Remediation == nil || res.Remediation.Err != nil || res.Remediation.Action != ActionQuarantine {
+		t.Fatalf("Scan: %+v", res)
+	}
+	if _, err := os.Stat(res.Path); !os.IsNotExist(err) {
+		t.Errorf("Scan: quarantined file still in place: %v", err)
+	}
+	if b, err := ioutil.ReadFile(res.Remediation.Dest); err != nil || string(b) != string(eicar) {
+		t.Errorf("Scan: quarantined %q: %v", b, err)
+	}
+}
+
+func TestRemediate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sample")
+	ioutil.WriteFile(path, eicar, 0644)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	m := (&RemediationPolicy{Rules: []RemediationRule{{Action: ActionStrip}}}).Remediate(f, path, "Win.Test.EICAR_HDB-1")
+	if fi, err := os.Stat(path); m.Err != nil || err != nil || fi.Mode().Perm()&0222 != 0 {
+		t.Errorf("Remediate: strip: %+v %v", m, err)
+	}
+	m = (&RemediationPolicy{Rules: []RemediationRule{{Action: ActionQuarantine}}}).Remediate(f, path, "Win.Test.EICAR_HDB-1")
+	if m.Err == nil {
+		t.Errorf("Remediate: quarantine without a directory: %+v", m)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// the path is swapped for another file after the scan
+	other := filepath.Join(dir, "other")
+	ioutil.WriteFile(other, []byte("innocent"), 0644)
+	if err := os.Rename(other, path); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	m = (&RemediationPolicy{Rules: []RemediationRule{{Action: ActionDelete}}}).Remediate(f, path, "Win.Test.EICAR_HDB-1")
+	if !errors.Is(m.Err, ErrFileReplaced) {
+		t.Errorf("Remediate: delete of a replaced file: %+v", m)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Remediate: replaced file removed: %v", err)
+	}
+}
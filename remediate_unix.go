@@ -0,0 +1,57 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package clamav
+
+/*
+#include <errno.h>
+#include <fcntl.h>
+#include <stdlib.h>
+#include <sys/stat.h>
+#include <unistd.h>
+
+// unlink_same removes name from the directory dirfd if it names the file open as fd, failing
+// with ESTALE otherwise
+static int unlink_same(int dirfd, const char *name, int fd)
+{
+	struct stat a, b;
+	if (fstat(fd, &a) < 0 || fstatat(dirfd, name, &b, AT_SYMLINK_NOFOLLOW) < 0)
+		return -1;
+	if (a.st_dev != b.st_dev || a.st_ino != b.st_ino) {
+		errno = ESTALE;
+		return -1;
+	}
+	return unlinkat(dirfd, name, 0);
+}
+*/
+import "C"
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// unlinkScanned removes name if it still names the file open as f, from a descriptor of its
+// directory
+func unlinkScanned(f *os.File, name string) error {
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	cname := C.CString(filepath.Base(name))
+	defer C.free(unsafe.Pointer(cname))
+	if n, err := C.unlink_same(C.int(dir.Fd()), cname, C.int(f.Fd())); n < 0 {
+		if err == syscall.ESTALE {
+			return ErrFileReplaced
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "os"
+
+// unlinkScanned removes name if it still names the file open as f. Windows cannot remove files
+// by descriptor, so a file swapped in between the check and the removal is removed instead.
+func unlinkScanned(f *os.File, name string) error {
+	a, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	b, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(a, b) {
+		return ErrFileReplaced
+	}
+	return os.Remove(name)
+}
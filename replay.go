@@ -0,0 +1,126 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Corpus holds the content of the objects of stored verdicts, by SHA-256
+type Corpus interface {
+	// Open returns the content of the object of the given SHA-256, an error satisfying
+	// os.IsNotExist if the corpus does not have it
+	Open(sha256 string) (io.ReadCloser, error)
+}
+
+// CorpusDir is a directory of objects named by their SHA-256 in hex, such as the quarantine
+// directory of a RemediationPolicy
+type CorpusDir string
+
+// Open opens the file of the object of the given SHA-256
+func (d CorpusDir) Open(sha256 string) (io.ReadCloser, error) {
+	if len(sha256) != 64 || filepath.Base(sha256) != sha256 {
+		return nil, fmt.Errorf("CorpusDir: %q: not a SHA-256", sha256)
+	}
+	return os.Open(filepath.Join(string(d), sha256))
+}
+
+// Kinds of VerdictChange
+const (
+	VerdictAdded   = "added"   // a virus is found in an object found clean before
+	VerdictDropped = "dropped" // no virus is found in an object found infected before
+	VerdictRenamed = "renamed" // another virus is found
+	VerdictFailed  = "failed"  // the object cannot be scanned any more
+)
+
+// VerdictChange is an object whose verdict changed when replayed
+type VerdictChange struct {
+	Kind   string
+	SHA256 string
+	Target string // name the object was last scanned under
+	Before string // virus found before, empty if none
+	After  string // virus found now, empty if none
+	Error  string `json:",omitempty"` // of the scan, for VerdictFailed
+}
+
+// ReplayReport is the outcome of a replay
+type ReplayReport struct {
+	Replayed  int // objects scanned again
+	Unchanged int
+	Missing   int // objects the corpus does not have, or verdicts without hash
+	Changes   []VerdictChange
+}
+
+// Replay scans again the objects of the verdicts found in the corpus with s, for example a
+// scanner of an engine with a database update, and reports the objects whose verdict changed,
+// so that detection regressions can be reviewed before the update is promoted. The last
+// verdict on every object is the one compared; objects that could not be scanned then are
+// replayed, but have no verdict to compare. Changes are sorted by kind and hash.
+func Replay(s Scanner, corpus Corpus, verdicts []StoredResult) (*ReplayReport, error) {
+	last := map[string]StoredResult{}
+	var hashes []string
+	rep := &ReplayReport{}
+	for _, v := range verdicts {
+		if v.SHA256 == "" {
+			rep.Missing++
+			continue
+		}
+		if _, ok := last[v.SHA256]; !ok {
+			hashes = append(hashes, v.SHA256)
+		}
+		last[v.SHA256] = v
+	}
+
+	for _, h := range hashes {
+		v := last[h]
+		r, err := corpus.Open(h)
+		if os.IsNotExist(err) {
+			rep.Missing++
+			continue
+		}
+		if err != nil {
+			return rep, fmt.Errorf("Replay: %v", err)
+		}
+		res, err := s.Scan(r, v.Target)
+		r.Close()
+		rep.Replayed++
+
+		c := VerdictChange{SHA256: h, Target: v.Target, Before: v.Virus}
+		if res != nil {
+			c.After = res.Virus
+		}
+		switch {
+		case err != nil && c.After == "":
+			if v.Error != "" {
+				rep.Unchanged++
+				continue
+			}
+			c.Kind, c.Error = VerdictFailed, err.Error()
+		case v.Error != "":
+			// the object could not be scanned before, there is no verdict to compare
+			rep.Unchanged++
+			continue
+		case c.Before == c.After:
+			rep.Unchanged++
+			continue
+		case c.Before == "":
+			c.Kind = VerdictAdded
+		case c.After == "":
+			c.Kind = VerdictDropped
+		default:
+			c.Kind = VerdictRenamed
+		}
+		rep.Changes = append(rep.Changes, c)
+	}
+	sort.Slice(rep.Changes, func(i, j int) bool {
+		a, b := rep.Changes[i], rep.Changes[j]
+		return a.Kind < b.Kind || a.Kind == b.Kind && a.SHA256 < b.SHA256
+	})
+	return rep, nil
+}
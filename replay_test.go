@@ -0,0 +1,62 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeCorpus writes the objects to dir under their SHA-256, returning their hashes
+func writeCorpus(t *testing.T, dir string, objects ...[]byte) []string {
+	var hashes []string
+	for _, o := range objects {
+		sum := sha256.Sum256(o)
+		h := hex.EncodeToString(sum[:])
+		if err := ioutil.WriteFile(filepath.Join(dir, h), o, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	h := writeCorpus(t, dir, eicar, []byte("clean"), append([]byte("renamed "), eicar...), []byte("unchanged"))
+	verdicts := []StoredResult{
+		{Target: "a.com", SHA256: h[0], Virus: "Eicar-Test-Signature"},
+		{Target: "a.com", SHA256: h[0]}, // the last verdict is the one compared
+		{Target: "b.txt", SHA256: h[1], Virus: "Doc.FP.Signature"},
+		{Target: "c.com", SHA256: h[2], Virus: "Win.Old.Name"},
+		{Target: "d.txt", SHA256: h[3]},
+		{Target: "gone.exe", SHA256: "00" + h[3][2:], Virus: "Win.Trojan.Gone"},
+		{Target: "unhashed"},
+	}
+	rep, err := Replay(eicarScanner{}, CorpusDir(dir), verdicts)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if rep.Replayed != 4 || rep.Unchanged != 1 || rep.Missing != 2 || len(rep.Changes) != 3 {
+		t.Fatalf("Replay: %+v", rep)
+	}
+	want := map[string]VerdictChange{
+		VerdictAdded:   {Kind: VerdictAdded, SHA256: h[0], Target: "a.com", After: "Eicar-Test-Signature"},
+		VerdictDropped: {Kind: VerdictDropped, SHA256: h[1], Target: "b.txt", Before: "Doc.FP.Signature"},
+		VerdictRenamed: {Kind: VerdictRenamed, SHA256: h[2], Target: "c.com", Before: "Win.Old.Name", After: "Eicar-Test-Signature"},
+	}
+	for _, c := range rep.Changes {
+		if c != want[c.Kind] {
+			t.Errorf("Replay: change %+v, want %+v", c, want[c.Kind])
+		}
+	}
+
+	if _, err := CorpusDir(dir).Open("../" + h[0][3:]); err == nil {
+		t.Errorf("Open: path outside the corpus opened")
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Reputation is what a reputation service, such as an internal hash database or a sandbox,
+// knows of some content
+type Reputation struct {
+	Known     bool   // the service has a verdict on the content
+	Malicious bool   // the verdict, if Known
+	Name      string // of the threat, if Malicious
+}
+
+// ReputationHook is consulted by a ReputationScanner with the hex encoded SHA256 hash of the
+// objects it scans
+type ReputationHook interface {
+	// Before returns the reputation of the content before it is scanned
+	Before(ctx context.Context, sha256 string) (Reputation, error)
+
+	// After is called with the result of the scan of the content Before did not know, to
+	// submit it to a sandbox for instance, and returns what is known of it by then
+	After(ctx context.Context, sha256 string, res *ScanResult) (Reputation, error)
+}
+
+// ReputationDetector is the Detector name of the detections of a ReputationHook
+const ReputationDetector = "reputation"
+
+// defaultReputationCacheSize is the number of reputations cached if CacheSize is zero
+const defaultReputationCacheSize = 4096
+
+// ReputationScanner is a Scanner consulting a ReputationHook about the content it scans. Content
+// of known reputation is not scanned: it is reported clean if known good, infected by the
+// threat named by the hook if known malicious. Content the hook does not know is scanned and
+// reported infected if the hook knows it to be malicious afterwards and the scan found
+// nothing. The scans go on as if the hook knew nothing when it fails or times out.
+//
+// The data has to be hashed before scanning, so it is held in memory or, if large, in a
+// temporary file unless it is read from an io.ReadSeeker.
+type ReputationScanner struct {
+	Scanner Scanner
+	Hook    ReputationHook
+
+	// Timeout bounds every call of the hook, no limit if zero
+	Timeout time.Duration
+
+	// CacheTTL is how long the known reputations are remembered, none are if zero, up to
+	// CacheSize of them, 4096 if zero
+	CacheTTL  time.Duration
+	CacheSize int
+
+	// OnError, if not nil, is called with the errors of the hook
+	OnError func(error)
+
+	mu    sync.Mutex
+	cache map[string]reputationEntry
+	order []string // of insertion in the cache, oldest first
+}
+
+// reputationEntry is a cached reputation
+type reputationEntry struct {
+	rep     Reputation
+	expires time.Time
+}
+
+// Scan scans the data read from r unless its reputation is known
+func (s *ReputationScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	rs, cleanup, err := replayable(r)
+	if err != nil {
+		return nil, fmt.Errorf("ReputationScanner: %v", err)
+	}
+	defer cleanup()
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("ReputationScanner: %v", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, rs); err != nil {
+		return nil, fmt.Errorf("ReputationScanner: %v", err)
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("ReputationScanner: %v", err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	rep, ok := s.cached(sum)
+	if !ok {
+		rep = s.call(func(ctx context.Context) (Reputation, error) { return s.Hook.Before(ctx, sum) })
+		s.remember(sum, rep)
+	}
+	if rep.Known {
+		res := &ScanResult{Name: name}
+		reputationVerdict(res, rep)
+		return res, nil
+	}
+
+	res, err := s.Scanner.Scan(rs, name)
+	if err != nil {
+		return nil, err
+	}
+	rep = s.call(func(ctx context.Context) (Reputation, error) { return s.Hook.After(ctx, sum, res) })
+	s.remember(sum, rep)
+	reputationVerdict(res, rep)
+	return res, nil
+}
+
+// reputationVerdict reports the threat of malicious content in res
+func reputationVerdict(res *ScanResult, rep Reputation) {
+	if !rep.Known || !rep.Malicious {
+		return
+	}
+	name := rep.Name
+	if name == "" {
+		name = "Reputation.Malicious"
+	}
+	if res.Virus == "" {
+		res.Virus = name
+	}
+	res.Detections = append(res.Detections, Detection{ReputationDetector, name})
+}
+
+// call calls the hook within the timeout, returning an unknown reputation if it fails
+func (s *ReputationScanner) call(fn func(context.Context) (Reputation, error)) Reputation {
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	type result struct {
+		rep Reputation
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rep, err := fn(ctx)
+		done <- result{rep, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	}
+	if res.err != nil {
+		if s.OnError != nil {
+			s.OnError(fmt.Errorf("ReputationScanner: %w", res.err))
+		}
+		return Reputation{}
+	}
+	return res.rep
+}
+
+// cached returns the reputation of the content with hash sum, if remembered
+func (s *ReputationScanner) cached(sum string) (Reputation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.cache[sum]
+	if !ok || time.Now().After(e.expires) {
+		return Reputation{}, false
+	}
+	return e.rep, true
+}
+
+// remember caches a known reputation, forgetting the oldest ones past the cache size
+func (s *ReputationScanner) remember(sum string, rep Reputation) {
+	if s.CacheTTL <= 0 || !rep.Known {
+		return
+	}
+	size := s.CacheSize
+	if size <= 0 {
+		size = defaultReputationCacheSize
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cache == nil {
+		s.cache = map[string]reputationEntry{}
+	}
+	if _, ok := s.cache[sum]; !ok {
+		s.order = append(s.order, sum)
+	}
+	s.cache[sum] = reputationEntry{rep, time.Now().Add(s.CacheTTL)}
+	for len(s.order) > size {
+		delete(s.cache, s.order[0])
+		s.order = s.order[1:]
+	}
+}
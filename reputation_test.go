@@ -0,0 +1,117 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeReputation knows the reputations of known, and learns that content it did not know is
+// malicious once it is scanned
+type fakeReputation struct {
+	known       map[string]Reputation
+	before      int32
+	after       int32
+	hang        bool
+	afterResult Reputation
+}
+
+func (f *fakeReputation) Before(ctx context.Context, sum string) (Reputation, error) {
+	atomic.AddInt32(&f.before, 1)
+	if f.hang {
+		<-ctx.Done()
+		return Reputation{}, ctx.Err()
+	}
+	return f.known[sum], nil
+}
+
+func (f *fakeReputation) After(ctx context.Context, sum string, res *ScanResult) (Reputation, error) {
+	atomic.AddInt32(&f.after, 1)
+	return f.afterResult, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// countingScanner counts the scans it runs
+type countingScanner struct{ calls int32 }
+
+func (s *countingScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return eicarScanner{}.Scan(r, name)
+}
+
+func TestReputationScanner(t *testing.T) {
+	bad, good := []byte("known bad"), []byte("known good")
+	hook := &fakeReputation{known: map[string]Reputation{
+		sha256Hex(bad):  {Known: true, Malicious: true, Name: "Sandbox.Trojan"},
+		sha256Hex(good): {Known: true},
+	}}
+	cs := &countingScanner{}
+	s := &ReputationScanner{Scanner: cs, Hook: hook, CacheTTL: time.Minute}
+
+	res, err := s.Scan(bytes.NewReader(bad), "bad")
+	if err != nil || res.Virus != "Sandbox.Trojan" || len(res.Detections) != 1 || res.Detections[0].Detector != ReputationDetector {
+		t.Errorf("Scan: known bad: %+v %v", res, err)
+	}
+	if res, err := s.Scan(bytes.NewReader(good), "good"); err != nil || res.Virus != "" {
+		t.Errorf("Scan: known good: %+v %v", res, err)
+	}
+	if n := atomic.LoadInt32(&cs.calls); n != 0 {
+		t.Errorf("%d scans of known content", n)
+	}
+
+	// cached
+	s.Scan(bytes.NewReader(bad), "bad")
+	if n := atomic.LoadInt32(&hook.before); n != 2 {
+		t.Errorf("Before called %d times, want 2", n)
+	}
+
+	// unknown content is scanned, then submitted
+	if res, err := s.Scan(bytes.NewReader(eicar), "eicar"); err != nil || res.Virus != "Eicar-Test-Signature" || hook.after != 1 {
+		t.Errorf("Scan: unknown: %+v %v", res, err)
+	}
+	hook.afterResult = Reputation{Known: true, Malicious: true}
+	if res, err := s.Scan(bytes.NewReader([]byte("new")), "new"); err != nil || res.Virus != "Reputation.Malicious" {
+		t.Errorf("Scan: malicious after scan: %+v %v", res, err)
+	}
+	if _, ok := s.cached(sha256Hex([]byte("new"))); !ok {
+		t.Errorf("reputation learnt after scan not cached")
+	}
+
+	// a hanging hook does not stop the scans
+	var errs int32
+	hang := &fakeReputation{hang: true}
+	s = &ReputationScanner{Scanner: cs, Hook: hang, Timeout: 10 * time.Millisecond, OnError: func(error) { atomic.AddInt32(&errs, 1) }}
+	if res, err := s.Scan(bytes.NewReader(eicar), "eicar"); err != nil || res.Virus != "Eicar-Test-Signature" || atomic.LoadInt32(&errs) != 1 {
+		t.Errorf("Scan: hanging hook: %+v %v, %d errors", res, err, errs)
+	}
+}
+
+func TestReputationCacheSize(t *testing.T) {
+	s := &ReputationScanner{CacheTTL: time.Minute, CacheSize: 2}
+	for _, sum := range []string{"a", "b", "c"} {
+		s.remember(sum, Reputation{Known: true})
+	}
+	if _, ok := s.cached("a"); ok {
+		t.Errorf("oldest reputation not evicted")
+	}
+	if _, ok := s.cached("c"); !ok || len(s.cache) != 2 {
+		t.Errorf("cache: %v", s.cache)
+	}
+	s.remember("d", Reputation{})
+	if _, ok := s.cached("d"); ok {
+		t.Errorf("unknown reputation cached")
+	}
+}
@@ -0,0 +1,461 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StoredResult is a verdict recorded in a ResultStore
+type StoredResult struct {
+	Time      time.Time
+	Target    string // name of the object scanned
+	SHA256    string `json:",omitempty"`
+	Virus     string `json:",omitempty"`
+	DBVersion uint   `json:",omitempty"` // of the daily database, if known
+	Error     string `json:",omitempty"`
+}
+
+// ResultStore records verdicts in a file of JSON lines, for audits and to find the objects to
+// rescan after a database update, such as those whose detection was since dropped as a false
+// positive. Records are appended as they come and kept in memory, indexed by hash, for
+// queries; stores meant to hold years of verdicts should be rotated, see SetRotation.
+type ResultStore struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	w       *bufio.Writer
+	records []StoredResult
+	byHash  map[string][]int
+
+	rotation ResultStoreRotation
+	size     int64         // of the file
+	started  time.Time     // time of the first record of the file, zero if none
+	backups  []storeBackup // rotated files, oldest first
+	rotated  int           // number of records of the backups, first in records
+}
+
+// ResultStoreRotation is when a ResultStore moves its file aside to start a new one, and how
+// long it keeps the files rotated. Rotated files are named after the file and the time of
+// their rotation, such as results.jsonl.20240102T150405.000000000, with .gz appended if
+// compressed.
+type ResultStoreRotation struct {
+	// MaxSize is the size in bytes past which the file is rotated, no limit if zero
+	MaxSize int64
+
+	// MaxAge is the age of the first record of the file past which the file is rotated, no
+	// limit if zero. It is checked as records are added.
+	MaxAge time.Duration
+
+	// Compress compresses the rotated files with gzip
+	Compress bool
+
+	// MaxBackups is the number of rotated files kept, and MaxBackupAge the time they are kept
+	// after their rotation; the oldest are removed first, none if zero
+	MaxBackups   int
+	MaxBackupAge time.Duration
+}
+
+// storeBackup is a rotated file of a ResultStore
+type storeBackup struct {
+	path    string
+	rotated time.Time
+	n       int // number of records
+}
+
+// backupStamp is the suffix of the rotated files, sorting as the times of the rotations
+const backupStamp = "20060102T150405.000000000"
+
+// OpenResultStore opens the store at path, creating it if needed. The records of the files
+// rotated from it are read back as well.
+func OpenResultStore(path string) (*ResultStore, error) {
+	s := &ResultStore{path: path, byHash: map[string][]int{}}
+	backups, err := findBackups(path)
+	if err != nil {
+		return nil, fmt.Errorf("OpenResultStore: %v", err)
+	}
+	for _, b := range backups {
+		if b.n, err = s.loadBackup(b.path); err != nil {
+			return nil, fmt.Errorf("OpenResultStore: %s: %v", b.path, err)
+		}
+		s.backups = append(s.backups, b)
+		s.rotated += b.n
+	}
+	if err := s.open(); err != nil {
+		return nil, fmt.Errorf("OpenResultStore: %v", err)
+	}
+	_, valid, err := s.load(s.f)
+	if err == nil && valid < s.size {
+		// drop the record torn by a crash, for the next one not to be appended to it
+		if err = s.f.Truncate(valid); err == nil {
+			s.size = valid
+		}
+	}
+	if err != nil {
+		s.f.Close()
+		return nil, fmt.Errorf("OpenResultStore: %s: %v", path, err)
+	}
+	if len(s.records) > s.rotated {
+		s.started = s.records[s.rotated].Time
+	}
+	return s, nil
+}
+
+// findBackups returns the files rotated from path, oldest first
+func findBackups(path string) ([]storeBackup, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	prefix := filepath.Base(path) + "."
+	var backups []storeBackup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		t, err := time.Parse(backupStamp, strings.TrimSuffix(name[len(prefix):], ".gz"))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, storeBackup{path: filepath.Join(filepath.Dir(path), name), rotated: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].path < backups[j].path })
+	return backups, nil
+}
+
+// loadBackup loads the records of a rotated file
+func (s *ResultStore) loadBackup(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	n, _, err := s.load(r)
+	return n, err
+}
+
+// load loads the records read from r, returning their number and the length of the lines
+// holding them. A last line without its newline is a record torn by a crash during Add, and
+// is skipped.
+func (s *ResultStore) load(r io.Reader) (int, int64, error) {
+	br := bufio.NewReader(r)
+	n := 0
+	var valid int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return n, valid, nil
+		} else if err != nil {
+			return n, valid, err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec StoredResult
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return n, valid, err
+			}
+			s.index(rec)
+			n++
+		}
+		valid += int64(len(line))
+	}
+}
+
+// open opens the file of the store for appending
+func (s *ResultStore) open() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.w, s.size = f, bufio.NewWriter(f), fi.Size()
+	return nil
+}
+
+// index adds r to the records in memory
+func (s *ResultStore) index(r StoredResult) {
+	s.records = append(s.records, r)
+	if r.SHA256 != "" {
+		s.byHash[r.SHA256] = append(s.byHash[r.SHA256], len(s.records)-1)
+	}
+}
+
+// SetRotation sets when the store rotates its file, and removes the rotated files it should no
+// longer keep
+func (s *ResultStore) SetRotation(r ResultStoreRotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotation = r
+	if err := s.prune(time.Now()); err != nil {
+		return fmt.Errorf("SetRotation: %v", err)
+	}
+	return nil
+}
+
+// due reports whether the file is to be rotated before n more bytes are written
+func (s *ResultStore) due(n int, now time.Time) bool {
+	if s.size == 0 {
+		return false
+	}
+	r := s.rotation
+	return r.MaxSize > 0 && s.size+int64(n) > r.MaxSize ||
+		r.MaxAge > 0 && !s.started.IsZero() && now.Sub(s.started) >= r.MaxAge
+}
+
+// rotate moves the file aside, compressing it if required, starts a new one and prunes the
+// rotated files
+func (s *ResultStore) rotate(now time.Time) error {
+	s.f.Close()
+	s.f = nil
+	name := s.path + "." + now.UTC().Format(backupStamp)
+	rerr := os.Rename(s.path, name)
+	if err := s.open(); err != nil {
+		return err
+	}
+	if rerr != nil {
+		return rerr
+	}
+	s.backups = append(s.backups, storeBackup{path: name, rotated: now, n: len(s.records) - s.rotated})
+	s.rotated = len(s.records)
+	s.started = time.Time{}
+	if s.rotation.Compress {
+		gz, err := compressFile(name)
+		if err != nil {
+			return err
+		}
+		s.backups[len(s.backups)-1].path = gz
+	}
+	return s.prune(now)
+}
+
+// compressFile replaces the file at path by its gzip compressed copy, path.gz
+func compressFile(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return "", err
+	}
+	src.Close()
+	return path + ".gz", os.Remove(path)
+}
+
+// prune removes the rotated files past the retention limits, and their records from memory
+func (s *ResultStore) prune(now time.Time) error {
+	var err error
+	n, dropped := 0, 0
+	for ; n < len(s.backups); n++ {
+		b := s.backups[n]
+		r := s.rotation
+		if !(r.MaxBackups > 0 && len(s.backups)-n > r.MaxBackups) && !(r.MaxBackupAge > 0 && now.Sub(b.rotated) > r.MaxBackupAge) {
+			break
+		}
+		if err = os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			break
+		}
+		err = nil
+		dropped += b.n
+	}
+	if n == 0 {
+		return err
+	}
+	s.backups = s.backups[n:]
+	s.rotated -= dropped
+	s.records = append([]StoredResult(nil), s.records[dropped:]...)
+	s.byHash = map[string][]int{}
+	for i, r := range s.records {
+		if r.SHA256 != "" {
+			s.byHash[r.SHA256] = append(s.byHash[r.SHA256], i)
+		}
+	}
+	return err
+}
+
+// Add records r, at the current time if r.Time is zero, rotating the file first if due. A
+// failure to compress or remove rotated files is returned once r is recorded.
+func (s *ResultStore) Add(r StoredResult) error {
+	now := time.Now()
+	if r.Time.IsZero() {
+		r.Time = now
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("Add: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rerr error
+	if s.due(len(b)+1, now) {
+		if rerr = s.rotate(now); s.f == nil {
+			return fmt.Errorf("Add: %v", rerr)
+		}
+	}
+	s.w.Write(b)
+	s.w.WriteByte('\n')
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("Add: %v", err)
+	}
+	s.size += int64(len(b) + 1)
+	if s.started.IsZero() {
+		s.started = now
+	}
+	s.index(r)
+	if rerr != nil {
+		return fmt.Errorf("Add: rotating: %v", rerr)
+	}
+	return nil
+}
+
+// Record records the outcome of a scan with databases of version dbVersion, zero if unknown
+func (s *ResultStore) Record(res *ScanResult, err error, dbVersion uint) error {
+	r := StoredResult{Target: res.Name, Virus: res.Virus, DBVersion: dbVersion}
+	if res.Hashes != nil {
+		r.SHA256 = res.Hashes.SHA256
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return s.Add(r)
+}
+
+// ByHash returns the verdicts on the content of the given SHA-256, oldest first
+func (s *ResultStore) ByHash(sha256 string) []StoredResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []StoredResult
+	for _, i := range s.byHash[sha256] {
+		found = append(found, s.records[i])
+	}
+	return found
+}
+
+// SeenInfected returns the last verdict finding the content of the given SHA-256 infected, if
+// it ever was
+func (s *ResultStore) SeenInfected(sha256 string) (StoredResult, bool) {
+	found := s.ByHash(sha256)
+	for i := len(found) - 1; i >= 0; i-- {
+		if found[i].Virus != "" {
+			return found[i], true
+		}
+	}
+	return StoredResult{}, false
+}
+
+// Detections returns the verdicts finding a virus recorded since the given time, oldest first
+func (s *ResultStore) Detections(since time.Time) []StoredResult {
+	return s.Query(func(r *StoredResult) bool { return r.Virus != "" && !r.Time.Before(since) })
+}
+
+// Query returns the verdicts match returns true for, oldest first
+func (s *ResultStore) Query(match func(*StoredResult) bool) []StoredResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []StoredResult
+	for i := range s.records {
+		if match(&s.records[i]) {
+			found = append(found, s.records[i])
+		}
+	}
+	return found
+}
+
+// Close closes the file of the store
+func (s *ResultStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// StoreScanner is a Scanner recording the verdicts of another in a ResultStore, hashing the
+// data as it is scanned. Verdicts on objects the scanner did not read in full are recorded
+// without their hash, unless the scanner returned it.
+type StoreScanner struct {
+	Scanner Scanner
+	Store   *ResultStore
+
+	// DBVersion, if not nil, returns the version of the databases the verdicts are made with
+	DBVersion func() uint
+}
+
+// hashingReader hashes the data read from r, telling whether it was read in full
+type hashingReader struct {
+	r   io.Reader
+	h   *hasher
+	eof bool
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Scan scans the data read from r with the scanner and records the verdict
+func (s *StoreScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	hr := &hashingReader{r: r, h: newHasher()}
+	res, err := s.Scanner.Scan(hr, name)
+	rec := res
+	if rec == nil {
+		rec = &ScanResult{Name: name}
+	}
+	if rec.Hashes == nil && hr.eof {
+		c := *rec
+		c.Hashes = hr.h.sum()
+		rec = &c
+	}
+	var version uint
+	if s.DBVersion != nil {
+		version = s.DBVersion()
+	}
+	if serr := s.Store.Record(rec, err, version); serr != nil && err == nil {
+		return res, fmt.Errorf("StoreScanner: %v", serr)
+	}
+	return res, err
+}
@@ -0,0 +1,170 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	store, err := OpenResultStore(path)
+	if err != nil {
+		t.Fatalf("OpenResultStore: %v", err)
+	}
+	s := &StoreScanner{Scanner: eicarScanner{}, Store: store, DBVersion: func() uint { return 27431 }}
+	for _, name := range []string{"eicar.com", "clean.txt", "eicar.zip"} {
+		data := []byte("clean")
+		if strings.HasPrefix(name, "eicar") {
+			data = eicar
+		}
+		if _, err := s.Scan(bytes.NewReader(data), name); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+	}
+	store.Add(StoredResult{Time: time.Now().Add(-48 * time.Hour), Target: "old.exe", SHA256: "00", Virus: "Win.Test"})
+	store.Close()
+
+	// the verdicts are read back
+	if store, err = OpenResultStore(path); err != nil {
+		t.Fatalf("OpenResultStore: %v", err)
+	}
+	defer store.Close()
+	sum := newHasher()
+	sum.Write(eicar)
+	r, ok := store.SeenInfected(sum.sum().SHA256)
+	if !ok || r.Target != "eicar.zip" || r.DBVersion != 27431 {
+		t.Errorf("SeenInfected: %+v, %v", r, ok)
+	}
+	sum = newHasher()
+	sum.Write([]byte("clean"))
+	if r, ok := store.SeenInfected(sum.sum().SHA256); ok || len(store.ByHash(sum.sum().SHA256)) != 1 {
+		t.Errorf("SeenInfected: clean content %+v", r)
+	}
+	if d := store.Detections(time.Now().Add(-24 * time.Hour)); len(d) != 2 || d[0].Target != "eicar.com" {
+		t.Errorf("Detections: %+v", d)
+	}
+	if d := store.Detections(time.Time{}); len(d) != 3 {
+		t.Errorf("Detections: %+v", d)
+	}
+}
+
+func TestResultStoreRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.jsonl")
+	store, err := OpenResultStore(path)
+	if err != nil {
+		t.Fatalf("OpenResultStore: %v", err)
+	}
+	if err := store.SetRotation(ResultStoreRotation{MaxSize: 300, Compress: true, MaxBackups: 2}); err != nil {
+		t.Fatalf("SetRotation: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := store.Add(StoredResult{Target: fmt.Sprintf("file%02d", i), SHA256: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	kept := len(store.Query(func(*StoredResult) bool { return true }))
+	store.Close()
+
+	backups, _ := filepath.Glob(path + ".*.gz")
+	if len(backups) != 2 {
+		t.Errorf("backups %v", backups)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() > 300 {
+		t.Errorf("Stat: %v, %v", fi, err)
+	}
+	if kept == 0 || kept >= 20 {
+		t.Errorf("%d records kept in memory", kept)
+	}
+
+	// the records of the files kept are read back, those of the files removed are gone
+	if store, err = OpenResultStore(path); err != nil {
+		t.Fatalf("OpenResultStore: %v", err)
+	}
+	defer store.Close()
+	all := store.Query(func(*StoredResult) bool { return true })
+	if len(all) != kept || all[len(all)-1].Target != "file19" || len(store.ByHash("0")) != 0 || len(store.ByHash("19")) != 1 {
+		t.Errorf("records read back: %+v", all)
+	}
+
+	// the rotated files expire
+	time.Sleep(10 * time.Millisecond)
+	if err := store.SetRotation(ResultStoreRotation{MaxAge: time.Nanosecond, MaxBackupAge: time.Millisecond}); err != nil {
+		t.Fatalf("SetRotation: %v", err)
+	}
+	if backups, _ := filepath.Glob(path + ".*"); len(backups) != 0 {
+		t.Errorf("expired backups kept: %v", backups)
+	}
+	store.Add(StoredResult{Target: "new"})
+	if all := store.Query(func(*StoredResult) bool { return true }); all[0].Target == "file00" || all[len(all)-1].Target != "new" {
+		t.Errorf("records after expiry: %+v", all)
+	}
+	if backups, _ := filepath.Glob(path + ".*"); len(backups) != 1 || filepath.Ext(backups[0]) == ".gz" {
+		t.Errorf("file not rotated by age: %v", backups)
+	}
+}
+
+// prefixScanner reads only the first bytes of the data
+type prefixScanner struct{}
+
+func (prefixScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	r.Read(make([]byte, 4))
+	return &ScanResult{Name: name}, nil
+}
+
+func TestResultStorePartialRead(t *testing.T) {
+	store, err := OpenResultStore(filepath.Join(t.TempDir(), "results.jsonl"))
+	if err != nil {
+		t.Fatalf("OpenResultStore: %v", err)
+	}
+	defer store.Close()
+	s := &StoreScanner{Scanner: prefixScanner{}, Store: store}
+	if _, err := s.Scan(bytes.NewReader(eicar), "eicar.com"); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	prefix := newHasher()
+	prefix.Write(eicar[:4])
+	if len(store.ByHash(prefix.sum().SHA256)) != 0 || len(store.Detections(time.Time{})) != 0 {
+		t.Errorf("Scan: hash of a prefix recorded")
+	}
+	if d := store.records; len(d) != 1 || d[0].SHA256 != "" {
+		t.Errorf("Scan: recorded %+v", d)
+	}
+}
+
+func TestResultStoreTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	store, err := OpenResultStore(path)
+	if err != nil {
+		t.Fatalf("OpenResultStore: %v", err)
+	}
+	store.Add(StoredResult{Target: "a.exe", SHA256: "aa", Virus: "Win.Test"})
+	store.Close()
+	// a crash in the middle of an Add
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"Time":"2024-01-02T15:04:05Z","Target":"b.e`)
+	f.Close()
+
+	if store, err = OpenResultStore(path); err != nil {
+		t.Fatalf("OpenResultStore: torn record: %v", err)
+	}
+	store.Add(StoredResult{Target: "c.exe", SHA256: "cc", Virus: "Win.Test"})
+	store.Close()
+	if store, err = OpenResultStore(path); err != nil {
+		t.Fatalf("OpenResultStore: %v", err)
+	}
+	defer store.Close()
+	if d := store.Detections(time.Time{}); len(d) != 2 || d[0].Target != "a.exe" || d[1].Target != "c.exe" {
+		t.Errorf("Detections: %+v", d)
+	}
+}
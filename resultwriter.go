@@ -0,0 +1,197 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// ResultWriter writes scan results in some format, to shape the output of scans without post
+// processing. Results are written one at a time, and Close completes the output; it does not
+// close the underlying writer.
+type ResultWriter interface {
+	// WriteResult writes the result of a scan, or, if err is not nil, the failure to scan
+	// res.Name
+	WriteResult(res *ScanResult, err error) error
+	Close() error
+}
+
+// ResultFormats are the formats of NewResultWriter
+var ResultFormats = []string{"text", "json", "jsonl", "csv", "stix", "template:<text/template>"}
+
+// NewResultWriter returns a writer for format, one of ResultFormats
+func NewResultWriter(w io.Writer, format string) (ResultWriter, error) {
+	switch {
+	case format == "text":
+		return NewTextWriter(w), nil
+	case format == "json":
+		return NewJSONWriter(w), nil
+	case format == "jsonl":
+		return NewJSONLinesWriter(w), nil
+	case format == "csv":
+		return NewCSVWriter(w), nil
+	case format == "stix":
+		return NewSTIXWriter(w), nil
+	case strings.HasPrefix(format, "template:"):
+		return NewTemplateWriter(w, strings.TrimPrefix(format, "template:"))
+	}
+	return nil, fmt.Errorf("NewResultWriter: unknown format %q", format)
+}
+
+// ResultRecord is a result as written by the writers of this package, and the data of the
+// templates of NewTemplateWriter
+type ResultRecord struct {
+	Name     string
+	Virus    string    `json:",omitempty"`
+	FileType string    `json:",omitempty"`
+	Hashes   *Hashes   `json:",omitempty"`
+	Metadata *Metadata `json:",omitempty"`
+	Error    string    `json:",omitempty"`
+
+	Encrypted []EncryptedObject `json:",omitempty"`
+}
+
+// newResultRecord returns the record of a result
+func newResultRecord(res *ScanResult, err error) *ResultRecord {
+	r := &ResultRecord{Name: res.Name, Virus: res.Virus, FileType: res.FileType, Hashes: res.Hashes, Metadata: res.Metadata, Encrypted: res.Encrypted}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// textWriter writes results in the format of clamscan
+type textWriter struct {
+	w *bufio.Writer
+}
+
+// NewTextWriter returns a writer of lines in the format of clamscan, such as
+// "eicar.com: Eicar-Test-Signature FOUND"
+func NewTextWriter(w io.Writer) ResultWriter {
+	return &textWriter{bufio.NewWriter(w)}
+}
+
+func (t *textWriter) WriteResult(res *ScanResult, err error) error {
+	switch {
+	case res.Virus != "":
+		_, err = fmt.Fprintf(t.w, "%s: %s FOUND\n", res.Name, res.Virus)
+	case err != nil:
+		_, err = fmt.Fprintf(t.w, "%s: %v ERROR\n", res.Name, err)
+	default:
+		_, err = fmt.Fprintf(t.w, "%s: OK\n", res.Name)
+	}
+	return err
+}
+
+func (t *textWriter) Close() error {
+	return t.w.Flush()
+}
+
+// jsonWriter writes a JSON array of records, or one record per line
+type jsonWriter struct {
+	w     *bufio.Writer
+	lines bool
+	n     int
+}
+
+// NewJSONWriter returns a writer of a JSON array of ResultRecords
+func NewJSONWriter(w io.Writer) ResultWriter {
+	return &jsonWriter{w: bufio.NewWriter(w)}
+}
+
+// NewJSONLinesWriter returns a writer of ResultRecords in JSON, one per line
+func NewJSONLinesWriter(w io.Writer) ResultWriter {
+	return &jsonWriter{w: bufio.NewWriter(w), lines: true}
+}
+
+func (j *jsonWriter) WriteResult(res *ScanResult, err error) error {
+	b, merr := json.Marshal(newResultRecord(res, err))
+	if merr != nil {
+		return merr
+	}
+	if !j.lines {
+		sep := ",\n"
+		if j.n == 0 {
+			sep = "[\n"
+		}
+		j.w.WriteString(sep)
+	}
+	j.n++
+	j.w.Write(b)
+	if j.lines {
+		j.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.lines {
+		if j.n == 0 {
+			j.w.WriteString("[")
+		}
+		j.w.WriteString("\n]\n")
+	}
+	return j.w.Flush()
+}
+
+// csvWriter writes records as CSV, with a header
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVWriter returns a writer of CSV records with the columns Name, Virus, FileType, MD5, SHA1,
+// SHA256 and Error, after a header line
+func NewCSVWriter(w io.Writer) ResultWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) WriteResult(res *ScanResult, err error) error {
+	if !c.header {
+		c.header = true
+		c.w.Write([]string{"Name", "Virus", "FileType", "MD5", "SHA1", "SHA256", "Error"})
+	}
+	r := newResultRecord(res, err)
+	h := r.Hashes
+	if h == nil {
+		h = &Hashes{}
+	}
+	return c.w.Write([]string{r.Name, r.Virus, r.FileType, h.MD5, h.SHA1, h.SHA256, r.Error})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// templateWriter executes a template for every record
+type templateWriter struct {
+	w *bufio.Writer
+	t *template.Template
+}
+
+// NewTemplateWriter returns a writer executing the text/template text with the ResultRecord of
+// every result, such as "{{.Name}}\t{{.Virus}}\n"
+func NewTemplateWriter(w io.Writer, text string) (ResultWriter, error) {
+	t, err := template.New("result").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("NewTemplateWriter: %v", err)
+	}
+	return &templateWriter{bufio.NewWriter(w), t}, nil
+}
+
+func (t *templateWriter) WriteResult(res *ScanResult, err error) error {
+	return t.t.Execute(t.w, newResultRecord(res, err))
+}
+
+func (t *templateWriter) Close() error {
+	return t.w.Flush()
+}
@@ -0,0 +1,71 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestResultWriters(t *testing.T) {
+	results := []struct {
+		res *ScanResult
+		err error
+	}{
+		{&ScanResult{Name: "eicar.com", Virus: "Eicar-Test-Signature", FileType: "CL_TYPE_TEXT_ASCII", Hashes: &Hashes{MD5: "44d8"}}, nil},
+		{&ScanResult{Name: "clean.txt"}, nil},
+		{&ScanResult{Name: "locked"}, errors.New("permission denied")},
+	}
+	tests := []struct {
+		format, want string
+	}{
+		{"text", "eicar.com: Eicar-Test-Signature FOUND\nclean.txt: OK\nlocked: permission denied ERROR\n"},
+		{"jsonl", `{"Name":"eicar.com","Virus":"Eicar-Test-Signature","FileType":"CL_TYPE_TEXT_ASCII","Hashes":{"MD5":"44d8","SHA1":"","SHA256":""}}` + "\n" +
+			`{"Name":"clean.txt"}` + "\n" + `{"Name":"locked","Error":"permission denied"}` + "\n"},
+		{"csv", "Name,Virus,FileType,MD5,SHA1,SHA256,Error\neicar.com,Eicar-Test-Signature,CL_TYPE_TEXT_ASCII,44d8,,,\nclean.txt,,,,,,\nlocked,,,,,,permission denied\n"},
+		{"template:{{.Name}}={{or .Virus .Error \"-\"}};", "eicar.com=Eicar-Test-Signature;clean.txt=-;locked=permission denied;"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w, err := NewResultWriter(&buf, tt.format)
+		if err != nil {
+			t.Fatalf("NewResultWriter(%q): %v", tt.format, err)
+		}
+		for _, r := range results {
+			if err := w.WriteResult(r.res, r.err); err != nil {
+				t.Errorf("%s: WriteResult: %v", tt.format, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: Close: %v", tt.format, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.format, buf.String(), tt.want)
+		}
+	}
+
+	// a JSON array, even when empty
+	for n := 0; n <= len(results); n += len(results) {
+		var buf bytes.Buffer
+		w := NewJSONWriter(&buf)
+		for _, r := range results[:n] {
+			w.WriteResult(r.res, r.err)
+		}
+		w.Close()
+		var recs []ResultRecord
+		if err := json.Unmarshal(buf.Bytes(), &recs); err != nil || len(recs) != n {
+			t.Errorf("NewJSONWriter: %d records from %q, %v", len(recs), buf.String(), err)
+		}
+	}
+
+	if _, err := NewResultWriter(nil, "xml"); err == nil {
+		t.Errorf("NewResultWriter: unknown format accepted")
+	}
+	if _, err := NewResultWriter(nil, "template:{{.Name"); err == nil {
+		t.Errorf("NewResultWriter: invalid template accepted")
+	}
+}
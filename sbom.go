@@ -0,0 +1,140 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SBOMComponent is a file referenced by an SBOM, and the result of its scan
+type SBOMComponent struct {
+	Ref    string // bom-ref of a CycloneDX component, SPDXID of an SPDX element
+	Path   string // as referenced, relative to the artifact root
+	Result *ScanResult
+	Err    error
+}
+
+// Verdict returns the verdict annotated in the SBOM: "clean", "infected: " followed by the
+// virus name, or "error: " followed by the reason the file could not be scanned
+func (c *SBOMComponent) Verdict() string {
+	switch {
+	case c.Err != nil:
+		return "error: " + c.Err.Error()
+	case c.Result != nil && c.Result.Virus != "":
+		return "infected: " + c.Result.Virus
+	}
+	return "clean"
+}
+
+// sbomVerdictProperty is the CycloneDX property and the SPDX annotation prefix of the verdicts
+const sbomVerdictProperty = "clamav:verdict"
+
+// errOutsideRoot is the error of the files an SBOM references outside of the artifact root
+var errOutsideRoot = errors.New("path outside the artifact root")
+
+// ScanSBOM scans with s the files referenced by a CycloneDX or SPDX SBOM in JSON, such as the
+// file components of a CycloneDX document or the files and package files of an SPDX document,
+// found relative to root, the directory the artifact is unpacked in. It returns the SBOM with
+// the verdict of every file added, as a "clamav:verdict" property of CycloneDX components or
+// an annotation of SPDX elements, so that supply chain tooling gets it with the rest of the
+// document. Files that are missing or outside of root get an error verdict; an error is
+// returned only if the SBOM cannot be parsed.
+func ScanSBOM(s Scanner, sbom []byte, root string) ([]byte, []SBOMComponent, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(sbom, &doc); err != nil {
+		return nil, nil, fmt.Errorf("ScanSBOM: %v", err)
+	}
+	var comps []SBOMComponent
+	scan := func(ref, p string) *SBOMComponent {
+		comps = append(comps, scanSBOMFile(s, root, ref, p))
+		return &comps[len(comps)-1]
+	}
+
+	switch {
+	case doc["bomFormat"] == "CycloneDX":
+		if list, ok := doc["components"].([]interface{}); ok {
+			walkCycloneDX(list, scan)
+		}
+	case doc["spdxVersion"] != nil:
+		now := time.Now().UTC().Format(time.RFC3339)
+		for _, key := range []string{"files", "packages"} {
+			elems, _ := doc[key].([]interface{})
+			for _, e := range elems {
+				elem, ok := e.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := elem["fileName"].(string)
+				if key == "packages" {
+					name, _ = elem["packageFileName"].(string)
+				}
+				if name == "" {
+					continue
+				}
+				id, _ := elem["SPDXID"].(string)
+				c := scan(id, name)
+				annotations, _ := elem["annotations"].([]interface{})
+				elem["annotations"] = append(annotations, map[string]interface{}{
+					"annotationType": "REVIEW",
+					"annotator":      "Tool: clamav",
+					"annotationDate": now,
+					"comment":        sbomVerdictProperty + ": " + c.Verdict(),
+				})
+			}
+		}
+	default:
+		return nil, nil, errors.New("ScanSBOM: neither a CycloneDX nor an SPDX document")
+	}
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("ScanSBOM: %v", err)
+	}
+	return out, comps, nil
+}
+
+// walkCycloneDX scans the file components of list and of the components they contain
+func walkCycloneDX(list []interface{}, scan func(ref, p string) *SBOMComponent) {
+	for _, v := range list {
+		comp, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if comp["type"] == "file" {
+			name, _ := comp["name"].(string)
+			ref, _ := comp["bom-ref"].(string)
+			c := scan(ref, name)
+			props, _ := comp["properties"].([]interface{})
+			comp["properties"] = append(props, map[string]interface{}{"name": sbomVerdictProperty, "value": c.Verdict()})
+		}
+		if sub, ok := comp["components"].([]interface{}); ok {
+			walkCycloneDX(sub, scan)
+		}
+	}
+}
+
+// scanSBOMFile scans the file at p, relative to root, without following links out of root
+func scanSBOMFile(s Scanner, root, ref, p string) SBOMComponent {
+	c := SBOMComponent{Ref: ref, Path: p}
+	rel := path.Clean(filepath.ToSlash(p))
+	if p == "" || rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) || filepath.IsAbs(p) {
+		c.Err = errOutsideRoot
+		return c
+	}
+	f, err := openUnder(root, rel)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	defer f.Close()
+	c.Result, c.Err = s.Scan(f, p)
+	return c
+}
@@ -0,0 +1,115 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanSBOMCycloneDX(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string][]byte{"bin/tool": eicar, "lib/a.so": []byte("clean")})
+	sbom := `{"bomFormat":"CycloneDX","specVersion":"1.5","components":[
+		{"type":"library","name":"libfoo","version":"1.0","components":[
+			{"type":"file","bom-ref":"f1","name":"lib/a.so"}]},
+		{"type":"file","bom-ref":"f2","name":"bin/tool","properties":[{"name":"owner","value":"ci"}]},
+		{"type":"file","bom-ref":"f3","name":"../../etc/passwd"},
+		{"type":"file","bom-ref":"f4","name":"missing"}]}`
+
+	out, comps, err := ScanSBOM(eicarScanner{}, []byte(sbom), root)
+	if err != nil {
+		t.Fatalf("ScanSBOM: %v", err)
+	}
+	verdicts := map[string]string{}
+	for _, c := range comps {
+		verdicts[c.Ref] = c.Verdict()
+	}
+	if verdicts["f1"] != "clean" || verdicts["f2"] != "infected: Eicar-Test-Signature" ||
+		verdicts["f3"] != "error: "+errOutsideRoot.Error() || !strings.HasPrefix(verdicts["f4"], "error: ") {
+		t.Errorf("verdicts %v", verdicts)
+	}
+
+	var doc struct {
+		Components []struct {
+			Name       string
+			Properties []struct{ Name, Value string }
+			Components []struct {
+				Properties []struct{ Name, Value string }
+			}
+		}
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if p := doc.Components[0].Components[0].Properties; len(p) != 1 || p[0].Name != "clamav:verdict" || p[0].Value != "clean" {
+		t.Errorf("nested component properties %+v", p)
+	}
+	if p := doc.Components[1].Properties; len(p) != 2 || p[1].Value != "infected: Eicar-Test-Signature" {
+		t.Errorf("component properties %+v", p)
+	}
+	if len(doc.Components[0].Properties) != 0 {
+		t.Errorf("library annotated: %+v", doc.Components[0].Properties)
+	}
+}
+
+func TestScanSBOMSPDX(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string][]byte{"src/x.js": eicar, "pkg.tgz": []byte("clean")})
+	sbom := `{"spdxVersion":"SPDX-2.3","files":[{"SPDXID":"SPDXRef-x","fileName":"./src/x.js"}],
+		"packages":[{"SPDXID":"SPDXRef-pkg","name":"pkg","packageFileName":"pkg.tgz"},{"SPDXID":"SPDXRef-dep","name":"dep"}]}`
+
+	out, comps, err := ScanSBOM(eicarScanner{}, []byte(sbom), root)
+	if err != nil || len(comps) != 2 {
+		t.Fatalf("ScanSBOM: %+v %v", comps, err)
+	}
+	var doc struct {
+		Files, Packages []struct {
+			Annotations []struct{ AnnotationType, Comment string }
+		}
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if a := doc.Files[0].Annotations; len(a) != 1 || a[0].Comment != "clamav:verdict: infected: Eicar-Test-Signature" {
+		t.Errorf("file annotations %+v", a)
+	}
+	if a := doc.Packages[0].Annotations; len(a) != 1 || a[0].Comment != "clamav:verdict: clean" {
+		t.Errorf("package annotations %+v", a)
+	}
+	if len(doc.Packages[1].Annotations) != 0 {
+		t.Errorf("package without file annotated")
+	}
+
+	if _, _, err := ScanSBOM(eicarScanner{}, []byte(`{"foo":1}`), root); err == nil {
+		t.Errorf("ScanSBOM: unknown format accepted")
+	}
+}
+
+func TestScanSBOMSymlink(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	writeTree(t, outside, map[string][]byte{"eicar": eicar})
+	if err := os.Symlink(filepath.Join(outside, "eicar"), filepath.Join(root, "link")); err != nil {
+		t.Skipf("Symlink: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Skipf("Symlink: %v", err)
+	}
+	sbom := `{"bomFormat":"CycloneDX","specVersion":"1.5","components":[
+		{"type":"file","bom-ref":"f1","name":"link"},
+		{"type":"file","bom-ref":"f2","name":"dir/eicar"}]}`
+	_, comps, err := ScanSBOM(eicarScanner{}, []byte(sbom), root)
+	if err != nil {
+		t.Fatalf("ScanSBOM: %v", err)
+	}
+	for _, c := range comps {
+		if c.Err == nil {
+			t.Errorf("ScanSBOM: %s scanned through a link out of the root: %+v", c.Path, c.Result)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package clamav
+
+import "os"
+
+// openUnder opens the file rel, a clean slash-separated path, under root without following
+// symbolic links, so that links in an unpacked artifact cannot point the scan outside of it
+func openUnder(root, rel string) (*os.File, error) {
+	d, err := os.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return OpenAt(int(d.Fd()), rel)
+}
@@ -0,0 +1,29 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// openUnder opens the file rel, a clean slash-separated path, under root, refusing it if it
+// resolves outside of root through links. Windows has no openat, so a link swapped in between
+// the check and the open is followed.
+func openUnder(root, rel string) (*os.File, error) {
+	base, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	p, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, err
+	}
+	if r, err := filepath.Rel(base, p); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return nil, errOutsideRoot
+	}
+	return os.Open(p)
+}
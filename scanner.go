@@ -0,0 +1,183 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Scanner is implemented by anything able to scan a stream of data for viruses, be it a local
+// ClamAV engine or a remote scanning service. The higher level components of this package,
+// such as ResponseScanner, scan through a Scanner.
+type Scanner interface {
+	// Scan reads r until EOF and scans the data. The name is only used for reporting. Finding
+	// a virus is not an error, it is reported in the result.
+	Scan(r io.Reader, name string) (*ScanResult, error)
+}
+
+// ScanResult is the outcome of scanning a single object
+type ScanResult struct {
+	Name  string // name the object was scanned under
+	Virus string // virus name, empty if the object is clean
+
+	// FileType is the type ClamAV determined for the object, such as "CL_TYPE_PDF", if the
+	// scanner reports it
+	FileType string
+
+	// Metadata is what ClamAV collected about the object when scanning with
+	// ScanGeneralCollectMetadata, nil otherwise or if the scanner does not report it
+	Metadata *Metadata
+
+	// Hashes are the digests of the object, if the scanner was asked for them
+	Hashes *Hashes
+
+	// Usage are the resources the scan used, if the scanner was asked for them
+	Usage *ScanUsage
+
+	// Timing is where the time of the scan went, if the scanner was asked for it
+	Timing *ScanTiming
+
+	// Encrypted are the encrypted archive members and documents found, which could not be
+	// inspected, as far as the scanner can tell
+	Encrypted []EncryptedObject
+
+	// Detections are everything detected in the object, that of libclamav first, when
+	// scanning with Detectors
+	Detections []Detection
+}
+
+// EngineScanner is a Scanner using a local engine
+type EngineScanner struct {
+	Engine  *Engine
+	Options *ScanOptions
+
+	// Hashes requests the digests of the scanned objects in the results
+	Hashes bool
+
+	// Usage requests the resources used by the scans in the results
+	Usage bool
+
+	// Timing requests the breakdown of the time of the scans by phase in the results
+	Timing bool
+
+	// Strict fails the scans with an *UnsupportedError rather than scanning if the linked
+	// libclamav does not support all of Options, see ScanOptions.Supported
+	Strict bool
+
+	// TempDir, if not empty, is where the objects too large to be scanned from memory are
+	// spooled, such as a tmpfs of a tenant or a job, rather than the default temporary
+	// directory. Every scan gets a directory of its own in it, removed with its content once the
+	// scan is over. The files libclamav extracts from the objects still go to the Tmpdir of the
+	// engine, which libclamav shares among all scans; scans needing those elsewhere too need an
+	// engine of their own.
+	TempDir string
+
+	// MemoryLimit is the size of the largest objects scanned from memory, 16 MiB if zero.
+	// Larger objects are spooled to TempDir. Deployments without a writable temporary
+	// directory raise it to scan all they can, up to the memory they have.
+	MemoryLimit int64
+
+	// Detectors, by name, are run on the scanned object after libclamav, in the order of
+	// their names; the objects libclamav unpacks from it are not passed to them. Their
+	// detections are reported in the Detections of the results, the first one as the Virus
+	// if libclamav found nothing. A failing detector does not hide what was found: the
+	// result of a detected object is returned along with the error.
+	Detectors map[string]Detector
+
+	// extract, if set, is called with the objects libclamav unpacks, see Engine.Extract
+	extract func(fd int, fileType string)
+}
+
+// Scan scans the data read from r with the engine
+func (s *EngineScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	if s.Strict {
+		if err := s.Options.Supported(); err != nil {
+			return nil, fmt.Errorf("EngineScanner: %w", err)
+		}
+	}
+	s.Engine.hook()
+	sc := &scanContext{}
+	if s.Hashes {
+		sc.hasher = newHasher()
+	}
+	if s.Usage {
+		sc.usage = &ScanUsage{}
+	}
+	if s.Timing {
+		sc.timer = &scanTimer{t: &ScanTiming{}}
+	}
+	sc.extract = s.extract
+	if a, ok := r.(interface{ aborted() bool }); ok {
+		// the scan is run by a Watchdog
+		sc.aborted = a.aborted
+	}
+	if s.TempDir != "" {
+		dir, err := ioutil.TempDir(s.TempDir, "scan")
+		if err != nil {
+			return nil, fmt.Errorf("EngineScanner: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		sc.tmpdir = dir
+	}
+	sc.memoryLimit = s.MemoryLimit
+	start := time.Now()
+	authenticode := AuthenticodeUnknown
+	var detections []Detection
+	var detectErr error
+	sc.inspect = func(r io.ReaderAt, size int64) {
+		if sc.metadata != "" && sc.fileType == "CL_TYPE_MSEXE" {
+			authenticode = authenticodeStatus(r, size)
+		}
+		if len(s.Detectors) > 0 {
+			detections, detectErr = runDetectors(s.Detectors, r, size, sc.fileType)
+		}
+	}
+	virus, _, err := s.Engine.scanReader(r, name, s.Options, sc)
+	if sc.usage != nil {
+		sc.usage.Wall = time.Since(start)
+	}
+	if sc.aborted != nil && sc.aborted() {
+		return nil, ErrScanHung
+	}
+	if virus == "" && err != nil {
+		return nil, err
+	}
+	res := &ScanResult{Name: name, Virus: virus, FileType: sc.fileType, Usage: sc.usage}
+	if len(s.Detectors) > 0 {
+		if virus != "" {
+			res.Detections = append(res.Detections, Detection{ClamAVDetector, virus})
+		}
+		res.Detections = append(res.Detections, detections...)
+		if res.Virus == "" && len(detections) > 0 {
+			res.Virus = detections[0].Name
+		}
+	}
+	if sc.timer != nil {
+		res.Timing = sc.timer.t
+	}
+	if sc.hasher != nil {
+		res.Hashes = sc.hasher.sum()
+	}
+	if sc.metadata != "" {
+		if res.Metadata, err = parseMetadata(sc.metadata); err != nil {
+			return nil, fmt.Errorf("EngineScanner: metadata: %v", err)
+		}
+		if res.Metadata.PE != nil {
+			res.Metadata.PE.Authenticode = authenticode
+		}
+	}
+	res.Encrypted = encryptedObjects(sc.encrypted, virus, sc.fileType, res.Metadata)
+	if detectErr != nil {
+		if res.Virus == "" {
+			return nil, fmt.Errorf("EngineScanner: %v", detectErr)
+		}
+		return res, fmt.Errorf("EngineScanner: %v", detectErr)
+	}
+	return res, nil
+}
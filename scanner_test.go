@@ -0,0 +1,239 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEngineScanner(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	s := &EngineScanner{Engine: eng, Options: stdopts}
+
+	res, err := s.Scan(bytes.NewReader(eicar), "eicar.com")
+	if err != nil || res.Virus != "Eicar-Test-Signature" || res.FileType == "" {
+		t.Errorf("Scan: eicar: %+v %v", res, err)
+	}
+	res, err = s.Scan(strings.NewReader("%PDF-1.4\n%%EOF\n"), "doc.pdf")
+	if err != nil || res.Virus != "" || res.FileType != "CL_TYPE_PDF" {
+		t.Errorf("Scan: pdf: %+v %v", res, err)
+	}
+}
+
+func TestEngineScannerMacros(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	opts := &ScanOptions{General: ScanGeneralCollectMetadata, Parse: ScanParseOle2}
+	s := &EngineScanner{Engine: eng, Options: opts}
+
+	// the test library reports data that looks like metadata as the metadata of the scan
+	doc := `{"Magic":"CLAMJSONv0","RootFileType":"CL_TYPE_ZIP","ContainedObjects":[{"FileType":"CL_TYPE_MSOLE2","HasMacros":true}]}`
+	res, err := s.Scan(strings.NewReader(doc), "macro.docm")
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if res.Metadata == nil || res.Metadata.RootFileType != "CL_TYPE_ZIP" || !res.Metadata.VBAMacros || res.Metadata.XLMMacros || !res.HasMacros() {
+		t.Errorf("Scan: macros not reported: %+v %+v", res, res.Metadata)
+	}
+
+	res, err = s.Scan(strings.NewReader("plain text"), "plain.txt")
+	if err != nil || res.Metadata == nil || res.HasMacros() {
+		t.Errorf("Scan: plain text: %+v %v", res, err)
+	}
+	if !(&ScanResult{Virus: "Heuristics.OLE2.ContainsMacros.XLM"}).HasMacros() {
+		t.Errorf("HasMacros: heuristic alert not recognized")
+	}
+}
+
+func TestScannerHashes(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	srv := &ClamdServer{Scanner: eicarScanner{}}
+	defer srv.Close()
+	client := NewClamdClient(startClamdServer(t, srv))
+	client.Hashes = true
+
+	large := bytes.Repeat([]byte("x"), readerMemoryLimit+1)
+	for _, s := range []Scanner{&EngineScanner{Engine: eng, Options: stdopts, Hashes: true}, client} {
+		for _, data := range [][]byte{eicar, large} {
+			want := &Hashes{
+				MD5:    fmt.Sprintf("%x", md5.Sum(data)),
+				SHA1:   fmt.Sprintf("%x", sha1.Sum(data)),
+				SHA256: fmt.Sprintf("%x", sha256.Sum256(data)),
+			}
+			res, err := s.Scan(bytes.NewReader(data), "object")
+			if err != nil || res.Hashes == nil || *res.Hashes != *want {
+				t.Errorf("Scan: %T: %d bytes: %+v %v, want %+v", s, len(data), res, err, want)
+			}
+		}
+	}
+	if res, err := (&EngineScanner{Engine: eng, Options: stdopts}).Scan(bytes.NewReader(eicar), "eicar"); err != nil || res.Hashes != nil {
+		t.Errorf("Scan: hashes not requested: %+v %v", res, err)
+	}
+}
+
+func TestScannerUsage(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	s := &EngineScanner{Engine: eng, Options: stdopts, Usage: true}
+	for _, data := range [][]byte{eicar, bytes.Repeat([]byte("x"), readerMemoryLimit+1)} {
+		res, err := s.Scan(bytes.NewReader(data), "object")
+		if err != nil || res.Usage == nil {
+			t.Fatalf("Scan: %+v %v", res, err)
+		}
+		u := res.Usage
+		if u.BytesRead != int64(len(data)) || u.Wall <= 0 || u.CPU < 0 || u.TempBytes < 0 {
+			t.Errorf("Scan: %d bytes: usage %+v", len(data), u)
+		}
+	}
+
+	// files are scanned from their descriptor
+	path := filepath.Join(t.TempDir(), "eicar.com")
+	ioutil.WriteFile(path, eicar, 0644)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	s.Hashes = true
+	res, err := s.Scan(f, "eicar.com")
+	if err != nil || res.Virus == "" || res.Usage.BytesRead != int64(len(eicar)) {
+		t.Fatalf("Scan: %+v %v", res, err)
+	}
+	if res.Hashes == nil || res.Hashes.MD5 != fmt.Sprintf("%x", md5.Sum(eicar)) {
+		t.Errorf("Scan: hashes %+v", res.Hashes)
+	}
+	if off, _ := f.Seek(0, io.SeekCurrent); off != int64(len(eicar)) {
+		t.Errorf("Scan: file left at offset %d", off)
+	}
+}
+
+// spoolReader reads an object, recording the directories of the scans in dir and their files
+// as it goes
+type spoolReader struct {
+	io.Reader
+	dir   string
+	dirs  []string
+	files []string
+}
+
+func (r *spoolReader) Read(p []byte) (int, error) {
+	if dirs, _ := filepath.Glob(filepath.Join(r.dir, "*")); len(dirs) > len(r.dirs) {
+		r.dirs = dirs
+	}
+	if files, _ := filepath.Glob(filepath.Join(r.dir, "*", "*")); len(files) > len(r.files) {
+		r.files = files
+	}
+	return r.Reader.Read(p)
+}
+
+func TestScannerTempDir(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	dir := t.TempDir()
+	s := &EngineScanner{Engine: eng, Options: stdopts, TempDir: dir}
+	r := &spoolReader{Reader: bytes.NewReader(bytes.Repeat([]byte("x"), readerMemoryLimit+1)), dir: dir}
+	if _, err := s.Scan(r, "large"); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	// the stream is spooled to the directory of the scan, to a file without a name where the
+	// system allows
+	if len(r.dirs) != 1 {
+		t.Errorf("Scan: scan directories %v", r.dirs)
+	}
+	if len(r.files) != 0 && runtime.GOOS != "windows" {
+		t.Errorf("Scan: spooled to %v", r.files)
+	}
+	if left, _ := ioutil.ReadDir(dir); len(left) != 0 {
+		t.Errorf("Scan: %d files left in the temporary directory", len(left))
+	}
+
+	s.TempDir = filepath.Join(dir, "missing")
+	if _, err := s.Scan(bytes.NewReader(eicar), "eicar"); err == nil {
+		t.Errorf("Scan: missing temporary directory accepted")
+	}
+}
+
+func TestScannerTiming(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.CreateRaw(&zip.FileHeader{Name: "eicar.com", Method: zip.Store, CRC32: crc32.ChecksumIEEE(eicar),
+		CompressedSize64: uint64(len(eicar)), UncompressedSize64: uint64(len(eicar))})
+	w.Write(eicar)
+	zw.Close()
+
+	s := &EngineScanner{Engine: eng, Options: stdopts, Timing: true}
+	res, err := s.Scan(bytes.NewReader(buf.Bytes()), "sample.zip")
+	if err != nil || res.Timing == nil {
+		t.Fatalf("Scan: %+v %v", res, err)
+	}
+	tm := res.Timing
+	if tm.Objects != 2 || tm.Read <= 0 || tm.Unpack < 0 || tm.Match <= 0 {
+		t.Errorf("Scan: timing %+v", tm)
+	}
+	if res, err := (&EngineScanner{Engine: eng, Options: stdopts}).Scan(bytes.NewReader(eicar), "eicar"); err != nil || res.Timing != nil {
+		t.Errorf("Scan: timing not requested: %+v %v", res, err)
+	}
+}
+
+func TestScannerMemoryLimit(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+	// no temporary directory to spool to, as on a read-only filesystem
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+
+	large := append(bytes.Repeat([]byte("x"), readerMemoryLimit), eicar...)
+	if _, err := (&EngineScanner{Engine: eng, Options: stdopts}).Scan(bytes.NewReader(large), "large"); err == nil {
+		t.Errorf("Scan: large object not spooled")
+	}
+	s := &EngineScanner{Engine: eng, Options: stdopts, MemoryLimit: 2 * readerMemoryLimit}
+	if res, err := s.Scan(bytes.NewReader(large), "large"); err != nil || res.Virus != "Eicar-Test-Signature" {
+		t.Errorf("Scan: %+v, %v", res, err)
+	}
+	s.MemoryLimit = 16
+	if _, err := s.Scan(bytes.NewReader(eicar), "eicar"); err == nil {
+		t.Errorf("Scan: MemoryLimit ignored")
+	}
+}
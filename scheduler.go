@@ -0,0 +1,198 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors of ScanScheduler.Scan for scans not run
+var (
+	ErrScanQueueFull    = errors.New("scan queue full")
+	ErrScanQueueTimeout = errors.New("timed out waiting in the scan queue")
+)
+
+// QoSClass is a class of scan requests of a ScanScheduler, such as interactive uploads or
+// background audits
+type QoSClass struct {
+	Name string
+
+	// Priority orders the classes: queued scans of a class of higher priority run first,
+	// ahead of those queued earlier in other classes
+	Priority int
+
+	// MaxRunning is the share of the workers the class may use at once, so that it cannot
+	// take all of them from the other classes; all the workers if zero
+	MaxRunning int
+
+	// MaxQueued is the most scans that may wait, further ones fail with ErrScanQueueFull; no
+	// limit if zero
+	MaxQueued int
+
+	// MaxWait is the longest a scan may wait, after which it fails with ErrScanQueueTimeout;
+	// no limit if zero
+	MaxWait time.Duration
+}
+
+// QoSStats are the statistics of a class of a ScanScheduler
+type QoSStats struct {
+	Name     string
+	Running  int
+	Queued   int
+	Scanned  int64
+	Rejected int64 // queue full
+	TimedOut int64
+	Waited   time.Duration // total time scans spent queued
+}
+
+// qosQueue is the state of a class
+type qosQueue struct {
+	QoSClass
+	stats   QoSStats
+	waiting []chan struct{}
+}
+
+// ScanScheduler runs scans on a Scanner, such as an EnginePool, with a fixed number of workers,
+// queueing scans by class so that batch scans never starve user facing requests
+type ScanScheduler struct {
+	scanner Scanner
+	workers int
+
+	mu      sync.Mutex
+	running int
+	queues  []*qosQueue // by decreasing priority
+	byName  map[string]*qosQueue
+}
+
+// NewScanScheduler returns a scheduler running at most workers scans at once on s, for the
+// given classes
+func NewScanScheduler(s Scanner, workers int, classes ...QoSClass) *ScanScheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	sc := &ScanScheduler{scanner: s, workers: workers, byName: map[string]*qosQueue{}}
+	for _, c := range classes {
+		if c.MaxRunning <= 0 || c.MaxRunning > workers {
+			c.MaxRunning = workers
+		}
+		q := &qosQueue{QoSClass: c, stats: QoSStats{Name: c.Name}}
+		sc.queues = append(sc.queues, q)
+		sc.byName[c.Name] = q
+	}
+	sort.SliceStable(sc.queues, func(i, j int) bool { return sc.queues[i].Priority > sc.queues[j].Priority })
+	return sc
+}
+
+// Scan scans the data read from r in class, once a worker is available to it
+func (s *ScanScheduler) Scan(class string, r io.Reader, name string) (*ScanResult, error) {
+	q := s.byName[class]
+	if q == nil {
+		return nil, fmt.Errorf("ScanScheduler: unknown class %q", class)
+	}
+	if err := s.acquire(q); err != nil {
+		return nil, err
+	}
+	defer s.release(q)
+	return s.scanner.Scan(r, name)
+}
+
+// Class returns a Scanner scanning in class
+func (s *ScanScheduler) Class(class string) Scanner {
+	return &classScanner{s, class}
+}
+
+type classScanner struct {
+	s     *ScanScheduler
+	class string
+}
+
+func (c *classScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	return c.s.Scan(c.class, r, name)
+}
+
+// acquire waits for a worker for a scan of q
+func (s *ScanScheduler) acquire(q *qosQueue) error {
+	s.mu.Lock()
+	// scans already queued in the class are blocked by its share or by the lack of workers
+	if len(q.waiting) == 0 && s.running < s.workers && q.stats.Running < q.MaxRunning {
+		s.running++
+		q.stats.Running++
+		q.stats.Scanned++
+		s.mu.Unlock()
+		return nil
+	}
+	if q.MaxQueued > 0 && len(q.waiting) >= q.MaxQueued {
+		q.stats.Rejected++
+		s.mu.Unlock()
+		return ErrScanQueueFull
+	}
+	ready := make(chan struct{})
+	q.waiting = append(q.waiting, ready)
+	s.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		s.mu.Lock()
+		q.stats.Waited += time.Since(start)
+		s.mu.Unlock()
+	}()
+	if q.MaxWait <= 0 {
+		<-ready
+		return nil
+	}
+	t := time.NewTimer(q.MaxWait)
+	defer t.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-t.C:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range q.waiting {
+		if w == ready {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.stats.TimedOut++
+			return ErrScanQueueTimeout
+		}
+	}
+	// a worker was granted meanwhile
+	return nil
+}
+
+// release frees the worker of a scan of q and hands workers to the queued scans
+func (s *ScanScheduler) release(q *qosQueue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	q.stats.Running--
+	for _, q := range s.queues {
+		for len(q.waiting) > 0 && s.running < s.workers && q.stats.Running < q.MaxRunning {
+			s.running++
+			q.stats.Running++
+			q.stats.Scanned++
+			close(q.waiting[0])
+			q.waiting = q.waiting[1:]
+		}
+	}
+}
+
+// Stats returns the statistics of the classes, by decreasing priority
+func (s *ScanScheduler) Stats() []QoSStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var st []QoSStats
+	for _, q := range s.queues {
+		qs := q.stats
+		qs.Queued = len(q.waiting)
+		st = append(st, qs)
+	}
+	return st
+}
@@ -0,0 +1,130 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateScanner records the order scans start in and blocks them until released
+type gateScanner struct {
+	mu      sync.Mutex
+	started []string
+	gates   map[string]chan struct{}
+}
+
+func (g *gateScanner) gate(name string) chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.gates == nil {
+		g.gates = map[string]chan struct{}{}
+	}
+	if g.gates[name] == nil {
+		g.gates[name] = make(chan struct{})
+	}
+	return g.gates[name]
+}
+
+// release lets the scan of name complete
+func (g *gateScanner) release(name string) {
+	close(g.gate(name))
+}
+
+func (g *gateScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	g.mu.Lock()
+	g.started = append(g.started, name)
+	g.mu.Unlock()
+	<-g.gate(name)
+	return &ScanResult{Name: name}, nil
+}
+
+// waitQueued waits until the classes have the given number of scans queued and running
+func waitQueued(t *testing.T, s *ScanScheduler, queued, running int) {
+	for i := 0; i < 1000; i++ {
+		q, r := 0, 0
+		for _, st := range s.Stats() {
+			q += st.Queued
+			r += st.Running
+		}
+		if q == queued && r == running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%+v, want %d queued and %d running", s.Stats(), queued, running)
+}
+
+func TestScanScheduler(t *testing.T) {
+	g := &gateScanner{}
+	s := NewScanScheduler(g, 2,
+		QoSClass{Name: "batch", MaxRunning: 1, MaxQueued: 2},
+		QoSClass{Name: "interactive", Priority: 10, MaxWait: time.Minute},
+	)
+	var wg sync.WaitGroup
+	scan := func(class, name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Class(class).Scan(strings.NewReader(""), name); err != nil {
+				t.Errorf("Scan: %s: %v", name, err)
+			}
+		}()
+	}
+
+	// batch scans take one worker only
+	scan("batch", "b1")
+	waitQueued(t, s, 0, 1)
+	scan("batch", "b2")
+	waitQueued(t, s, 1, 1)
+	scan("batch", "b3")
+	waitQueued(t, s, 2, 1)
+	if _, err := s.Scan("batch", strings.NewReader(""), "b4"); err != ErrScanQueueFull {
+		t.Errorf("Scan: got %v with a full queue", err)
+	}
+	scan("interactive", "i1")
+	waitQueued(t, s, 2, 2)
+
+	// queued interactive scans run before the batch scans queued earlier
+	scan("interactive", "i2")
+	waitQueued(t, s, 3, 2)
+	g.release("b1")
+	waitQueued(t, s, 2, 2)
+	g.release("i1")
+	waitQueued(t, s, 1, 2)
+	for _, name := range []string{"i2", "b2", "b3"} {
+		g.release(name)
+	}
+	wg.Wait()
+
+	if got := strings.Join(g.started, " "); got != "b1 i1 i2 b2 b3" {
+		t.Errorf("Scan: started in order %s", got)
+	}
+	st := s.Stats()
+	if st[0].Name != "interactive" || st[0].Scanned != 2 || st[1].Scanned != 3 || st[1].Rejected != 1 {
+		t.Errorf("Stats: %+v", st)
+	}
+
+	if _, err := s.Scan("audit", strings.NewReader(""), "x"); err == nil {
+		t.Errorf("Scan: unknown class accepted")
+	}
+}
+
+func TestScanSchedulerTimeout(t *testing.T) {
+	g := &gateScanner{}
+	defer g.release("b1")
+	s := NewScanScheduler(g, 1, QoSClass{Name: "batch", MaxWait: 10 * time.Millisecond})
+	go s.Scan("batch", strings.NewReader(""), "b1")
+	waitQueued(t, s, 0, 1)
+	if _, err := s.Scan("batch", strings.NewReader(""), "b2"); err != ErrScanQueueTimeout {
+		t.Errorf("Scan: got %v, want a timeout", err)
+	}
+	if st := s.Stats(); st[0].TimedOut != 1 || st[0].Queued != 0 {
+		t.Errorf("Stats: %+v", st)
+	}
+}
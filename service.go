@@ -0,0 +1,221 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Service describes a program built on the package to run as a system service, such as a
+// daemon serving ClamdServer, a DirScanner watching a directory or periodic scans, for its
+// systemd unit or launchd property list to be generated and installed
+type Service struct {
+	Name        string   // of the unit, and the label of the launchd job after "org.clamav."
+	Description string   // optional
+	Exec        []string // absolute path of the program, and its arguments
+	User        string   // the service runs as, root if empty
+
+	// ReadWritePaths are the only paths the service may write under systemd, such as the
+	// quarantine directory or the databases a service updates; the rest of the filesystem is
+	// read-only to it
+	ReadWritePaths []string
+
+	// Interval, if not zero, runs the program every Interval rather than keeping it running,
+	// through a timer under systemd
+	Interval time.Duration
+}
+
+// Default directories Service.Install writes to
+const (
+	SystemdUnitDir   = "/etc/systemd/system"
+	LaunchdDaemonDir = "/Library/LaunchDaemons"
+)
+
+// check returns an error if the service cannot be described to systemd or launchd
+func (s *Service) check() error {
+	if s.Name == "" || strings.ContainsAny(s.Name, "/\\ \n") {
+		return fmt.Errorf("invalid service name %q", s.Name)
+	}
+	if len(s.Exec) == 0 || !filepath.IsAbs(s.Exec[0]) {
+		return errors.New("the program of the service is not an absolute path")
+	}
+	for _, v := range append(append([]string{s.Description, s.User}, s.Exec...), s.ReadWritePaths...) {
+		if strings.ContainsAny(v, "\n\r") {
+			return fmt.Errorf("newline in %q", v)
+		}
+	}
+	return nil
+}
+
+// systemdQuote quotes a word of a systemd command line or path list
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// SystemdUnit returns the unit of the service, sandboxed: the service gets no new privileges,
+// a private /tmp and devices, a read-only view of the system and home directories but for
+// ReadWritePaths, and only the capability to read every file, to scan them. Memory both
+// writable and executable stays allowed, for the bytecode JIT of libclamav.
+func (s *Service) SystemdUnit() (string, error) {
+	if err := s.check(); err != nil {
+		return "", fmt.Errorf("SystemdUnit: %v", err)
+	}
+	var b strings.Builder
+	desc := s.Description
+	if desc == "" {
+		desc = s.Name
+	}
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s\nAfter=network.target\n\n[Service]\n", desc)
+	if s.Interval > 0 {
+		b.WriteString("Type=oneshot\n")
+	} else {
+		b.WriteString("Type=simple\nRestart=on-failure\nRestartSec=5s\n")
+	}
+	var exec []string
+	for _, w := range s.Exec {
+		exec = append(exec, systemdQuote(w))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(exec, " "))
+	if s.User != "" {
+		fmt.Fprintf(&b, "User=%s\nAmbientCapabilities=CAP_DAC_READ_SEARCH\n", s.User)
+	}
+	b.WriteString(`CapabilityBoundingSet=CAP_DAC_READ_SEARCH
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=read-only
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectControlGroups=yes
+RestrictSUIDSGID=yes
+RestrictRealtime=yes
+LockPersonality=yes
+`)
+	if len(s.ReadWritePaths) > 0 {
+		var paths []string
+		for _, p := range s.ReadWritePaths {
+			paths = append(paths, systemdQuote(p))
+		}
+		fmt.Fprintf(&b, "ReadWritePaths=%s\n", strings.Join(paths, " "))
+	}
+	if s.Interval > 0 {
+		return b.String(), nil
+	}
+	b.WriteString("\n[Install]\nWantedBy=multi-user.target\n")
+	return b.String(), nil
+}
+
+// SystemdTimer returns the timer running the service every Interval, "" if it has none
+func (s *Service) SystemdTimer() string {
+	if s.Interval <= 0 {
+		return ""
+	}
+	sec := int64(s.Interval / time.Second)
+	if sec < 1 {
+		sec = 1
+	}
+	return fmt.Sprintf("[Unit]\nDescription=Run %s every %v\n\n[Timer]\nOnBootSec=%ds\nOnUnitActiveSec=%ds\nPersistent=true\n\n[Install]\nWantedBy=timers.target\n",
+		s.Name, s.Interval, sec, sec)
+}
+
+// LaunchdPlist returns the property list of the launchd job of the service, kept running or
+// started every Interval. launchd has no equivalent of the sandboxing of SystemdUnit.
+func (s *Service) LaunchdPlist() (string, error) {
+	if err := s.check(); err != nil {
+		return "", fmt.Errorf("LaunchdPlist: %v", err)
+	}
+	esc := func(v string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(v))
+		return b.String()
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(&b, "\t<key>Label</key>\n\t<string>%s</string>\n\t<key>ProgramArguments</key>\n\t<array>\n", esc(s.launchdLabel()))
+	for _, w := range s.Exec {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", esc(w))
+	}
+	b.WriteString("\t</array>\n")
+	if s.User != "" {
+		fmt.Fprintf(&b, "\t<key>UserName</key>\n\t<string>%s</string>\n", esc(s.User))
+	}
+	if s.Interval > 0 {
+		sec := int64(s.Interval / time.Second)
+		if sec < 1 {
+			sec = 1
+		}
+		fmt.Fprintf(&b, "\t<key>StartInterval</key>\n\t<integer>%d</integer>\n", sec)
+	} else {
+		b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n\t<key>KeepAlive</key>\n\t<true/>\n")
+	}
+	b.WriteString("\t<key>ProcessType</key>\n\t<string>Background</string>\n</dict>\n</plist>\n")
+	return b.String(), nil
+}
+
+func (s *Service) launchdLabel() string {
+	return "org.clamav." + s.Name
+}
+
+// Install writes the files of the service for the system it runs on into dir, SystemdUnitDir
+// or LaunchdDaemonDir if empty: the unit and timer of systemd, or the property list of launchd
+// on macOS. It returns the paths written; enabling the service is left to systemctl or
+// launchctl.
+func (s *Service) Install(dir string) ([]string, error) {
+	files := map[string]string{}
+	if runtime.GOOS == "darwin" {
+		if dir == "" {
+			dir = LaunchdDaemonDir
+		}
+		plist, err := s.LaunchdPlist()
+		if err != nil {
+			return nil, fmt.Errorf("Install: %v", err)
+		}
+		files[s.launchdLabel()+".plist"] = plist
+	} else {
+		if dir == "" {
+			dir = SystemdUnitDir
+		}
+		unit, err := s.SystemdUnit()
+		if err != nil {
+			return nil, fmt.Errorf("Install: %v", err)
+		}
+		files[s.Name+".service"] = unit
+		if timer := s.SystemdTimer(); timer != "" {
+			files[s.Name+".timer"] = timer
+		}
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var paths []string
+	for _, name := range names {
+		content := files[name]
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			return paths, fmt.Errorf("Install: %v", err)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService(t *testing.T) {
+	s := &Service{
+		Name:           "goclamd",
+		Exec:           []string{"/usr/local/bin/goclamd", "-listen", "/run/clamd.sock", "-dir", "/srv/my files"},
+		User:           "clamav",
+		ReadWritePaths: []string{"/var/lib/clamav", "/var/quarantine"},
+	}
+	unit, err := s.SystemdUnit()
+	if err != nil {
+		t.Fatalf("SystemdUnit: %v", err)
+	}
+	for _, want := range []string{
+		"Description=goclamd\n",
+		"Type=simple\nRestart=on-failure\n",
+		`ExecStart=/usr/local/bin/goclamd -listen /run/clamd.sock -dir "/srv/my files"` + "\n",
+		"User=clamav\nAmbientCapabilities=CAP_DAC_READ_SEARCH\n",
+		"NoNewPrivileges=yes\n", "ProtectSystem=strict\n", "PrivateTmp=yes\n",
+		"ReadWritePaths=/var/lib/clamav /var/quarantine\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("SystemdUnit: %q missing in\n%s", want, unit)
+		}
+	}
+	if s.SystemdTimer() != "" {
+		t.Errorf("SystemdTimer: timer of a service kept running")
+	}
+
+	s.Interval = 6 * time.Hour
+	s.Exec = append(s.Exec, "100%<&>")
+	unit, _ = s.SystemdUnit()
+	if !strings.Contains(unit, "Type=oneshot\n") || strings.Contains(unit, "[Install]") || !strings.Contains(unit, " 100%%<&>\n") {
+		t.Errorf("SystemdUnit: periodic service\n%s", unit)
+	}
+	if timer := s.SystemdTimer(); !strings.Contains(timer, "OnUnitActiveSec=21600s\n") || !strings.Contains(timer, "WantedBy=timers.target\n") {
+		t.Errorf("SystemdTimer:\n%s", timer)
+	}
+
+	plist, err := s.LaunchdPlist()
+	if err != nil {
+		t.Fatalf("LaunchdPlist: %v", err)
+	}
+	if err := xml.Unmarshal([]byte(plist), new(struct{})); err != nil {
+		t.Errorf("LaunchdPlist: invalid XML: %v\n%s", err, plist)
+	}
+	for _, want := range []string{"<string>org.clamav.goclamd</string>", "<string>100%&lt;&amp;&gt;</string>", "<integer>21600</integer>", "<string>clamav</string>"} {
+		if !strings.Contains(plist, want) {
+			t.Errorf("LaunchdPlist: %q missing in\n%s", want, plist)
+		}
+	}
+
+	dir := t.TempDir()
+	paths, err := s.Install(dir)
+	if err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	want := []string{filepath.Join(dir, "goclamd.service"), filepath.Join(dir, "goclamd.timer")}
+	if runtime.GOOS == "darwin" {
+		want = []string{filepath.Join(dir, "org.clamav.goclamd.plist")}
+	}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("Install: %v, want %v", paths, want)
+	}
+	if b, _ := ioutil.ReadFile(paths[0]); len(b) == 0 {
+		t.Errorf("Install: %s empty", paths[0])
+	}
+
+	for _, bad := range []*Service{
+		{Name: "x", Exec: []string{"goclamd"}},
+		{Name: "a/b", Exec: []string{"/bin/goclamd"}},
+		{Name: "x", Exec: []string{"/bin/goclamd", "a\nExecStartPre=/bin/sh"}},
+	} {
+		if _, err := bad.SystemdUnit(); err == nil {
+			t.Errorf("SystemdUnit: %+v accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// SkipPolicy trades coverage for throughput by skipping objects by type or size, such as "never
+// scan video/* or objects over 2GB, but always scan executables and Office documents". Skipped
+// objects, be they files or objects inside them, are reported clean.
+//
+// Types are matched with path.Match patterns against both the ClamAV type of the object, such
+// as "CL_TYPE_MSEXE", and its MIME type as sniffed by http.DetectContentType, such as
+// "video/mp4". The ClamAV type is known for all objects, the size and MIME type are unknown for
+// objects scanned from memory inside other objects, which are then only skipped by type.
+type SkipPolicy struct {
+	// first, for the 64-bit atomic operations to be aligned on 32-bit platforms
+	scanned, skippedType, skippedSize uint64
+
+	Always  []string // types scanned whatever their size, e.g. ExecutableTypes
+	Skip    []string // types never scanned, e.g. "video/*" or "CL_TYPE_GRAPHICS"
+	MaxSize int64    // objects larger are not scanned, no limit if zero
+}
+
+// ExecutableTypes are the ClamAV types of executables, scripts and documents that may carry
+// macros, which a SkipPolicy should usually always scan
+var ExecutableTypes = []string{
+	"CL_TYPE_MSEXE", "CL_TYPE_ELF", "CL_TYPE_MACHO", "CL_TYPE_MACHO_UNIBIN",
+	"CL_TYPE_MSOLE2", "CL_TYPE_OOXML_*", "CL_TYPE_PDF", "CL_TYPE_RTF",
+	"CL_TYPE_SCRIPT", "CL_TYPE_JAVA", "CL_TYPE_ONENOTE",
+}
+
+// SkipStats counts the decisions of a SkipPolicy
+type SkipStats struct {
+	Scanned     uint64
+	SkippedType uint64 // objects skipped for their type
+	SkippedSize uint64 // objects skipped for their size
+}
+
+// Stats returns the decisions counted so far
+func (p *SkipPolicy) Stats() SkipStats {
+	return SkipStats{
+		Scanned:     atomic.LoadUint64(&p.scanned),
+		SkippedType: atomic.LoadUint64(&p.skippedType),
+		SkippedSize: atomic.LoadUint64(&p.skippedSize),
+	}
+}
+
+// skip decides whether to skip an object of the ClamAV type ftype and size bytes, -1 if
+// unknown. sniff returns the first bytes of the object, nil if unknown, and is only called if
+// MIME type patterns need it.
+func (p *SkipPolicy) skip(ftype string, size int64, sniff func() []byte) bool {
+	mime, sniffed := "", false
+	matches := func(patterns []string) bool {
+		for _, pat := range patterns {
+			if strings.Contains(pat, "/") {
+				if !sniffed {
+					if head := sniff(); head != nil {
+						mime = strings.SplitN(http.DetectContentType(head), ";", 2)[0]
+					}
+					sniffed = true
+				}
+				if ok, _ := path.Match(pat, mime); ok && mime != "" {
+					return true
+				}
+			} else if ok, _ := path.Match(pat, ftype); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case matches(p.Always):
+	case matches(p.Skip):
+		atomic.AddUint64(&p.skippedType, 1)
+		return true
+	case p.MaxSize > 0 && size > p.MaxSize:
+		atomic.AddUint64(&p.skippedSize, 1)
+		return true
+	}
+	atomic.AddUint64(&p.scanned, 1)
+	return false
+}
+
+// sniffLen is the number of bytes http.DetectContentType considers
+const sniffLen = 512
+
+// skipDesc decides whether to skip the file open as fd
+func (p *SkipPolicy) skipDesc(fd int, ftype string) bool {
+	return p.skip(ftype, descSize(fd), func() []byte {
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(&descReader{fd: fd}, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil
+		}
+		return head[:n]
+	})
+}
+
+// skipData decides whether to skip the object buf, nil if unknown
+func (p *SkipPolicy) skipData(buf []byte, ftype string) bool {
+	if buf == nil {
+		return p.skip(ftype, -1, func() []byte { return nil })
+	}
+	return p.skip(ftype, int64(len(buf)), func() []byte {
+		if len(buf) > sniffLen {
+			return buf[:sniffLen]
+		}
+		return buf
+	})
+}
+
+// SetSkipPolicy makes the scans skip the objects selected by p, or stops skipping if p is nil.
+// Like the callbacks, the policy is process-wide, shared by all engines, and may be replaced
+// while scans are in progress; it is applied from the pre_cache callback.
+func (e *Engine) SetSkipPolicy(p *SkipPolicy) {
+	if p == nil {
+		setCallbackFunc("skip", nil)
+		return
+	}
+	setCallbackFunc("skip", p)
+	e.hook()
+}
+
+// currentSkipPolicy returns the policy set with SetSkipPolicy, nil if none
+func currentSkipPolicy() *SkipPolicy {
+	p, _ := callbackFunc("skip").(*SkipPolicy)
+	return p
+}
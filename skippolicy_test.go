@@ -0,0 +1,98 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSkipPolicy(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	p := &SkipPolicy{Always: ExecutableTypes, Skip: []string{"video/*", "CL_TYPE_ZIP"}, MaxSize: 1 << 10}
+	eng.SetSkipPolicy(p)
+	defer eng.SetSkipPolicy(nil)
+
+	// the test data carries the EICAR string, so it is reported unless skipped
+	video := append([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), eicar...)
+	zip := append([]byte("PK\x03\x04"), eicar...)
+	large := append(bytes.Repeat([]byte("x"), 1<<10), eicar...)
+	exe := append(append([]byte("MZ"), bytes.Repeat([]byte("x"), 1<<10)...), eicar...)
+	for _, tc := range []struct {
+		name string
+		data []byte
+		skip bool
+	}{
+		{"video", video, true},
+		{"zip", zip, true},
+		{"large", large, true},
+		{"large exe", exe, false},
+		{"eicar", eicar, false},
+	} {
+		virus, _, _ := eng.ScanBytes(tc.data, tc.name, stdopts)
+		if (virus == "") != tc.skip {
+			t.Errorf("ScanBytes: %s: virus %q, skip %v", tc.name, virus, tc.skip)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	ioutil.WriteFile(path, video, 0644)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if virus, _, _ := eng.ScanDescCb("video.mp4", int(f.Fd()), stdopts, nil); virus != "" {
+		t.Errorf("ScanDescCb: video scanned: %q", virus)
+	}
+
+	if s := p.Stats(); s != (SkipStats{Scanned: 2, SkippedType: 3, SkippedSize: 1}) {
+		t.Errorf("Stats: %+v", s)
+	}
+}
+
+func TestSkipPolicyConcurrent(t *testing.T) {
+	p, err := NewEnginePool(LoadEngine(DBDir(), DbStdopt, nil), nil, stdopts)
+	if err != nil {
+		t.Fatalf("NewEnginePool: %v", err)
+	}
+	defer p.Close()
+	e, release, err := p.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer release()
+	defer e.SetSkipPolicy(nil)
+
+	// the policy is replaced while the pool scans
+	policy := &SkipPolicy{MaxSize: 1}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				res, err := p.Scan(bytes.NewReader(eicar), "eicar")
+				if err != nil || res.Virus != "" && res.Virus != "Eicar-Test-Signature" {
+					t.Errorf("Scan: %+v %v", res, err)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		e.SetSkipPolicy(policy)
+		e.SetSkipPolicy(nil)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,417 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPAction is what an SMTPProxy does with infected messages
+type SMTPAction int
+
+// Actions on infected messages
+const (
+	SMTPReject SMTPAction = iota // refuse the message with a permanent error
+	SMTPTag                      // relay the message with an X-Virus-Found header
+)
+
+// SMTPProxy is a transparent SMTP proxy that scans messages before relaying them to an upstream
+// MTA, for MTAs without milter support. Envelope commands are relayed as they arrive, so the
+// client gets the replies of the upstream MTA, while message data is held in memory until it
+// has been scanned. Messages that cannot be scanned are refused with a temporary error, so that
+// the client retries later.
+type SMTPProxy struct {
+	Upstream string // host:port of the MTA messages are relayed to
+	Scanner  Scanner
+	Action   SMTPAction
+
+	// Hostname is announced in the greeting, the host name of the system if empty
+	Hostname string
+
+	// MaxSize is the largest message accepted, DefaultMaxMessageSize if zero
+	MaxSize int64
+
+	// Timeout bounds every exchange with the client or the upstream MTA, no limit if zero
+	Timeout time.Duration
+
+	// UpstreamTLS, if set, is used to secure the connection to the upstream MTA with STARTTLS
+	// when it offers it
+	UpstreamTLS *tls.Config
+
+	// Detected is called, if set, for every infected message
+	Detected func(from string, to []string, virus string)
+
+	conns tracker
+}
+
+// DefaultMaxMessageSize is the message size limit used when SMTPProxy.MaxSize is zero
+const DefaultMaxMessageSize = 32 << 20
+
+// ErrSMTPProxyClosed is returned by SMTPProxy.Serve after Close
+var ErrSMTPProxyClosed = errors.New("smtp proxy: closed")
+
+// ListenAndServe listens on the TCP address and serves connections, see Serve
+func (p *SMTPProxy) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// ServeActivated serves SMTP connections on the sockets named name passed by socket
+// activation, see ActivationListeners, until Close is called or one of them fails
+func (p *SMTPProxy) ServeActivated(name string) error {
+	ls, err := ActivationListeners(name)
+	if err != nil {
+		return err
+	}
+	return serveListeners(ls, p.Serve)
+}
+
+// Serve accepts SMTP connections on l until Close is called, always returning a non-nil error
+func (p *SMTPProxy) Serve(l net.Listener) error {
+	err := p.conns.serve(l, p.serveConn)
+	if err == errTrackerClosed {
+		return ErrSMTPProxyClosed
+	}
+	return err
+}
+
+// Close closes the listeners and all connections of the proxy
+func (p *SMTPProxy) Close() error {
+	p.conns.close()
+	return nil
+}
+
+// maxSMTPLine is the length of the longest command line accepted, twice the 512 bytes of RFC
+// 5321 to leave room for the parameters of extensions
+const maxSMTPLine = 1024
+
+// errUpstreamLost is the error of the transactions whose upstream connection was dropped
+var errUpstreamLost = errors.New("upstream connection lost")
+
+// smtpSession is the state of a client connection
+type smtpSession struct {
+	p        *SMTPProxy
+	conn     net.Conn
+	tp       *textproto.Conn
+	upstream *smtp.Client
+	upconn   net.Conn // the connection of upstream, for deadlines
+	mailing  bool     // within a mail transaction
+	from     string
+	to       []string
+}
+
+func (p *SMTPProxy) serveConn(conn net.Conn) {
+	s := &smtpSession{p: p, conn: conn, tp: textproto.NewConn(conn)}
+	defer s.closeUpstream()
+
+	s.reply(220, p.hostname()+" ESMTP ready")
+	for {
+		s.deadline()
+		line, err := readLine(s.tp.R, '\n', maxSMTPLine)
+		if err == errCommandTooLong {
+			s.reply(500, "5.5.2 Line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\r")
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			s.reset()
+			s.reply(250, p.hostname(), "8BITMIME", fmt.Sprintf("SIZE %d", p.maxSize()))
+		case "HELO":
+			s.reset()
+			s.reply(250, p.hostname())
+		case "MAIL":
+			s.mail(arg)
+		case "RCPT":
+			s.rcpt(arg)
+		case "DATA":
+			s.data()
+		case "RSET":
+			s.reset()
+			s.reply(250, "2.0.0 OK")
+		case "NOOP":
+			s.reply(250, "2.0.0 OK")
+		case "VRFY":
+			s.reply(252, "2.5.0 Cannot verify user")
+		case "QUIT":
+			s.reply(221, "2.0.0 Bye")
+			return
+		default:
+			s.reply(502, "5.5.2 Command not implemented")
+		}
+	}
+}
+
+func (p *SMTPProxy) hostname() string {
+	if p.Hostname != "" {
+		return p.Hostname
+	}
+	if h, err := os.Hostname(); err == nil {
+		return h
+	}
+	return "localhost"
+}
+
+func (p *SMTPProxy) maxSize() int64 {
+	if p.MaxSize > 0 {
+		return p.MaxSize
+	}
+	return DefaultMaxMessageSize
+}
+
+// deadline renews the deadline of the client connection
+func (s *smtpSession) deadline() {
+	if s.p.Timeout > 0 {
+		s.conn.SetDeadline(time.Now().Add(s.p.Timeout))
+	}
+}
+
+// reply sends a possibly multi-line reply
+func (s *smtpSession) reply(code int, lines ...string) {
+	for i, l := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		s.tp.PrintfLine("%d%s%s", code, sep, l)
+	}
+}
+
+// replyError relays an error of the upstream MTA, or reports that it is unreachable
+func (s *smtpSession) replyError(err error) {
+	if e, ok := err.(*textproto.Error); ok {
+		s.reply(e.Code, e.Msg)
+		return
+	}
+	s.lostUpstream()
+}
+
+// reset aborts the current transaction
+func (s *smtpSession) reset() {
+	if s.upstream != nil && s.mailing {
+		s.upstreamDeadline()
+		if err := s.upstream.Reset(); err != nil {
+			s.closeUpstream()
+		}
+	}
+	s.mailing, s.from, s.to = false, "", nil
+}
+
+// closeUpstream drops the connection to the upstream MTA, and with it the transaction the
+// upstream MTA had the envelope of
+func (s *smtpSession) closeUpstream() {
+	if s.upstream != nil {
+		s.upstream.Close()
+		s.upstream, s.upconn = nil, nil
+	}
+	s.mailing, s.from, s.to = false, "", nil
+}
+
+// lostUpstream replies to a command of a transaction whose upstream connection was dropped
+func (s *smtpSession) lostUpstream() {
+	s.closeUpstream()
+	s.reply(451, "4.4.1 Upstream server unavailable")
+}
+
+// upstreamDeadline renews the deadline of the upstream connection
+func (s *smtpSession) upstreamDeadline() {
+	if s.p.Timeout > 0 && s.upconn != nil {
+		s.upconn.SetDeadline(time.Now().Add(s.p.Timeout))
+	}
+}
+
+// dialUpstream connects to the upstream MTA, unless already connected
+func (s *smtpSession) dialUpstream() error {
+	if s.upstream != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", s.p.Upstream, s.p.Timeout)
+	if err != nil {
+		return err
+	}
+	if s.p.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.p.Timeout))
+	}
+	host, _, _ := net.SplitHostPort(s.p.Upstream)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if err := c.Hello(s.p.hostname()); err != nil {
+		c.Close()
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok && s.p.UpstreamTLS != nil {
+		cfg := s.p.UpstreamTLS
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = host
+		}
+		if err := c.StartTLS(cfg); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	s.upstream, s.upconn = c, conn
+	return nil
+}
+
+// pathArg returns the address of a "FROM:<address> [parameters]" or "TO:<address>" argument
+func pathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", false
+	}
+	return arg[1:end], true
+}
+
+func (s *smtpSession) mail(arg string) {
+	if s.mailing {
+		s.reply(503, "5.5.1 Nested MAIL command")
+		return
+	}
+	from, ok := pathArg(arg, "FROM:")
+	if !ok {
+		s.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	if err := s.dialUpstream(); err != nil {
+		s.replyError(err)
+		return
+	}
+	s.upstreamDeadline()
+	if err := s.upstream.Mail(from); err != nil {
+		s.replyError(err)
+		return
+	}
+	s.mailing, s.from = true, from
+	s.reply(250, "2.1.0 OK")
+}
+
+func (s *smtpSession) rcpt(arg string) {
+	if !s.mailing {
+		s.reply(503, "5.5.1 Need MAIL command")
+		return
+	}
+	to, ok := pathArg(arg, "TO:")
+	if !ok || to == "" {
+		s.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	if s.upstream == nil {
+		s.lostUpstream()
+		return
+	}
+	s.upstreamDeadline()
+	if err := s.upstream.Rcpt(to); err != nil {
+		s.replyError(err)
+		return
+	}
+	s.to = append(s.to, to)
+	s.reply(250, "2.1.5 OK")
+}
+
+func (s *smtpSession) data() {
+	if len(s.to) == 0 {
+		s.reply(503, "5.5.1 Need RCPT command")
+		return
+	}
+	if s.upstream == nil {
+		s.lostUpstream()
+		return
+	}
+	s.reply(354, "Start mail input; end with <CRLF>.<CRLF>")
+
+	max := s.p.maxSize()
+	dr := s.tp.DotReader()
+	msg, err := ioutil.ReadAll(io.LimitReader(dr, max+1))
+	if err != nil {
+		return
+	}
+	if int64(len(msg)) > max {
+		// drain the rest of the message before replying
+		if _, err := io.Copy(ioutil.Discard, dr); err != nil {
+			return
+		}
+		s.reset()
+		s.reply(552, "5.3.4 Message size exceeds fixed limit")
+		return
+	}
+
+	res, err := s.p.Scanner.Scan(bytes.NewReader(msg), "message")
+	if err != nil {
+		s.reset()
+		s.reply(451, "4.7.1 Unable to scan message, try again later")
+		return
+	}
+	if res.Virus != "" {
+		if s.p.Detected != nil {
+			s.p.Detected(s.from, s.to, res.Virus)
+		}
+		if s.p.Action == SMTPReject {
+			s.reset()
+			s.reply(554, "5.7.1 Message rejected: virus "+res.Virus+" found")
+			return
+		}
+		msg = append([]byte("X-Virus-Found: "+res.Virus+"\n"), msg...)
+	}
+
+	// the upstream MTA ends the transaction whatever the outcome
+	err = s.relay(msg)
+	s.mailing, s.from, s.to = false, "", nil
+	if err != nil {
+		s.replyError(err)
+		return
+	}
+	s.reply(250, "2.0.0 OK")
+}
+
+// relay sends the message data to the upstream MTA, the envelope having been sent already
+func (s *smtpSession) relay(msg []byte) error {
+	if s.upstream == nil {
+		return errUpstreamLost
+	}
+	s.upstreamDeadline()
+	w, err := s.upstream.Data()
+	if err != nil {
+		return err
+	}
+	// DotReader turned line endings into "\n", the writer turns them back into "\r\n"
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,207 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMTA records the messages relayed to it, refusing recipients at "invalid" and dropping
+// the connection at recipients at "drop"
+type fakeMTA struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (m *fakeMTA) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			tp := textproto.NewConn(conn)
+			tp.PrintfLine("220 upstream ESMTP")
+			for {
+				line, err := tp.ReadLine()
+				if err != nil {
+					return
+				}
+				switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); {
+				case cmd == "EHLO":
+					tp.PrintfLine("250-upstream")
+					tp.PrintfLine("250 8BITMIME")
+				case cmd == "RCPT" && strings.Contains(line, "@drop"):
+					return
+				case cmd == "RCPT" && strings.Contains(line, "@invalid"):
+					tp.PrintfLine("550 5.1.1 No such user")
+				case cmd == "DATA":
+					tp.PrintfLine("354 go ahead")
+					msg, err := ioutil.ReadAll(tp.DotReader())
+					if err != nil {
+						return
+					}
+					m.mu.Lock()
+					m.messages = append(m.messages, string(msg))
+					m.mu.Unlock()
+					tp.PrintfLine("250 queued")
+				case cmd == "QUIT":
+					tp.PrintfLine("221 bye")
+					return
+				default:
+					tp.PrintfLine("250 OK")
+				}
+			}
+		}()
+	}
+}
+
+func (m *fakeMTA) relayed() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.messages...)
+}
+
+func startSMTPProxy(t *testing.T, p *SMTPProxy, mta *fakeMTA) string {
+	ul, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go mta.serve(ul)
+	p.Upstream = ul.Addr().String()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go func() {
+		p.Serve(l)
+		ul.Close()
+	}()
+	return l.Addr().String()
+}
+
+func TestSMTPProxy(t *testing.T) {
+	var detected []string
+	mta := &fakeMTA{}
+	p := &SMTPProxy{Scanner: eicarScanner{}, Hostname: "proxy", MaxSize: 1 << 16, Timeout: 5 * time.Second,
+		Detected: func(from string, to []string, virus string) {
+			detected = append(detected, from+" "+strings.Join(to, ",")+" "+virus)
+		}}
+	defer p.Close()
+	addr := startSMTPProxy(t, p, mta)
+
+	clean := "Subject: hello\r\n\r\nclean\r\n"
+	if err := smtp.SendMail(addr, nil, "a@example.com", []string{"b@example.com"}, []byte(clean)); err != nil {
+		t.Errorf("SendMail: clean: %v", err)
+	}
+	infected := "Subject: eicar\r\n\r\n" + string(eicar) + "\r\n"
+	err := smtp.SendMail(addr, nil, "a@example.com", []string{"b@example.com"}, []byte(infected))
+	if e, ok := err.(*textproto.Error); !ok || e.Code != 554 {
+		t.Errorf("SendMail: infected: %v", err)
+	}
+	err = smtp.SendMail(addr, nil, "a@example.com", []string{"c@invalid"}, []byte(clean))
+	if e, ok := err.(*textproto.Error); !ok || e.Code != 550 {
+		t.Errorf("SendMail: invalid recipient: %v", err)
+	}
+	big := "Subject: big\r\n\r\n" + strings.Repeat("x", 1<<17) + "\r\n"
+	err = smtp.SendMail(addr, nil, "a@example.com", []string{"b@example.com"}, []byte(big))
+	if e, ok := err.(*textproto.Error); !ok || e.Code != 552 {
+		t.Errorf("SendMail: oversized: %v", err)
+	}
+
+	msgs := mta.relayed()
+	if len(msgs) != 1 || msgs[0] != "Subject: hello\n\nclean\n" {
+		t.Errorf("SMTPProxy: relayed %q", msgs)
+	}
+	if len(detected) != 1 || detected[0] != "a@example.com b@example.com Eicar-Test-Signature" {
+		t.Errorf("SMTPProxy: detected %q", detected)
+	}
+
+	p.Action = SMTPTag
+	if err := smtp.SendMail(addr, nil, "a@example.com", []string{"b@example.com"}, []byte(infected)); err != nil {
+		t.Errorf("SendMail: tagged: %v", err)
+	}
+	msgs = mta.relayed()
+	if len(msgs) != 2 || !strings.HasPrefix(msgs[1], "X-Virus-Found: Eicar-Test-Signature\nSubject: eicar\n") {
+		t.Errorf("SMTPProxy: tagged %q", msgs)
+	}
+}
+
+func TestSMTPProxyUpstreamLost(t *testing.T) {
+	mta := &fakeMTA{}
+	p := &SMTPProxy{Scanner: eicarScanner{}, Hostname: "proxy", Timeout: 5 * time.Second}
+	defer p.Close()
+	addr := startSMTPProxy(t, p, mta)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.ReadResponse(220)
+	for _, c := range []struct {
+		cmd  string
+		code int
+	}{
+		{"EHLO client", 250},
+		{"MAIL FROM:<a@example.com>", 250},
+		{"RCPT TO:<b@drop>", 451},
+		// the transaction went with the upstream connection
+		{"RCPT TO:<b@example.com>", 503},
+		{"DATA", 503},
+		{"MAIL FROM:<a@example.com>", 250},
+		{"RCPT TO:<b@example.com>", 250},
+		{"DATA", 354},
+	} {
+		id, _ := tp.Cmd("%s", c.cmd)
+		tp.StartResponse(id)
+		code, msg, err := tp.ReadResponse(0)
+		tp.EndResponse(id)
+		if code != c.code {
+			t.Fatalf("%s: %d %s %v, want %d", c.cmd, code, msg, err, c.code)
+		}
+	}
+	tp.PrintfLine("Subject: hello\r\n\r\nclean\r\n.")
+	if _, _, err := tp.ReadResponse(250); err != nil {
+		t.Errorf("DATA: %v", err)
+	}
+	if msgs := mta.relayed(); len(msgs) != 1 {
+		t.Errorf("SMTPProxy: relayed %q", msgs)
+	}
+}
+
+func TestSMTPProxyLineTooLong(t *testing.T) {
+	p := &SMTPProxy{Scanner: eicarScanner{}, Hostname: "proxy", Timeout: 5 * time.Second}
+	defer p.Close()
+	conn, err := net.Dial("tcp", startSMTPProxy(t, p, &fakeMTA{}))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.ReadResponse(220)
+	go func() {
+		io.WriteString(conn, "EHLO ")
+		chunk := strings.Repeat("x", 4096)
+		for i := 0; i < 1024; i++ {
+			if _, err := io.WriteString(conn, chunk); err != nil {
+				return
+			}
+		}
+	}()
+	if code, msg, _ := tp.ReadResponse(0); code != 500 {
+		t.Errorf("EHLO: endless line: %d %s", code, msg)
+	}
+}
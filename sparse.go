@@ -0,0 +1,63 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#define _GNU_SOURCE
+#include <errno.h>
+#include <unistd.h>
+
+// seek_extent returns the offset of the first data, or hole if set, at or after off in fd, -1
+// with errno ENXIO if there is none, -1 with errno EINVAL if the system cannot tell
+static long long seek_extent(int fd, long long off, int hole)
+{
+#if defined(SEEK_DATA) && defined(SEEK_HOLE)
+	return lseek(fd, (off_t)off, hole ? SEEK_HOLE : SEEK_DATA);
+#else
+	errno = EINVAL;
+	return -1;
+#endif
+}
+*/
+import "C"
+
+import (
+	"os"
+	"syscall"
+)
+
+// extent is a range of a file
+type extent struct {
+	off, len int64
+}
+
+// dataExtents returns the allocated extents of the file f of size bytes, merging those less
+// than gap bytes apart, so that objects across small holes are scanned whole. It fails if the
+// system or file system cannot tell the extents of files.
+func dataExtents(f *os.File, size, gap int64) ([]extent, error) {
+	var ext []extent
+	fd := C.int(f.Fd())
+	for off := int64(0); off < size; {
+		d, err := C.seek_extent(fd, C.longlong(off), 0)
+		if d < 0 {
+			if err == syscall.ENXIO {
+				// no data past off
+				break
+			}
+			return nil, err
+		}
+		h, err := C.seek_extent(fd, d, 1)
+		if h < 0 {
+			return nil, err
+		}
+		if n := len(ext); n > 0 && int64(d)-(ext[n-1].off+ext[n-1].len) < gap {
+			ext[n-1].len = int64(h) - ext[n-1].off
+		} else {
+			ext = append(ext, extent{int64(d), int64(h - d)})
+		}
+		off = int64(h)
+	}
+	return ext, nil
+}
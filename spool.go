@@ -0,0 +1,33 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// spoolFile creates a temporary file in dir, or the default directory if empty, to hold data
+// to be scanned, such as a stream too large for memory. Where the system supports it the file
+// never has a name, elsewhere it is unlinked as soon as it is created, so that hostile content
+// is never at a predictable path and is freed by the system if the process dies. Only where
+// open files cannot be unlinked, as on Windows, does it keep its name until cleanup, which
+// closes it and removes it if need be.
+func spoolFile(dir string) (f *os.File, cleanup func(), err error) {
+	if f, err := openTmpfile(dir); err == nil {
+		return f, func() { f.Close() }, nil
+	}
+	f, err = ioutil.TempFile(dir, "clamav")
+	if err != nil {
+		return nil, nil, err
+	}
+	if os.Remove(f.Name()) == nil {
+		return f, func() { f.Close() }, nil
+	}
+	return f, func() {
+		f.Close()
+		os.Remove(f.Name())
+	}, nil
+}
@@ -0,0 +1,30 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#define _GNU_SOURCE
+#include <fcntl.h>
+*/
+import "C"
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// openTmpfile opens an unnamed file in dir with O_TMPFILE, which fails on kernels before 3.11
+// and on file systems without support for it
+func openTmpfile(dir string) (*os.File, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	fd, err := syscall.Open(dir, C.O_TMPFILE|syscall.O_RDWR|syscall.O_CLOEXEC, 0600)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(dir, "(unnamed)")), nil
+}
@@ -0,0 +1,18 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package clamav
+
+import (
+	"errors"
+	"os"
+)
+
+// openTmpfile fails: files without a name are only supported on Linux
+func openTmpfile(dir string) (*os.File, error) {
+	return nil, errors.New("O_TMPFILE not supported")
+}
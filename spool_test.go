@@ -0,0 +1,33 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io/ioutil"
+	"runtime"
+	"testing"
+)
+
+func TestSpoolFile(t *testing.T) {
+	dir := t.TempDir()
+	f, cleanup, err := spoolFile(dir)
+	if err != nil {
+		t.Fatalf("spoolFile: %v", err)
+	}
+	if _, err := f.Write(eicar); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if names, _ := ioutil.ReadDir(dir); len(names) != 0 && runtime.GOOS != "windows" {
+		t.Errorf("spoolFile: %d names in %s", len(names), dir)
+	}
+	buf := make([]byte, len(eicar))
+	if _, err := f.ReadAt(buf, 0); err != nil || string(buf) != string(eicar) {
+		t.Errorf("ReadAt: %q %v", buf, err)
+	}
+	cleanup()
+	if names, _ := ioutil.ReadDir(dir); len(names) != 0 {
+		t.Errorf("cleanup: %d names left in %s", len(names), dir)
+	}
+}
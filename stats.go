@@ -0,0 +1,251 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+/*
+#include <clamav.h>
+#include <stdlib.h>
+
+void stats_add_sample_cgo(const char *virname, const unsigned char *md5, size_t size, stats_section_t *sections, void *cbdata);
+void stats_remove_sample_cgo(const char *virname, const unsigned char *md5, size_t size, void *cbdata);
+void stats_decrement_count_cgo(const char *virname, const unsigned char *md5, size_t size, void *cbdata);
+void stats_submit_cgo(struct cl_engine *engine, void *cbdata);
+void stats_flush_cgo(struct cl_engine *engine, void *cbdata);
+size_t stats_get_num_cgo(void *cbdata);
+size_t stats_get_size_cgo(void *cbdata);
+char *stats_get_hostid_cgo(void *cbdata);
+*/
+import "C"
+import (
+	"encoding/hex"
+	"sync"
+	"unsafe"
+)
+
+// StatsSample is a detection recorded in the statistics of libclamav
+type StatsSample struct {
+	Virus    string
+	MD5      string // of the detected object, in hex
+	Size     uint64
+	Hits     int            // times the object was detected
+	Sections []StatsSection // of PE objects, unless EngineDisablePeStats is set
+}
+
+// StatsSection is a section of a PE object recorded in the statistics of libclamav
+type StatsSection struct {
+	MD5  string
+	Size uint64
+}
+
+// StatsRecorder captures the statistics libclamav collects about detections, which clamd
+// submits to the ClamAV project when StatsEnabled is set, so that the application can use
+// them, or submit them itself, instead
+type StatsRecorder struct {
+	// HostID identifies the host in the statistics, "none" if empty
+	HostID string
+
+	// OnSubmit, if set, is called with the samples recorded when libclamav submits its
+	// statistics, after which they are dropped
+	OnSubmit func([]StatsSample)
+
+	mu      sync.Mutex
+	samples []*StatsSample
+}
+
+// SetStatsRecorder installs the statistics callbacks of libclamav on e, reporting to r instead
+// of the submission to the ClamAV project. Like the other callbacks, r is process-wide, shared
+// by all engines, and may be replaced while scans are in progress.
+func (e *Engine) SetStatsRecorder(r *StatsRecorder) {
+	setCallbackFunc("stats", r)
+
+	ce := (*C.struct_cl_engine)(unsafe.Pointer(e))
+	C.cl_engine_set_clcb_stats_add_sample(ce, (C.clcb_stats_add_sample)(unsafe.Pointer(C.stats_add_sample_cgo)))
+	C.cl_engine_set_clcb_stats_remove_sample(ce, (C.clcb_stats_remove_sample)(unsafe.Pointer(C.stats_remove_sample_cgo)))
+	C.cl_engine_set_clcb_stats_decrement_count(ce, (C.clcb_stats_decrement_count)(unsafe.Pointer(C.stats_decrement_count_cgo)))
+	C.cl_engine_set_clcb_stats_submit(ce, (C.clcb_stats_submit)(unsafe.Pointer(C.stats_submit_cgo)))
+	C.cl_engine_set_clcb_stats_flush(ce, (C.clcb_stats_flush)(unsafe.Pointer(C.stats_flush_cgo)))
+	C.cl_engine_set_clcb_stats_get_num(ce, (C.clcb_stats_get_num)(unsafe.Pointer(C.stats_get_num_cgo)))
+	C.cl_engine_set_clcb_stats_get_size(ce, (C.clcb_stats_get_size)(unsafe.Pointer(C.stats_get_size_cgo)))
+	C.cl_engine_set_clcb_stats_get_hostid(ce, (C.clcb_stats_get_hostid)(unsafe.Pointer(C.stats_get_hostid_cgo)))
+}
+
+// DisableStats removes the statistics callbacks of e and turns off the collection of PE section
+// statistics, so that libclamav neither collects nor submits statistics for e, whether or not
+// they were enabled before
+func (e *Engine) DisableStats() error {
+	ce := (*C.struct_cl_engine)(unsafe.Pointer(e))
+	C.cl_engine_set_clcb_stats_add_sample(ce, nil)
+	C.cl_engine_set_clcb_stats_remove_sample(ce, nil)
+	C.cl_engine_set_clcb_stats_decrement_count(ce, nil)
+	C.cl_engine_set_clcb_stats_submit(ce, nil)
+	C.cl_engine_set_clcb_stats_flush(ce, nil)
+	C.cl_engine_set_clcb_stats_get_num(ce, nil)
+	C.cl_engine_set_clcb_stats_get_size(ce, nil)
+	C.cl_engine_set_clcb_stats_get_hostid(ce, nil)
+	return e.SetNum(EngineDisablePeStats, 1)
+}
+
+// Samples returns the samples recorded since the last submission
+func (r *StatsRecorder) Samples() []StatsSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := make([]StatsSample, len(r.samples))
+	for i, p := range r.samples {
+		s[i] = *p
+		s[i].Sections = append([]StatsSection(nil), p.Sections...)
+	}
+	return s
+}
+
+// find returns the index of the sample of virus for the object of the given hash and size, -1
+// if there is none
+func (r *StatsRecorder) find(virus, md5 string, size uint64) int {
+	for i, s := range r.samples {
+		if s.Virus == virus && s.MD5 == md5 && s.Size == size {
+			return i
+		}
+	}
+	return -1
+}
+
+// add records a detection, counting it again if the object was already detected
+func (r *StatsRecorder) add(s *StatsSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.find(s.Virus, s.MD5, s.Size); i >= 0 {
+		r.samples[i].Hits++
+		return
+	}
+	s.Hits = 1
+	r.samples = append(r.samples, s)
+}
+
+// remove forgets a detection, or counts it once less if decrement is set
+func (r *StatsRecorder) remove(virus, md5 string, size uint64, decrement bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.find(virus, md5, size)
+	if i < 0 {
+		return
+	}
+	if decrement && r.samples[i].Hits > 1 {
+		r.samples[i].Hits--
+		return
+	}
+	r.samples = append(r.samples[:i], r.samples[i+1:]...)
+}
+
+// submit passes the samples to OnSubmit and drops them
+func (r *StatsRecorder) submit() {
+	samples := r.Samples()
+	r.flush()
+	if r.OnSubmit != nil {
+		r.OnSubmit(samples)
+	}
+}
+
+// flush drops the samples
+func (r *StatsRecorder) flush() {
+	r.mu.Lock()
+	r.samples = nil
+	r.mu.Unlock()
+}
+
+// size returns the approximate memory held by the samples, as libclamav accounts for its own
+func (r *StatsRecorder) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, s := range r.samples {
+		n += int(unsafe.Sizeof(*s)) + len(s.Virus) + len(s.MD5) + len(s.Sections)*int(unsafe.Sizeof(StatsSection{}))
+	}
+	return n
+}
+
+// The callbacks below recover the panics of the recorder, such as of OnSubmit, which are
+// printed to standard error as they happen out of any scan.
+
+// statsRecorder returns the recorder set by SetStatsRecorder, nil if there is none
+func statsRecorder() *StatsRecorder {
+	r, _ := callbackFunc("stats").(*StatsRecorder)
+	return r
+}
+
+//export statsAddSampleCallback
+func statsAddSampleCallback(virname *C.char, md5 *C.uchar, size C.size_t, sections *C.stats_section_t, cbdata unsafe.Pointer) {
+	defer recoverCallback("stats add sample", nil, nil)
+	r := statsRecorder()
+	if r == nil {
+		return
+	}
+	s := &StatsSample{Virus: C.GoString(virname), MD5: hex.EncodeToString(C.GoBytes(unsafe.Pointer(md5), 16)), Size: uint64(size)}
+	if sections != nil && sections.nsections > 0 {
+		for _, h := range unsafe.Slice(sections.sections, int(sections.nsections)) {
+			s.Sections = append(s.Sections, StatsSection{MD5: hex.EncodeToString(C.GoBytes(unsafe.Pointer(&h.md5[0]), 16)), Size: uint64(h.len)})
+		}
+	}
+	r.add(s)
+}
+
+//export statsRemoveSampleCallback
+func statsRemoveSampleCallback(virname *C.char, md5 *C.uchar, size C.size_t, cbdata unsafe.Pointer) {
+	defer recoverCallback("stats remove sample", nil, nil)
+	if r := statsRecorder(); r != nil {
+		r.remove(C.GoString(virname), hex.EncodeToString(C.GoBytes(unsafe.Pointer(md5), 16)), uint64(size), false)
+	}
+}
+
+//export statsDecrementCountCallback
+func statsDecrementCountCallback(virname *C.char, md5 *C.uchar, size C.size_t, cbdata unsafe.Pointer) {
+	defer recoverCallback("stats decrement count", nil, nil)
+	if r := statsRecorder(); r != nil {
+		r.remove(C.GoString(virname), hex.EncodeToString(C.GoBytes(unsafe.Pointer(md5), 16)), uint64(size), true)
+	}
+}
+
+//export statsSubmitCallback
+func statsSubmitCallback(engine *C.struct_cl_engine, cbdata unsafe.Pointer) {
+	defer recoverCallback("stats submit", nil, nil)
+	if r := statsRecorder(); r != nil {
+		r.submit()
+	}
+}
+
+//export statsFlushCallback
+func statsFlushCallback(engine *C.struct_cl_engine, cbdata unsafe.Pointer) {
+	defer recoverCallback("stats flush", nil, nil)
+	if r := statsRecorder(); r != nil {
+		r.flush()
+	}
+}
+
+//export statsGetNumCallback
+func statsGetNumCallback(cbdata unsafe.Pointer) C.size_t {
+	defer recoverCallback("stats get num", nil, nil)
+	if r := statsRecorder(); r != nil {
+		return C.size_t(len(r.Samples()))
+	}
+	return 0
+}
+
+//export statsGetSizeCallback
+func statsGetSizeCallback(cbdata unsafe.Pointer) C.size_t {
+	defer recoverCallback("stats get size", nil, nil)
+	if r := statsRecorder(); r != nil {
+		return C.size_t(r.size())
+	}
+	return 0
+}
+
+//export statsGetHostidCallback
+func statsGetHostidCallback(cbdata unsafe.Pointer) (id *C.char) {
+	// libclamav expects an allocated string in any case
+	defer recoverCallback("stats get hostid", nil, func() { id = C.CString("none") })
+	name := "none"
+	if r := statsRecorder(); r != nil && r.HostID != "" {
+		name = r.HostID
+	}
+	return C.CString(name)
+}
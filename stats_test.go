@@ -0,0 +1,78 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStatsRecorder(t *testing.T) {
+	var submitted []StatsSample
+	r := &StatsRecorder{OnSubmit: func(s []StatsSample) { submitted = s }}
+	eng := New()
+	defer eng.Free()
+	eng.SetStatsRecorder(r)
+
+	const md5 = "44d88612fea8a8f36de82e1278abb02f"
+	r.add(&StatsSample{Virus: "Eicar-Test-Signature", MD5: md5, Size: 68})
+	r.add(&StatsSample{Virus: "Eicar-Test-Signature", MD5: md5, Size: 68})
+	r.add(&StatsSample{Virus: "Other", MD5: md5, Size: 68, Sections: []StatsSection{{MD5: md5, Size: 512}}})
+	if s := r.Samples(); len(s) != 2 || s[0].Hits != 2 || len(s[1].Sections) != 1 {
+		t.Fatalf("Samples: %+v", s)
+	}
+
+	r.remove("Eicar-Test-Signature", md5, 68, true)
+	r.remove("Other", md5, 68, false)
+	if s := r.Samples(); len(s) != 1 || s[0].Hits != 1 || r.size() == 0 {
+		t.Errorf("Samples: after removal: %+v", s)
+	}
+
+	r.submit()
+	if len(submitted) != 1 || len(r.Samples()) != 0 {
+		t.Errorf("submit: submitted %+v, kept %+v", submitted, r.Samples())
+	}
+	if err := eng.DisableStats(); err != nil {
+		t.Errorf("DisableStats: %v", err)
+	}
+}
+
+func TestStatsRecorderPanic(t *testing.T) {
+	r := &StatsRecorder{OnSubmit: func([]StatsSample) { panic("recorder bug") }}
+	eng := New()
+	defer eng.Free()
+	eng.SetStatsRecorder(r)
+	defer func() { setCallbackFunc("stats", nil) }()
+
+	r.add(&StatsSample{Virus: "Eicar-Test-Signature", MD5: "44d88612fea8a8f36de82e1278abb02f", Size: 68})
+	// called by libclamav, the panic must not unwind into C
+	statsSubmitCallback(nil, nil)
+	if len(r.Samples()) != 0 {
+		t.Errorf("submit: samples kept %+v", r.Samples())
+	}
+}
+
+func TestStatsRecorderConcurrent(t *testing.T) {
+	eng := New()
+	defer eng.Free()
+	defer eng.SetStatsRecorder(nil)
+
+	// the recorder is replaced while libclamav submits
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				statsSubmitCallback(nil, nil)
+				statsGetNumCallback(nil)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		eng.SetStatsRecorder(&StatsRecorder{})
+	}
+	wg.Wait()
+}
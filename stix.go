@@ -0,0 +1,171 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// stixNamespace is the namespace of the deterministic identifiers of STIX Cyber-observable
+// Objects, from the STIX 2.1 specification
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// uuid5 returns the version 5 UUID of name in namespace
+func uuid5(namespace [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+// uuid4 returns a random UUID
+func uuid4() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// stixWriter collects the detections of the results into a STIX bundle
+type stixWriter struct {
+	w       io.Writer
+	created string
+	objects []map[string]interface{}
+	seen    map[string]bool
+}
+
+// NewSTIXWriter returns a writer of a STIX 2.1 bundle describing the detections, for threat
+// intelligence platforms: for every infected object a file object with its name and hashes,
+// for every signature a malware object with the files as samples, and for every infected
+// object an indicator matching its hashes, related to the malware it indicates. Clean objects
+// and failed scans are left out. The bundle is written by Close.
+//
+// The identifiers of the objects derive from their content, so that the same detection
+// exported twice is recognized as such.
+func NewSTIXWriter(w io.Writer) ResultWriter {
+	return &stixWriter{w: w, created: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), seen: map[string]bool{}}
+}
+
+// add adds an object, unless one with the same identifier was added already
+func (s *stixWriter) add(obj map[string]interface{}) {
+	id := obj["id"].(string)
+	if s.seen[id] {
+		return
+	}
+	s.seen[id] = true
+	if obj["type"] != "file" {
+		obj["created"] = s.created
+		obj["modified"] = s.created
+	}
+	obj["spec_version"] = "2.1"
+	s.objects = append(s.objects, obj)
+}
+
+func (s *stixWriter) WriteResult(res *ScanResult, err error) error {
+	if err != nil || res.Virus == "" {
+		return nil
+	}
+	detections := res.Detections
+	if len(detections) == 0 {
+		detections = []Detection{{ClamAVDetector, res.Virus}}
+	}
+
+	file := map[string]interface{}{"type": "file", "name": res.Name}
+	pattern := fmt.Sprintf("[file:name = '%s']", stixEscaper.Replace(res.Name))
+	if h := res.Hashes; h != nil && h.SHA256 != "" {
+		file["hashes"] = map[string]string{"MD5": h.MD5, "SHA-1": h.SHA1, "SHA-256": h.SHA256}
+		pattern = fmt.Sprintf("[file:hashes.'SHA-256' = '%s']", h.SHA256)
+		file["id"] = "file--" + uuid5(stixNamespace, `{"hashes":{"SHA-256":"`+h.SHA256+`"}}`)
+	} else {
+		name, _ := json.Marshal(res.Name)
+		file["id"] = "file--" + uuid5(stixNamespace, `{"name":`+string(name)+`}`)
+	}
+	s.add(file)
+
+	for _, d := range detections {
+		malware := map[string]interface{}{
+			"type":        "malware",
+			"id":          "malware--" + uuid5(stixNamespace, "malware:"+d.Name),
+			"name":        d.Name,
+			"is_family":   false,
+			"sample_refs": []string{file["id"].(string)},
+		}
+		if s.seen[malware["id"].(string)] {
+			s.addSample(malware["id"].(string), file["id"].(string))
+		}
+		s.add(malware)
+
+		indicator := map[string]interface{}{
+			"type":            "indicator",
+			"id":              "indicator--" + uuid5(stixNamespace, "indicator:"+d.Name+":"+pattern),
+			"name":            d.Name,
+			"description":     fmt.Sprintf("%s detected by %s", d.Name, d.Detector),
+			"indicator_types": []string{"malicious-activity"},
+			"pattern":         pattern,
+			"pattern_type":    "stix",
+			"valid_from":      s.created,
+		}
+		s.add(indicator)
+		s.add(map[string]interface{}{
+			"type":              "relationship",
+			"id":                "relationship--" + uuid5(stixNamespace, "indicates:"+indicator["id"].(string)),
+			"relationship_type": "indicates",
+			"source_ref":        indicator["id"],
+			"target_ref":        malware["id"],
+		})
+	}
+	return nil
+}
+
+// addSample adds a file to the samples of a malware object added already
+func (s *stixWriter) addSample(malware, file string) {
+	for _, o := range s.objects {
+		if o["id"] != malware {
+			continue
+		}
+		refs := o["sample_refs"].([]string)
+		for _, ref := range refs {
+			if ref == file {
+				return
+			}
+		}
+		o["sample_refs"] = append(refs, file)
+	}
+}
+
+func (s *stixWriter) Close() error {
+	objects := s.objects
+	if objects == nil {
+		objects = []map[string]interface{}{}
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{
+		"type":    "bundle",
+		"id":      "bundle--" + uuid4(),
+		"objects": objects,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// stixEscaper escapes the strings of STIX patterns
+var stixEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
@@ -0,0 +1,67 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSTIXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewSTIXWriter(&buf)
+	sha := strings.Repeat("ab", 32)
+	w.WriteResult(&ScanResult{Name: "eicar.com", Virus: "Eicar-Test-Signature", Hashes: &Hashes{MD5: "44d8", SHA1: "3395", SHA256: sha}}, nil)
+	w.WriteResult(&ScanResult{Name: "copy of eicar's.com", Virus: "Eicar-Test-Signature"}, nil)
+	w.WriteResult(&ScanResult{Name: "clean.txt"}, nil)
+	w.WriteResult(&ScanResult{Name: "locked"}, errors.New("permission denied"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var bundle struct {
+		Type    string
+		ID      string
+		Objects []map[string]interface{}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &bundle); err != nil {
+		t.Fatalf("Unmarshal: %v\n%s", err, buf.Bytes())
+	}
+	if bundle.Type != "bundle" || !strings.HasPrefix(bundle.ID, "bundle--") {
+		t.Errorf("bundle %s %s", bundle.Type, bundle.ID)
+	}
+	byType := map[string][]map[string]interface{}{}
+	for _, o := range bundle.Objects {
+		if o["spec_version"] != "2.1" || !strings.HasPrefix(o["id"].(string), o["type"].(string)+"--") {
+			t.Errorf("object %v", o)
+		}
+		byType[o["type"].(string)] = append(byType[o["type"].(string)], o)
+	}
+	if len(byType["file"]) != 2 || len(byType["malware"]) != 1 || len(byType["indicator"]) != 2 || len(byType["relationship"]) != 2 {
+		t.Fatalf("objects %v", bundle.Objects)
+	}
+	if refs := byType["malware"][0]["sample_refs"].([]interface{}); len(refs) != 2 {
+		t.Errorf("sample_refs %v", refs)
+	}
+	patterns := []string{byType["indicator"][0]["pattern"].(string), byType["indicator"][1]["pattern"].(string)}
+	if patterns[0] != "[file:hashes.'SHA-256' = '"+sha+"']" || patterns[1] != `[file:name = 'copy of eicar\'s.com']` {
+		t.Errorf("patterns %q", patterns)
+	}
+
+	// the identifiers are stable
+	var again bytes.Buffer
+	w = NewSTIXWriter(&again)
+	w.WriteResult(&ScanResult{Name: "eicar.com", Virus: "Eicar-Test-Signature", Hashes: &Hashes{SHA256: sha}}, nil)
+	w.Close()
+	if !strings.Contains(again.String(), byType["file"][0]["id"].(string)) || !strings.Contains(again.String(), byType["malware"][0]["id"].(string)) {
+		t.Errorf("identifiers changed:\n%s", again.String())
+	}
+	if u := uuid5(stixNamespace, "x"); len(u) != 36 || u[14] != '5' {
+		t.Errorf("uuid5 = %s", u)
+	}
+}
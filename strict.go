@@ -0,0 +1,93 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnsupported is returned in strict mode for settings the linked libclamav does not support
+var ErrUnsupported = errors.New("not supported by the linked libclamav")
+
+// UnsupportedError lists the requested settings the linked libclamav would silently ignore
+type UnsupportedError struct {
+	Problems []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrUnsupported, strings.Join(e.Problems, "; "))
+}
+
+// Is makes an UnsupportedError match ErrUnsupported
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// Supported returns an *UnsupportedError if any of the option bits is unknown to this package
+// or newer than the linked libclamav, nil if all of them are in effect. Unlike Validate it does
+// not object to options merely without effect in combination with others.
+func (o *ScanOptions) Supported() error {
+	if o == nil {
+		return nil
+	}
+	if problems := o.unsupported(); len(problems) > 0 {
+		return &UnsupportedError{Problems: problems}
+	}
+	return nil
+}
+
+// SetNumStrict is SetNum failing with an *UnsupportedError if the field is not a numeric field
+// known to this package, or if the engine does not hold num afterwards, as when a 32-bit field
+// truncates it
+func (e *Engine) SetNumStrict(field EngineField, num uint64) error {
+	if err := checkFieldKind(field, FieldNum); err != nil {
+		return err
+	}
+	if err := e.SetNum(field, num); err != nil {
+		return err
+	}
+	got, err := e.GetNum(field)
+	if err != nil {
+		return err
+	}
+	if got != num {
+		return &UnsupportedError{Problems: []string{fmt.Sprintf("%v set to %d, engine holds %d", field, num, got)}}
+	}
+	return nil
+}
+
+// SetStringStrict is SetString failing with an *UnsupportedError if the field is not a string
+// field known to this package, or if the engine does not hold s afterwards
+func (e *Engine) SetStringStrict(field EngineField, s string) error {
+	if err := checkFieldKind(field, FieldString); err != nil {
+		return err
+	}
+	if err := e.SetString(field, s); err != nil {
+		return err
+	}
+	got, err := e.GetString(field)
+	if err != nil {
+		return err
+	}
+	if got != s {
+		return &UnsupportedError{Problems: []string{fmt.Sprintf("%v set to %q, engine holds %q", field, s, got)}}
+	}
+	return nil
+}
+
+// checkFieldKind fails if field is unknown or not of kind k
+func checkFieldKind(field EngineField, k FieldKind) error {
+	for _, ef := range engineFields {
+		if ef.field == field {
+			if ef.kind != k {
+				return &UnsupportedError{Problems: []string{fmt.Sprintf("%s is a %v field, not %v", ef.name, ef.kind, k)}}
+			}
+			return nil
+		}
+	}
+	return &UnsupportedError{Problems: []string{fmt.Sprintf("%v is unknown", field)}}
+}
@@ -0,0 +1,59 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	opts := &ScanOptions{General: ScanGeneralHeuristics, Parse: ScanParseArchive | ScanParsePdf}
+	if err := opts.Supported(); err != nil {
+		t.Errorf("Supported: %v", err)
+	}
+	unknown := &ScanOptions{General: ScanGeneralHeuristics, Parse: 0x80000000}
+	if err := unknown.Supported(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Supported: unknown option: %v", err)
+	}
+
+	if err := Init(InitDefault); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	eng := New()
+	defer eng.Free()
+	for _, err := range []error{
+		eng.SetNumStrict(EngineField(999), 1),
+		eng.SetNumStrict(Tmpdir, 1),
+		eng.SetStringStrict(MaxFiles, "1"),
+		eng.SetNumStrict(MaxFiles, 1<<33), // truncated by the 32-bit field
+	} {
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("strict setter: %v, want ErrUnsupported", err)
+		}
+	}
+
+	// the fields of dat.go are known, MaxScantime among them
+	for _, f := range []EngineField{EngineMaxScantime, EngineDisablePeStats, EngineStatsTimeout} {
+		if err := checkFieldKind(f, FieldNum); err != nil {
+			t.Errorf("checkFieldKind: %v", err)
+		}
+	}
+	// whether the engine holds the value depends on the linked library, but the field is known
+	if err := eng.SetNumStrict(EngineMaxScantime, 120000); err != nil && strings.Contains(err.Error(), "is unknown") {
+		t.Errorf("SetNumStrict: MaxScantime: %v", err)
+	}
+
+	s := &EngineScanner{Engine: eng, Options: unknown, Strict: true}
+	if _, err := s.Scan(bytes.NewReader(eicar), "eicar"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Scan: %v, want ErrUnsupported", err)
+	}
+	c := &ClamdConfig{Options: *unknown}
+	if err := c.ApplyStrict(eng); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("ApplyStrict: %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Errors of TenantScanner.Scan for scans refused to a tenant
+var (
+	ErrTenantRateLimited = errors.New("tenant scan rate exceeded")
+	ErrTenantConcurrency = errors.New("tenant concurrent scan limit reached")
+	ErrTenantQuota       = errors.New("tenant byte quota exceeded")
+)
+
+// TenantLimits are the limits of a tenant of a TenantScanner. Zero values mean no limit.
+type TenantLimits struct {
+	Rate  float64 // scans per second
+	Burst int     // scans allowed at once above Rate, one if zero
+
+	MaxConcurrent int
+
+	// MaxBytes is the quota of bytes scanned per QuotaPeriod, or over the lifetime of the
+	// scanner if QuotaPeriod is zero. A scan exceeding it fails with ErrTenantQuota.
+	MaxBytes    int64
+	QuotaPeriod time.Duration
+}
+
+// TenantEvent describes a scan of a tenant, or a scan refused, for metrics and audit logs
+type TenantEvent struct {
+	Tenant   string
+	Name     string
+	Virus    string
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
+// TenantStats are the totals of a tenant
+type TenantStats struct {
+	Scans    int64
+	Infected int64
+	Refused  int64 // by the limits
+	Bytes    int64 // in total
+	Running  int
+
+	QuotaUsed int64 // bytes of the current quota period
+}
+
+// tenantState is the accounting of a tenant
+type tenantState struct {
+	stats       TenantStats
+	tokens      float64
+	refilled    time.Time
+	periodStart time.Time
+}
+
+// TenantScanner scans on behalf of many tenants, such as the customers of a scanning service,
+// enforcing limits per tenant on a shared Scanner
+type TenantScanner struct {
+	Scanner Scanner
+
+	// Default are the limits of the tenants not listed in Tenants
+	Default TenantLimits
+	Tenants map[string]TenantLimits
+
+	// Observe, if not nil, is called after every scan and every scan refused
+	Observe func(*TenantEvent)
+
+	mu    sync.Mutex
+	state map[string]*tenantState
+}
+
+// limits returns the limits of tenant
+func (s *TenantScanner) limits(tenant string) TenantLimits {
+	if l, ok := s.Tenants[tenant]; ok {
+		return l
+	}
+	return s.Default
+}
+
+// Scan scans the data read from r on behalf of tenant
+func (s *TenantScanner) Scan(tenant string, r io.Reader, name string) (*ScanResult, error) {
+	l := s.limits(tenant)
+	ev := &TenantEvent{Tenant: tenant, Name: name}
+	if ev.Err = s.admit(tenant, l); ev.Err != nil {
+		s.observe(ev)
+		return nil, ev.Err
+	}
+
+	start := time.Now()
+	qr := &quotaReader{r: r, s: s, tenant: tenant, max: l.MaxBytes}
+	res, err := s.Scanner.Scan(qr, name)
+	if qr.err != nil {
+		res, err = nil, qr.err
+	}
+	ev.Bytes, ev.Duration, ev.Err = qr.n, time.Since(start), err
+	if res != nil {
+		ev.Virus = res.Virus
+	}
+
+	s.mu.Lock()
+	st := &s.state[tenant].stats
+	st.Running--
+	if ev.Virus != "" {
+		st.Infected++
+	}
+	if err == ErrTenantQuota {
+		st.Refused++
+	}
+	s.mu.Unlock()
+	s.observe(ev)
+	return res, err
+}
+
+// Tenant returns a Scanner scanning on behalf of tenant
+func (s *TenantScanner) Tenant(tenant string) Scanner {
+	return &tenantScanner{s, tenant}
+}
+
+type tenantScanner struct {
+	s      *TenantScanner
+	tenant string
+}
+
+func (t *tenantScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	return t.s.Scan(t.tenant, r, name)
+}
+
+// admit checks the limits of tenant for a new scan and counts it as running
+func (s *TenantScanner) admit(tenant string, l TenantLimits) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		s.state = map[string]*tenantState{}
+	}
+	now := time.Now()
+	t := s.state[tenant]
+	if t == nil {
+		t = &tenantState{refilled: now, periodStart: now}
+		t.tokens = float64(burst(l))
+		s.state[tenant] = t
+	}
+	if l.QuotaPeriod > 0 && now.Sub(t.periodStart) >= l.QuotaPeriod {
+		t.periodStart = now
+		t.stats.QuotaUsed = 0
+	}
+	if l.Rate > 0 {
+		t.tokens += now.Sub(t.refilled).Seconds() * l.Rate
+		if max := float64(burst(l)); t.tokens > max {
+			t.tokens = max
+		}
+		t.refilled = now
+	}
+
+	var err error
+	switch {
+	case l.MaxConcurrent > 0 && t.stats.Running >= l.MaxConcurrent:
+		err = ErrTenantConcurrency
+	case l.MaxBytes > 0 && t.stats.QuotaUsed >= l.MaxBytes:
+		err = ErrTenantQuota
+	case l.Rate > 0 && t.tokens < 1:
+		err = ErrTenantRateLimited
+	}
+	if err != nil {
+		t.stats.Refused++
+		return err
+	}
+	if l.Rate > 0 {
+		t.tokens--
+	}
+	t.stats.Scans++
+	t.stats.Running++
+	return nil
+}
+
+// burst returns the size of the token bucket of l
+func burst(l TenantLimits) int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+func (s *TenantScanner) observe(ev *TenantEvent) {
+	if s.Observe != nil {
+		s.Observe(ev)
+	}
+}
+
+// Stats returns the totals of tenant
+func (s *TenantScanner) Stats(tenant string) TenantStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.state[tenant]; t != nil {
+		return t.stats
+	}
+	return TenantStats{}
+}
+
+// quotaReader counts the bytes a tenant scans, failing once its quota is exceeded
+type quotaReader struct {
+	r      io.Reader
+	s      *TenantScanner
+	tenant string
+	max    int64
+	n      int64
+	err    error
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	n, err := q.r.Read(p)
+	q.n += int64(n)
+	q.s.mu.Lock()
+	st := &q.s.state[q.tenant].stats
+	st.Bytes += int64(n)
+	st.QuotaUsed += int64(n)
+	over := q.max > 0 && st.QuotaUsed > q.max
+	q.s.mu.Unlock()
+	if over {
+		q.err = ErrTenantQuota
+		return n, q.err
+	}
+	return n, err
+}
@@ -0,0 +1,73 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTenantScanner(t *testing.T) {
+	var events []*TenantEvent
+	s := &TenantScanner{
+		Scanner: eicarScanner{},
+		Default: TenantLimits{Rate: 1, Burst: 2},
+		Tenants: map[string]TenantLimits{"small": {MaxBytes: 100, QuotaPeriod: time.Hour}},
+		Observe: func(ev *TenantEvent) { events = append(events, ev) },
+	}
+
+	// the burst is used up, then scans are refused until the rate allows more
+	for i, want := range []error{nil, nil, ErrTenantRateLimited} {
+		if _, err := s.Scan("acme", bytes.NewReader(eicar), "eicar.com"); err != want {
+			t.Errorf("Scan %d: got %v, want %v", i, err, want)
+		}
+	}
+	if st := s.Stats("acme"); st.Scans != 2 || st.Infected != 2 || st.Refused != 1 || st.Bytes != int64(2*len(eicar)) {
+		t.Errorf("Stats: %+v", st)
+	}
+	if len(events) != 3 || events[0].Tenant != "acme" || events[0].Virus == "" || events[2].Err != ErrTenantRateLimited {
+		t.Errorf("Observe: %+v", events)
+	}
+
+	// other tenants have limits of their own
+	if _, err := s.Tenant("small").Scan(strings.NewReader(strings.Repeat("x", 60)), "a"); err != nil {
+		t.Errorf("Scan: %v", err)
+	}
+	if _, err := s.Tenant("small").Scan(strings.NewReader(strings.Repeat("x", 60)), "b"); err != ErrTenantQuota {
+		t.Errorf("Scan: got %v over the quota", err)
+	}
+	if _, err := s.Tenant("small").Scan(strings.NewReader("x"), "c"); err != ErrTenantQuota {
+		t.Errorf("Scan: got %v with the quota used up", err)
+	}
+	if st := s.Stats("small"); st.Scans != 2 || st.Refused != 2 || st.Running != 0 {
+		t.Errorf("Stats: %+v", st)
+	}
+}
+
+func TestTenantScannerConcurrency(t *testing.T) {
+	g := &gateScanner{}
+	s := &TenantScanner{Scanner: g, Default: TenantLimits{MaxConcurrent: 1}}
+	done := make(chan error)
+	go func() {
+		_, err := s.Scan("acme", strings.NewReader(""), "first")
+		done <- err
+	}()
+	for i := 0; i < 1000 && s.Stats("acme").Running == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := s.Scan("acme", strings.NewReader(""), "second"); err != ErrTenantConcurrency {
+		t.Errorf("Scan: got %v over the concurrency limit", err)
+	}
+	g.release("first")
+	if err := <-done; err != nil {
+		t.Errorf("Scan: %v", err)
+	}
+	g.release("third")
+	if _, err := s.Scan("acme", strings.NewReader(""), "third"); err != nil {
+		t.Errorf("Scan: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "time"
+
+// ScanTiming breaks the wall time of a scan down by phase, to tell whether a slow scan waited
+// on its input, on unpacking or on signature matching.
+//
+// libclamav does not report its phases, they are told apart by the objects it reports to the
+// callbacks: the time from the previous object, or the previous archive member listed, to
+// each object libclamav extracted is counted as unpacking, the rest of the time spent in
+// libclamav as matching. Matching thus includes file type detection and normalization.
+type ScanTiming struct {
+	Read   time.Duration // reading the input, including spooling it to disk
+	Unpack time.Duration // libclamav extracting the contained objects
+	Match  time.Duration // libclamav scanning the objects
+
+	Objects int // reported by libclamav, the top level one included
+}
+
+// Total is the sum of the phases
+func (t *ScanTiming) Total() time.Duration {
+	return t.Read + t.Unpack + t.Match
+}
+
+// scanTimer accounts for the phases of a scan in a ScanTiming
+type scanTimer struct {
+	t    *ScanTiming
+	mark time.Time // of the last event reported by libclamav
+}
+
+// read adds the time since start to the reading phase, if t is not nil
+func (t *scanTimer) read(start time.Time) {
+	if t != nil {
+		t.t.Read += time.Since(start)
+	}
+}
+
+// engine calls scan, adding the time libclamav did not spend unpacking to the matching phase
+func (t *scanTimer) engine(scan func()) {
+	if t == nil {
+		scan()
+		return
+	}
+	start := time.Now()
+	unpack := t.t.Unpack
+	t.mark = start
+	scan()
+	t.t.Match += time.Since(start) - (t.t.Unpack - unpack)
+}
+
+// member records libclamav listing an archive member, which it extracts next
+func (t *scanTimer) member() {
+	if t != nil {
+		t.mark = time.Now()
+	}
+}
+
+// object records libclamav reporting an object, extracted unless top is set
+func (t *scanTimer) object(top bool) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	if !top {
+		t.t.Unpack += now.Sub(t.mark)
+	}
+	t.mark = now
+	t.t.Objects++
+}
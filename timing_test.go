@@ -0,0 +1,38 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanTimer(t *testing.T) {
+	tm := &ScanTiming{}
+	timer := &scanTimer{t: tm}
+	timer.read(time.Now().Add(-time.Millisecond))
+	timer.engine(func() {
+		timer.object(true)
+		time.Sleep(2 * time.Millisecond) // matching the archive
+		timer.member()
+		time.Sleep(5 * time.Millisecond) // extracting the member
+		timer.object(false)
+		time.Sleep(2 * time.Millisecond) // matching the member
+	})
+	if tm.Objects != 2 || tm.Read < time.Millisecond {
+		t.Errorf("timing %+v", tm)
+	}
+	if tm.Unpack < 5*time.Millisecond || tm.Match < 4*time.Millisecond {
+		t.Errorf("timing %+v: unpacking or matching too short", tm)
+	}
+	if tm.Total() != tm.Read+tm.Unpack+tm.Match {
+		t.Errorf("Total = %v", tm.Total())
+	}
+
+	// nil timers are not accounted
+	var none *scanTimer
+	none.read(time.Now())
+	none.engine(func() { none.member(); none.object(false) })
+}
@@ -0,0 +1,87 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// errTrackerClosed is returned by tracker.serve once the tracker is closed
+var errTrackerClosed = errors.New("server closed")
+
+// tracker keeps the listeners and connections of a server, so that they can all be closed
+type tracker struct {
+	mu      sync.Mutex
+	closers map[io.Closer]bool
+	closed  bool
+}
+
+// add tracks c, reporting false if the tracker is closed already
+func (t *tracker) add(c io.Closer) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	if t.closers == nil {
+		t.closers = map[io.Closer]bool{}
+	}
+	t.closers[c] = true
+	return true
+}
+
+func (t *tracker) remove(c io.Closer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.closers, c)
+}
+
+// close closes everything tracked, and everything added later
+func (t *tracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for c := range t.closers {
+		c.Close()
+	}
+	t.closers = nil
+}
+
+// serve accepts connections on l until it fails or the tracker is closed, handling each one
+// in its own goroutine with handle. The connections are closed once handled.
+func (t *tracker) serve(l net.Listener, handle func(net.Conn)) error {
+	if !t.add(l) {
+		l.Close()
+		return errTrackerClosed
+	}
+	defer t.remove(l)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			t.mu.Lock()
+			closed := t.closed
+			t.mu.Unlock()
+			if closed {
+				return errTrackerClosed
+			}
+			return err
+		}
+		if !t.add(conn) {
+			conn.Close()
+			return errTrackerClosed
+		}
+		go func() {
+			defer func() {
+				t.remove(conn)
+				conn.Close()
+			}()
+			handle(conn)
+		}()
+	}
+}
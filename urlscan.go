@@ -0,0 +1,160 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Errors of URLScanner.Fetch for content not scanned
+var (
+	ErrFetchTooLarge    = errors.New("content too large")
+	ErrFetchContentType = errors.New("content type not allowed")
+	ErrFetchRedirect    = errors.New("too many redirects")
+	ErrFetchAddress     = errors.New("address not allowed")
+)
+
+// DefaultFetchTimeout is the time limit of a fetch when URLScanner.Timeout is zero
+const DefaultFetchTimeout = 30 * time.Second
+
+// URLScanner downloads and scans the content of URLs, for "check this link" features. Since the
+// URLs come from users, fetches are bounded in size and time, and by default cannot reach the
+// loopback, private and link-local addresses of the network of the service.
+type URLScanner struct {
+	Scanner Scanner
+
+	// Client is used for the fetches, with the limits of the scanner; one of its own if nil
+	Client *http.Client
+
+	// MaxSize is the largest content fetched, DefaultMaxResponseSize if zero
+	MaxSize int64
+
+	// Timeout bounds the whole fetch, DefaultFetchTimeout if zero; scanning is not bounded
+	Timeout time.Duration
+
+	// ContentTypes restricts fetches to the listed media types, as for ResponseScanner
+	ContentTypes []string
+
+	// MaxRedirects is the number of redirects followed, none if zero
+	MaxRedirects int
+
+	// AllowPrivate allows fetches from private addresses, when the client is the one of the
+	// scanner. Only then does the client use the proxy of the environment.
+	AllowPrivate bool
+}
+
+// FetchResult is the verdict on the content of a URL, with what was fetched
+type FetchResult struct {
+	*ScanResult
+	URL         string // fetched, after redirects
+	Status      int
+	ContentType string
+	Size        int64
+	Header      http.Header
+}
+
+// Fetch downloads the content of rawurl and scans it. The body of responses of any status is
+// scanned, as error pages can be malicious too.
+func (s *URLScanner) Fetch(ctx context.Context, rawurl string) (*FetchResult, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Fetch: %v", err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("Fetch: %s: unsupported scheme", rawurl)
+	}
+	resp, err := s.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Fetch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	r := &FetchResult{
+		URL:         resp.Request.URL.String(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Header:      resp.Header,
+	}
+	if !mediaTypeAllowed(s.ContentTypes, r.ContentType) {
+		return r, fmt.Errorf("Fetch: %s: %w: %s", r.URL, ErrFetchContentType, r.ContentType)
+	}
+	max := s.MaxSize
+	if max <= 0 {
+		max = DefaultMaxResponseSize
+	}
+	if resp.ContentLength > max {
+		return r, fmt.Errorf("Fetch: %s: %w: %d bytes", r.URL, ErrFetchTooLarge, resp.ContentLength)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	r.Size = int64(len(buf))
+	if err != nil {
+		return r, fmt.Errorf("Fetch: %s: %v", r.URL, err)
+	}
+	if int64(len(buf)) > max {
+		return r, fmt.Errorf("Fetch: %s: %w", r.URL, ErrFetchTooLarge)
+	}
+	r.ScanResult, err = s.Scanner.Scan(bytes.NewReader(buf), req.URL.Path)
+	if err != nil {
+		return r, fmt.Errorf("Fetch: %s: %v", r.URL, err)
+	}
+	return r, nil
+}
+
+// client returns the client of the fetches, with the redirect policy of the scanner
+func (s *URLScanner) client() *http.Client {
+	var c http.Client
+	if s.Client != nil {
+		c = *s.Client
+	} else {
+		d := &net.Dialer{Timeout: 10 * time.Second}
+		t := &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         d.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}
+		if !s.AllowPrivate {
+			// only the address of a proxy would be checked, not the one it connects to
+			d.Control = publicOnly
+			t.Proxy = nil
+		}
+		c.Transport = t
+	}
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > s.MaxRedirects {
+			return ErrFetchRedirect
+		}
+		return nil
+	}
+	return &c
+}
+
+// publicOnly refuses connections to loopback, private, link-local and unspecified addresses
+func publicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrFetchAddress, host)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestURLScanner(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eicar.com", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(eicar)
+	})
+	mux.Handle("/download", http.RedirectHandler("/eicar.com", http.StatusFound))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx := context.Background()
+
+	s := &URLScanner{Scanner: eicarScanner{}, AllowPrivate: true, MaxRedirects: 1}
+	r, err := s.Fetch(ctx, srv.URL+"/download")
+	if err != nil || r.Virus == "" || r.URL != srv.URL+"/eicar.com" || r.Status != http.StatusOK ||
+		r.Size != int64(len(eicar)) || r.ContentType != "application/octet-stream" {
+		t.Fatalf("Fetch: %+v, %v", r, err)
+	}
+
+	for _, c := range []struct {
+		s    URLScanner
+		url  string
+		want error
+	}{
+		{URLScanner{}, srv.URL + "/eicar.com", ErrFetchAddress},
+		{URLScanner{AllowPrivate: true}, srv.URL + "/download", ErrFetchRedirect},
+		{URLScanner{AllowPrivate: true, MaxSize: 10}, srv.URL + "/eicar.com", ErrFetchTooLarge},
+		{URLScanner{AllowPrivate: true, ContentTypes: []string{"text/"}}, srv.URL + "/eicar.com", ErrFetchContentType},
+	} {
+		c.s.Scanner = eicarScanner{}
+		if _, err := c.s.Fetch(ctx, c.url); !errors.Is(err, c.want) {
+			t.Errorf("Fetch: %s with %+v: %v, want %v", c.url, c.s, err, c.want)
+		}
+	}
+	if _, err := s.Fetch(ctx, "file:///etc/passwd"); err == nil {
+		t.Errorf("Fetch: file URL accepted")
+	}
+}
+
+func TestURLScannerProxy(t *testing.T) {
+	// a proxy would connect to private addresses on behalf of the scanner
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(eicar)
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("HTTPS_PROXY", proxy.URL)
+
+	if tr := (&URLScanner{}).client().Transport.(*http.Transport); tr.Proxy != nil {
+		t.Errorf("client: proxy used with private addresses refused")
+	}
+	if tr := (&URLScanner{AllowPrivate: true}).client().Transport.(*http.Transport); tr.Proxy == nil {
+		t.Errorf("client: proxy of the environment not used")
+	}
+}
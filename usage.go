@@ -0,0 +1,52 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"io"
+	"runtime"
+	"time"
+)
+
+// ScanUsage are the resources a scan used, for capacity planning
+type ScanUsage struct {
+	Wall      time.Duration // from the first read of the input to the end of the scan
+	CPU       time.Duration // user and system time of the scanning thread (Linux only)
+	BytesRead int64         // read from the input
+
+	// TempBytes are the bytes the scanning thread wrote while libclamav scanned, mostly to
+	// the temporary files of unpacked objects (Linux only). The copy of large inputs spooled
+	// to disk before scanning is not included.
+	TempBytes int64
+}
+
+// measure calls scan on a thread of its own, adding the CPU time it used and the bytes it wrote
+// to u, if not nil
+func (u *ScanUsage) measure(scan func()) {
+	if u == nil {
+		scan()
+		return
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cpu0, w0, ok := threadUsage()
+	scan()
+	if cpu1, w1, ok1 := threadUsage(); ok && ok1 {
+		u.CPU += cpu1 - cpu0
+		u.TempBytes += w1 - w0
+	}
+}
+
+// usageReader counts the bytes read from r
+type usageReader struct {
+	r io.Reader
+	u *ScanUsage
+}
+
+func (r *usageReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.u.BytesRead += int64(n)
+	return n, err
+}
@@ -0,0 +1,58 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rusageThread is RUSAGE_THREAD of getrusage(2)
+const rusageThread = 1
+
+// threadUsage returns the CPU time used by the calling thread, which must be locked to its
+// goroutine, and the bytes it wrote
+func threadUsage() (cpu time.Duration, written int64, ok bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(rusageThread, &ru); err != nil {
+		return 0, 0, false
+	}
+	cpu = time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+
+	f, err := os.Open(fmt.Sprintf("/proc/self/task/%d/io", syscall.Gettid()))
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// wchar counts the bytes written, whether they reached the disk yet or not
+		if v := strings.TrimPrefix(s.Text(), "wchar: "); v != s.Text() {
+			written, err = strconv.ParseInt(v, 10, 64)
+			return cpu, written, err == nil
+		}
+	}
+	return 0, 0, false
+}
+
+// residentMemory returns the resident set size of the process
+func residentMemory() (int64, bool) {
+	b, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	f := strings.Fields(string(b))
+	if len(f) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseInt(f[1], 10, 64)
+	return pages * int64(os.Getpagesize()), err == nil
+}
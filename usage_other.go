@@ -0,0 +1,20 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package clamav
+
+import "time"
+
+// threadUsage is not available: resources are accounted per process on this system
+func threadUsage() (cpu time.Duration, written int64, ok bool) {
+	return 0, 0, false
+}
+
+// residentMemory is not available on this system
+func residentMemory() (int64, bool) {
+	return 0, false
+}
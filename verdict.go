@@ -0,0 +1,124 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"fmt"
+	"path"
+)
+
+// Decision is what a VerdictPolicy decides to do with an object, in increasing severity
+type Decision int
+
+// Decisions of a VerdictPolicy
+const (
+	DecisionAllow      Decision = iota // nothing found, or nothing that matters
+	DecisionFlag                       // let the object through, marked for review
+	DecisionQuarantine                 // hold the object back
+	DecisionBlock                      // reject the object
+)
+
+func (d Decision) String() string {
+	switch d {
+	case DecisionAllow:
+		return "allow"
+	case DecisionFlag:
+		return "flag"
+	case DecisionQuarantine:
+		return "quarantine"
+	case DecisionBlock:
+		return "block"
+	}
+	return fmt.Sprintf("Decision(%d)", int(d))
+}
+
+// VerdictRule decides on the detections of a detector whose names match
+type VerdictRule struct {
+	// Detector is ClamAVDetector or the name of a Detector of the EngineScanner; the rule
+	// applies to all detectors if empty
+	Detector string
+
+	// Match is a pattern, as of path.Match, of the names of the detections the rule applies
+	// to, such as "PUA.*" or "Heuristics.*" for the heuristic alerts of libclamav; the rule
+	// applies to all if empty
+	Match string
+
+	Decision Decision
+	Score    int // added to the score of the object
+}
+
+// VerdictPolicy combines everything known about a scanned object into a single decision. Each
+// detection is decided on by the first rule matching it, detections matching none are blocked.
+// The decision for the object is the most severe of those of its detections and of its score,
+// the sum of the scores of the rules applied, unless the object is allowlisted.
+type VerdictPolicy struct {
+	Rules []VerdictRule
+
+	// FlagScore, QuarantineScore and BlockScore are the scores from which objects are flagged,
+	// quarantined and blocked, ignored if zero
+	FlagScore, QuarantineScore, BlockScore int
+
+	// Allowlist, if set, allows the objects whose SHA256 hash it holds whatever was detected
+	// in them. It requires the hashes in the results, see EngineScanner.Hashes.
+	Allowlist *Allowlist
+}
+
+// Verdict is the decision of a VerdictPolicy on a scanned object
+type Verdict struct {
+	Decision    Decision
+	Score       int
+	Allowlisted bool
+
+	// Reasons explain the decision, such as "clamav Eicar-Test-Signature: block"
+	Reasons []string
+}
+
+// rule returns the first rule matching the detection, nil if none
+func (p *VerdictPolicy) rule(d Detection) *VerdictRule {
+	for i, r := range p.Rules {
+		if r.Detector != "" && r.Detector != d.Detector {
+			continue
+		}
+		if ok, _ := path.Match(r.Match, d.Name); ok || r.Match == "" {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// Decide returns the verdict of the policy on the result of a scan
+func (p *VerdictPolicy) Decide(res *ScanResult) *Verdict {
+	v := &Verdict{}
+	if p.Allowlist != nil && res.Hashes != nil && p.Allowlist.Allowed(res.Hashes.SHA256) {
+		v.Allowlisted = true
+		v.Reasons = append(v.Reasons, "allowlisted")
+		return v
+	}
+	detections := res.Detections
+	if len(detections) == 0 && res.Virus != "" {
+		detections = []Detection{{ClamAVDetector, res.Virus}}
+	}
+	for _, d := range detections {
+		decision, score := DecisionBlock, 0
+		if r := p.rule(d); r != nil {
+			decision, score = r.Decision, r.Score
+		}
+		v.Score += score
+		if decision > v.Decision {
+			v.Decision = decision
+		}
+		v.Reasons = append(v.Reasons, fmt.Sprintf("%s %s: %v", d.Detector, d.Name, decision))
+	}
+	for _, t := range []struct {
+		score    int
+		decision Decision
+	}{{p.FlagScore, DecisionFlag}, {p.QuarantineScore, DecisionQuarantine}, {p.BlockScore, DecisionBlock}} {
+		if t.score != 0 && v.Score >= t.score && t.decision > v.Decision {
+			v.Decision = t.decision
+			v.Reasons = append(v.Reasons, fmt.Sprintf("score %d: %v", v.Score, t.decision))
+		}
+	}
+	return v
+}
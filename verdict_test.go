@@ -0,0 +1,43 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import "testing"
+
+func TestVerdictPolicy(t *testing.T) {
+	p := &VerdictPolicy{
+		Rules: []VerdictRule{
+			{Detector: ClamAVDetector, Match: "PUA.*", Decision: DecisionFlag, Score: 10},
+			{Detector: ClamAVDetector, Match: "Heuristics.Encrypted.*", Decision: DecisionQuarantine},
+			{Detector: "entropy", Decision: DecisionAllow, Score: 15},
+		},
+		QuarantineScore: 20,
+		Allowlist:       NewAllowlist("aa"),
+	}
+	for _, tc := range []struct {
+		res  *ScanResult
+		want Decision
+	}{
+		{&ScanResult{}, DecisionAllow},
+		{&ScanResult{Virus: "Eicar-Test-Signature"}, DecisionBlock},
+		{&ScanResult{Virus: "PUA.Win.Tool.Test"}, DecisionFlag},
+		{&ScanResult{Virus: "Heuristics.Encrypted.Zip"}, DecisionQuarantine},
+		{&ScanResult{Virus: "High.Entropy", Detections: []Detection{{"entropy", "High.Entropy"}}}, DecisionAllow},
+		// the scores of both detections reach QuarantineScore
+		{&ScanResult{Virus: "PUA.Win.Tool.Test", Detections: []Detection{{ClamAVDetector, "PUA.Win.Tool.Test"}, {"entropy", "High.Entropy"}}}, DecisionQuarantine},
+		{&ScanResult{Virus: "Eicar-Test-Signature", Hashes: &Hashes{SHA256: "AA"}}, DecisionAllow},
+	} {
+		v := p.Decide(tc.res)
+		if v.Decision != tc.want || len(v.Reasons) == 0 && tc.res.Virus != "" {
+			t.Errorf("Decide(%+v) = %+v, want %v", tc.res, v, tc.want)
+		}
+	}
+	if v := p.Decide(&ScanResult{Virus: "Eicar-Test-Signature", Hashes: &Hashes{SHA256: "aa"}}); !v.Allowlisted || v.Score != 0 {
+		t.Errorf("Decide: allowlisted: %+v", v)
+	}
+	if s := DecisionQuarantine.String(); s != "quarantine" {
+		t.Errorf("String = %q", s)
+	}
+}
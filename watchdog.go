@@ -0,0 +1,148 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrScanHung is the error of the scans a Watchdog gave up on
+var ErrScanHung = errors.New("scan exceeded the watchdog limit")
+
+// HungScan describes a scan a Watchdog gave up on
+type HungScan struct {
+	Name    string
+	Started time.Time
+	Bytes   int64   // of the sample read by the scanner
+	Hashes  *Hashes // of the sample, nil if the scanner had not read it in full
+}
+
+// WatchdogStats are the totals of a Watchdog
+type WatchdogStats struct {
+	Scans  int64
+	GaveUp int64 // scans that exceeded the limit
+	Hung   int   // of those, still running
+}
+
+// Watchdog is a Scanner bounding the wall-clock time of the scans of another, as a last resort
+// against scans stuck despite EngineMaxScantime, such as in a parser looping on a crafted
+// sample. A scan exceeding the limit fails with ErrScanHung, and the engine is asked to abort
+// it at the next object it extracts. A scan stuck on a single object cannot be interrupted:
+// its thread keeps running, and is counted as hung until the scan returns. Services should
+// replace the engine, or restart, once Healthy reports too many hung scans.
+type Watchdog struct {
+	Scanner Scanner
+	Limit   time.Duration
+
+	// MaxHung is the number of hung scans at which Healthy reports false, one if zero
+	MaxHung int
+
+	// OnHung, if not nil, is called with every scan the watchdog gives up on, for example to
+	// log the sample for analysis
+	OnHung func(*HungScan)
+
+	mu    sync.Mutex
+	stats WatchdogStats
+}
+
+// watchdogReader is the data of a scan run by a Watchdog. It hashes the sample as the scanner
+// reads it, and tells EngineScanners whether the scan should be aborted. Reads of an aborted
+// scan fail, so that a scanner the watchdog gave up on stops consuming the reader of the caller.
+type watchdogReader struct {
+	r     io.Reader
+	abort int32
+
+	mu     sync.Mutex
+	hasher *hasher
+	n      int64
+	eof    bool
+}
+
+func (w *watchdogReader) Read(p []byte) (int, error) {
+	if w.aborted() {
+		return 0, ErrScanHung
+	}
+	n, err := w.r.Read(p)
+	w.mu.Lock()
+	w.hasher.Write(p[:n])
+	w.n += int64(n)
+	w.eof = err == io.EOF
+	w.mu.Unlock()
+	return n, err
+}
+
+// aborted reports whether the watchdog gave up on the scan
+func (w *watchdogReader) aborted() bool {
+	return atomic.LoadInt32(&w.abort) != 0
+}
+
+// Scan scans the data read from r with the scanner, giving up after the limit
+func (w *Watchdog) Scan(r io.Reader, name string) (*ScanResult, error) {
+	type result struct {
+		res *ScanResult
+		err error
+	}
+	wr := &watchdogReader{r: r, hasher: newHasher()}
+	done := make(chan result, 1)
+	w.mu.Lock()
+	w.stats.Scans++
+	w.mu.Unlock()
+	start := time.Now()
+	go func() {
+		res, err := w.Scanner.Scan(wr, name)
+		done <- result{res, err}
+	}()
+
+	t := time.NewTimer(w.Limit)
+	defer t.Stop()
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-t.C:
+	}
+
+	atomic.StoreInt32(&wr.abort, 1)
+	hs := &HungScan{Name: name, Started: start}
+	wr.mu.Lock()
+	hs.Bytes = wr.n
+	if wr.eof {
+		hs.Hashes = wr.hasher.sum()
+	}
+	wr.mu.Unlock()
+	w.mu.Lock()
+	w.stats.GaveUp++
+	w.stats.Hung++
+	w.mu.Unlock()
+	go func() {
+		<-done
+		w.mu.Lock()
+		w.stats.Hung--
+		w.mu.Unlock()
+	}()
+	if w.OnHung != nil {
+		w.OnHung(hs)
+	}
+	return nil, ErrScanHung
+}
+
+// Stats returns the totals of the watchdog
+func (w *Watchdog) Stats() WatchdogStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// Healthy reports whether fewer than MaxHung scans are hung
+func (w *Watchdog) Healthy() bool {
+	max := w.MaxHung
+	if max < 1 {
+		max = 1
+	}
+	return w.Stats().Hung < max
+}
@@ -0,0 +1,78 @@
+// Copyright 2013 the Go ClamAV authors
+// Use of this source code is governed by a
+// license that can be found in the LICENSE file.
+
+package clamav
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// readingScanner reads the data and scans it with a gateScanner
+type readingScanner struct {
+	*gateScanner
+}
+
+func (s readingScanner) Scan(r io.Reader, name string) (*ScanResult, error) {
+	ioutil.ReadAll(r)
+	return s.gateScanner.Scan(r, name)
+}
+
+func TestWatchdog(t *testing.T) {
+	g := &gateScanner{}
+	var hung []*HungScan
+	w := &Watchdog{Scanner: readingScanner{g}, Limit: 10 * time.Millisecond, OnHung: func(h *HungScan) { hung = append(hung, h) }}
+
+	g.release("quick")
+	if res, err := w.Scan(bytes.NewReader(eicar), "quick"); err != nil || res.Name != "quick" {
+		t.Errorf("Scan: %+v %v", res, err)
+	}
+	if _, err := w.Scan(bytes.NewReader(eicar), "stuck"); err != ErrScanHung {
+		t.Fatalf("Scan: got %v, want ErrScanHung", err)
+	}
+	want := fmt.Sprintf("%x", sha256.Sum256(eicar))
+	if len(hung) != 1 || hung[0].Name != "stuck" || hung[0].Bytes != int64(len(eicar)) || hung[0].Hashes == nil || hung[0].Hashes.SHA256 != want {
+		t.Errorf("OnHung: %+v", hung)
+	}
+	if st := w.Stats(); st.Scans != 2 || st.GaveUp != 1 || st.Hung != 1 || w.Healthy() {
+		t.Errorf("Stats: %+v", st)
+	}
+
+	// the service is healthy again once the hung scan returns
+	g.release("stuck")
+	for i := 0; i < 1000 && !w.Healthy(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if st := w.Stats(); st.Hung != 0 || !w.Healthy() {
+		t.Errorf("Stats: %+v", st)
+	}
+}
+
+func TestWatchdogAbortsEngineScans(t *testing.T) {
+	eng, err := testInitAll()
+	if err != nil {
+		t.Fatalf("testInitAll: %v", err)
+	}
+	defer eng.Free()
+
+	s := &EngineScanner{Engine: eng, Options: stdopts}
+	wr := &watchdogReader{r: bytes.NewReader(eicar), hasher: newHasher(), abort: 1}
+	if _, err := s.Scan(wr, "eicar"); err != ErrScanHung {
+		t.Errorf("Scan: got %v from an aborted scan", err)
+	}
+	r := bytes.NewReader(eicar)
+	wr = &watchdogReader{r: r, hasher: newHasher(), abort: 1}
+	if n, err := wr.Read(make([]byte, 10)); n != 0 || err != ErrScanHung || r.Len() != len(eicar) {
+		t.Errorf("Read: %d %v from an aborted scan", n, err)
+	}
+	w := &Watchdog{Scanner: s, Limit: time.Minute}
+	if res, err := w.Scan(bytes.NewReader(eicar), "eicar"); err != nil || res.Virus == "" {
+		t.Errorf("Scan: %+v %v", res, err)
+	}
+}